@@ -0,0 +1,221 @@
+package courier
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/sirupsen/logrus"
+)
+
+// ChannelQueue describes the outgoing queue of a single channel
+type ChannelQueue struct {
+	ChannelUUID ChannelUUID `json:"channel_uuid"`
+	ChannelType ChannelType `json:"channel_type"`
+	State       string      `json:"state"`
+	TPS         int         `json:"tps"`
+	Workers     int         `json:"workers"`
+	Paused      bool        `json:"paused"`
+	Breaker     string      `json:"breaker"`
+	Size        int         `json:"size"`
+	BulkSize    int         `json:"bulk_size"`
+}
+
+// QueuedItem is a single item in the outgoing queue of a channel, usually a batch of msgs
+type QueuedItem struct {
+	HighPriority bool            `json:"high_priority"`
+	AvailableOn  time.Time       `json:"available_on"`
+	Value        json.RawMessage `json:"value"`
+}
+
+const (
+	defaultPeekCount = 10
+	maxPeekCount     = 100
+)
+
+// addAdminRoutes adds the routes of our JSON admin API, these all require the status username and password
+func (s *server) addAdminRoutes() {
+	s.router.Route("/admin", func(r chi.Router) {
+		r.Use(s.adminAuth)
+		r.Get("/queues", s.handleAdminQueues)
+		r.Get("/queues/{uuid}", s.handleAdminPeekQueue)
+		r.Delete("/queues/{uuid}", s.handleAdminPurgeQueue)
+		r.Post("/channels/{uuid}/pause", s.handleAdminPauseChannel)
+		r.Post("/channels/{uuid}/resume", s.handleAdminResumeChannel)
+		r.Post("/channels/{uuid}/drain", s.handleAdminDrainChannel)
+		r.Post("/channels/{uuid}/invalidate", s.handleAdminInvalidateChannel)
+	})
+}
+
+// adminAuth rejects any request without valid credentials, unlike our status page the admin API
+// is never available without a username and password being configured
+func (s *server) adminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if s.config.StatusUsername == "" || !ok || user != s.config.StatusUsername || pass != s.config.StatusPassword {
+			w.Header().Set("WWW-Authenticate", `Basic realm="Authenticate"`)
+			WriteDataResponse(r.Context(), w, http.StatusUnauthorized, "Unauthorized", []interface{}{NewErrorData("invalid credentials")})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type adminQueuesResponse struct {
+	Queues []*ChannelQueue `json:"queues"`
+}
+
+func (s *server) handleAdminQueues(w http.ResponseWriter, r *http.Request) {
+	queues, err := s.backend.Queues(r.Context())
+	if err != nil {
+		writeAdminError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSONResponse(r.Context(), w, http.StatusOK, &adminQueuesResponse{Queues: queues})
+}
+
+type adminPeekResponse struct {
+	ChannelUUID ChannelUUID   `json:"channel_uuid"`
+	Items       []*QueuedItem `json:"items"`
+}
+
+func (s *server) handleAdminPeekQueue(w http.ResponseWriter, r *http.Request) {
+	uuid, err := NewChannelUUID(chi.URLParam(r, "uuid"))
+	if err != nil {
+		writeAdminError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	count := defaultPeekCount
+	if r.URL.Query().Get("count") != "" {
+		count, err = strconv.Atoi(r.URL.Query().Get("count"))
+		if err != nil || count < 1 || count > maxPeekCount {
+			writeAdminError(w, r, http.StatusBadRequest, fmt.Errorf("count must be between 1 and %d", maxPeekCount))
+			return
+		}
+	}
+
+	items, err := s.backend.PeekQueue(r.Context(), uuid, count)
+	if err != nil {
+		writeAdminError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSONResponse(r.Context(), w, http.StatusOK, &adminPeekResponse{ChannelUUID: uuid, Items: items})
+}
+
+type adminPurgeResponse struct {
+	ChannelUUID ChannelUUID `json:"channel_uuid"`
+	Purged      int         `json:"purged"`
+}
+
+func (s *server) handleAdminPurgeQueue(w http.ResponseWriter, r *http.Request) {
+	uuid, err := NewChannelUUID(chi.URLParam(r, "uuid"))
+	if err != nil {
+		writeAdminError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	purged, err := s.backend.PurgeQueue(r.Context(), uuid)
+	if err != nil {
+		writeAdminError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	logrus.WithField("channel_uuid", uuid).WithField("purged", purged).Info("purged channel queue")
+	writeJSONResponse(r.Context(), w, http.StatusOK, &adminPurgeResponse{ChannelUUID: uuid, Purged: purged})
+}
+
+type adminChannelResponse struct {
+	ChannelUUID ChannelUUID `json:"channel_uuid"`
+	Paused      bool        `json:"paused"`
+	Failed      *int        `json:"failed,omitempty"`
+}
+
+func (s *server) handleAdminPauseChannel(w http.ResponseWriter, r *http.Request) {
+	uuid, err := NewChannelUUID(chi.URLParam(r, "uuid"))
+	if err != nil {
+		writeAdminError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	err = s.backend.PauseChannel(r.Context(), uuid)
+	if err != nil {
+		writeAdminError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	logrus.WithField("channel_uuid", uuid).Info("paused channel")
+	writeJSONResponse(r.Context(), w, http.StatusOK, &adminChannelResponse{ChannelUUID: uuid, Paused: true})
+}
+
+func (s *server) handleAdminResumeChannel(w http.ResponseWriter, r *http.Request) {
+	uuid, err := NewChannelUUID(chi.URLParam(r, "uuid"))
+	if err != nil {
+		writeAdminError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	err = s.backend.ResumeChannel(r.Context(), uuid)
+	if err != nil {
+		writeAdminError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	logrus.WithField("channel_uuid", uuid).Info("resumed channel")
+	writeJSONResponse(r.Context(), w, http.StatusOK, &adminChannelResponse{ChannelUUID: uuid, Paused: false})
+}
+
+// handleAdminDrainChannel fails all the msgs queued for a channel, the channel remains paused if it was paused
+func (s *server) handleAdminDrainChannel(w http.ResponseWriter, r *http.Request) {
+	uuid, err := NewChannelUUID(chi.URLParam(r, "uuid"))
+	if err != nil {
+		writeAdminError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	failed, err := s.backend.DrainChannel(r.Context(), uuid)
+	if err != nil {
+		writeAdminError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	paused, err := s.backend.IsChannelPaused(r.Context(), uuid)
+	if err != nil {
+		writeAdminError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	logrus.WithField("channel_uuid", uuid).WithField("failed", failed).Info("drained channel")
+	writeJSONResponse(r.Context(), w, http.StatusOK, &adminChannelResponse{ChannelUUID: uuid, Paused: paused, Failed: &failed})
+}
+
+type adminInvalidateResponse struct {
+	ChannelUUID ChannelUUID `json:"channel_uuid"`
+	Invalidated bool        `json:"invalidated"`
+}
+
+// handleAdminInvalidateChannel clears any cached copies of a channel, called when a channel is changed
+func (s *server) handleAdminInvalidateChannel(w http.ResponseWriter, r *http.Request) {
+	uuid, err := NewChannelUUID(chi.URLParam(r, "uuid"))
+	if err != nil {
+		writeAdminError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	err = s.backend.InvalidateChannel(r.Context(), uuid)
+	if err != nil {
+		writeAdminError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	logrus.WithField("channel_uuid", uuid).Info("invalidated channel")
+	writeJSONResponse(r.Context(), w, http.StatusOK, &adminInvalidateResponse{ChannelUUID: uuid, Invalidated: true})
+}
+
+func writeAdminError(w http.ResponseWriter, r *http.Request, statusCode int, err error) {
+	logrus.WithError(err).WithField("url", r.URL.String()).WithField("method", r.Method).Error("error handling admin request")
+	WriteDataResponse(r.Context(), w, statusCode, http.StatusText(statusCode), []interface{}{NewErrorData(err.Error())})
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/garyburd/redigo/redis"
+	"github.com/go-chi/chi"
 	"github.com/nyaruka/gocommon/urns"
 )
 
@@ -78,8 +80,33 @@ type Backend interface {
 	// Mark a external ID as seen for a period
 	WriteExternalIDSeen(Msg)
 
-	// Health returns a string describing any health problems the backend has, or empty string if all is well
-	Health() string
+	// Queues returns the outgoing queue of each channel which has msgs waiting to be sent
+	Queues(context.Context) ([]*ChannelQueue, error)
+
+	// PeekQueue returns up to the passed in number of items from the head of a channel's outgoing queue
+	PeekQueue(context.Context, ChannelUUID, int) ([]*QueuedItem, error)
+
+	// PurgeQueue removes all items from a channel's outgoing queue, returning the number of items removed
+	PurgeQueue(context.Context, ChannelUUID) (int, error)
+
+	// PauseChannel stops msgs being sent for the passed in channel, queued msgs are kept until it is resumed
+	PauseChannel(context.Context, ChannelUUID) error
+
+	// ResumeChannel resumes sending msgs for a paused channel
+	ResumeChannel(context.Context, ChannelUUID) error
+
+	// IsChannelPaused returns whether sending is currently paused for the passed in channel
+	IsChannelPaused(context.Context, ChannelUUID) (bool, error)
+
+	// DrainChannel removes all msgs from a channel's outgoing queue and marks them as failed, returning the number of msgs failed
+	DrainChannel(context.Context, ChannelUUID) (int, error)
+
+	// InvalidateChannel clears any cached copies of the passed in channel, on this and any other instances, so that
+	// changes to it are picked up on its next use
+	InvalidateChannel(context.Context, ChannelUUID) error
+
+	// Health returns a report on the health of each of the dependencies of this backend
+	Health() *HealthReport
 
 	// Status returns a string describing the current status, this can detail queue sizes or other attributes
 	Status() string
@@ -91,6 +118,33 @@ type Backend interface {
 	RedisPool() *redis.Pool
 }
 
+// RouteProvider is an optional interface backends can implement to expose their own routes, for example an API
+// through which msgs to send can be queued. Routes are added when the server starts, after the backend has started.
+type RouteProvider interface {
+	AddRoutes(chi.Router)
+}
+
+// OutgoingNotifier is an optional interface backends can implement to wake the foreman as soon as msgs are queued
+// to be sent, rather than it finding them on its next poll
+type OutgoingNotifier interface {
+	// OutgoingNotifications returns a channel which receives a value whenever msgs may be ready to be popped
+	OutgoingNotifications() <-chan bool
+}
+
+// MultiPopper is an optional interface backends can implement to pop the msgs for several senders at once
+type MultiPopper interface {
+	// PopNextOutgoingMsgs pops up to count of the next msgs that need to be sent
+	PopNextOutgoingMsgs(ctx context.Context, count int) ([]Msg, error)
+}
+
+// ReadWatermarker is an optional interface backends can implement to mark msgs as read by watermark, as reported by
+// channels such as Facebook whose read receipts only tell us the time up to which a contact has read our msgs
+type ReadWatermarker interface {
+	// WriteMsgsReadBefore marks as read the msgs sent to the passed in URN on the passed in channel before the
+	// watermark, returning the statuses written
+	WriteMsgsReadBefore(ctx context.Context, channel Channel, urn urns.URN, watermark time.Time) ([]MsgStatus, error)
+}
+
 // NewBackend creates the type of backend passed in
 func NewBackend(config *Config) (Backend, error) {
 	backendFunc, found := registeredBackends[strings.ToLower(config.Backend)]
@@ -7,24 +7,22 @@ import (
 	"fmt"
 	"net/url"
 	"path"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/garyburd/redigo/redis"
 	"github.com/jmoiron/sqlx"
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/batch"
 	"github.com/nyaruka/courier/chatbase"
+	"github.com/nyaruka/courier/events"
+	"github.com/nyaruka/courier/metrics"
 	"github.com/nyaruka/courier/queue"
+	"github.com/nyaruka/courier/storage"
 	"github.com/nyaruka/courier/utils"
 	"github.com/nyaruka/gocommon/urns"
-	"github.com/nyaruka/librato"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
@@ -40,11 +38,19 @@ const chatbaseAPIKey = "CHATBASE_API_KEY"
 const chatbaseVersion = "CHATBASE_VERSION"
 const chatbaseMessageType = "msg"
 
+// the subdirectories of our spool directory we write msgs, statuses, events and events we failed to publish to
+var spoolDirs = []string{"msgs", "statuses", "events", publishedSpoolDir}
+
 // our timeout for backend operations
 const backendTimeout = time.Second * 20
 
 func init() {
 	courier.RegisterBackend("rapidpro", newBackend)
+
+	courier.RegisterSpoolParser("msgs", func(contents []byte) error { _, err := parseMsgFile(contents); return err })
+	courier.RegisterSpoolParser("statuses", func(contents []byte) error { _, err := parseStatusFile(contents); return err })
+	courier.RegisterSpoolParser("events", func(contents []byte) error { _, err := parseChannelEventFile(contents); return err })
+	courier.RegisterSpoolParser(publishedSpoolDir, func(contents []byte) error { _, err := parsePublishedFile(contents); return err })
 }
 
 // GetChannel returns the channel for the passed in type and UUID
@@ -52,7 +58,7 @@ func (b *backend) GetChannel(ctx context.Context, ct courier.ChannelType, uuid c
 	timeout, cancel := context.WithTimeout(ctx, backendTimeout)
 	defer cancel()
 
-	return getChannel(timeout, b.db, ct, uuid)
+	return getChannel(timeout, b, ct, uuid)
 }
 
 // GetContact returns the contact for the passed in channel and URN
@@ -78,6 +84,11 @@ func (b *backend) AddURNtoContact(ctx context.Context, c courier.Channel, contac
 		return urns.NilURN, err
 	}
 
+	// this URN may have belonged to another contact
+	rc := b.redisPool.Get()
+	defer rc.Close()
+	clearCachedContacts(rc, dbChannel.OrgID(), urn.Identity().String())
+
 	return urn, nil
 }
 
@@ -98,6 +109,11 @@ func (b *backend) RemoveURNfromContact(ctx context.Context, c courier.Channel, c
 	if err != nil {
 		return urns.NilURN, err
 	}
+
+	rc := b.redisPool.Get()
+	defer rc.Close()
+	clearCachedContacts(rc, dbContact.OrgID_, urn.Identity().String())
+
 	return urn, nil
 }
 
@@ -133,34 +149,90 @@ func (b *backend) PopNextOutgoingMsg(ctx context.Context) (courier.Msg, error) {
 	rc := b.redisPool.Get()
 	defer rc.Close()
 
-	token, msgJSON, err := queue.PopFromQueue(rc, msgQueueName)
-	for token == queue.Retry {
-		token, msgJSON, err = queue.PopFromQueue(rc, msgQueueName)
+	for {
+		token, msgJSON, err := queue.PopFromQueue(rc, msgQueueName)
+		for token == queue.Retry {
+			token, msgJSON, err = queue.PopFromQueue(rc, msgQueueName)
+		}
+
+		if err != nil || msgJSON == "" {
+			return nil, err
+		}
+
+		// if our msg was held until later, try the next one
+		msg, err := b.outgoingMsgFromQueue(ctx, rc, token, msgJSON)
+		if msg != nil || err != nil {
+			return msg, err
+		}
+	}
+}
+
+// PopNextOutgoingMsgs pops up to count of the next messages that need to be sent with a single call to redis, msgs
+// which can't be loaded are logged and skipped
+func (b *backend) PopNextOutgoingMsgs(ctx context.Context, count int) ([]courier.Msg, error) {
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	tokens, msgJSONs, err := queue.PopManyFromQueue(rc, msgQueueName, count)
+	if err != nil {
+		return nil, err
 	}
 
-	if msgJSON != "" {
-		dbMsg := &DBMsg{}
-		err = json.Unmarshal([]byte(msgJSON), dbMsg)
+	msgs := make([]courier.Msg, 0, len(tokens))
+	for i := range tokens {
+		msg, err := b.outgoingMsgFromQueue(ctx, rc, tokens[i], msgJSONs[i])
 		if err != nil {
-			queue.MarkComplete(rc, msgQueueName, token)
-			return nil, fmt.Errorf("unable to unmarshal message '%s': %s", msgJSON, err)
+			logrus.WithError(err).Error("error loading popped msg")
+			continue
 		}
-		// populate the channel on our db msg
-		channel, err := b.GetChannel(ctx, courier.AnyChannelType, dbMsg.ChannelUUID_)
-		if err != nil {
-			queue.MarkComplete(rc, msgQueueName, token)
-			return nil, err
+		if msg != nil {
+			msgs = append(msgs, msg)
 		}
-		dbMsg.channel = channel.(*DBChannel)
-		dbMsg.workerToken = token
+	}
+	return msgs, nil
+}
 
-		// clear out our seen incoming messages
-		clearMsgSeen(rc, dbMsg)
+// outgoingMsgFromQueue loads the msg popped from our queue with the passed in token, if it can't be loaded the
+// task is marked as complete so we don't hold onto its worker. Msgs which shouldn't be sent yet are pushed back onto
+// our queue until they should be, in which case no msg is returned.
+func (b *backend) outgoingMsgFromQueue(ctx context.Context, rc redis.Conn, token queue.WorkerToken, msgJSON string) (courier.Msg, error) {
+	dbMsg := &DBMsg{}
+	err := json.Unmarshal([]byte(msgJSON), dbMsg)
+	if err != nil {
+		queue.MarkComplete(rc, msgQueueName, token)
+		return nil, fmt.Errorf("unable to unmarshal message '%s': %s", msgJSON, err)
+	}
 
-		return dbMsg, nil
+	if dbMsg.SendAfter_ != nil && dbMsg.SendAfter_.After(time.Now()) {
+		priority := queue.Priority(queue.LowPriority)
+		if dbMsg.HighPriority_ {
+			priority = queue.HighPriority
+		}
+
+		err := queue.Requeue(rc, msgQueueName, token, "["+msgJSON+"]", priority, *dbMsg.SendAfter_)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error requeuing msg %d until %s", dbMsg.ID_, dbMsg.SendAfter_)
+		}
+		return nil, nil
 	}
+	// populate the channel on our db msg
+	channel, err := b.GetChannel(ctx, courier.AnyChannelType, dbMsg.ChannelUUID_)
+	if err != nil {
+		queue.MarkComplete(rc, msgQueueName, token)
+		return nil, err
+	}
+	dbMsg.channel = channel.(*DBChannel)
+	dbMsg.workerToken = token
+
+	// clear out our seen incoming messages
+	clearMsgSeen(rc, dbMsg)
 
-	return nil, nil
+	return dbMsg, nil
+}
+
+// OutgoingNotifications returns the chan we notify whenever messages are pushed onto our queue
+func (b *backend) OutgoingNotifications() <-chan bool {
+	return b.outgoingNotify
 }
 
 var luaSent = redis.NewScript(3,
@@ -192,6 +264,11 @@ func (b *backend) MarkOutgoingMsgComplete(ctx context.Context, msg courier.Msg,
 
 	queue.MarkComplete(rc, msgQueueName, dbMsg.workerToken)
 
+	// update the circuit breaker for our channel
+	if status != nil && b.config.BreakerThreshold > 0 {
+		b.recordBreakerOutcome(rc, msg.Channel(), courier.NewBreakerOutcome(status))
+	}
+
 	// mark as sent in redis as well if this was actually wired or sent
 	if status != nil && (status.Status() == courier.MsgSent || status.Status() == courier.MsgWired) {
 		dateKey := fmt.Sprintf(sentSetName, time.Now().UTC().Format("2006_01_02"))
@@ -276,6 +353,28 @@ func (b *backend) WriteMsgStatus(ctx context.Context, status courier.MsgStatus)
 	return nil
 }
 
+// WriteMsgsReadBefore marks as read the msgs sent to the passed in URN on the passed in channel before the watermark
+func (b *backend) WriteMsgsReadBefore(ctx context.Context, channel courier.Channel, urn urns.URN, watermark time.Time) ([]courier.MsgStatus, error) {
+	timeout, cancel := context.WithTimeout(ctx, backendTimeout)
+	defer cancel()
+
+	ids, err := selectMsgsSentBefore(timeout, b, channel.(*DBChannel), urn, watermark)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error looking up msgs sent before read watermark")
+	}
+
+	statuses := make([]courier.MsgStatus, 0, len(ids))
+	for _, id := range ids {
+		status := b.NewMsgStatusForID(channel, id, courier.MsgRead)
+		err = b.WriteMsgStatus(ctx, status)
+		if err != nil {
+			return statuses, err
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
 // NewChannelEvent creates a new channel event with the passed in parameters
 func (b *backend) NewChannelEvent(channel courier.Channel, eventType courier.ChannelEventType, urn urns.URN) courier.ChannelEvent {
 	return newChannelEvent(channel, eventType, urn)
@@ -299,6 +398,7 @@ func (b *backend) WriteChannelLogs(ctx context.Context, logs []*courier.ChannelL
 		if err != nil {
 			logrus.WithError(err).Error("error writing channel log")
 		}
+		b.publishEvent(events.TypeChannelLog, newChannelLogData(l))
 	}
 	return nil
 }
@@ -320,30 +420,73 @@ func (b *backend) WriteExternalIDSeen(msg courier.Msg) {
 	writeExternalIDSeen(b, msg)
 }
 
-// Health returns the health of this backend as a string, returning "" if all is well
-func (b *backend) Health() string {
-	// test redis
-	rc := b.redisPool.Get()
-	defer rc.Close()
-	_, redisErr := rc.Do("PING")
+// Health returns a report on the health of our db, redis, s3 and spool
+func (b *backend) Health() *courier.HealthReport {
+	health := courier.NewHealthReport()
 
 	// test our db
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-	dbErr := b.db.PingContext(ctx)
+	health.Add("db", b.db.PingContext(ctx))
 	cancel()
 
-	health := bytes.Buffer{}
-	if redisErr != nil {
-		health.WriteString(fmt.Sprintf("\n% 16s: %v", "redis err", redisErr))
+	// test redis
+	rc := b.redisPool.Get()
+	_, redisErr := rc.Do("PING")
+	rc.Close()
+	health.Add("redis", redisErr)
+
+	// add the checks of our media storage and spool, which are only degraded if they fail
+	for _, check := range b.storageHealth() {
+		health.AddCheck(check)
+	}
+
+	return health
+}
+
+// how long we cache the checks of our media storage and spool for, as they are too expensive to run on every probe
+const storageHealthTTL = time.Second * 30
+
+// storageHealth returns the checks of our media storage and spool directories, these write files and look at every
+// spooled file so are cached. We can keep receiving if they fail, writing to our spool or with attachments as
+// placeholders, so they are optional.
+func (b *backend) storageHealth() []*courier.HealthCheck {
+	b.storageHealthMutex.Lock()
+	defer b.storageHealthMutex.Unlock()
+
+	if b.storageHealthChecks != nil && time.Since(b.storageHealthCheckedOn) < storageHealthTTL {
+		return b.storageHealthChecks
 	}
-	if dbErr != nil {
-		health.WriteString(fmt.Sprintf("\n% 16s: %v", "db err", dbErr))
+
+	health := courier.NewHealthReport()
+
+	// test our media storage
+	health.AddOptional(b.mediaStorage.Name(), b.mediaStorage.Test())
+
+	// test that our spool directories are writable and check how many files are waiting to be flushed
+	spoolErr := error(nil)
+	backlog := 0
+	dead := 0
+	oldest := time.Duration(0)
+	for _, subdir := range spoolDirs {
+		dir := path.Join(b.config.SpoolDir, subdir)
+		if spoolErr == nil {
+			spoolErr = courier.CheckSpoolDirWritable(dir)
+		}
+		backlog += courier.SpoolBacklogSize(dir)
+		dead += courier.SpoolDeadSize(dir)
+		if age := courier.SpoolOldestAge(dir); age > oldest {
+			oldest = age
+		}
 	}
+	health.AddOptional("spool", spoolErr)
+	health.AddOptional("spool_backlog", nil).WithDetail("files", backlog).WithDetail("dead_files", dead).WithDetail("oldest_age", int(oldest.Seconds()))
 
-	return health.String()
+	b.storageHealthChecks = health.Checks
+	b.storageHealthCheckedOn = time.Now()
+	return b.storageHealthChecks
 }
 
-// Heartbeat is called every minute, we log our queue depth to librato
+// Heartbeat is called every minute, we report our queue depths to our metrics sinks
 func (b *backend) Heartbeat() error {
 	rc := b.redisPool.Get()
 	defer rc.Close()
@@ -356,31 +499,60 @@ func (b *backend) Heartbeat() error {
 	if err != nil {
 		return errors.Wrapf(err, "error getting throttled queues")
 	}
-	queues := append(active, throttled...)
+	saturated, err := redis.Strings(rc.Do("zrange", fmt.Sprintf("%s:saturated", msgQueueName), "0", "-1"))
+	if err != nil {
+		return errors.Wrapf(err, "error getting saturated queues")
+	}
 
 	prioritySize := 0
 	bulkSize := 0
-	for _, queue := range queues {
-		q := fmt.Sprintf("%s/1", queue)
-		count, err := redis.Int(rc.Do("zcard", q))
-		if err != nil {
-			return errors.Wrapf(err, "error getting size of priority queue: %s", q)
-		}
-		prioritySize += count
+	for state, queues := range map[string][]string{"active": active, "throttled": throttled, "saturated": saturated} {
+		statePrioritySize := 0
+		stateBulkSize := 0
+		for _, queue := range queues {
+			q := fmt.Sprintf("%s/1", queue)
+			count, err := redis.Int(rc.Do("zcard", q))
+			if err != nil {
+				return errors.Wrapf(err, "error getting size of priority queue: %s", q)
+			}
+			statePrioritySize += count
 
-		q = fmt.Sprintf("%s/0", queue)
-		count, err = redis.Int(rc.Do("zcard", q))
-		if err != nil {
-			return errors.Wrapf(err, "error getting size of bulk queue: %s", q)
+			q = fmt.Sprintf("%s/0", queue)
+			count, err = redis.Int(rc.Do("zcard", q))
+			if err != nil {
+				return errors.Wrapf(err, "error getting size of bulk queue: %s", q)
+			}
+			stateBulkSize += count
 		}
-		bulkSize += count
+
+		metrics.Gauge("queues", metrics.Labels{"state": state}, float64(len(queues)))
+		metrics.Gauge("queue_size", metrics.Labels{"state": state, "priority": "high"}, float64(statePrioritySize))
+		metrics.Gauge("queue_size", metrics.Labels{"state": state, "priority": "bulk"}, float64(stateBulkSize))
+
+		prioritySize += statePrioritySize
+		bulkSize += stateBulkSize
 	}
 
 	// log our total
-	librato.Gauge("courier.bulk_queue", float64(bulkSize))
-	librato.Gauge("courier.priority_queue", float64(prioritySize))
+	metrics.Gauge("bulk_queue", nil, float64(bulkSize))
+	metrics.Gauge("priority_queue", nil, float64(prioritySize))
 	logrus.WithField("bulk_queue", bulkSize).WithField("priority_queue", prioritySize).Info("heartbeat queue sizes calculated")
 
+	// report how many channels currently have open or half open circuit breakers
+	breakers, err := queue.ListBreakers(rc, msgQueueName)
+	if err != nil {
+		return errors.Wrapf(err, "error getting circuit breakers")
+	}
+	breakerCounts := map[queue.BreakerState]int{queue.BreakerOpen: 0, queue.BreakerHalfOpen: 0}
+	for _, breaker := range breakers {
+		if breaker.State != queue.BreakerClosed {
+			breakerCounts[breaker.State]++
+		}
+	}
+	for state, count := range breakerCounts {
+		metrics.Gauge("breakers", metrics.Labels{"state": string(state)}, float64(count))
+	}
+
 	return nil
 }
 
@@ -394,12 +566,15 @@ func (b *backend) Status() string {
 	status.WriteString("     Size | Bulk Size | Workers | TPS | Type | Channel              \n")
 	status.WriteString("------------------------------------------------------------------------------------\n")
 
-	var queue string
+	var queueName string
 	var workers float64
 
 	// get all our queues
 	rc.Send("zrevrangebyscore", fmt.Sprintf("%s:active", msgQueueName), "+inf", "-inf", "withscores")
 	rc.Send("zrevrangebyscore", fmt.Sprintf("%s:throttled", msgQueueName), "+inf", "-inf", "withscores")
+	rc.Send("zrevrangebyscore", fmt.Sprintf("%s:saturated", msgQueueName), "+inf", "-inf", "withscores")
+	rc.Send("zrevrangebyscore", fmt.Sprintf("%s:paused", msgQueueName), "+inf", "-inf", "withscores")
+	rc.Send("zrevrangebyscore", fmt.Sprintf("%s:broken", msgQueueName), "+inf", "-inf", "withscores")
 	rc.Flush()
 
 	active, err := redis.Values(rc.Receive())
@@ -410,39 +585,51 @@ func (b *backend) Status() string {
 	if err != nil {
 		return fmt.Sprintf("unable to read throttled queues: %v", err)
 	}
-	values := append(active, throttled...)
+	saturated, err := redis.Values(rc.Receive())
+	if err != nil {
+		return fmt.Sprintf("unable to read saturated queues: %v", err)
+	}
+	paused, err := redis.Values(rc.Receive())
+	if err != nil {
+		return fmt.Sprintf("unable to read paused queues: %v", err)
+	}
+	broken, err := redis.Values(rc.Receive())
+	if err != nil {
+		return fmt.Sprintf("unable to read broken queues: %v", err)
+	}
+	values := append(append(append(append(active, throttled...), saturated...), paused...), broken...)
 
 	for len(values) > 0 {
-		values, err = redis.Scan(values, &queue, &workers)
+		values, err = redis.Scan(values, &queueName, &workers)
 		if err != nil {
 			return fmt.Sprintf("error reading active queues: %v", err)
 		}
 
 		// our queue name is in the format msgs:uuid|tps, break it apart
-		queue = strings.TrimPrefix(queue, "msgs:")
-		parts := strings.Split(queue, "|")
+		queueName = strings.TrimPrefix(queueName, "msgs:")
+		parts := strings.Split(queueName, "|")
 		if len(parts) != 2 {
-			return fmt.Sprintf("error parsing queue name '%s'", queue)
+			return fmt.Sprintf("error parsing queue name '%s'", queueName)
 		}
 		uuid := parts[0]
 		tps := parts[1]
 
 		// try to look up our channel
 		channelUUID, _ := courier.NewChannelUUID(uuid)
-		channel, err := getChannel(context.Background(), b.db, courier.AnyChannelType, channelUUID)
+		channel, err := getChannel(context.Background(), b, courier.AnyChannelType, channelUUID)
 		channelType := "!!"
 		if err == nil {
 			channelType = channel.ChannelType().String()
 		}
 
 		// get # of items in our normal queue
-		size, err := redis.Int64(rc.Do("zcard", fmt.Sprintf("%s:%s/1", msgQueueName, queue)))
+		size, err := redis.Int64(rc.Do("zcard", fmt.Sprintf("%s:%s/1", msgQueueName, queueName)))
 		if err != nil {
 			return fmt.Sprintf("error reading queue size: %v", err)
 		}
 
 		// get # of items in the bulk queue
-		bulkSize, err := redis.Int64(rc.Do("zcard", fmt.Sprintf("%s:%s/0", msgQueueName, queue)))
+		bulkSize, err := redis.Int64(rc.Do("zcard", fmt.Sprintf("%s:%s/0", msgQueueName, queueName)))
 		if err != nil {
 			return fmt.Sprintf("error reading bulk queue size: %v", err)
 		}
@@ -450,9 +637,234 @@ func (b *backend) Status() string {
 		status.WriteString(fmt.Sprintf("% 9d   % 9d   % 7d   % 3s   % 4s   %s\n", size, bulkSize, int(workers), tps, channelType, uuid))
 	}
 
+	// list any channels whose circuit breakers aren't closed
+	breakers, err := queue.ListBreakers(rc, msgQueueName)
+	if err != nil {
+		return fmt.Sprintf("unable to read circuit breakers: %v", err)
+	}
+	breakerUUIDs := make([]string, 0, len(breakers))
+	for uuid, breaker := range breakers {
+		if breaker.State != queue.BreakerClosed {
+			breakerUUIDs = append(breakerUUIDs, uuid)
+		}
+	}
+	sort.Strings(breakerUUIDs)
+	if len(breakerUUIDs) > 0 {
+		status.WriteString("------------------------------------------------------------------------------------\n")
+		status.WriteString(" Breaker   | Failures | Open Until           | Channel\n")
+		status.WriteString("------------------------------------------------------------------------------------\n")
+		for _, uuid := range breakerUUIDs {
+			breaker := breakers[uuid]
+			status.WriteString(fmt.Sprintf(" % 9s   % 8d   %s   %s\n", breaker.State, breaker.Failures, breaker.OpenUntil.UTC().Format("2006-01-02 15:04:05"), uuid))
+		}
+	}
+
+	// list the orgs currently being sent for and any which have been weighted
+	orgs, err := queue.ListOrgs(rc, msgQueueName)
+	if err != nil {
+		return fmt.Sprintf("unable to read org workers: %v", err)
+	}
+	if len(orgs) > 0 {
+		status.WriteString("------------------------------------------------------------------------------------\n")
+		status.WriteString(" Workers | Weight | Org\n")
+		status.WriteString("------------------------------------------------------------------------------------\n")
+		for _, org := range orgs {
+			status.WriteString(fmt.Sprintf(" % 7d   % 6.2f   %s\n", org.Workers, org.Weight, org.Org))
+		}
+	}
+
+	// list any paused channels, these may or may not have queued msgs
+	pausedChannels, err := queue.PausedQueues(rc, msgQueueName)
+	if err != nil {
+		return fmt.Sprintf("unable to read paused channels: %v", err)
+	}
+	if len(pausedChannels) > 0 {
+		status.WriteString("------------------------------------------------------------------------------------\n")
+		status.WriteString(" Paused Channels\n")
+		status.WriteString("------------------------------------------------------------------------------------\n")
+		for _, uuid := range pausedChannels {
+			status.WriteString(fmt.Sprintf(" %s\n", uuid))
+		}
+	}
+
 	return status.String()
 }
 
+// recordBreakerOutcome records the outcome of a send against the circuit breaker of the passed in channel
+func (b *backend) recordBreakerOutcome(rc redis.Conn, channel courier.Channel, outcome courier.BreakerOutcome) {
+	log := logrus.WithField("channel_uuid", channel.UUID()).WithField("channel_type", channel.ChannelType())
+	labels := metrics.ChannelType(string(channel.ChannelType()))
+
+	switch outcome {
+	case courier.BreakerFailure:
+		cooldown := time.Duration(b.config.BreakerCooldown) * time.Second
+		probeTimeout := courier.GetSendLimits(channel).Timeout
+		opened, err := queue.RecordFailure(rc, msgQueueName, channel.UUID().String(), b.config.BreakerThreshold, cooldown, probeTimeout)
+		if err != nil {
+			log.WithError(err).Error("error recording circuit breaker failure")
+		} else if opened {
+			log.WithField("cooldown", cooldown).Warning("circuit breaker opened, sending stopped")
+			metrics.Count("breaker_opened", labels, 1)
+		}
+
+	case courier.BreakerSuccess:
+		closed, err := queue.RecordSuccess(rc, msgQueueName, channel.UUID().String())
+		if err != nil {
+			log.WithError(err).Error("error recording circuit breaker success")
+		} else if closed {
+			log.Info("circuit breaker closed, sending resumed")
+			metrics.Count("breaker_closed", labels, 1)
+		}
+	}
+}
+
+// Queues returns the outgoing queue of each channel which has msgs waiting to be sent
+func (b *backend) Queues(ctx context.Context) ([]*courier.ChannelQueue, error) {
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	infos, err := queue.ListQueues(rc, msgQueueName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error listing queues")
+	}
+
+	queues := make([]*courier.ChannelQueue, 0, len(infos))
+	for _, info := range infos {
+		channelUUID, err := courier.NewChannelUUID(info.Queue)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid channel uuid for queue '%s'", info.Queue)
+		}
+
+		// try to look up our channel type
+		channelType := courier.ChannelType("!!")
+		channel, err := getChannel(ctx, b, courier.AnyChannelType, channelUUID)
+		if err == nil {
+			channelType = channel.ChannelType()
+		}
+
+		queues = append(queues, &courier.ChannelQueue{
+			ChannelUUID: channelUUID,
+			ChannelType: channelType,
+			State:       info.State,
+			TPS:         info.TPS,
+			Workers:     info.Workers,
+			Paused:      info.Paused,
+			Breaker:     string(info.Breaker),
+			Size:        info.Size,
+			BulkSize:    info.BulkSize,
+		})
+	}
+
+	return queues, nil
+}
+
+// PeekQueue returns up to the passed in number of items from the head of a channel's outgoing queue
+func (b *backend) PeekQueue(ctx context.Context, channelUUID courier.ChannelUUID, count int) ([]*courier.QueuedItem, error) {
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	peeked, err := queue.PeekQueue(rc, msgQueueName, channelUUID.String(), count)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error peeking queue for channel %s", channelUUID)
+	}
+
+	items := make([]*courier.QueuedItem, 0, len(peeked))
+	for _, p := range peeked {
+		items = append(items, &courier.QueuedItem{
+			HighPriority: p.Priority == queue.HighPriority,
+			AvailableOn:  time.Unix(0, int64(p.Score*float64(time.Second))).UTC(),
+			Value:        json.RawMessage(p.Value),
+		})
+	}
+
+	return items, nil
+}
+
+// PurgeQueue removes all items from a channel's outgoing queue, returning the number of items removed
+func (b *backend) PurgeQueue(ctx context.Context, channelUUID courier.ChannelUUID) (int, error) {
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	purged, err := queue.PurgeQueue(rc, msgQueueName, channelUUID.String())
+	if err != nil {
+		return 0, errors.Wrapf(err, "error purging queue for channel %s", channelUUID)
+	}
+	return purged, nil
+}
+
+// PauseChannel stops msgs being sent for the passed in channel, queued msgs are kept until it is resumed
+func (b *backend) PauseChannel(ctx context.Context, channelUUID courier.ChannelUUID) error {
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	return queue.PauseQueue(rc, msgQueueName, channelUUID.String())
+}
+
+// ResumeChannel resumes sending msgs for a paused channel
+func (b *backend) ResumeChannel(ctx context.Context, channelUUID courier.ChannelUUID) error {
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	return queue.ResumeQueue(rc, msgQueueName, channelUUID.String())
+}
+
+// IsChannelPaused returns whether sending is currently paused for the passed in channel
+func (b *backend) IsChannelPaused(ctx context.Context, channelUUID courier.ChannelUUID) (bool, error) {
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	return queue.IsQueuePaused(rc, msgQueueName, channelUUID.String())
+}
+
+// InvalidateChannel clears the passed in channel from our shared cache and the local caches of all instances
+func (b *backend) InvalidateChannel(ctx context.Context, channelUUID courier.ChannelUUID) error {
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	err := invalidateChannel(rc, channelUUID)
+	if err != nil {
+		return errors.Wrapf(err, "error invalidating channel %s", channelUUID)
+	}
+	return nil
+}
+
+// DrainChannel removes all msgs from a channel's outgoing queue and marks them as failed, returning the number of msgs failed
+func (b *backend) DrainChannel(ctx context.Context, channelUUID courier.ChannelUUID) (int, error) {
+	channel, err := getChannel(ctx, b, courier.AnyChannelType, channelUUID)
+	if err != nil {
+		return 0, errors.Wrapf(err, "error looking up channel %s", channelUUID)
+	}
+
+	rc := b.redisPool.Get()
+	items, err := queue.DrainQueue(rc, msgQueueName, channelUUID.String())
+	rc.Close()
+	if err != nil {
+		return 0, errors.Wrapf(err, "error draining queue for channel %s", channelUUID)
+	}
+
+	failed := 0
+	for _, item := range items {
+		msgs := make([]struct {
+			ID courier.MsgID `json:"id"`
+		}, 0)
+		err := json.Unmarshal([]byte(item.Value), &msgs)
+		if err != nil {
+			logrus.WithError(err).WithField("channel_uuid", channelUUID).WithField("value", item.Value).Error("unable to parse drained queue item")
+			continue
+		}
+
+		for _, msg := range msgs {
+			err := b.WriteMsgStatus(ctx, b.NewMsgStatusForID(channel, msg.ID, courier.MsgFailed))
+			if err != nil {
+				return failed, errors.Wrapf(err, "error failing msg %s", msg.ID)
+			}
+			failed++
+		}
+	}
+
+	return failed, nil
+}
+
 // Start starts our RapidPro backend, this tests our various connections and starts our spool flushers
 func (b *backend) Start() error {
 	// parse and test our redis config
@@ -493,6 +905,9 @@ func (b *backend) Start() error {
 		log.Info("db ok")
 	}
 
+	// only write the errors of failed sends if RapidPro's msgs_msg table has been migrated to have columns for them
+	b.getStatusSQL()
+
 	// parse and test our redis config
 	redisURL, err := url.Parse(b.config.Redis)
 	if err != nil {
@@ -539,39 +954,41 @@ func (b *backend) Start() error {
 		log.Info("redis ok")
 	}
 
+	// listen for changed channels so we don't keep using stale copies of them
+	startChannelInvalidator(b)
+
 	// start our dethrottler if we are going to be doing some sending
 	if b.config.MaxWorkers > 0 {
+		// set the weights of orgs when sharing our workers between them
+		err = queue.SetOrgWeights(conn, msgQueueName, b.config.ParseOrgWeights())
+		if err != nil {
+			log.WithError(err).Error("error setting org weights")
+		}
+
 		queue.StartDethrottler(redisPool, b.stopChan, b.waitGroup, msgQueueName)
+		queue.StartNotifier(redisPool, b.stopChan, b.waitGroup, msgQueueName, b.outgoingNotify)
 	}
 
-	// create our s3 client
-	s3Session, err := session.NewSession(&aws.Config{
-		Credentials:      credentials.NewStaticCredentials(b.config.AWSAccessKeyID, b.config.AWSSecretAccessKey, ""),
-		Endpoint:         aws.String(b.config.S3Endpoint),
-		Region:           aws.String(b.config.S3Region),
-		DisableSSL:       aws.Bool(b.config.S3DisableSSL),
-		S3ForcePathStyle: aws.Bool(b.config.S3ForcePathStyle),
-	})
+	// create the storage we write attachments to
+	b.mediaStorage, err = newMediaStorage(b.config)
 	if err != nil {
 		return err
 	}
-	b.s3Client = s3.New(s3Session)
 
-	// test out our S3 credentials
-	err = utils.TestS3(b.s3Client, b.config.S3MediaBucket)
+	// test out our media storage
+	err = b.mediaStorage.Test()
 	if err != nil {
-		log.WithError(err).Error("s3 bucket not reachable")
+		log.WithError(err).WithField("storage", b.mediaStorage.Name()).Error("media storage not accessible")
 	} else {
-		log.Info("s3 bucket ok")
+		log.WithField("storage", b.mediaStorage.Name()).Info("media storage ok")
 	}
 
 	// make sure our spool dirs are writable
-	err = courier.EnsureSpoolDirPresent(b.config.SpoolDir, "msgs")
-	if err == nil {
-		err = courier.EnsureSpoolDirPresent(b.config.SpoolDir, "statuses")
-	}
-	if err == nil {
-		err = courier.EnsureSpoolDirPresent(b.config.SpoolDir, "events")
+	for _, subdir := range spoolDirs {
+		err = courier.EnsureSpoolDirPresent(b.config.SpoolDir, subdir)
+		if err != nil {
+			break
+		}
 	}
 	if err != nil {
 		log.WithError(err).Error("spool directories not writable")
@@ -580,13 +997,23 @@ func (b *backend) Start() error {
 	}
 
 	// create our status committer and start it
-	b.statusCommitter = batch.NewCommitter("status committer", b.db, bulkUpdateMsgStatusSQL, time.Millisecond*500, b.committerWG,
+	b.statusCommitter = batch.NewUpdateCommitter("status committer", b.db, func() string { return b.getStatusSQL().bulkUpdateMsgStatus }, time.Millisecond*500, b.committerWG,
 		func(err error, value batch.Value) {
 			logrus.WithField("comp", "status committer").WithError(err).Error("error writing status")
 			err = courier.WriteToSpool(b.config.SpoolDir, "statuses", value)
 			if err != nil {
 				logrus.WithField("comp", "status committer").WithError(err).Error("error writing status to spool")
 			}
+		},
+		func(value batch.Value) {
+			b.publishEvent(events.TypeMsgStatus, courier.NewStatusData(value.(*DBMsgStatus)))
+		},
+		func(value batch.Value) {
+			// statuses which weren't applied are either for msgs which no longer exist or would have regressed them
+			err := checkStatusNotApplied(context.Background(), b, value.(*DBMsgStatus))
+			if err != nil && err != courier.ErrMsgNotFound {
+				logrus.WithField("comp", "status committer").WithError(err).Error("error checking skipped status")
+			}
 		})
 	b.statusCommitter.Start()
 
@@ -597,6 +1024,31 @@ func (b *backend) Start() error {
 		})
 	b.logCommitter.Start()
 
+	// create our incoming msg inserter and start it
+	b.msgInserter = batch.NewInserter("msg inserter", b.db, insertMsgSQL, "uuid", backendTimeout, b.committerWG,
+		func(values []batch.Value) { queueInsertedMsgs(b, values) })
+	b.msgInserter.Start()
+
+	// create our fanout to our message bus and start it
+	if b.config.EventsURL != "" {
+		publisher, err := events.NewPublisher(b.config.EventsURL)
+		if err != nil {
+			return err
+		}
+
+		b.fanout = events.NewFanout(publisher, b.config.EventsPrefix, b.fanoutWG,
+			func(err error, event *events.Event) {
+				logrus.WithField("comp", "fanout").WithError(err).Error("error publishing event")
+				err = courier.WriteToSpool(b.config.SpoolDir, publishedSpoolDir, event)
+				if err != nil {
+					logrus.WithField("comp", "fanout").WithError(err).Error("error writing event to spool")
+				}
+			})
+		b.fanout.Start()
+
+		courier.RegisterFlusher(path.Join(b.config.SpoolDir, publishedSpoolDir), b.flushPublishedFile)
+	}
+
 	// register and start our spool flushers
 	courier.RegisterFlusher(path.Join(b.config.SpoolDir, "msgs"), b.flushMsgFile)
 	courier.RegisterFlusher(path.Join(b.config.SpoolDir, "statuses"), b.flushStatusFile)
@@ -631,9 +1083,20 @@ func (b *backend) Cleanup() error {
 		b.logCommitter.Stop()
 	}
 
+	// stop our msg inserter
+	if b.msgInserter != nil {
+		b.msgInserter.Stop()
+	}
+
 	// wait for them to flush fully
 	b.committerWG.Wait()
 
+	// only then stop our fanout, as flushing can publish events, and wait for it to flush too
+	if b.fanout != nil {
+		b.fanout.Stop()
+		b.fanoutWG.Wait()
+	}
+
 	// close our db and redis pool
 	if b.db != nil {
 		b.db.Close()
@@ -654,7 +1117,9 @@ func newBackend(config *courier.Config) courier.Backend {
 		stopChan:  make(chan bool),
 		waitGroup: &sync.WaitGroup{},
 
-		committerWG: &sync.WaitGroup{},
+		committerWG:    &sync.WaitGroup{},
+		fanoutWG:       &sync.WaitGroup{},
+		outgoingNotify: make(chan bool, 1),
 	}
 }
 
@@ -663,14 +1128,25 @@ type backend struct {
 
 	statusCommitter batch.Committer
 	logCommitter    batch.Committer
+	msgInserter     batch.Inserter
 	committerWG     *sync.WaitGroup
+	fanout          events.Fanout
+	fanoutWG        *sync.WaitGroup
+
+	db           *sqlx.DB
+	redisPool    *redis.Pool
+	mediaStorage storage.MediaStorage
+
+	statusSQLMutex     sync.Mutex
+	statusSQL          *statusSQL
+	statusSQLCheckedOn time.Time
 
-	db        *sqlx.DB
-	redisPool *redis.Pool
-	s3Client  s3iface.S3API
-	awsCreds  *credentials.Credentials
+	storageHealthMutex     sync.Mutex
+	storageHealthChecks    []*courier.HealthCheck
+	storageHealthCheckedOn time.Time
 
-	popScript *redis.Script
+	popScript      *redis.Script
+	outgoingNotify chan bool
 
 	stopChan  chan bool
 	waitGroup *sync.WaitGroup
@@ -2,12 +2,15 @@ package rapidpro
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
 	"strings"
 	"sync"
 	"testing"
@@ -18,8 +21,11 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/garyburd/redigo/redis"
+	"github.com/go-chi/chi"
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/queue"
+	"github.com/nyaruka/courier/storage"
+	"github.com/nyaruka/courier/utils"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/nyaruka/null"
 	"github.com/sirupsen/logrus"
@@ -47,6 +53,7 @@ func testConfig() *courier.Config {
 	config := courier.NewConfig()
 	config.DB = "postgres://courier@localhost/courier_test?sslmode=disable"
 	config.Redis = "redis://localhost:6379/0"
+	config.MediaSecret = "sesame"
 	return config
 }
 
@@ -78,7 +85,7 @@ func (ts *BackendTestSuite) SetupSuite() {
 	r.Do("FLUSHDB")
 
 	// plug in our mock s3 client
-	ts.b.s3Client = &mockS3Client{}
+	ts.b.mediaStorage = storage.NewS3Storage(&mockS3Client{}, "courier-media", "/media/", storage.NewURLSigner("localhost", "sesame", 0), 0)
 }
 
 func (ts *BackendTestSuite) TearDownSuite() {
@@ -410,6 +417,87 @@ func (ts *BackendTestSuite) TestContactURN() {
 	ts.Equal(contact2.URNID_, contact3.URNID_)
 }
 
+func (ts *BackendTestSuite) TestContactCache() {
+	knChannel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	twChannel := ts.getChannel("TW", "dbc126ed-66bc-4e28-b67b-81dc3327c96a")
+	urn, _ := urns.NewTelURNForCountry("12065551533", "US")
+
+	ctx := context.Background()
+	rc := ts.b.redisPool.Get()
+	defer rc.Close()
+
+	// nothing cached before we've seen this URN
+	ts.Nil(getCachedContact(rc, knChannel.OrgID_, knChannel, urn, ""))
+
+	contact, err := contactForURN(ctx, ts.b, knChannel.OrgID_, knChannel, urn, "", "Bob")
+	ts.NoError(err)
+	ts.True(contact.IsNew_)
+
+	// now it is, but only for the same channel and auth
+	cached := getCachedContact(rc, knChannel.OrgID_, knChannel, urn, "")
+	ts.NotNil(cached)
+	ts.Equal(contact.ID_, cached.ID_)
+	ts.Equal(contact.UUID_, cached.UUID_)
+	ts.Equal(contact.URNID_, cached.URNID_)
+	ts.Equal(null.String("Bob"), cached.Name_)
+	ts.Nil(getCachedContact(rc, twChannel.OrgID_, twChannel, urn, ""))
+	ts.Nil(getCachedContact(rc, knChannel.OrgID_, knChannel, urn, "sesame"))
+
+	// looking up our contact again uses our cache
+	contact2, err := contactForURN(ctx, ts.b, knChannel.OrgID_, knChannel, urn, "", "")
+	ts.NoError(err)
+	ts.False(contact2.IsNew_)
+	ts.Equal(contact.ID_, contact2.ID_)
+
+	// seeing it on another channel updates the URN and caches it for that channel instead
+	contact3, err := contactForURN(ctx, ts.b, twChannel.OrgID_, twChannel, urn, "", "")
+	ts.NoError(err)
+	ts.Equal(contact.ID_, contact3.ID_)
+	ts.Nil(getCachedContact(rc, knChannel.OrgID_, knChannel, urn, ""))
+	ts.NotNil(getCachedContact(rc, twChannel.OrgID_, twChannel, urn, ""))
+
+	// adding a URN to a contact clears it, as it may have belonged to another contact
+	urn2, _ := urns.NewTelURNForCountry("12065551534", "US")
+	_, err = contactForURN(ctx, ts.b, twChannel.OrgID_, twChannel, urn2, "", "")
+	ts.NoError(err)
+	ts.NotNil(getCachedContact(rc, twChannel.OrgID_, twChannel, urn2, ""))
+
+	_, err = ts.b.AddURNtoContact(ctx, twChannel, contact, urn2)
+	ts.NoError(err)
+	ts.Nil(getCachedContact(rc, twChannel.OrgID_, twChannel, urn2, ""))
+
+	// as does removing one
+	_, err = ts.b.RemoveURNfromContact(ctx, twChannel, contact, urn)
+	ts.NoError(err)
+	ts.Nil(getCachedContact(rc, twChannel.OrgID_, twChannel, urn, ""))
+
+	// changing the default URN of a contact clears its other URNs
+	_, err = contactForURN(ctx, ts.b, twChannel.OrgID_, twChannel, urn2, "", "")
+	ts.NoError(err)
+	ts.NotNil(getCachedContact(rc, twChannel.OrgID_, twChannel, urn2, ""))
+
+	urn3, _ := urns.NewTelURNForCountry("12065551535", "US")
+	_, err = ts.b.AddURNtoContact(ctx, knChannel, contact, urn3)
+	ts.NoError(err)
+	_, err = contactForURN(ctx, ts.b, knChannel.OrgID_, knChannel, urn3, "", "")
+	ts.NoError(err)
+	ts.Nil(getCachedContact(rc, twChannel.OrgID_, twChannel, urn2, ""))
+
+	// cached contacts which are changed outside of courier aren't used
+	contact4, err := contactForURN(ctx, ts.b, knChannel.OrgID_, knChannel, urn3, "", "")
+	ts.NoError(err)
+	ts.NotNil(getCachedContact(rc, knChannel.OrgID_, knChannel, urn3, ""))
+	ts.b.db.MustExec(`UPDATE contacts_contact SET is_active = FALSE WHERE id = $1`, contact4.ID_)
+
+	current, err := checkCachedContact(ctx, ts.b.db, contact4, knChannel)
+	ts.NoError(err)
+	ts.False(current)
+
+	contact5, err := contactForURN(ctx, ts.b, knChannel.OrgID_, knChannel, urn3, "", "")
+	ts.NoError(err)
+	ts.NotEqual(contact4.ID_, contact5.ID_)
+}
+
 func (ts *BackendTestSuite) TestContactURNPriority() {
 	knChannel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
 	twChannel := ts.getChannel("TW", "dbc126ed-66bc-4e28-b67b-81dc3327c96a")
@@ -482,6 +570,40 @@ func (ts *BackendTestSuite) TestMsgStatus() {
 	ts.Equal(m.ExternalID_, null.String("ext0"))
 	ts.True(m.ModifiedOn_.After(now))
 
+	// mark it as read
+	status = ts.b.NewMsgStatusForID(channel, courier.NewMsgID(10001), courier.MsgRead)
+	err = ts.b.WriteMsgStatus(ctx, status)
+	ts.NoError(err)
+	time.Sleep(time.Second)
+
+	m, err = readMsgFromDB(ts.b, courier.NewMsgID(10001))
+	ts.NoError(err)
+	ts.Equal(m.Status_, courier.MsgRead)
+
+	// late delivery and wired statuses don't take it backwards
+	status = ts.b.NewMsgStatusForID(channel, courier.NewMsgID(10001), courier.MsgDelivered)
+	err = ts.b.WriteMsgStatus(ctx, status)
+	ts.NoError(err)
+	status = ts.b.NewMsgStatusForID(channel, courier.NewMsgID(10001), courier.MsgWired)
+	err = ts.b.WriteMsgStatus(ctx, status)
+	ts.NoError(err)
+	time.Sleep(time.Second)
+
+	m, err = readMsgFromDB(ts.b, courier.NewMsgID(10001))
+	ts.NoError(err)
+	ts.Equal(m.Status_, courier.MsgRead)
+
+	// and neither are statuses written directly or flushed from our spool
+	dbStatus := ts.b.NewMsgStatusForID(channel, courier.NewMsgID(10001), courier.MsgSent).(*DBMsgStatus)
+	ts.NoError(writeMsgStatusToDB(ctx, ts.b, dbStatus))
+	statusJSON, err := json.Marshal(dbStatus)
+	ts.NoError(err)
+	ts.NoError(ts.b.flushStatusFile("status.json", statusJSON))
+
+	m, err = readMsgFromDB(ts.b, courier.NewMsgID(10001))
+	ts.NoError(err)
+	ts.Equal(m.Status_, courier.MsgRead)
+
 	// update by external id
 	status = ts.b.NewMsgStatusForExternalID(channel, "ext1", courier.MsgFailed)
 	err = ts.b.WriteMsgStatus(ctx, status)
@@ -540,9 +662,387 @@ func (ts *BackendTestSuite) TestMsgStatus() {
 	ts.Equal(m.ErrorCount_, 3)
 }
 
+func (ts *BackendTestSuite) TestMsgStatusRetryPolicy() {
+	ctx := context.Background()
+	knChannel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+
+	// copy our channel so we can override its retry policy in its config
+	channel := *knChannel
+	channel.Config_ = utils.NullMap{Valid: true, Map: map[string]interface{}{
+		courier.ConfigRetryMaxAttempts: float64(10),
+		courier.ConfigRetryDelay:       float64(60),
+		courier.ConfigRetryBackoff:     "exponential",
+	}}
+
+	// put our msg back in a sent state, statuses which aren't errored don't need our retry delays
+	status := ts.b.NewMsgStatusForID(&channel, courier.NewMsgID(10000), courier.MsgSent)
+	ts.Equal("{}", status.(*DBMsgStatus).RetryDelays_)
+	ts.NoError(ts.b.WriteMsgStatus(ctx, status))
+	time.Sleep(time.Second)
+
+	// error it, which should be retried according to our exponential backoff
+	now := time.Now()
+	status = ts.b.NewMsgStatusForID(&channel, courier.NewMsgID(10000), courier.MsgWired)
+	status.SetStatus(courier.MsgErrored)
+	ts.Equal("{60,120,240,480,960,1920,3840,7680,15360}", status.(*DBMsgStatus).RetryDelays_)
+	ts.NoError(ts.b.WriteMsgStatus(ctx, status))
+	time.Sleep(time.Second)
+
+	m, err := readMsgFromDB(ts.b, courier.NewMsgID(10000))
+	ts.NoError(err)
+	ts.Equal(courier.MsgErrored, m.Status_)
+
+	expectedDelay := time.Minute * time.Duration(math.Pow(2, float64(m.ErrorCount_-1)))
+	ts.WithinDuration(now.Add(expectedDelay), m.NextAttempt_, time.Second*5)
+
+	// a retry-after hint from the provider overrides our backoff
+	now = time.Now()
+	status = ts.b.NewMsgStatusForID(&channel, courier.NewMsgID(10000), courier.MsgErrored)
+	status.SetSendError(courier.NewRateLimitedError("429", "Too Many Requests", time.Second*90))
+	ts.NoError(ts.b.WriteMsgStatus(ctx, status))
+	time.Sleep(time.Second)
+
+	m, err = readMsgFromDB(ts.b, courier.NewMsgID(10000))
+	ts.NoError(err)
+	ts.Equal(courier.MsgErrored, m.Status_)
+	ts.WithinDuration(now.Add(time.Second*90), m.NextAttempt_, time.Second*5)
+
+	// allow only a single attempt and our next error fails the msg
+	channel.Config_.Map[courier.ConfigRetryMaxAttempts] = float64(1)
+	status = ts.b.NewMsgStatusForID(&channel, courier.NewMsgID(10000), courier.MsgErrored)
+	ts.Equal("{}", status.(*DBMsgStatus).RetryDelays_)
+	ts.NoError(ts.b.WriteMsgStatus(ctx, status))
+	time.Sleep(time.Second)
+
+	m, err = readMsgFromDB(ts.b, courier.NewMsgID(10000))
+	ts.NoError(err)
+	ts.Equal(courier.MsgFailed, m.Status_)
+}
+
+func (ts *BackendTestSuite) TestWriteMsgsReadBefore() {
+	ctx := context.Background()
+	channel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	urn, _ := urns.NewTelURNForCountry("12067799192", "US")
+
+	defer ts.b.db.MustExec(`UPDATE msgs_msg SET status = 'W' WHERE id IN (10000, 10001)`)
+
+	// a watermark before our msgs were sent doesn't mark them as read
+	statuses, err := ts.b.WriteMsgsReadBefore(ctx, channel, urn, time.Now().Add(-time.Hour))
+	ts.NoError(err)
+	ts.Len(statuses, 0)
+
+	// nor does one for a different URN
+	other, _ := urns.NewTelURNForCountry("12067799191", "US")
+	statuses, err = ts.b.WriteMsgsReadBefore(ctx, channel, other, time.Now())
+	ts.NoError(err)
+	ts.Len(statuses, 0)
+
+	// but a watermark after they were sent does
+	statuses, err = ts.b.WriteMsgsReadBefore(ctx, channel, urn, time.Now())
+	ts.NoError(err)
+	ts.Len(statuses, 2)
+	time.Sleep(time.Second)
+
+	m, err := readMsgFromDB(ts.b, courier.NewMsgID(10000))
+	ts.NoError(err)
+	ts.Equal(courier.MsgRead, m.Status_)
+
+	// and the next receipt finds nothing left to mark
+	statuses, err = ts.b.WriteMsgsReadBefore(ctx, channel, urn, time.Now())
+	ts.NoError(err)
+	ts.Len(statuses, 0)
+}
+
+func (ts *BackendTestSuite) TestMsgStatusErrorCode() {
+	ctx := context.Background()
+	channel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+
+	readError := func() (sql.NullString, sql.NullString, sql.NullString) {
+		var code, providerCode, description sql.NullString
+		err := ts.b.db.QueryRow("SELECT error_code, provider_error_code, error_description FROM msgs_msg WHERE id = $1", 10000).Scan(&code, &providerCode, &description)
+		ts.NoError(err)
+		return code, providerCode, description
+	}
+
+	// our test schema has the error columns so we write to them, without them we leave them out of our updates
+	hasColumns, err := hasMsgErrorColumns(ctx, ts.b.db)
+	ts.NoError(err)
+	ts.True(hasColumns)
+	ts.Contains(ts.b.getStatusSQL().updateMsgID, "error_code")
+	ts.NotContains(newStatusSQL(false).updateMsgID, "error_code")
+	ts.NotContains(newStatusSQL(false).updateMsgExternalID, "error_code")
+	ts.NotContains(newStatusSQL(false).bulkUpdateMsgStatus, "error_code")
+
+	// if we couldn't check for them, we leave them out until we check again
+	ts.b.statusSQL = nil
+	ts.b.statusSQLCheckedOn = time.Now()
+	ts.Equal(statusSQLWithoutErrors, ts.b.getStatusSQL())
+
+	ts.b.statusSQLCheckedOn = time.Now().Add(-statusSQLRecheck)
+	ts.Contains(ts.b.getStatusSQL().updateMsgID, "error_code")
+
+	// put our msg back in a sent state
+	status := ts.b.NewMsgStatusForID(channel, courier.NewMsgID(10000), courier.MsgSent)
+	ts.NoError(ts.b.WriteMsgStatus(ctx, status))
+	time.Sleep(time.Second)
+
+	// error our msg with a provider error
+	status = ts.b.NewMsgStatusForID(channel, courier.NewMsgID(10000), courier.MsgErrored)
+	status.SetSendError(courier.NewSendError(courier.ErrorInvalidRecipient, "21211", "Invalid 'To' Phone Number"))
+	ts.NoError(ts.b.WriteMsgStatus(ctx, status))
+	time.Sleep(time.Second)
+
+	code, providerCode, description := readError()
+	ts.Equal("invalid_recipient", code.String)
+	ts.Equal("21211", providerCode.String)
+	ts.Equal("Invalid 'To' Phone Number", description.String)
+
+	// our error survives being spooled
+	dbStatus := status.(*DBMsgStatus)
+	statusJSON, err := json.Marshal(dbStatus)
+	ts.NoError(err)
+	spooled := &DBMsgStatus{}
+	ts.NoError(json.Unmarshal(statusJSON, spooled))
+	ts.Equal(dbStatus.SendError(), spooled.SendError())
+
+	// a failure without an error leaves it in place
+	status = ts.b.NewMsgStatusForID(channel, courier.NewMsgID(10000), courier.MsgFailed)
+	ts.NoError(ts.b.WriteMsgStatus(ctx, status))
+	time.Sleep(time.Second)
+
+	code, _, _ = readError()
+	ts.Equal("invalid_recipient", code.String)
+
+	// but it is cleared once the msg is sent
+	status = ts.b.NewMsgStatusForID(channel, courier.NewMsgID(10000), courier.MsgWired)
+	ts.NoError(ts.b.WriteMsgStatus(ctx, status))
+	time.Sleep(time.Second)
+
+	code, providerCode, description = readError()
+	ts.False(code.Valid)
+	ts.False(providerCode.Valid)
+	ts.False(description.Valid)
+}
+
+func (ts *BackendTestSuite) TestChannelLogData() {
+	channel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	log := courier.NewChannelLog("Message Sent", channel, courier.NewMsgID(10), "POST", "https://api.telegram.org/bot123:secret/sendMessage?token=abc",
+		200, "request", "response", time.Second, nil)
+
+	// we only publish the host of the request, not any credentials in its URL
+	data := newChannelLogData(log)
+	ts.Equal("api.telegram.org", data.Host)
+
+	dataJSON, err := json.Marshal(data)
+	ts.NoError(err)
+	ts.NotContains(string(dataJSON), "secret")
+	ts.NotContains(string(dataJSON), "token")
+}
+
 func (ts *BackendTestSuite) TestHealth() {
 	// all should be well in test land
-	ts.Equal(ts.b.Health(), "")
+	health := ts.b.Health()
+	ts.True(health.Healthy())
+	ts.Equal("", health.String())
+
+	checks := make(map[string]*courier.HealthCheck)
+	for _, c := range health.Checks {
+		checks[c.Name] = c
+	}
+	ts.Contains(checks, "db")
+	ts.Contains(checks, "redis")
+	ts.Contains(checks, "s3")
+	ts.Contains(checks, "spool")
+	ts.Equal(0, checks["spool_backlog"].Details["files"])
+
+	// our media storage and spool only degrade us
+	ts.False(checks["db"].Optional)
+	ts.True(checks["s3"].Optional)
+	ts.True(checks["spool"].Optional)
+
+	// and are cached between probes
+	ts.Equal(checks["s3"], ts.b.Health().Checks[2])
+}
+
+func (ts *BackendTestSuite) TestQueueAdmin() {
+	ctx := context.Background()
+	rc := ts.b.redisPool.Get()
+	defer rc.Close()
+	rc.Do("FLUSHDB")
+
+	channelUUID, _ := courier.NewChannelUUID("dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+
+	err := queue.PushOntoQueue(rc, msgQueueName, channelUUID.String(), 10, `[{"id":10000}]`, queue.HighPriority, time.Time{})
+	ts.NoError(err)
+	err = queue.PushOntoQueue(rc, msgQueueName, channelUUID.String(), 10, `[{"id":10001},{"id":10002}]`, queue.LowPriority, time.Time{})
+	ts.NoError(err)
+
+	queues, err := ts.b.Queues(ctx)
+	ts.NoError(err)
+	ts.Equal([]*courier.ChannelQueue{
+		{ChannelUUID: channelUUID, ChannelType: courier.ChannelType("KN"), State: "active", TPS: 10, Workers: 0, Breaker: "closed", Size: 1, BulkSize: 1},
+	}, queues)
+
+	items, err := ts.b.PeekQueue(ctx, channelUUID, 10)
+	ts.NoError(err)
+	ts.Equal(2, len(items))
+	ts.True(items[0].HighPriority)
+	ts.Equal(`[{"id":10000}]`, string(items[0].Value))
+	ts.False(items[1].HighPriority)
+	ts.Equal(`[{"id":10001},{"id":10002}]`, string(items[1].Value))
+	ts.WithinDuration(time.Now(), items[0].AvailableOn, time.Minute)
+
+	purged, err := ts.b.PurgeQueue(ctx, channelUUID)
+	ts.NoError(err)
+	ts.Equal(2, purged)
+
+	queues, err = ts.b.Queues(ctx)
+	ts.NoError(err)
+	ts.Equal(0, len(queues))
+
+	msg, err := ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Nil(msg)
+}
+
+func (ts *BackendTestSuite) TestPauseChannel() {
+	ctx := context.Background()
+	rc := ts.b.redisPool.Get()
+	defer rc.Close()
+	rc.Do("FLUSHDB")
+
+	channelUUID, _ := courier.NewChannelUUID("dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+
+	dbMsg, err := readMsgFromDB(ts.b, courier.NewMsgID(10000))
+	ts.NoError(err)
+	dbMsg.ChannelUUID_ = channelUUID
+	msgJSON, err := json.Marshal([]interface{}{dbMsg})
+	ts.NoError(err)
+
+	err = queue.PushOntoQueue(rc, msgQueueName, channelUUID.String(), 10, string(msgJSON), queue.HighPriority, time.Time{})
+	ts.NoError(err)
+
+	err = ts.b.PauseChannel(ctx, channelUUID)
+	ts.NoError(err)
+
+	paused, err := ts.b.IsChannelPaused(ctx, channelUUID)
+	ts.NoError(err)
+	ts.True(paused)
+	ts.Contains(ts.b.Status(), "Paused Channels\n dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+
+	// nothing to pop while paused
+	msg, err := ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Nil(msg)
+
+	// but our msg is still queued
+	queues, err := ts.b.Queues(ctx)
+	ts.NoError(err)
+	ts.Equal(1, len(queues))
+	ts.Equal("paused", queues[0].State)
+	ts.True(queues[0].Paused)
+	ts.Equal(1, queues[0].Size)
+
+	// drain it, failing our msg
+	failed, err := ts.b.DrainChannel(ctx, channelUUID)
+	ts.NoError(err)
+	ts.Equal(1, failed)
+
+	queues, err = ts.b.Queues(ctx)
+	ts.NoError(err)
+	ts.Equal(0, len(queues))
+
+	// wait for our status to be committed
+	time.Sleep(time.Second)
+	m, err := readMsgFromDB(ts.b, courier.NewMsgID(10000))
+	ts.NoError(err)
+	ts.Equal(courier.MsgFailed, m.Status_)
+
+	// draining doesn't resume us
+	paused, err = ts.b.IsChannelPaused(ctx, channelUUID)
+	ts.NoError(err)
+	ts.True(paused)
+
+	err = ts.b.ResumeChannel(ctx, channelUUID)
+	ts.NoError(err)
+
+	paused, err = ts.b.IsChannelPaused(ctx, channelUUID)
+	ts.NoError(err)
+	ts.False(paused)
+	ts.NotContains(ts.b.Status(), "Paused Channels")
+}
+
+func (ts *BackendTestSuite) TestSendAfter() {
+	ctx := context.Background()
+	rc := ts.b.redisPool.Get()
+	defer rc.Close()
+	rc.Do("FLUSHDB")
+
+	channelUUID, _ := courier.NewChannelUUID("dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+
+	dbMsg, err := readMsgFromDB(ts.b, courier.NewMsgID(10000))
+	ts.NoError(err)
+	dbMsg.ChannelUUID_ = channelUUID
+
+	// queue our msg to be sent in a second
+	sendAfter := time.Now().Add(time.Second)
+	dbMsg.SendAfter_ = &sendAfter
+	msgJSON, err := json.Marshal([]interface{}{dbMsg})
+	ts.NoError(err)
+
+	err = queue.PushOntoQueue(rc, msgQueueName, channelUUID.String(), 10, string(msgJSON), queue.HighPriority, time.Time{})
+	ts.NoError(err)
+
+	// it is popped but pushed straight back onto our queue until it should be sent
+	msg, err := ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Nil(msg)
+
+	items, err := queue.PeekQueue(rc, msgQueueName, channelUUID.String(), 10)
+	ts.NoError(err)
+	ts.Equal(1, len(items))
+	ts.InDelta(float64(sendAfter.UnixNano())/float64(time.Second), items[0].Score, 0.001)
+
+	// without holding onto a worker
+	queues, err := ts.b.Queues(ctx)
+	ts.NoError(err)
+	ts.Equal(0, queues[0].Workers)
+
+	// once it is time, and our dethrottler has moved our queue back to active, it can be popped
+	time.Sleep(time.Second * 2)
+	msg, err = ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.NotNil(msg)
+	ts.Equal(courier.NewMsgID(10000), msg.ID())
+}
+
+func (ts *BackendTestSuite) TestCircuitBreaker() {
+	ctx := context.Background()
+	rc := ts.b.redisPool.Get()
+	defer rc.Close()
+	rc.Do("FLUSHDB")
+
+	ts.b.config.BreakerThreshold = 2
+	defer func() { ts.b.config.BreakerThreshold = 10 }()
+
+	channel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	msg, err := readMsgFromDB(ts.b, courier.NewMsgID(10000))
+	ts.NoError(err)
+	msg.workerToken = queue.WorkerToken("msgs:dbc126ed-66bc-4e28-b67b-81dc3327c95d|10")
+
+	// a failed msg doesn't count towards our breaker
+	ts.b.MarkOutgoingMsgComplete(ctx, msg, ts.b.NewMsgStatusForID(channel, msg.ID(), courier.MsgFailed))
+	ts.NotContains(ts.b.Status(), "Breaker")
+
+	// but errored msgs do
+	ts.b.MarkOutgoingMsgComplete(ctx, msg, ts.b.NewMsgStatusForID(channel, msg.ID(), courier.MsgErrored))
+	ts.b.MarkOutgoingMsgComplete(ctx, msg, ts.b.NewMsgStatusForID(channel, msg.ID(), courier.MsgErrored))
+	ts.Contains(ts.b.Status(), "     open          2")
+	ts.Contains(ts.b.Status(), "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+
+	// a successful send closes it again
+	ts.b.MarkOutgoingMsgComplete(ctx, msg, ts.b.NewMsgStatusForID(channel, msg.ID(), courier.MsgWired))
+	ts.NotContains(ts.b.Status(), "Breaker")
 }
 
 func (ts *BackendTestSuite) TestDupes() {
@@ -585,7 +1085,7 @@ func (ts *BackendTestSuite) TestDupes() {
 	msgJSON, err := json.Marshal([]interface{}{dbMsg})
 	ts.NoError(err)
 
-	err = queue.PushOntoQueue(r, msgQueueName, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 10, string(msgJSON), queue.HighPriority)
+	err = queue.PushOntoQueue(r, msgQueueName, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 10, string(msgJSON), queue.HighPriority, time.Time{})
 	ts.NoError(err)
 
 	_, err = ts.b.PopNextOutgoingMsg(ctx)
@@ -635,7 +1135,7 @@ func (ts *BackendTestSuite) TestStatus() {
 	msgJSON, err := json.Marshal([]interface{}{dbMsg})
 	ts.NoError(err)
 
-	err = queue.PushOntoQueue(r, msgQueueName, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 10, string(msgJSON), queue.HighPriority)
+	err = queue.PushOntoQueue(r, msgQueueName, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 10, string(msgJSON), queue.HighPriority, time.Time{})
 	ts.NoError(err)
 
 	// status should now contain that channel
@@ -657,7 +1157,7 @@ func (ts *BackendTestSuite) TestOutgoingQueue() {
 	msgJSON, err := json.Marshal([]interface{}{dbMsg})
 	ts.NoError(err)
 
-	err = queue.PushOntoQueue(r, msgQueueName, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 10, string(msgJSON), queue.HighPriority)
+	err = queue.PushOntoQueue(r, msgQueueName, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 10, string(msgJSON), queue.HighPriority, time.Time{})
 	ts.NoError(err)
 
 	// pop a message off our queue
@@ -744,6 +1244,63 @@ func (ts *BackendTestSuite) TestChannel() {
 	ts.Equal("missingValue", val)
 }
 
+func (ts *BackendTestSuite) TestChannelCache() {
+	channelUUID, _ := courier.NewChannelUUID("dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	ctx := context.Background()
+	ts.NoError(ts.b.InvalidateChannel(ctx, channelUUID))
+
+	knChannel := ts.getChannel("KN", channelUUID.String())
+	ts.Equal("2500", knChannel.Address())
+
+	// change our channel in the database, we keep using our cached copy
+	ts.b.db.MustExec(`UPDATE channels_channel SET address = '2501' WHERE uuid = $1`, channelUUID.String())
+	defer ts.b.db.MustExec(`UPDATE channels_channel SET address = '2500' WHERE uuid = $1`, channelUUID.String())
+
+	ts.Equal("2500", ts.getChannel("KN", channelUUID.String()).Address())
+
+	// as do other instances without a local copy, which use our shared one
+	clearLocalChannel(channelUUID)
+	_, err := ts.b.GetChannel(ctx, courier.ChannelType("EX"), channelUUID)
+	ts.Equal(courier.ErrChannelWrongType, err)
+	ts.Equal("2500", ts.getChannel("KN", channelUUID.String()).Address())
+
+	// until it is invalidated
+	ts.NoError(ts.b.InvalidateChannel(ctx, channelUUID))
+	ts.Equal("2501", ts.getChannel("KN", channelUUID.String()).Address())
+
+	// which also notifies other instances
+	ts.b.db.MustExec(`UPDATE channels_channel SET address = '2502' WHERE uuid = $1`, channelUUID.String())
+	r := ts.b.redisPool.Get()
+	defer r.Close()
+	r.Do("del", fmt.Sprintf(sharedChannelKey, channelUUID))
+	r.Do("publish", channelInvalidationsKey, channelUUID.String())
+	time.Sleep(time.Millisecond * 100)
+
+	ts.Equal("2502", ts.getChannel("KN", channelUUID.String()).Address())
+	ts.NoError(ts.b.InvalidateChannel(ctx, channelUUID))
+
+	// a copy loaded before the channel was invalidated isn't cached, locally or shared
+	version, err := getSharedChannelVersion(r, channelUUID)
+	ts.NoError(err)
+	generation := localCacheGeneration()
+	stale, err := loadChannelFromDB(ctx, ts.b.db, courier.ChannelType("KN"), channelUUID)
+	ts.NoError(err)
+
+	ts.NoError(ts.b.InvalidateChannel(ctx, channelUUID))
+	ts.False(cacheSharedChannel(r, stale, version))
+	cacheChannel(stale, generation)
+
+	_, err = getCachedChannel(courier.ChannelType("KN"), channelUUID)
+	ts.Equal(courier.ErrChannelNotFound, err)
+	_, err = getSharedChannel(r, courier.ChannelType("KN"), channelUUID)
+	ts.Equal(courier.ErrChannelNotFound, err)
+
+	// but a current copy is
+	version, _ = getSharedChannelVersion(r, channelUUID)
+	ts.True(cacheSharedChannel(r, stale, version))
+	ts.NoError(ts.b.InvalidateChannel(ctx, channelUUID))
+}
+
 func (ts *BackendTestSuite) TestChanneLog() {
 	knChannel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
 	ctx := context.Background()
@@ -758,11 +1315,40 @@ func (ts *BackendTestSuite) TestChanneLog() {
 func (ts *BackendTestSuite) TestWriteAttachment() {
 	ctx := context.Background()
 
+	defer func(original time.Duration) { mediaRetryBackoff = original }(mediaRetryBackoff)
+	mediaRetryBackoff = time.Millisecond
+
+	defer func(original string) { ts.b.config.MaxMediaSizes = original }(ts.b.config.MaxMediaSizes)
+	ts.b.config.MaxMediaSizes = "KN:1"
+
+	flakyRequests := 0
 	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer r.Body.Close()
 
 		content := ""
 		switch r.URL.Path {
+		case "/flaky":
+			flakyRequests++
+			if flakyRequests == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			content = "GIF87aandstuff"
+
+		case "/missing.jpg":
+			w.WriteHeader(http.StatusNotFound)
+			return
+
+		case "/large":
+			w.Header().Add("Content-Type", "video/mp4")
+			content = strings.Repeat("a", 1024*1024+1)
+
+		case "/sized":
+			w.Header().Add("Content-Type", "video/mp4")
+			w.Header().Add("Content-Length", "2000000")
+			w.WriteHeader(http.StatusOK)
+			return
+
 		case "/test.jpg":
 			content = "malformedjpegbody"
 
@@ -828,6 +1414,116 @@ func (ts *BackendTestSuite) TestWriteAttachment() {
 		ts.True(strings.HasPrefix(m.Attachments()[0], "image/png:"))
 		ts.True(strings.HasSuffix(m.Attachments()[0], ".png"))
 	}
+
+	// server errors are retried
+	msg = ts.b.NewIncomingMsg(knChannel, urn, "flaky attachment").(*DBMsg)
+	msg.WithAttachment(testServer.URL + "/flaky")
+
+	err = ts.b.WriteMsg(ctx, msg)
+	ts.NoError(err)
+	ts.Equal(2, flakyRequests)
+	if ts.Equal(1, len(msg.Attachments())) {
+		ts.True(strings.HasPrefix(msg.Attachments()[0], "image/gif:"))
+		ts.True(strings.HasSuffix(msg.Attachments()[0], ".gif"))
+	}
+
+	// media we can't download is replaced by a placeholder without its URL rather than failing our msg
+	msg = ts.b.NewIncomingMsg(knChannel, urn, "missing attachment").(*DBMsg)
+	msg.WithAttachment(testServer.URL + "/missing.jpg")
+
+	err = ts.b.WriteMsg(ctx, msg)
+	ts.NoError(err)
+	ts.Equal([]string{"image/jpeg:"}, msg.Attachments())
+
+	// as is media which is larger than the maximum size for our channel type, whether we find that out as we read it
+	msg = ts.b.NewIncomingMsg(knChannel, urn, "large attachment").(*DBMsg)
+	msg.WithAttachment(testServer.URL + "/large")
+
+	err = ts.b.WriteMsg(ctx, msg)
+	ts.NoError(err)
+	ts.Equal([]string{"video/mp4:"}, msg.Attachments())
+
+	// or from its content length
+	msg = ts.b.NewIncomingMsg(knChannel, urn, "sized attachment").(*DBMsg)
+	msg.WithAttachment(testServer.URL + "/sized")
+
+	err = ts.b.WriteMsg(ctx, msg)
+	ts.NoError(err)
+	ts.Equal([]string{"video/mp4:"}, msg.Attachments())
+}
+
+func (ts *BackendTestSuite) TestNewMediaStorage() {
+	config := courier.NewConfig()
+
+	// S3 media is served through courier by default, which needs a media secret
+	_, err := newMediaStorage(config)
+	ts.EqualError(err, "media secret must be set to serve S3 media through courier")
+
+	config.MediaSecret = "sesame"
+	mediaStorage, err := newMediaStorage(config)
+	ts.NoError(err)
+	ts.Equal("s3", mediaStorage.Name())
+
+	// URLs always expire
+	config.MediaURLExpiration = 0
+	_, err = newMediaStorage(config)
+	ts.EqualError(err, "media URL expiration must be at least 1 hour")
+
+	// pre-signed URLs don't need a secret, but can only be valid for so long
+	config.S3MediaURLs = "presigned"
+	config.MediaSecret = ""
+	config.MediaURLExpiration = 200
+	_, err = newMediaStorage(config)
+	ts.EqualError(err, "media URL expiration must be no more than 168 hours to use pre-signed S3 URLs")
+
+	config.MediaURLExpiration = 24
+	_, err = newMediaStorage(config)
+	ts.NoError(err)
+
+	// local storage always needs a secret
+	config.MediaStorage = "local"
+	_, err = newMediaStorage(config)
+	ts.EqualError(err, "media secret must be set to use local media storage")
+}
+
+func (ts *BackendTestSuite) TestMediaRoute() {
+	dir, err := ioutil.TempDir("", "media")
+	ts.NoError(err)
+	defer os.RemoveAll(dir)
+
+	defer func(original storage.MediaStorage) { ts.b.mediaStorage = original }(ts.b.mediaStorage)
+	ts.b.mediaStorage = storage.NewLocalStorage(dir, storage.NewURLSigner("localhost", "sesame", time.Hour))
+
+	router := chi.NewRouter()
+	ts.b.AddRoutes(router)
+
+	get := func(url string) (int, string) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, strings.TrimPrefix(url, "https://localhost"), nil))
+		return w.Code, w.Body.String()
+	}
+
+	mediaURL, err := ts.b.mediaStorage.Put("1/abcd/efgh/abcdefgh.jpg", "image/jpeg", strings.NewReader("jpegdata"))
+	ts.NoError(err)
+	ts.True(strings.HasPrefix(mediaURL, "https://localhost/media/"))
+	ts.True(strings.HasSuffix(mediaURL, "/1/abcd/efgh/abcdefgh.jpg"))
+
+	// media is served from the URL it was written with
+	code, body := get(mediaURL)
+	ts.Equal(200, code)
+	ts.Equal("jpegdata", body)
+
+	// but not if the URL has been changed
+	code, _ = get(strings.Replace(mediaURL, "abcdefgh.jpg", "abcdefgh.png", 1))
+	ts.Equal(403, code)
+
+	// missing media is a 404 even when signed
+	code, _ = get(storage.NewURLSigner("localhost", "sesame", time.Hour).URL("1/abcd/efgh/missing.jpg"))
+	ts.Equal(404, code)
+
+	// and URLs which never expire aren't served
+	code, _ = get(storage.NewURLSigner("localhost", "sesame", 0).URL("1/abcd/efgh/abcdefgh.jpg"))
+	ts.Equal(403, code)
 }
 
 func (ts *BackendTestSuite) TestWriteMsg() {
@@ -932,6 +1628,54 @@ func (ts *BackendTestSuite) TestWriteMsg() {
 	ts.Equal(1, count)
 }
 
+func (ts *BackendTestSuite) TestWriteMsgBatch() {
+	knChannel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	urn, _ := urns.NewTelURNForCountry("12065551216", "US")
+	ctx := context.Background()
+
+	// write a msg first so our contact exists
+	msg := ts.b.NewIncomingMsg(knChannel, urn, "first").(*DBMsg)
+	ts.NoError(writeMsgToDB(ctx, ts.b, msg))
+
+	rc := ts.b.redisPool.Get()
+	defer rc.Close()
+	contactQueue := fmt.Sprintf("c:1:%d", msg.ContactID_)
+	rc.Do("DEL", contactQueue)
+
+	// write a bunch of msgs at once, these should be inserted together
+	msgs := make([]*DBMsg, 10)
+	errs := make([]error, len(msgs))
+	wg := &sync.WaitGroup{}
+	for i := range msgs {
+		msgs[i] = ts.b.NewIncomingMsg(knChannel, urn, fmt.Sprintf("msg %d", i)).(*DBMsg)
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = writeMsgToDB(ctx, ts.b, msgs[i])
+		}(i)
+	}
+	wg.Wait()
+
+	// each msg was assigned its own id
+	ids := make(map[courier.MsgID]bool)
+	for i, m := range msgs {
+		ts.NoError(errs[i])
+		ts.NotEqual(courier.NilMsgID, m.ID_)
+		ids[m.ID_] = true
+
+		text := ""
+		ts.NoError(ts.b.db.Get(&text, "SELECT text FROM msgs_msg WHERE id = $1", m.ID_))
+		ts.Equal(m.Text_, text)
+	}
+	ts.Equal(len(msgs), len(ids))
+
+	// and was queued for handling
+	count, err := redis.Int(rc.Do("LLEN", contactQueue))
+	ts.NoError(err)
+	ts.Equal(len(msgs), count)
+}
+
 func (ts *BackendTestSuite) TestChannelEvent() {
 	ctx := context.Background()
 
@@ -3,20 +3,30 @@ package rapidpro
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"sync"
 	"time"
 
+	"github.com/garyburd/redigo/redis"
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
 	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/queue"
 	"github.com/nyaruka/courier/utils"
+	"github.com/sirupsen/logrus"
 )
 
 // getChannel will look up the channel with the passed in UUID and channel type.
 // It will return an error if the channel does not exist or is not active.
-func getChannel(ctx context.Context, db *sqlx.DB, channelType courier.ChannelType, channelUUID courier.ChannelUUID) (*DBChannel, error) {
+//
+// Channels are cached in two tiers, locally in each instance and in redis where they are shared by all instances.
+// When a channel changes it is invalidated in both, see invalidateChannel.
+func getChannel(ctx context.Context, b *backend, channelType courier.ChannelType, channelUUID courier.ChannelUUID) (*DBChannel, error) {
+	// note our local generation so we don't cache a channel which was invalidated while we were loading it
+	generation := localCacheGeneration()
+
 	// look for the channel locally
 	cachedChannel, localErr := getCachedChannel(channelType, channelUUID)
 
@@ -25,12 +35,38 @@ func getChannel(ctx context.Context, db *sqlx.DB, channelType courier.ChannelTyp
 		return cachedChannel, nil
 	}
 
+	// wrong type is wrong type regardless of where we look
+	if localErr == courier.ErrChannelWrongType {
+		return nil, localErr
+	}
+
+	// look in our shared cache next
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	sharedChannel, sharedErr := getSharedChannel(rc, channelType, channelUUID)
+	if sharedErr == nil {
+		cacheChannel(sharedChannel, generation)
+		return sharedChannel, nil
+	}
+	if sharedErr == courier.ErrChannelWrongType {
+		return nil, sharedErr
+	}
+
+	// note the version of the channel before we load it, if it is invalidated while we are loading it, our copy
+	// may be stale and won't be shared
+	version, err := getSharedChannelVersion(rc, channelUUID)
+	if err != nil {
+		logrus.WithError(err).WithField("channel_uuid", channelUUID).Error("error getting shared channel version")
+	}
+
 	// look in our database instead
-	channel, dbErr := loadChannelFromDB(ctx, db, channelType, channelUUID)
+	channel, dbErr := loadChannelFromDB(ctx, b.db, channelType, channelUUID)
 
-	// if it wasn't found in the DB, clear our cache and return that it wasn't found
+	// if it wasn't found in the DB, clear our caches and return that it wasn't found
 	if dbErr == courier.ErrChannelNotFound {
 		clearLocalChannel(channelUUID)
+		clearSharedChannel(rc, channelUUID)
 		return cachedChannel, fmt.Errorf("unable to find channel with type: %s and uuid: %s", channelType.String(), channelUUID.String())
 	}
 
@@ -44,11 +80,29 @@ func getChannel(ctx context.Context, db *sqlx.DB, channelType courier.ChannelTyp
 		return nil, dbErr
 	}
 
-	// we found it in the db, cache it locally
-	cacheChannel(channel)
+	// we found it in the db, share it with other instances and cache it locally, unless it has been invalidated since
+	// we loaded it, in which case the next lookup will load it again
+	if err != nil || cacheSharedChannel(rc, channel, version) {
+		cacheChannel(channel, generation)
+	}
+
+	// and make sure our queue enforces its current max concurrency and knows its org
+	updateChannelQueue(rc, channel)
 	return channel, nil
 }
 
+// invalidateChannel increments the version of the passed in channel and clears it from our shared cache, and notifies
+// all instances, including this one, that they should clear it from their local caches
+func invalidateChannel(rc redis.Conn, channelUUID courier.ChannelUUID) error {
+	clearLocalChannel(channelUUID)
+
+	rc.Send("hincrby", sharedChannelVersionsKey, channelUUID.String(), 1)
+	rc.Send("del", fmt.Sprintf(sharedChannelKey, channelUUID))
+	rc.Send("publish", channelInvalidationsKey, channelUUID.String())
+	_, err := rc.Do("")
+	return err
+}
+
 const lookupChannelFromUUIDSQL = `
 SELECT 
 	org_id, 
@@ -119,44 +173,239 @@ func getCachedChannel(channelType courier.ChannelType, uuid courier.ChannelUUID)
 	return nil, courier.ErrChannelNotFound
 }
 
-func cacheChannel(channel *DBChannel) {
+// cacheChannel caches the passed in channel locally, as long as no channels have been cleared from our local cache
+// since the passed in generation
+func cacheChannel(channel *DBChannel, generation int) {
 	channel.expiration = time.Now().Add(localTTL)
 
 	cacheMutex.Lock()
-	channelCache[channel.UUID()] = channel
+	if generation == cacheGeneration {
+		channelCache[channel.UUID()] = channel
+	}
 	cacheMutex.Unlock()
 }
 
 func clearLocalChannel(uuid courier.ChannelUUID) {
 	cacheMutex.Lock()
 	delete(channelCache, uuid)
+	cacheGeneration++
 	cacheMutex.Unlock()
 }
 
+func clearLocalChannels() {
+	cacheMutex.Lock()
+	channelCache = make(map[courier.ChannelUUID]*DBChannel)
+	cacheGeneration++
+	cacheMutex.Unlock()
+}
+
+// localCacheGeneration returns the current generation of our local cache
+func localCacheGeneration() int {
+	cacheMutex.RLock()
+	defer cacheMutex.RUnlock()
+	return cacheGeneration
+}
+
 // channels stay cached in memory for a minute at a time
 const localTTL = 60 * time.Second
 
 var cacheMutex sync.RWMutex
 var channelCache = make(map[courier.ChannelUUID]*DBChannel)
 
+// incremented whenever channels are cleared from our local cache
+var cacheGeneration int
+
+// getSharedChannel returns the channel with the passed in type and UUID from our shared cache
+func getSharedChannel(rc redis.Conn, channelType courier.ChannelType, uuid courier.ChannelUUID) (*DBChannel, error) {
+	channelJSON, err := redis.Bytes(rc.Do("get", fmt.Sprintf(sharedChannelKey, uuid)))
+	if err != nil {
+		return nil, courier.ErrChannelNotFound
+	}
+
+	channel := &DBChannel{}
+	err = json.Unmarshal(channelJSON, channel)
+	if err != nil {
+		logrus.WithError(err).WithField("channel_uuid", uuid).Error("error unmarshalling shared channel")
+		return nil, courier.ErrChannelNotFound
+	}
+
+	if channelType != courier.AnyChannelType && channel.ChannelType() != channelType {
+		return nil, courier.ErrChannelWrongType
+	}
+	return channel, nil
+}
+
+// getSharedChannelVersion returns the current version of the channel with the passed in UUID, which is incremented
+// each time it is invalidated
+func getSharedChannelVersion(rc redis.Conn, uuid courier.ChannelUUID) (int, error) {
+	version, err := redis.Int(rc.Do("hget", sharedChannelVersionsKey, uuid.String()))
+	if err == redis.ErrNil {
+		return 0, nil
+	}
+	return version, err
+}
+
+var luaCacheSharedChannel = redis.NewScript(2, `-- KEYS: [VersionsKey, ChannelKey] ARGV: [ChannelUUID, Version, ChannelJSON, TTL]
+	-- only cache our channel if it hasn't been invalidated since we loaded it
+	local current = redis.call("hget", KEYS[1], ARGV[1]) or "0"
+	if current ~= ARGV[2] then
+		return 0
+	end
+
+	redis.call("set", KEYS[2], ARGV[3], "ex", ARGV[4])
+	return 1
+`)
+
+// cacheSharedChannel caches the passed in channel in our shared cache if it is still at the passed in version,
+// returning false if it has since been invalidated
+func cacheSharedChannel(rc redis.Conn, channel *DBChannel, version int) bool {
+	channelJSON, err := json.Marshal(channel)
+	if err != nil {
+		logrus.WithError(err).WithField("channel_uuid", channel.UUID()).Error("error marshalling shared channel")
+		return true
+	}
+
+	cached, err := redis.Bool(luaCacheSharedChannel.Do(rc, sharedChannelVersionsKey, fmt.Sprintf(sharedChannelKey, channel.UUID()),
+		channel.UUID().String(), version, channelJSON, int(sharedTTL/time.Second)))
+	if err != nil {
+		logrus.WithError(err).WithField("channel_uuid", channel.UUID()).Error("error caching shared channel")
+		return true
+	}
+	return cached
+}
+
+// updateChannelQueue records the max concurrency and org of the passed in channel on our msg queue, this is done
+// whenever the channel is loaded from the database so changes to it take effect once it is invalidated
+func updateChannelQueue(rc redis.Conn, channel *DBChannel) {
+	log := logrus.WithField("channel_uuid", channel.UUID())
+
+	limits := courier.GetSendLimits(channel)
+	err := queue.SetMaxConcurrency(rc, msgQueueName, channel.UUID().String(), limits.MaxConcurrency)
+	if err != nil {
+		log.WithError(err).Error("error setting max concurrency")
+	}
+
+	err = queue.SetBurst(rc, msgQueueName, channel.UUID().String(), limits.Burst)
+	if err != nil {
+		log.WithError(err).Error("error setting burst")
+	}
+
+	org := ""
+	if channel.OrgID_ != NilOrgID {
+		org = fmt.Sprintf("%d", channel.OrgID_)
+	}
+	err = queue.SetQueueOrg(rc, msgQueueName, channel.UUID().String(), org)
+	if err != nil {
+		log.WithError(err).Error("error setting queue org")
+	}
+}
+
+func clearSharedChannel(rc redis.Conn, uuid courier.ChannelUUID) {
+	rc.Do("del", fmt.Sprintf(sharedChannelKey, uuid))
+}
+
+// channels stay cached in redis for no longer than they do locally, in case they are changed without being invalidated
+const sharedTTL = localTTL
+
+// the key our shared channels are cached under
+const sharedChannelKey = "channel:%s"
+
+// the hash of the version of each channel, so that copies loaded before it was invalidated aren't cached
+const sharedChannelVersionsKey = "channel_versions"
+
+// the redis pub/sub channel the UUIDs of invalidated channels are published to
+const channelInvalidationsKey = "channel_invalidations"
+
+// startChannelInvalidator starts a goroutine which subscribes to channel invalidations, clearing invalidated channels
+// from our local cache. If our subscription drops we resubscribe, clearing our local cache in case we missed any.
+func startChannelInvalidator(b *backend) {
+	b.waitGroup.Add(1)
+
+	go func() {
+		defer b.waitGroup.Done()
+
+		log := logrus.WithField("comp", "channel invalidator")
+		log.WithField("state", "started").Info("channel invalidator started")
+
+		for {
+			err := listenForInvalidations(b)
+
+			select {
+			case <-b.stopChan:
+				log.WithField("state", "stopped").Info("channel invalidator stopped")
+				return
+
+			case <-time.After(time.Second * 5):
+				log.WithError(err).Error("channel invalidation subscription lost, resubscribing")
+			}
+		}
+	}()
+}
+
+// listenForInvalidations subscribes to channel invalidations and clears each invalidated channel from our local
+// cache, returning when our connection errors or we are stopped
+func listenForInvalidations(b *backend) error {
+	// we need a connection of our own as receiving blocks, and closing a pooled connection doesn't unblock it
+	conn, err := b.redisPool.Dial()
+	if err != nil {
+		return err
+	}
+	psc := redis.PubSubConn{Conn: conn}
+
+	// close our connection when we are stopped
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		select {
+		case <-b.stopChan:
+		case <-done:
+		}
+		psc.Close()
+	}()
+
+	err = psc.Subscribe(channelInvalidationsKey)
+	if err != nil {
+		return err
+	}
+
+	for {
+		switch v := psc.Receive().(type) {
+		case redis.Message:
+			channelUUID, err := courier.NewChannelUUID(string(v.Data))
+			if err == nil {
+				clearLocalChannel(channelUUID)
+			}
+
+		case redis.Subscription:
+			// we may have missed invalidations while we weren't subscribed
+			if v.Kind == "subscribe" {
+				clearLocalChannels()
+			}
+
+		case error:
+			return v
+		}
+	}
+}
+
 //-----------------------------------------------------------------------------
 // Channel Implementation
 //-----------------------------------------------------------------------------
 
 // DBChannel is the RapidPro specific concrete type satisfying the courier.Channel interface
 type DBChannel struct {
-	OrgID_       OrgID               `db:"org_id"`
-	ID_          courier.ChannelID   `db:"id"`
-	ChannelType_ courier.ChannelType `db:"channel_type"`
-	Schemes_     pq.StringArray      `db:"schemes"`
-	UUID_        courier.ChannelUUID `db:"uuid"`
-	Name_        sql.NullString      `db:"name"`
-	Address_     sql.NullString      `db:"address"`
-	Country_     sql.NullString      `db:"country"`
-	Config_      utils.NullMap       `db:"config"`
-
-	OrgConfig_ utils.NullMap `db:"org_config"`
-	OrgIsAnon_ bool          `db:"org_is_anon"`
+	OrgID_       OrgID               `json:"org_id"       db:"org_id"`
+	ID_          courier.ChannelID   `json:"id"           db:"id"`
+	ChannelType_ courier.ChannelType `json:"channel_type" db:"channel_type"`
+	Schemes_     pq.StringArray      `json:"schemes"      db:"schemes"`
+	UUID_        courier.ChannelUUID `json:"uuid"         db:"uuid"`
+	Name_        sql.NullString      `json:"name"         db:"name"`
+	Address_     sql.NullString      `json:"address"      db:"address"`
+	Country_     sql.NullString      `json:"country"      db:"country"`
+	Config_      utils.NullMap       `json:"config"       db:"config"`
+
+	OrgConfig_ utils.NullMap `json:"org_config"  db:"org_config"`
+	OrgIsAnon_ bool          `json:"org_is_anon" db:"org_is_anon"`
 
 	expiration time.Time
 }
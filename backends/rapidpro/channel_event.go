@@ -4,15 +4,14 @@ import (
 	"context"
 	"database/sql/driver"
 	"encoding/json"
-	"fmt"
-	"log"
-	"os"
+	"errors"
 	"strconv"
 	"time"
 
 	"github.com/nyaruka/null"
 
 	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/events"
 	"github.com/nyaruka/courier/utils"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/sirupsen/logrus"
@@ -80,8 +79,11 @@ func writeChannelEvent(ctx context.Context, b *backend, event courier.ChannelEve
 		logrus.WithError(err).WithField("channel_id", dbEvent.ChannelID).WithField("event_type", dbEvent.EventType_).Error("error writing channel event to db")
 	}
 
+	// we only publish events once they are in our db, spooled events are published when they are flushed
 	if err != nil {
 		err = courier.WriteToSpool(b.config.SpoolDir, "events", dbEvent)
+	} else {
+		b.publishEvent(events.TypeChannelEvent, courier.NewEventReceiveData(dbEvent))
 	}
 
 	return err
@@ -130,16 +132,26 @@ func writeChannelEventToDB(ctx context.Context, b *backend, e *DBChannelEvent) e
 	return nil
 }
 
+// parseChannelEventFile parses a spooled channel event, returning an invalid spool file error if it can never be written
+func parseChannelEventFile(contents []byte) (*DBChannelEvent, error) {
+	event := &DBChannelEvent{}
+	err := json.Unmarshal(contents, event)
+	if err != nil {
+		return nil, courier.InvalidSpoolFile(err)
+	}
+	if event.ChannelUUID_ == courier.NilChannelUUID {
+		return nil, courier.InvalidSpoolFile(errors.New("missing channel_uuid"))
+	}
+	return event, nil
+}
+
 func (b *backend) flushChannelEventFile(filename string, contents []byte) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
 	defer cancel()
 
-	event := &DBChannelEvent{}
-	err := json.Unmarshal(contents, event)
+	event, err := parseChannelEventFile(contents)
 	if err != nil {
-		log.Printf("ERROR unmarshalling spool file '%s', renaming: %s\n", filename, err)
-		os.Rename(filename, fmt.Sprintf("%s.error", filename))
-		return nil
+		return err
 	}
 
 	// look up our channel
@@ -150,7 +162,13 @@ func (b *backend) flushChannelEventFile(filename string, contents []byte) error
 	event.channel = channel.(*DBChannel)
 
 	// try to flush to our database
-	return writeChannelEventToDB(ctx, b, event)
+	err = writeChannelEventToDB(ctx, b, event)
+	if err != nil {
+		return err
+	}
+
+	b.publishEvent(events.TypeChannelEvent, courier.NewEventReceiveData(event))
+	return nil
 }
 
 const selectEventSQL = `
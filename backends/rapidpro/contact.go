@@ -2,6 +2,8 @@ package rapidpro
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"strconv"
 	"time"
 	"unicode/utf8"
@@ -12,11 +14,12 @@ import (
 	"database/sql"
 	"database/sql/driver"
 
+	"github.com/garyburd/redigo/redis"
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
 	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/metrics"
 	"github.com/nyaruka/gocommon/urns"
-	"github.com/nyaruka/librato"
 	"github.com/sirupsen/logrus"
 )
 
@@ -98,8 +101,27 @@ WHERE
 
 // contactForURN first tries to look up a contact for the passed in URN, if not finding one then creating one
 func contactForURN(ctx context.Context, b *backend, org OrgID, channel *DBChannel, urn urns.URN, auth string, name string) (*DBContact, error) {
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	// if we've recently seen this URN on this channel there's nothing to update, use our cached contact as long as it
+	// is still active and still owns the URN, as they may have been changed outside of courier
+	contact := getCachedContact(rc, org, channel, urn, auth)
+	if contact != nil {
+		current, err := checkCachedContact(ctx, b.db, contact, channel)
+		if err != nil {
+			logrus.WithError(err).WithField("urn", urn.Identity()).WithField("org_id", org).Error("error checking cached contact")
+		}
+		if current {
+			metrics.Count("contact_cache_hit", nil, 1)
+			return contact, nil
+		}
+		clearCachedContacts(rc, org, urn.Identity().String())
+	}
+	metrics.Count("contact_cache_miss", nil, 1)
+
 	// try to look up our contact by URN
-	contact := &DBContact{}
+	contact = &DBContact{}
 	err := b.db.GetContext(ctx, contact, lookupContactFromURNSQL, urn.Identity(), org)
 	if err != nil && err != sql.ErrNoRows {
 		logrus.WithError(err).WithField("urn", urn.Identity()).WithField("org_id", org).Error("error looking up contact")
@@ -115,13 +137,22 @@ func contactForURN(ctx context.Context, b *backend, org OrgID, channel *DBChanne
 			return nil, err
 		}
 
-		err = setDefaultURN(tx, channel.ID(), contact, urn, auth)
+		changed, err := setDefaultURN(tx, channel.ID(), contact, urn, auth)
 		if err != nil {
 			logrus.WithError(err).WithField("urn", urn.Identity()).WithField("org_id", org).Error("error looking up contact")
 			tx.Rollback()
 			return nil, err
 		}
-		return contact, tx.Commit()
+
+		err = tx.Commit()
+		if err != nil {
+			return nil, err
+		}
+
+		// our contact's other URNs may no longer have the right channel or priority
+		clearCachedContacts(rc, org, changed...)
+		cacheContact(rc, contact, channel, urn, auth)
+		return contact, nil
 	}
 
 	// didn't find it, we need to create it instead
@@ -210,14 +241,127 @@ func contactForURN(ctx context.Context, b *backend, org OrgID, channel *DBChanne
 
 	// store this URN on our contact
 	contact.URNID_ = contactURN.ID
+	cacheContact(rc, contact, channel, urn, auth)
 
-	// log that we created a new contact to librato
-	librato.Gauge("courier.new_contact", float64(1))
+	// log that we created a new contact
+	metrics.Count("new_contact", nil, 1)
 
 	// and return it
 	return contact, nil
 }
 
+// contacts stay cached for a minute, hits are checked against the database as they can be changed outside of courier
+const contactCacheTTL = time.Minute
+
+// the key contacts are cached under, by org and URN identity
+const contactCacheKey = "contact:%d:%s"
+
+// cachedContact is what we cache for a URN, the contact it belongs to and the channel and auth it was last seen with
+type cachedContact struct {
+	ID         ContactID           `json:"id"`
+	UUID       courier.ContactUUID `json:"uuid"`
+	Name       null.String         `json:"name"`
+	URNID      ContactURNID        `json:"urn_id"`
+	CreatedOn  time.Time           `json:"created_on"`
+	ModifiedOn time.Time           `json:"modified_on"`
+
+	ChannelID courier.ChannelID `json:"channel_id"`
+	Display   string            `json:"display"`
+	Auth      string            `json:"auth"`
+}
+
+// getCachedContact returns the cached contact for the passed in org and URN if there is one and it was cached for the
+// same channel, display and auth, meaning there is nothing we need to update, otherwise nil
+func getCachedContact(rc redis.Conn, org OrgID, channel *DBChannel, urn urns.URN, auth string) *DBContact {
+	cachedJSON, err := redis.Bytes(rc.Do("get", fmt.Sprintf(contactCacheKey, org, urn.Identity())))
+	if err != nil {
+		return nil
+	}
+
+	cached := &cachedContact{}
+	err = json.Unmarshal(cachedJSON, cached)
+	if err != nil {
+		logrus.WithError(err).WithField("urn", urn.Identity()).WithField("org_id", org).Error("error unmarshalling cached contact")
+		return nil
+	}
+
+	if cached.ChannelID != channel.ID() || cached.Display != urn.Display() || (auth != "" && cached.Auth != auth) {
+		return nil
+	}
+
+	return &DBContact{
+		OrgID_:      org,
+		ID_:         cached.ID,
+		UUID_:       cached.UUID,
+		Name_:       cached.Name,
+		URNID_:      cached.URNID,
+		CreatedOn_:  cached.CreatedOn,
+		ModifiedOn_: cached.ModifiedOn,
+	}
+}
+
+const checkCachedContactSQL = `
+SELECT 
+	EXISTS(
+		SELECT 
+			1 
+		FROM 
+			contacts_contacturn u 
+			INNER JOIN contacts_contact c ON c.id = u.contact_id 
+		WHERE 
+			u.id = $1 AND 
+			u.contact_id = $2 AND 
+			u.channel_id = $3 AND 
+			c.is_active = TRUE
+	)`
+
+// checkCachedContact returns whether the passed in cached contact is still active and still owns its URN on the
+// passed in channel, this is a single lookup by primary key so is much cheaper than looking up the contact again
+func checkCachedContact(ctx context.Context, db *sqlx.DB, contact *DBContact, channel *DBChannel) (bool, error) {
+	current := false
+	err := db.GetContext(ctx, &current, checkCachedContactSQL, contact.URNID_, contact.ID_, channel.ID())
+	return current, err
+}
+
+// cacheContact caches the passed in contact for the passed in URN, along with the channel and auth it was seen with
+func cacheContact(rc redis.Conn, contact *DBContact, channel *DBChannel, urn urns.URN, auth string) {
+	cachedJSON, err := json.Marshal(&cachedContact{
+		ID:         contact.ID_,
+		UUID:       contact.UUID_,
+		Name:       contact.Name_,
+		URNID:      contact.URNID_,
+		CreatedOn:  contact.CreatedOn_,
+		ModifiedOn: contact.ModifiedOn_,
+		ChannelID:  channel.ID(),
+		Display:    urn.Display(),
+		Auth:       auth,
+	})
+	if err == nil {
+		_, err = rc.Do("set", fmt.Sprintf(contactCacheKey, contact.OrgID_, urn.Identity()), cachedJSON, "ex", int(contactCacheTTL/time.Second))
+	}
+	if err != nil {
+		logrus.WithError(err).WithField("urn", urn.Identity()).WithField("org_id", contact.OrgID_).Error("error caching contact")
+	}
+}
+
+// clearCachedContacts clears the cached contacts for the passed in org and URN identities, called whenever the
+// contact, channel or priority of a URN changes
+func clearCachedContacts(rc redis.Conn, org OrgID, identities ...string) {
+	if len(identities) == 0 {
+		return
+	}
+
+	keys := make([]interface{}, len(identities))
+	for i, identity := range identities {
+		keys[i] = fmt.Sprintf(contactCacheKey, org, identity)
+	}
+
+	_, err := rc.Do("del", keys...)
+	if err != nil {
+		logrus.WithError(err).WithField("org_id", org).Error("error clearing cached contacts")
+	}
+}
+
 // DBContact is our struct for a contact in the database
 type DBContact struct {
 	OrgID_ OrgID               `db:"org_id"`
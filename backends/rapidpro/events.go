@@ -0,0 +1,88 @@
+package rapidpro
+
+import (
+	"encoding/json"
+	"net/url"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/events"
+	"github.com/sirupsen/logrus"
+)
+
+// the subdirectory of our spool directory we write events we failed to publish to
+const publishedSpoolDir = "published"
+
+// publishEvent queues an event with the passed in type and data to be published to our message bus, if we have one
+func (b *backend) publishEvent(eventType string, data interface{}) {
+	if b.fanout == nil {
+		return
+	}
+
+	event, err := events.NewEvent(eventType, data)
+	if err != nil {
+		logrus.WithError(err).WithField("event_type", eventType).Error("error creating event")
+		return
+	}
+	b.fanout.Queue(event)
+}
+
+// channelLogData is the data of a published channel log event, we don't include request and response bodies or the
+// full URL of the request, as these can contain the credentials of the channel, only the host the request was made to
+type channelLogData struct {
+	ChannelUUID courier.ChannelUUID `json:"channel_uuid"`
+	ChannelType courier.ChannelType `json:"channel_type"`
+	MsgID       courier.MsgID       `json:"msg_id,omitempty"`
+	Description string              `json:"description"`
+	Method      string              `json:"method"`
+	Host        string              `json:"host"`
+	StatusCode  int                 `json:"status_code"`
+	Error       string              `json:"error,omitempty"`
+	ElapsedMS   int64               `json:"elapsed_ms"`
+	CreatedOn   time.Time           `json:"created_on"`
+}
+
+func newChannelLogData(l *courier.ChannelLog) *channelLogData {
+	return &channelLogData{
+		ChannelUUID: l.Channel.UUID(),
+		ChannelType: l.Channel.ChannelType(),
+		MsgID:       l.MsgID,
+		Description: l.Description,
+		Method:      l.Method,
+		Host:        urlHost(l.URL),
+		StatusCode:  l.StatusCode,
+		Error:       l.Error,
+		ElapsedMS:   int64(l.Elapsed / time.Millisecond),
+		CreatedOn:   l.CreatedOn,
+	}
+}
+
+// urlHost returns the host of the passed in URL, or an empty string if it can't be parsed
+func urlHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// parsePublishedFile parses a spooled event, returning an invalid spool file error if it can never be published
+func parsePublishedFile(contents []byte) (*events.Event, error) {
+	event := &events.Event{}
+	err := json.Unmarshal(contents, event)
+	if err != nil {
+		return nil, courier.InvalidSpoolFile(err)
+	}
+	return event, nil
+}
+
+// flushPublishedFile tries to publish an event we previously failed to publish
+func (b *backend) flushPublishedFile(filename string, contents []byte) error {
+	event, err := parsePublishedFile(contents)
+	if err != nil {
+		return err
+	}
+
+	// try to publish it again
+	return b.fanout.Publish(event)
+}
@@ -0,0 +1,340 @@
+package rapidpro
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/go-chi/chi"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/storage"
+	"github.com/nyaruka/courier/utils"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	filetype "gopkg.in/h2non/filetype.v1"
+)
+
+// how many times we try to download an attachment before giving up on it
+const mediaDownloadAttempts = 3
+
+// how much of the start of an attachment we look at to figure out its type
+const mediaSniffSize = 300
+
+// the content type we use for attachments we weren't able to download and don't know the type of
+const unknownMediaType = "application/octet-stream"
+
+// how long we wait before our first retry of a failed download, doubling for each retry after that
+var mediaRetryBackoff = time.Second
+
+// errMediaTooLarge is returned when an attachment is bigger than the maximum size for its channel type
+var errMediaTooLarge = errors.New("media exceeds maximum size")
+
+// downloadMedia streams the attachment at the passed in URL to our media storage and returns the new attachment.
+// Attachments which can't be downloaded or which are too large for the channel type are replaced by a placeholder with
+// just their type, as their original URL may contain credentials, and a channel log with that URL is written, so an
+// error is only returned if we can't write to our storage.
+func downloadMedia(ctx context.Context, b *backend, channel courier.Channel, orgID OrgID, msgUUID courier.MsgUUID, mediaURL string) (string, error) {
+	parsedURL, err := url.Parse(mediaURL)
+	if err != nil {
+		return "", err
+	}
+
+	start := time.Now()
+	resp, statusCode, err := fetchMedia(ctx, b, channel, mediaURL)
+	if err != nil {
+		mimeType, _ := classifyMedia(nil, parsedURL, "")
+		logMediaFailure(ctx, b, channel, "Media Download Failed", mediaURL, statusCode, time.Since(start), err)
+		return placeholderAttachment(mimeType), nil
+	}
+	defer resp.Body.Close()
+
+	// if the provider tells us how big our media is, we can avoid downloading it at all
+	maxSize := b.config.MaxMediaSizeFor(channel.ChannelType())
+	if resp.ContentLength > maxSize {
+		mimeType, _ := classifyMedia(nil, parsedURL, resp.Header.Get("Content-Type"))
+		err := errors.Errorf("media size of %d bytes exceeds maximum of %d bytes", resp.ContentLength, maxSize)
+		logMediaFailure(ctx, b, channel, "Media Too Large", mediaURL, resp.StatusCode, time.Since(start), err)
+		return placeholderAttachment(mimeType), nil
+	}
+
+	// figure out our type from the start of our body, then stream the rest of it to S3
+	body := &mediaReader{reader: resp.Body, maxSize: maxSize}
+	buffered := bufio.NewReaderSize(body, mediaSniffSize)
+	prefix, _ := buffered.Peek(mediaSniffSize)
+	mimeType, extension := classifyMedia(prefix, parsedURL, resp.Header.Get("Content-Type"))
+
+	// create our filename
+	filename := msgUUID.String()
+	if extension != "" {
+		filename = fmt.Sprintf("%s.%s", msgUUID, extension)
+	}
+	path := filepath.Join(strconv.FormatInt(int64(orgID), 10), filename[:4], filename[4:8], filename)
+
+	storedURL, err := b.mediaStorage.Put(path, mimeType, buffered)
+	if err != nil {
+		// the upload failed because of our download, not because of our storage
+		if body.err != nil {
+			description := "Media Download Failed"
+			if body.err == errMediaTooLarge {
+				description = "Media Too Large"
+			}
+			logMediaFailure(ctx, b, channel, description, mediaURL, resp.StatusCode, time.Since(start), body.err)
+			return placeholderAttachment(mimeType), nil
+		}
+		return "", err
+	}
+
+	// return our new media URL, which is prefixed by our content type
+	return fmt.Sprintf("%s:%s", mimeType, storedURL), nil
+}
+
+// fetchMedia requests the attachment at the passed in URL, retrying with a backoff if the request fails or the server
+// returns an error we expect to be temporary. It returns the status code of the last response, which is zero if we
+// didn't get one.
+func fetchMedia(ctx context.Context, b *backend, channel courier.Channel, mediaURL string) (*http.Response, int, error) {
+	backoff := mediaRetryBackoff
+	for attempt := 1; ; attempt++ {
+		req, err := buildMediaRequest(ctx, b, channel, mediaURL)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		statusCode := 0
+		resp, err := utils.GetHTTPClient().Do(req.WithContext(ctx))
+		if err == nil {
+			if resp.StatusCode/100 == 2 {
+				return resp, resp.StatusCode, nil
+			}
+
+			statusCode = resp.StatusCode
+			resp.Body.Close()
+			err = errors.Errorf("media download returned status %d", statusCode)
+		}
+
+		if !isRetryableDownload(statusCode) || attempt >= mediaDownloadAttempts {
+			return nil, statusCode, err
+		}
+
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return nil, statusCode, errors.Wrapf(err, "context done before retry")
+		}
+	}
+}
+
+// buildMediaRequest builds the request to download the passed in attachment, which is built by the handler of the
+// channel if it needs to add authentication
+func buildMediaRequest(ctx context.Context, b *backend, channel courier.Channel, mediaURL string) (*http.Request, error) {
+	handler := courier.GetHandler(channel.ChannelType())
+	if handler != nil {
+		builder, isBuilder := handler.(courier.MediaDownloadRequestBuilder)
+		if isBuilder {
+			req, err := builder.BuildDownloadMediaRequest(ctx, b, channel, mediaURL)
+
+			// in the case of errors, we log the error but move onwards anyways
+			if err != nil {
+				logrus.WithField("channel_uuid", channel.UUID()).WithField("channel_type", channel.ChannelType()).WithField("media_url", mediaURL).WithError(err).Error("unable to build media download request")
+			}
+			if req != nil {
+				return req, nil
+			}
+		}
+	}
+
+	return http.NewRequest(http.MethodGet, mediaURL, nil)
+}
+
+// isRetryableDownload returns whether a download which failed with the passed in status code should be retried
+func isRetryableDownload(statusCode int) bool {
+	return statusCode == 0 || statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// classifyMedia figures out the mime type and extension of an attachment, first from the passed in start of its body,
+// then from the extension of its URL and finally from the content type its server gave us
+func classifyMedia(prefix []byte, mediaURL *url.URL, contentType string) (string, string) {
+	mimeType := ""
+	extension := filepath.Ext(mediaURL.Path)
+	if extension != "" {
+		extension = extension[1:]
+	}
+
+	// first try getting our mime type from the start of our body
+	fileType, _ := filetype.Match(prefix)
+	if fileType != filetype.Unknown {
+		mimeType = fileType.MIME.Value
+		extension = fileType.Extension
+	} else {
+		// if that didn't work, try from our extension
+		fileType = filetype.GetType(extension)
+		if fileType != filetype.Unknown {
+			mimeType = fileType.MIME.Value
+			extension = fileType.Extension
+		}
+	}
+
+	// we still don't know our mime type, use our content header instead
+	if mimeType == "" {
+		mimeType, _, _ = mime.ParseMediaType(contentType)
+		if extension == "" {
+			extensions, err := mime.ExtensionsByType(mimeType)
+			if extensions == nil || err != nil {
+				extension = ""
+			} else {
+				extension = extensions[0][1:]
+			}
+		}
+	}
+
+	return mimeType, extension
+}
+
+// placeholderAttachment returns the attachment we use for media we didn't download, which has its type but no URL
+func placeholderAttachment(mimeType string) string {
+	if mimeType == "" {
+		mimeType = unknownMediaType
+	}
+	return fmt.Sprintf("%s:", mimeType)
+}
+
+// logMediaFailure writes a channel log for an attachment which we didn't download, this is the only place its original
+// URL is recorded
+func logMediaFailure(ctx context.Context, b *backend, channel courier.Channel, description string, mediaURL string, statusCode int, elapsed time.Duration, err error) {
+	logrus.WithField("channel_uuid", channel.UUID()).WithError(err).Warn(strings.ToLower(description))
+
+	log := courier.NewChannelLog(description, channel, courier.NilMsgID, http.MethodGet, mediaURL, statusCode, "", "", elapsed, err)
+	b.WriteChannelLogs(ctx, []*courier.ChannelLog{log})
+}
+
+// mediaReader reads the body of an attachment, failing once more than our maximum size has been read and remembering
+// any error so we can tell download errors apart from upload errors
+type mediaReader struct {
+	reader  io.Reader
+	maxSize int64
+	read    int64
+	err     error
+}
+
+func (r *mediaReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+	if r.read > r.maxSize {
+		err = errMediaTooLarge
+	}
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// the longest pre-signed S3 URLs can be valid for, in hours
+const maxPresignedExpiration = 24 * 7
+
+// newMediaStorage creates the storage attachments are written to, as selected in our config
+func newMediaStorage(config *courier.Config) (storage.MediaStorage, error) {
+	// attachments are never public, so the URLs we give out for them always expire
+	if config.MediaURLExpiration < 1 {
+		return nil, errors.New("media URL expiration must be at least 1 hour")
+	}
+	expiration := time.Duration(config.MediaURLExpiration) * time.Hour
+
+	// URLs served by courier are signed with our media secret, which must be set explicitly if we use them
+	var signer *storage.URLSigner
+	if config.MediaSecret != "" {
+		signer = storage.NewURLSigner(config.Domain, config.MediaSecret, expiration)
+	}
+
+	switch strings.ToLower(config.MediaStorage) {
+	case "local":
+		if signer == nil {
+			return nil, errors.New("media secret must be set to use local media storage")
+		}
+		return storage.NewLocalStorage(config.MediaDir, signer), nil
+
+	case "s3", "s3compatible":
+		awsConfig := &aws.Config{
+			Credentials:      credentials.NewStaticCredentials(config.AWSAccessKeyID, config.AWSSecretAccessKey, ""),
+			Endpoint:         aws.String(config.S3Endpoint),
+			Region:           aws.String(config.S3Region),
+			DisableSSL:       aws.Bool(config.S3DisableSSL),
+			S3ForcePathStyle: aws.Bool(config.S3ForcePathStyle),
+		}
+
+		s3Session, err := session.NewSession(awsConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		switch strings.ToLower(config.S3MediaURLs) {
+		case "proxy":
+			if signer == nil {
+				return nil, errors.New("media secret must be set to serve S3 media through courier")
+			}
+		case "presigned":
+			// pre-signed URLs can only be valid for so long
+			if config.MediaURLExpiration > maxPresignedExpiration {
+				return nil, errors.Errorf("media URL expiration must be no more than %d hours to use pre-signed S3 URLs", maxPresignedExpiration)
+			}
+			signer = nil
+		default:
+			return nil, errors.Errorf("unknown S3 media URLs '%s', must be proxy or presigned", config.S3MediaURLs)
+		}
+
+		return storage.NewS3Storage(s3.New(s3Session), config.S3MediaBucket, config.S3MediaPrefix, signer, expiration), nil
+
+	default:
+		return nil, errors.Errorf("unknown media storage '%s', must be s3, s3compatible or local", config.MediaStorage)
+	}
+}
+
+// AddRoutes adds the route attachments are served from when they are served by courier rather than by our storage
+func (b *backend) AddRoutes(r chi.Router) {
+	r.Get("/media/{expires}/{signature}/*", b.handleMedia)
+}
+
+// handleMedia serves the attachment at the requested path if the URL was signed by us and hasn't expired
+func (b *backend) handleMedia(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	path := chi.URLParam(r, "*")
+
+	if b.config.MediaSecret == "" {
+		courier.WriteDataResponse(ctx, w, http.StatusNotFound, "Not Found", []interface{}{courier.NewErrorData("media not served")})
+		return
+	}
+
+	signer := storage.NewURLSigner(b.config.Domain, b.config.MediaSecret, time.Duration(b.config.MediaURLExpiration)*time.Hour)
+	err := signer.Verify(path, chi.URLParam(r, "expires"), chi.URLParam(r, "signature"), time.Now())
+	if err != nil {
+		courier.WriteDataResponse(ctx, w, http.StatusForbidden, "Forbidden", []interface{}{courier.NewErrorData(err.Error())})
+		return
+	}
+
+	contents, contentType, err := b.mediaStorage.Get(path)
+	if err == storage.ErrNotFound {
+		courier.WriteDataResponse(ctx, w, http.StatusNotFound, "Not Found", []interface{}{courier.NewErrorData(err.Error())})
+		return
+	}
+	if err != nil {
+		logrus.WithError(err).WithField("path", path).Error("error reading media")
+		courier.WriteDataResponse(ctx, w, http.StatusInternalServerError, "Error", []interface{}{courier.NewErrorData("error reading media")})
+		return
+	}
+	defer contents.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, contents)
+}
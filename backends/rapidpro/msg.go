@@ -4,29 +4,20 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"log"
-	"net/http"
-	"net/url"
-	"os"
-	"path/filepath"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/buger/jsonparser"
 
-	"mime"
-
 	"github.com/garyburd/redigo/redis"
 	"github.com/lib/pq"
 	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/batch"
+	"github.com/nyaruka/courier/events"
 	"github.com/nyaruka/courier/queue"
-	"github.com/nyaruka/courier/utils"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/nyaruka/null"
 	"github.com/sirupsen/logrus"
-	filetype "gopkg.in/h2non/filetype.v1"
 )
 
 // MsgDirection is the direction of a message
@@ -60,10 +51,10 @@ func writeMsg(ctx context.Context, b *backend, msg courier.Msg) error {
 
 	channel := m.Channel()
 
-	// if we have media, go download it to S3
+	// if we have media, go download it to our storage
 	for i, attachment := range m.Attachments_ {
 		if strings.HasPrefix(attachment, "http") {
-			url, err := downloadMediaToS3(ctx, b, channel, m.OrgID_, m.UUID_, attachment)
+			url, err := downloadMedia(ctx, b, channel, m.OrgID_, m.UUID_, attachment)
 			if err != nil {
 				return err
 			}
@@ -79,12 +70,16 @@ func writeMsg(ctx context.Context, b *backend, msg courier.Msg) error {
 		logrus.WithError(err).WithField("msg", m.UUID().String()).Error("error writing to db")
 	}
 
-	// if we failed write to spool
+	// if we failed write to spool, we'll publish it once it is flushed to our db and has an id
 	if err != nil {
 		err = courier.WriteToSpool(b.config.SpoolDir, "msgs", m)
+	} else {
+		b.publishEvent(events.TypeMsgReceived, courier.NewMsgReceiveData(m))
 	}
+
 	// mark this msg as having been seen
 	writeMsgSeen(b, m)
+
 	return err
 }
 
@@ -125,7 +120,7 @@ INSERT INTO
              visibility, external_id, channel_id, contact_id, contact_urn_id, created_on, modified_on, next_attempt, queued_on, sent_on)
     VALUES(:org_id, :uuid, :direction, :text, :attachments, :msg_count, :error_count, :high_priority, :status,
            :visibility, :external_id, :channel_id, :contact_id, :contact_urn_id, :created_on, :modified_on, :next_attempt, :queued_on, :sent_on)
-RETURNING id
+RETURNING id, uuid
 `
 
 func writeMsgToDB(ctx context.Context, b *backend, m *DBMsg) error {
@@ -140,31 +135,28 @@ func writeMsgToDB(ctx context.Context, b *backend, m *DBMsg) error {
 	// set our contact and urn ids from our contact
 	m.ContactID_ = contact.ID_
 	m.ContactURNID_ = contact.URNID_
+	m.contact = contact
 
-	rows, err := b.db.NamedQueryContext(ctx, insertMsgSQL, m)
-	if err != nil {
-		return err
-	}
-	defer rows.Close()
+	// insert it along with any other msgs being written, this assigns our id and queues it to be handled by RapidPro
+	return b.msgInserter.Insert(m)
+}
 
-	rows.Next()
-	err = rows.Scan(&m.ID_)
-	if err != nil {
-		return err
+// queueInsertedMsgs queues handling of msgs which have been inserted by our inserter
+func queueInsertedMsgs(b *backend, values []batch.Value) {
+	msgs := make([]*DBMsg, len(values))
+	for i, v := range values {
+		msgs[i] = v.(*DBMsg)
 	}
 
-	// queue this up to be handled by RapidPro
 	rc := b.redisPool.Get()
 	defer rc.Close()
-	err = queueMsgHandling(rc, contact, m)
+	err := queueMsgsHandling(rc, msgs)
 
-	// if we had a problem queueing the handling, log it, but our message is written, it'll
+	// if we had a problem queueing the handling, log it, but our messages are written, they'll
 	// get picked up by our rapidpro catch-all after a period
 	if err != nil {
-		logrus.WithError(err).WithField("msg_id", m.ID_).Error("error queueing msg handling")
+		logrus.WithError(err).WithField("count", len(msgs)).Error("error queueing msg handling")
 	}
-
-	return nil
 }
 
 const selectMsgSQL = `
@@ -202,101 +194,6 @@ func readMsgFromDB(b *backend, id courier.MsgID) (*DBMsg, error) {
 	return m, err
 }
 
-//-----------------------------------------------------------------------------
-// Media download and classification
-//-----------------------------------------------------------------------------
-
-func downloadMediaToS3(ctx context.Context, b *backend, channel courier.Channel, orgID OrgID, msgUUID courier.MsgUUID, mediaURL string) (string, error) {
-
-	parsedURL, err := url.Parse(mediaURL)
-	if err != nil {
-		return "", err
-	}
-
-	var req *http.Request
-	handler := courier.GetHandler(channel.ChannelType())
-	if handler != nil {
-		builder, isBuilder := handler.(courier.MediaDownloadRequestBuilder)
-		if isBuilder {
-			req, err = builder.BuildDownloadMediaRequest(ctx, b, channel, parsedURL.String())
-
-			// in the case of errors, we log the error but move onwards anyways
-			if err != nil {
-				logrus.WithField("channel_uuid", channel.UUID()).WithField("channel_type", channel.ChannelType()).WithField("media_url", mediaURL).WithError(err).Error("unable to build media download request")
-			}
-		}
-	}
-
-	if req == nil {
-		// first fetch our media
-		req, err = http.NewRequest(http.MethodGet, mediaURL, nil)
-		if err != nil {
-			return "", err
-		}
-	}
-
-	resp, err := utils.GetHTTPClient().Do(req)
-	if err != nil {
-		return "", err
-	}
-	body, err := ioutil.ReadAll(resp.Body)
-	defer resp.Body.Close()
-	if err != nil {
-		return "", err
-	}
-
-	mimeType := ""
-	extension := filepath.Ext(parsedURL.Path)
-	if extension != "" {
-		extension = extension[1:]
-	}
-
-	// first try getting our mime type from the first 300 bytes of our body
-	fileType, err := filetype.Match(body[:300])
-	if fileType != filetype.Unknown {
-		mimeType = fileType.MIME.Value
-		extension = fileType.Extension
-	} else {
-		// if that didn't work, try from our extension
-		fileType = filetype.GetType(extension)
-		if fileType != filetype.Unknown {
-			mimeType = fileType.MIME.Value
-			extension = fileType.Extension
-		}
-	}
-
-	// we still don't know our mime type, use our content header instead
-	if mimeType == "" {
-		mimeType, _, _ = mime.ParseMediaType(resp.Header.Get("Content-Type"))
-		if extension == "" {
-			extensions, err := mime.ExtensionsByType(mimeType)
-			if extensions == nil || err != nil {
-				extension = ""
-			} else {
-				extension = extensions[0][1:]
-			}
-		}
-	}
-
-	// create our filename
-	filename := msgUUID.String()
-	if extension != "" {
-		filename = fmt.Sprintf("%s.%s", msgUUID, extension)
-	}
-	path := filepath.Join(b.config.S3MediaPrefix, strconv.FormatInt(int64(orgID), 10), filename[:4], filename[4:8], filename)
-	if !strings.HasPrefix(path, "/") {
-		path = fmt.Sprintf("/%s", path)
-	}
-
-	s3URL, err := utils.PutS3File(b.s3Client, b.config.S3MediaBucket, path, mimeType, body)
-	if err != nil {
-		return "", err
-	}
-
-	// return our new media URL, which is prefixed by our content type
-	return fmt.Sprintf("%s:%s", mimeType, s3URL), nil
-}
-
 //-----------------------------------------------------------------------------
 // Msg flusher for flushing failed writes
 //-----------------------------------------------------------------------------
@@ -305,12 +202,9 @@ func (b *backend) flushMsgFile(filename string, contents []byte) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
 	defer cancel()
 
-	msg := &DBMsg{}
-	err := json.Unmarshal(contents, msg)
+	msg, err := parseMsgFile(contents)
 	if err != nil {
-		log.Printf("ERROR unmarshalling spool file '%s', renaming: %s\n", filename, err)
-		os.Rename(filename, fmt.Sprintf("%s.error", filename))
-		return nil
+		return err
 	}
 
 	// look up our channel
@@ -324,7 +218,25 @@ func (b *backend) flushMsgFile(filename string, contents []byte) error {
 	err = writeMsgToDB(ctx, b, msg)
 
 	// fail? oh well, we'll try again later
-	return err
+	if err != nil {
+		return err
+	}
+
+	b.publishEvent(events.TypeMsgReceived, courier.NewMsgReceiveData(msg))
+	return nil
+}
+
+// parseMsgFile parses a spooled msg, returning an invalid spool file error if it can never be written
+func parseMsgFile(contents []byte) (*DBMsg, error) {
+	msg := &DBMsg{}
+	err := json.Unmarshal(contents, msg)
+	if err != nil {
+		return nil, courier.InvalidSpoolFile(err)
+	}
+	if msg.ChannelUUID_ == courier.NilChannelUUID {
+		return nil, courier.InvalidSpoolFile(fmt.Errorf("missing channel_uuid"))
+	}
+	return msg, nil
 }
 
 //-----------------------------------------------------------------------------
@@ -499,6 +411,9 @@ type DBMsg struct {
 	QueuedOn_    time.Time `json:"queued_on"     db:"queued_on"`
 	SentOn_      time.Time `json:"sent_on"       db:"sent_on"`
 
+	// msgs can be queued to be held until a later time, such as when a provider asked us to wait before retrying
+	SendAfter_ *time.Time `json:"send_after,omitempty"`
+
 	// fields used only for mailroom enabled orgs.. these allow courier to update a session's timeout when
 	// a message is sent for correct and efficient timeout behavior
 	SessionID_            SessionID  `json:"session_id,omitempty"`
@@ -506,11 +421,22 @@ type DBMsg struct {
 	SessionWaitStartedOn_ *time.Time `json:"session_wait_started_on,omitempty"`
 
 	channel        *DBChannel
+	contact        *DBContact
 	workerToken    queue.WorkerToken
 	alreadyWritten bool
 	quickReplies   []string
 }
 
+// RowID satisfies our batch.Value interface, we are always inserting msgs so we have no row id
+func (m *DBMsg) RowID() string {
+	return ""
+}
+
+// InsertKey satisfies our batch.KeyedValue interface, msgs returned by our insert are matched by their UUID
+func (m *DBMsg) InsertKey() string {
+	return m.UUID_.String()
+}
+
 func (m *DBMsg) ID() courier.MsgID            { return m.ID_ }
 func (m *DBMsg) EventID() int64               { return int64(m.ID_) }
 func (m *DBMsg) UUID() courier.MsgUUID        { return m.UUID_ }
@@ -71,6 +71,9 @@ CREATE TABLE msgs_msg (
     msg_count integer NOT NULL,
     error_count integer NOT NULL,
     next_attempt timestamp with time zone NOT NULL,
+    error_code character varying(32),
+    provider_error_code character varying(64),
+    error_description text,
     external_id character varying(255),
     attachments character varying(255)[],
     channel_id integer references channels_channel(id) on delete cascade,
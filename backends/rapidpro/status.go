@@ -5,20 +5,23 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"log"
-	"os"
+	"math"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/events"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/sirupsen/logrus"
 )
 
 // newMsgStatus creates a new DBMsgStatus for the passed in parameters
 func newMsgStatus(channel courier.Channel, id courier.MsgID, externalID string, status courier.MsgStatusValue) *DBMsgStatus {
 	dbChannel := channel.(*DBChannel)
 
-	return &DBMsgStatus{
+	dbStatus := &DBMsgStatus{
 		ChannelUUID_: channel.UUID(),
 		ChannelID_:   dbChannel.ID(),
 		ID_:          id,
@@ -26,6 +29,8 @@ func newMsgStatus(channel courier.Channel, id courier.MsgID, externalID string,
 		Status_:      status,
 		ModifiedOn_:  time.Now().In(time.UTC),
 	}
+	dbStatus.setRetryPolicy(courier.GetRetryPolicy(channel))
+	return dbStatus
 }
 
 // writeMsgStatus writes the passed in status to the database, queueing it to our spool in case the database is down
@@ -69,15 +74,28 @@ func checkMsgExists(b *backend, status courier.MsgStatus) (err error) {
 	return err
 }
 
-// the craziness below lets us update our status to 'F' and schedule retries without knowing anything about the message
-const updateMsgID = `
+// the status transitions allowed by our state machine as concatenated from and to pairs, ex: 'WS', statuses which
+// would take a msg anywhere else are not applied
+var validStatusTransitionsSQL = buildStatusTransitionsSQL()
+
+func buildStatusTransitionsSQL() string {
+	pairs := make([]string, 0)
+	for _, t := range courier.ValidMsgStatusTransitions() {
+		pairs = append(pairs, fmt.Sprintf("'%s%s'", t[0], t[1]))
+	}
+	return strings.Join(pairs, ", ")
+}
+
+// the craziness below lets us update our status to 'F' and schedule retries with the delays of the channel's retry
+// policy, or any retry-after hint from the provider, without knowing anything about the message
+const updateMsgIDSQL = `
 UPDATE msgs_msg SET 
 	status = CASE 
 		WHEN 
 			:status = 'E' 
 		THEN CASE 
 			WHEN 
-				error_count >= 2 OR status = 'F' 
+				(CAST(:retry_delays AS INTEGER[]))[error_count + 1] IS NULL OR status = 'F' 
 			THEN 
 				'F' 
 			ELSE 
@@ -98,7 +116,10 @@ UPDATE msgs_msg SET
 		WHEN 
 			:status = 'E' 
 		THEN 
-			NOW() + (5 * (error_count+1) * interval '1 minutes') 
+			NOW() + COALESCE(
+				NULLIF(CAST(:retry_after AS INTEGER), 0),
+				(CAST(:retry_delays AS INTEGER[]))[error_count + 1]
+			) * interval '1 second' 
 		ELSE 
 			next_attempt 
 		END,
@@ -118,22 +139,23 @@ UPDATE msgs_msg SET
 		ELSE
 			external_id
 		END,
-	modified_on = :modified_on
+%s	modified_on = :modified_on
 WHERE 
 	msgs_msg.id = :msg_id AND
-	msgs_msg.channel_id = :channel_id
+	msgs_msg.channel_id = :channel_id AND
+	(msgs_msg.status || :status) IN (%s)
 RETURNING 
 	msgs_msg.id
 `
 
-const updateMsgExternalID = `
+const updateMsgExternalIDSQL = `
 UPDATE msgs_msg SET 
 	status = CASE 
 		WHEN 
 			:status = 'E' 
 		THEN CASE 
 			WHEN 
-				error_count >= 2 OR status = 'F' 
+				(CAST(:retry_delays AS INTEGER[]))[error_count + 1] IS NULL OR status = 'F' 
 			THEN 
 				'F' 
 			ELSE 
@@ -154,7 +176,10 @@ UPDATE msgs_msg SET
 		WHEN 
 			:status = 'E' 
 		THEN 
-			NOW() + (5 * (error_count+1) * interval '1 minutes') 
+			NOW() + COALESCE(
+				NULLIF(CAST(:retry_after AS INTEGER), 0),
+				(CAST(:retry_delays AS INTEGER[]))[error_count + 1]
+			) * interval '1 second' 
 		ELSE 
 			next_attempt 
 		END,
@@ -166,9 +191,10 @@ UPDATE msgs_msg SET
 		ELSE 
 			sent_on 
 		END,
-	modified_on = :modified_on
+%s	modified_on = :modified_on
 WHERE 
-	msgs_msg.id = (SELECT msgs_msg.id FROM msgs_msg WHERE msgs_msg.external_id = :external_id AND msgs_msg.channel_id = :channel_id LIMIT 1)
+	msgs_msg.id = (SELECT msgs_msg.id FROM msgs_msg WHERE msgs_msg.external_id = :external_id AND msgs_msg.channel_id = :channel_id LIMIT 1) AND
+	(msgs_msg.status || :status) IN (%s)
 RETURNING 
 	msgs_msg.id
 `
@@ -178,10 +204,15 @@ func writeMsgStatusToDB(ctx context.Context, b *backend, status *DBMsgStatus) er
 	var rows *sqlx.Rows
 	var err error
 
+	// statuses spooled before we had retry delays won't have any, use our default policy
+	if status.RetryDelays_ == "" {
+		status.setRetryPolicy(courier.DefaultRetryPolicy)
+	}
+
 	if status.ID() != courier.NilMsgID {
-		rows, err = b.db.NamedQueryContext(ctx, updateMsgID, status)
+		rows, err = b.db.NamedQueryContext(ctx, b.getStatusSQL().updateMsgID, status)
 	} else if status.ExternalID() != "" {
-		rows, err = b.db.NamedQueryContext(ctx, updateMsgExternalID, status)
+		rows, err = b.db.NamedQueryContext(ctx, b.getStatusSQL().updateMsgExternalID, status)
 	} else {
 		return fmt.Errorf("attempt to update msg status without id or external id")
 	}
@@ -194,19 +225,90 @@ func writeMsgStatusToDB(ctx context.Context, b *backend, status *DBMsgStatus) er
 	if rows.Next() {
 		rows.Scan(&status.ID_)
 	} else {
+		return checkStatusNotApplied(ctx, b, status)
+	}
+
+	b.publishEvent(events.TypeMsgStatus, courier.NewStatusData(status))
+	return nil
+}
+
+// how far back before a read watermark we look for msgs which it marks as read
+const readWatermarkWindow = time.Hour * 24 * 7
+
+const selectMsgsSentBeforeSQL = `
+SELECT 
+	m.id 
+FROM 
+	msgs_msg m 
+	INNER JOIN contacts_contacturn u ON u.id = m.contact_urn_id 
+WHERE 
+	m.channel_id = $1 AND 
+	u.org_id = $2 AND 
+	u.identity = $3 AND 
+	m.direction = 'O' AND 
+	m.status IN ('W', 'S', 'D') AND 
+	COALESCE(m.sent_on, m.created_on) <= $4 AND 
+	m.created_on > $5`
+
+// selectMsgsSentBefore returns the ids of the msgs we've sent to the passed in URN on the passed in channel before
+// the watermark which haven't yet been read
+func selectMsgsSentBefore(ctx context.Context, b *backend, channel *DBChannel, urn urns.URN, watermark time.Time) ([]courier.MsgID, error) {
+	ids := make([]courier.MsgID, 0)
+	err := b.db.SelectContext(ctx, &ids, selectMsgsSentBeforeSQL, channel.ID_, channel.OrgID_, urn.Identity().String(), watermark, watermark.Add(-readWatermarkWindow))
+	return ids, err
+}
+
+const selectMsgStatusForID = `
+SELECT status FROM msgs_msg WHERE id = $1 AND channel_id = $2`
+
+const selectMsgStatusForExternalID = `
+SELECT status FROM msgs_msg WHERE external_id = $1 AND channel_id = $2 LIMIT 1`
+
+// checkStatusNotApplied works out why the passed in status wasn't applied, returning ErrMsgNotFound if its msg doesn't
+// exist, otherwise the status would have regressed the msg so we log that and drop it
+func checkStatusNotApplied(ctx context.Context, b *backend, status *DBMsgStatus) error {
+	var current courier.MsgStatusValue
+	var err error
+
+	if status.ID() != courier.NilMsgID {
+		err = b.db.QueryRowContext(ctx, selectMsgStatusForID, status.ID(), status.ChannelID_).Scan(&current)
+	} else {
+		err = b.db.QueryRowContext(ctx, selectMsgStatusForExternalID, status.ExternalID(), status.ChannelID_).Scan(&current)
+	}
+	if err == sql.ErrNoRows {
 		return courier.ErrMsgNotFound
 	}
+	if err != nil {
+		return err
+	}
 
+	logrus.WithFields(logrus.Fields{
+		"channel_uuid": status.ChannelUUID(),
+		"msg_id":       status.ID().String(),
+		"external_id":  status.ExternalID(),
+		"from":         current,
+		"to":           status.Status(),
+	}).Warn("rejected msg status regression")
 	return nil
 }
 
-func (b *backend) flushStatusFile(filename string, contents []byte) error {
+// parseStatusFile parses a spooled msg status, returning an invalid spool file error if it can never be written
+func parseStatusFile(contents []byte) (*DBMsgStatus, error) {
 	status := &DBMsgStatus{}
 	err := json.Unmarshal(contents, status)
 	if err != nil {
-		log.Printf("ERROR unmarshalling spool file '%s', renaming: %s\n", filename, err)
-		os.Rename(filename, fmt.Sprintf("%s.error", filename))
-		return nil
+		return nil, courier.InvalidSpoolFile(err)
+	}
+	if status.ID() == courier.NilMsgID && status.ExternalID() == "" {
+		return nil, courier.InvalidSpoolFile(fmt.Errorf("missing msg_id or external_id"))
+	}
+	return status, nil
+}
+
+func (b *backend) flushStatusFile(filename string, contents []byte) error {
+	status, err := parseStatusFile(contents)
+	if err != nil {
+		return err
 	}
 
 	// try to flush to our db
@@ -232,7 +334,7 @@ UPDATE msgs_msg SET
 			s.status = 'E' 
 		THEN CASE 
 			WHEN 
-				error_count >= 2 OR msgs_msg.status = 'F' 
+				(s.retry_delays::int[])[error_count + 1] IS NULL OR msgs_msg.status = 'F' 
 			THEN 
 				'F' 
 			ELSE 
@@ -253,7 +355,10 @@ UPDATE msgs_msg SET
 		WHEN 
 			s.status = 'E' 
 		THEN 
-			NOW() + (5 * (error_count+1) * interval '1 minutes') 
+			NOW() + COALESCE(
+				NULLIF(s.retry_after::int, 0),
+				(s.retry_delays::int[])[error_count + 1]
+			) * interval '1 second' 
 		ELSE 
 			next_attempt 
 		END,
@@ -273,18 +378,166 @@ UPDATE msgs_msg SET
 		ELSE
 			msgs_msg.external_id
 		END,
-	modified_on = NOW()
+%s	modified_on = NOW()
 FROM
-	(VALUES(:msg_id, :channel_id, :status, :external_id)) 
+	(VALUES(:msg_id, :channel_id, :status, :external_id, :retry_delays, :retry_after, :error_code, :provider_error_code, :error_description)) 
 AS 
-	s(msg_id, channel_id, status, external_id) 
+	s(msg_id, channel_id, status, external_id, retry_delays, retry_after, error_code, provider_error_code, error_description) 
 WHERE 
 	msgs_msg.id = s.msg_id::int AND
-	msgs_msg.channel_id = s.channel_id::int
+	msgs_msg.channel_id = s.channel_id::int AND
+	(msgs_msg.status || s.status) IN (%s)
 RETURNING 
 	msgs_msg.id
 `
 
+// how our status updates set the error columns of msgs, these are only written if the msgs_msg table has them
+const updateMsgErrorSQL = `
+	error_code = CASE 
+		WHEN 
+			:error_code != '' 
+		THEN 
+			:error_code 
+		WHEN 
+			:status IN ('W', 'S', 'D', 'R') 
+		THEN 
+			NULL 
+		ELSE 
+			error_code 
+		END,
+	provider_error_code = CASE 
+		WHEN 
+			:error_code != '' 
+		THEN 
+			NULLIF(:provider_error_code, '') 
+		WHEN 
+			:status IN ('W', 'S', 'D', 'R') 
+		THEN 
+			NULL 
+		ELSE 
+			provider_error_code 
+		END,
+	error_description = CASE 
+		WHEN 
+			:error_code != '' 
+		THEN 
+			NULLIF(:error_description, '') 
+		WHEN 
+			:status IN ('W', 'S', 'D', 'R') 
+		THEN 
+			NULL 
+		ELSE 
+			error_description 
+		END,
+`
+
+const bulkUpdateMsgErrorSQL = `
+	error_code = CASE 
+		WHEN 
+			s.error_code != '' 
+		THEN 
+			s.error_code 
+		WHEN 
+			s.status IN ('W', 'S', 'D', 'R') 
+		THEN 
+			NULL 
+		ELSE 
+			msgs_msg.error_code 
+		END,
+	provider_error_code = CASE 
+		WHEN 
+			s.error_code != '' 
+		THEN 
+			NULLIF(s.provider_error_code, '') 
+		WHEN 
+			s.status IN ('W', 'S', 'D', 'R') 
+		THEN 
+			NULL 
+		ELSE 
+			msgs_msg.provider_error_code 
+		END,
+	error_description = CASE 
+		WHEN 
+			s.error_code != '' 
+		THEN 
+			NULLIF(s.error_description, '') 
+		WHEN 
+			s.status IN ('W', 'S', 'D', 'R') 
+		THEN 
+			NULL 
+		ELSE 
+			msgs_msg.error_description 
+		END,
+`
+
+// statusSQL holds the queries we update msgs with when writing statuses
+type statusSQL struct {
+	updateMsgID         string
+	updateMsgExternalID string
+	bulkUpdateMsgStatus string
+}
+
+// newStatusSQL builds the queries we update msgs with when writing statuses, errorColumns is whether the msgs_msg
+// table has the columns for the errors of failed sends, which RapidPro only has once migrated to add them
+func newStatusSQL(errorColumns bool) *statusSQL {
+	updateError, bulkUpdateError := "", ""
+	if errorColumns {
+		updateError, bulkUpdateError = updateMsgErrorSQL, bulkUpdateMsgErrorSQL
+	}
+	return &statusSQL{
+		updateMsgID:         fmt.Sprintf(updateMsgIDSQL, updateError, validStatusTransitionsSQL),
+		updateMsgExternalID: fmt.Sprintf(updateMsgExternalIDSQL, updateError, validStatusTransitionsSQL),
+		bulkUpdateMsgStatus: fmt.Sprintf(bulkUpdateMsgStatusSQL, bulkUpdateError, validStatusTransitionsSQL),
+	}
+}
+
+const selectMsgErrorColumns = `
+SELECT COUNT(*) FROM information_schema.columns 
+WHERE table_name = 'msgs_msg' AND column_name IN ('error_code', 'provider_error_code', 'error_description')`
+
+// hasMsgErrorColumns returns whether the msgs_msg table has the columns for the errors of failed sends
+func hasMsgErrorColumns(ctx context.Context, db *sqlx.DB) (bool, error) {
+	count := 0
+	err := db.GetContext(ctx, &count, selectMsgErrorColumns)
+	return count == 3, err
+}
+
+// the queries we use while we don't know whether msgs_msg has error columns
+var statusSQLWithoutErrors = newStatusSQL(false)
+
+// how often we check again whether msgs_msg has error columns if we couldn't
+const statusSQLRecheck = time.Minute
+
+// getStatusSQL returns the queries we update msgs with when writing statuses. We check whether msgs_msg has error
+// columns the first time, and if we couldn't, because our db was unavailable, we don't write errors but check again
+// once a minute until we can.
+func (b *backend) getStatusSQL() *statusSQL {
+	b.statusSQLMutex.Lock()
+	defer b.statusSQLMutex.Unlock()
+
+	if b.statusSQL == nil && time.Since(b.statusSQLCheckedOn) >= statusSQLRecheck {
+		b.statusSQLCheckedOn = time.Now()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		errorColumns, err := hasMsgErrorColumns(ctx, b.db)
+		cancel()
+
+		if err != nil {
+			logrus.WithError(err).Error("unable to check msgs_msg for error columns, will check again")
+		} else {
+			if !errorColumns {
+				logrus.Info("msgs_msg has no error columns, send error codes will not be written")
+			}
+			b.statusSQL = newStatusSQL(errorColumns)
+		}
+	}
+
+	if b.statusSQL == nil {
+		return statusSQLWithoutErrors
+	}
+	return b.statusSQL
+}
+
 //-----------------------------------------------------------------------------
 // MsgStatusUpdate implementation
 //-----------------------------------------------------------------------------
@@ -298,9 +551,35 @@ type DBMsgStatus struct {
 	Status_      courier.MsgStatusValue `json:"status"                   db:"status"`
 	ModifiedOn_  time.Time              `json:"modified_on"              db:"modified_on"`
 
+	// the delays in seconds before each retry from the retry policy of our channel as a Postgres array, applied if
+	// this status is errored, once there are no more retries our msg is failed
+	RetryDelays_ string `json:"retry_delays,omitempty" db:"retry_delays"`
+	retryPolicy  *courier.RetryPolicy
+
+	// the error our send failed with, a retry-after hint from it overrides our retry policy
+	ErrorCode_         courier.ErrorCode `json:"error_code,omitempty"          db:"error_code"`
+	ProviderErrorCode_ string            `json:"provider_error_code,omitempty" db:"provider_error_code"`
+	ErrorDescription_  string            `json:"error_description,omitempty"   db:"error_description"`
+	RetryAfter_        int               `json:"retry_after,omitempty"         db:"retry_after"`
+
 	logs []*courier.ChannelLog
 }
 
+// setRetryPolicy sets the retry policy that will be applied if this status is errored, we only need its delays if it is
+func (s *DBMsgStatus) setRetryPolicy(policy courier.RetryPolicy) {
+	s.retryPolicy = &policy
+	s.RetryDelays_ = "{}"
+	if s.Status_ != courier.MsgErrored {
+		return
+	}
+
+	delays := make([]string, 0, policy.MaxAttempts)
+	for _, delay := range policy.Delays() {
+		delays = append(delays, strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+	}
+	s.RetryDelays_ = "{" + strings.Join(delays, ",") + "}"
+}
+
 func (s *DBMsgStatus) EventID() int64 { return int64(s.ID_) }
 
 func (s *DBMsgStatus) ChannelUUID() courier.ChannelUUID { return s.ChannelUUID_ }
@@ -321,5 +600,39 @@ func (s *DBMsgStatus) SetExternalID(id string) { s.ExternalID_ = id }
 func (s *DBMsgStatus) Logs() []*courier.ChannelLog    { return s.logs }
 func (s *DBMsgStatus) AddLog(log *courier.ChannelLog) { s.logs = append(s.logs, log) }
 
-func (s *DBMsgStatus) Status() courier.MsgStatusValue          { return s.Status_ }
-func (s *DBMsgStatus) SetStatus(status courier.MsgStatusValue) { s.Status_ = status }
+func (s *DBMsgStatus) Status() courier.MsgStatusValue { return s.Status_ }
+
+func (s *DBMsgStatus) SetStatus(status courier.MsgStatusValue) {
+	s.Status_ = status
+
+	// our retry delays depend on whether we are errored
+	if s.retryPolicy != nil {
+		s.setRetryPolicy(*s.retryPolicy)
+	}
+}
+
+func (s *DBMsgStatus) SendError() *courier.SendError {
+	if s.ErrorCode_ == "" {
+		return nil
+	}
+	return &courier.SendError{
+		Code:         s.ErrorCode_,
+		ProviderCode: s.ProviderErrorCode_,
+		Description:  s.ErrorDescription_,
+		RetryAfter:   time.Duration(s.RetryAfter_) * time.Second,
+	}
+}
+
+func (s *DBMsgStatus) SetSendError(err *courier.SendError) {
+	s.ErrorCode_, s.ProviderErrorCode_, s.ErrorDescription_, s.RetryAfter_ = "", "", "", 0
+	if err == nil {
+		return
+	}
+
+	s.ErrorCode_ = err.Code
+	s.ProviderErrorCode_ = err.ProviderCode
+	s.ErrorDescription_ = err.Description
+	if err.RetryAfter > 0 {
+		s.RetryAfter_ = int(math.Ceil(err.RetryAfter.Seconds()))
+	}
+}
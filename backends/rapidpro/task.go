@@ -9,7 +9,24 @@ import (
 	"github.com/nyaruka/courier"
 )
 
-func queueMsgHandling(rc redis.Conn, c *DBContact, m *DBMsg) error {
+// queueMsgsHandling queues handling of all the passed in msgs to mailroom in a single transaction, the contact of each
+// msg must have been set when it was written
+func queueMsgsHandling(rc redis.Conn, msgs []*DBMsg) error {
+	rc.Send("multi")
+	for _, m := range msgs {
+		err := sendMsgHandling(rc, m.contact, m)
+		if err != nil {
+			rc.Do("discard")
+			return err
+		}
+	}
+	_, err := rc.Do("exec")
+	return err
+}
+
+// sendMsgHandling sends the commands to queue handling of the passed in msg to mailroom, callers are responsible for
+// wrapping them in a transaction
+func sendMsgHandling(rc redis.Conn, c *DBContact, m *DBMsg) error {
 	channel := m.Channel().(*DBChannel)
 
 	// queue to mailroom
@@ -27,7 +44,7 @@ func queueMsgHandling(rc redis.Conn, c *DBContact, m *DBMsg) error {
 		"new_contact":     c.IsNew_,
 	}
 
-	return queueMailroomTask(rc, "msg_event", m.OrgID_, m.ContactID_, body)
+	return sendMailroomTask(rc, "msg_event", m.OrgID_, m.ContactID_, body)
 }
 
 func queueChannelEvent(rc redis.Conn, c *DBContact, e *DBChannelEvent) error {
@@ -79,7 +96,20 @@ func queueChannelEvent(rc redis.Conn, c *DBContact, e *DBChannelEvent) error {
 
 // queueMailroomTask queues the passed in task to mailroom. Mailroom processes both messages and
 // channel event tasks through the same ordered queue.
-func queueMailroomTask(rc redis.Conn, taskType string, orgID OrgID, contactID ContactID, body map[string]interface{}) (err error) {
+func queueMailroomTask(rc redis.Conn, taskType string, orgID OrgID, contactID ContactID, body map[string]interface{}) error {
+	rc.Send("multi")
+	err := sendMailroomTask(rc, taskType, orgID, contactID, body)
+	if err != nil {
+		rc.Do("discard")
+		return err
+	}
+	_, err = rc.Do("exec")
+	return err
+}
+
+// sendMailroomTask sends the commands to queue the passed in task to mailroom, callers are responsible for wrapping
+// them in a transaction
+func sendMailroomTask(rc redis.Conn, taskType string, orgID OrgID, contactID ContactID, body map[string]interface{}) error {
 	// create our event task
 	eventTask := mrTask{
 		Type:     taskType,
@@ -111,15 +141,11 @@ func queueMailroomTask(rc redis.Conn, taskType string, orgID OrgID, contactID Co
 	now := time.Now().UTC()
 	epochFloat := float64(now.UnixNano()) / float64(time.Second)
 
-	// we do all our queueing in a transaction
 	contactQueue := fmt.Sprintf("c:%d:%d", orgID, contactID)
-	rc.Send("multi")
 	rc.Send("rpush", contactQueue, eventJSON)
 	rc.Send("zadd", fmt.Sprintf("handler:%d", orgID), fmt.Sprintf("%.5f", epochFloat-10000000), contactJSON)
 	rc.Send("zincrby", "handler:active", 0, orgID)
-	_, err = rc.Do("exec")
-
-	return err
+	return nil
 }
 
 type mrContactTask struct {
@@ -96,20 +96,21 @@ func contactURNsForContact(db *sqlx.Tx, contactID ContactID) ([]*DBContactURN, e
 }
 
 // setDefaultURN makes sure that the passed in URN is the default URN for this contact and
-// that the passed in channel is the default one for that URN
+// that the passed in channel is the default one for that URN. It returns the identities of
+// any of the contact's other URNs which were changed.
 //
 // Note that the URN must be one of the contact's URN before calling this method
-func setDefaultURN(db *sqlx.Tx, channelID courier.ChannelID, contact *DBContact, urn urns.URN, auth string) error {
+func setDefaultURN(db *sqlx.Tx, channelID courier.ChannelID, contact *DBContact, urn urns.URN, auth string) ([]string, error) {
 	scheme := urn.Scheme()
 	contactURNs, err := contactURNsForContact(db, contact.ID_)
 	if err != nil {
 		logrus.WithError(err).WithField("urn", urn.Identity()).WithField("channel_id", channelID).Error("error looking up contact urns")
-		return err
+		return nil, err
 	}
 
 	// no URNs? that's an error
 	if len(contactURNs) == 0 {
-		return fmt.Errorf("URN '%s' not present for contact %d", urn.Identity(), contact.ID_)
+		return nil, fmt.Errorf("URN '%s' not present for contact %d", urn.Identity(), contact.ID_)
 	}
 
 	// only a single URN and it is ours
@@ -123,9 +124,9 @@ func setDefaultURN(db *sqlx.Tx, channelID courier.ChannelID, contact *DBContact,
 			if auth != "" {
 				contactURNs[0].Auth = null.String(auth)
 			}
-			return updateContactURN(db, contactURNs[0])
+			return nil, updateContactURN(db, contactURNs[0])
 		}
-		return nil
+		return nil, nil
 	}
 
 	// multiple URNs and we aren't the top, iterate across them and update channel for matching schemes
@@ -133,6 +134,7 @@ func setDefaultURN(db *sqlx.Tx, channelID courier.ChannelID, contact *DBContact,
 	// the preferred channel changes (rare as well)
 	topPriority := 99
 	currPriority := 50
+	changed := make([]string, 0, len(contactURNs)-1)
 	for _, existing := range contactURNs {
 		// if this is current URN, make sure it has an updated auth as well
 		if existing.Identity == string(urn.Identity()) {
@@ -149,14 +151,15 @@ func setDefaultURN(db *sqlx.Tx, channelID courier.ChannelID, contact *DBContact,
 				existing.ChannelID = channelID
 			}
 			currPriority--
+			changed = append(changed, existing.Identity)
 		}
 		err := updateContactURN(db, existing)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	return nil
+	return changed, nil
 }
 
 const selectOrgURN = `
@@ -0,0 +1,120 @@
+package relay
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/gsm7"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/queue"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// the maximum size of the body of a request to our API
+const maxRequestBodyBytes = 100000
+
+// AddRoutes adds the routes of our API through which msgs to send are pushed to us
+func (b *backend) AddRoutes(r chi.Router) {
+	r.Post("/relay/send", b.handleSend)
+}
+
+// handleSend verifies and queues a msg to be sent, requests must be signed the same way as the requests we make
+func (b *backend) handleSend(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxRequestBodyBytes))
+	defer r.Body.Close()
+	if err != nil {
+		courier.WriteError(ctx, w, r, errors.Wrapf(err, "unable to read request body"))
+		return
+	}
+
+	err = verifySignature(b.config.RelaySecret, r, body, time.Now())
+	if err != nil {
+		courier.WriteDataResponse(ctx, w, http.StatusUnauthorized, "Unauthorized", []interface{}{courier.NewErrorData(err.Error())})
+		return
+	}
+
+	m := &msg{}
+	err = json.Unmarshal(body, m)
+	if err != nil {
+		courier.WriteError(ctx, w, r, errors.Wrapf(err, "unable to parse request JSON"))
+		return
+	}
+	err = handlers.Validate(m)
+	if err != nil {
+		courier.WriteError(ctx, w, r, err)
+		return
+	}
+
+	c, found := b.channels[m.ChannelUUID_]
+	if !found {
+		courier.WriteError(ctx, w, r, errors.Errorf("no channel with uuid: %s", m.ChannelUUID_))
+		return
+	}
+	if m.URN_.Validate() != nil {
+		courier.WriteError(ctx, w, r, errors.Errorf("invalid urn: %s", m.URN_))
+		return
+	}
+	if m.Text_ == "" && len(m.Attachments_) == 0 {
+		courier.WriteError(ctx, w, r, errors.New("msg must have text or attachments"))
+		return
+	}
+
+	if m.UUID_ == courier.NilMsgUUID {
+		m.UUID_ = courier.NewMsgUUID()
+	}
+	m.CreatedOn_ = time.Now().UTC()
+	m.channel = c
+
+	err = b.queueMsg(m)
+	if err != nil {
+		logrus.WithError(err).WithField("channel_uuid", c.UUID()).WithField("msg_id", m.ID_.String()).Error("error queueing msg")
+		courier.WriteDataResponse(ctx, w, http.StatusInternalServerError, "Error", []interface{}{courier.NewErrorData("error queueing msg")})
+		return
+	}
+
+	courier.WriteDataResponse(ctx, w, http.StatusOK, "Message Queued", []interface{}{newQueuedData(m)})
+}
+
+// queueMsg pushes the passed in msg onto the outgoing queue of its channel, msgs sent as several SMS parts count
+// as that many transactions against the TPS of their channel
+func (b *backend) queueMsg(m *msg) error {
+	if m.URN_.Scheme() == urns.TelScheme {
+		m.TPSCost_ = gsm7.Segments(m.Text_)
+	}
+
+	value, err := json.Marshal([]*msg{m})
+	if err != nil {
+		return err
+	}
+
+	priority := queue.Priority(queue.LowPriority)
+	if m.HighPriority_ {
+		priority = queue.HighPriority
+	}
+
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	return queue.PushOntoQueue(rc, msgQueueName, m.ChannelUUID_.String(), m.channel.TPS(), string(value), priority, time.Time{})
+}
+
+// queuedData is our response payload for a queued msg
+type queuedData struct {
+	Type        string              `json:"type"`
+	ChannelUUID courier.ChannelUUID `json:"channel_uuid"`
+	MsgID       courier.MsgID       `json:"msg_id"`
+	MsgUUID     courier.MsgUUID     `json:"msg_uuid"`
+}
+
+func newQueuedData(m *msg) queuedData {
+	return queuedData{"queued", m.ChannelUUID_, m.ID_, m.UUID_}
+}
@@ -0,0 +1,628 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/backends/static"
+	"github.com/nyaruka/courier/metrics"
+	"github.com/nyaruka/courier/queue"
+	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// the name for our message queue
+const msgQueueName = "msgs"
+
+// our timeout for relaying a payload to our relay URL, this includes any retries
+const backendTimeout = time.Second * 20
+
+func init() {
+	courier.RegisterBackend("relay", newBackend)
+
+	courier.RegisterSpoolParser(relaySpoolDir, func(contents []byte) error { _, err := parseRelayFile(contents); return err })
+}
+
+// GetChannel returns the channel for the passed in type and UUID
+func (b *backend) GetChannel(ctx context.Context, ct courier.ChannelType, uuid courier.ChannelUUID) (courier.Channel, error) {
+	c, found := b.channels[uuid]
+	if !found {
+		return nil, courier.ErrChannelNotFound
+	}
+	if ct != courier.AnyChannelType && c.ChannelType() != ct {
+		return nil, courier.ErrChannelWrongType
+	}
+	return c, nil
+}
+
+// GetContact returns the contact for the passed in channel and URN
+func (b *backend) GetContact(ctx context.Context, c courier.Channel, urn urns.URN, auth string, name string) (courier.Contact, error) {
+	return contactForURN(urn), nil
+}
+
+// AddURNtoContact is a no-op as contacts are managed by the application we relay to
+func (b *backend) AddURNtoContact(ctx context.Context, c courier.Channel, ct courier.Contact, urn urns.URN) (urns.URN, error) {
+	return urn, nil
+}
+
+// RemoveURNfromContact is a no-op as contacts are managed by the application we relay to
+func (b *backend) RemoveURNfromContact(ctx context.Context, c courier.Channel, ct courier.Contact, urn urns.URN) (urns.URN, error) {
+	return urn, nil
+}
+
+// NewIncomingMsg creates a new message from the given params
+func (b *backend) NewIncomingMsg(c courier.Channel, urn urns.URN, text string) courier.Msg {
+	// remove any control characters
+	text = utils.CleanString(text)
+
+	m := newMsg(c.(*static.Channel), urn, text)
+	m.WithReceivedOn(time.Now().UTC())
+
+	// have we seen this msg in the past period? if so use its UUID and don't relay it again
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	prevUUID := checkSeen(rc, msgSeenKey(m), m.Text_)
+	if prevUUID != courier.NilMsgUUID {
+		m.UUID_ = prevUUID
+		m.alreadyWritten = true
+	}
+	return m
+}
+
+// WriteMsg queues the passed in message to be relayed to our relay URL
+func (b *backend) WriteMsg(ctx context.Context, cm courier.Msg) error {
+	m := cm.(*msg)
+
+	// this msg has already been relayed (we received it twice), we are a no op
+	if m.alreadyWritten {
+		return nil
+	}
+
+	err := b.queueRelay(courier.NewMsgReceiveData(m))
+	if err != nil {
+		return errors.Wrapf(err, "error relaying msg")
+	}
+
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	err = writeSeen(rc, msgSeenKey(m), m.UUID_, m.Text_, msgSeenTTL)
+	if err != nil {
+		logrus.WithError(err).WithField("msg_uuid", m.UUID_.String()).Error("error recording msg as seen")
+	}
+	return nil
+}
+
+// NewMsgStatusForID creates a new Status object for the given message id
+func (b *backend) NewMsgStatusForID(c courier.Channel, id courier.MsgID, status courier.MsgStatusValue) courier.MsgStatus {
+	return newMsgStatus(c, id, "", status)
+}
+
+// NewMsgStatusForExternalID creates a new Status object for the given external id
+func (b *backend) NewMsgStatusForExternalID(c courier.Channel, externalID string, status courier.MsgStatusValue) courier.MsgStatus {
+	return newMsgStatus(c, courier.NilMsgID, externalID, status)
+}
+
+// WriteMsgStatus queues the passed in MsgStatus to be relayed to our relay URL
+func (b *backend) WriteMsgStatus(ctx context.Context, status courier.MsgStatus) error {
+	err := b.queueRelay(courier.NewStatusData(status))
+	if err != nil {
+		return errors.Wrapf(err, "error relaying msg status")
+	}
+
+	// if we are marking an outgoing msg as errored, then clear our sent flag so it can be retried
+	if status.ID() != courier.NilMsgID && status.Status() == courier.MsgErrored {
+		rc := b.redisPool.Get()
+		defer rc.Close()
+
+		_, err := rc.Do("del", sentKey(status.ID()))
+		if err != nil {
+			logrus.WithError(err).WithField("msg", status.ID().String()).Error("error clearing sent flag")
+		}
+	}
+
+	return nil
+}
+
+// NewChannelEvent creates a new channel event with the passed in parameters
+func (b *backend) NewChannelEvent(c courier.Channel, eventType courier.ChannelEventType, urn urns.URN) courier.ChannelEvent {
+	return newChannelEvent(c, eventType, urn)
+}
+
+// WriteChannelEvent queues the passed in channel event to be relayed to our relay URL
+func (b *backend) WriteChannelEvent(ctx context.Context, event courier.ChannelEvent) error {
+	err := b.queueRelay(courier.NewEventReceiveData(event))
+	if err != nil {
+		return errors.Wrapf(err, "error relaying channel event")
+	}
+	return nil
+}
+
+// WriteChannelLogs logs any errors in the passed in channel logs, we don't relay logs to the application
+func (b *backend) WriteChannelLogs(ctx context.Context, logs []*courier.ChannelLog) error {
+	for _, l := range logs {
+		if l.Error != "" {
+			logrus.WithField("channel_uuid", l.Channel.UUID()).WithField("msg_id", l.MsgID.String()).WithField("url", l.URL).WithField("status_code", l.StatusCode).Error(l.Error)
+		}
+	}
+	return nil
+}
+
+// PopNextOutgoingMsg pops the next message that needs to be sent
+func (b *backend) PopNextOutgoingMsg(ctx context.Context) (courier.Msg, error) {
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	token, msgJSON, err := queue.PopFromQueue(rc, msgQueueName)
+	for token == queue.Retry {
+		token, msgJSON, err = queue.PopFromQueue(rc, msgQueueName)
+	}
+	if err != nil || msgJSON == "" {
+		return nil, err
+	}
+
+	return b.outgoingMsgFromQueue(rc, token, msgJSON)
+}
+
+// PopNextOutgoingMsgs pops up to count of the next messages that need to be sent with a single call to redis, msgs
+// which can't be loaded are logged and skipped
+func (b *backend) PopNextOutgoingMsgs(ctx context.Context, count int) ([]courier.Msg, error) {
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	tokens, msgJSONs, err := queue.PopManyFromQueue(rc, msgQueueName, count)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := make([]courier.Msg, 0, len(tokens))
+	for i := range tokens {
+		m, err := b.outgoingMsgFromQueue(rc, tokens[i], msgJSONs[i])
+		if err != nil {
+			logrus.WithError(err).Error("error loading popped msg")
+			continue
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, nil
+}
+
+// OutgoingNotifications returns the chan we notify whenever messages are pushed onto our queue
+func (b *backend) OutgoingNotifications() <-chan bool {
+	return b.outgoingNotify
+}
+
+// outgoingMsgFromQueue loads the msg popped from our queue with the passed in token, if it can't be loaded the
+// task is marked as complete so we don't hold onto its worker
+func (b *backend) outgoingMsgFromQueue(rc redis.Conn, token queue.WorkerToken, msgJSON string) (courier.Msg, error) {
+	m := &msg{}
+	err := json.Unmarshal([]byte(msgJSON), m)
+	if err != nil {
+		queue.MarkComplete(rc, msgQueueName, token)
+		return nil, errors.Wrapf(err, "unable to unmarshal message '%s'", msgJSON)
+	}
+
+	c, found := b.channels[m.ChannelUUID_]
+	if !found {
+		queue.MarkComplete(rc, msgQueueName, token)
+		return nil, errors.Wrapf(courier.ErrChannelNotFound, "error looking up channel %s", m.ChannelUUID_)
+	}
+	m.channel = c
+	m.workerToken = string(token)
+
+	// clear out our seen incoming messages
+	rc.Do("del", msgSeenKey(m))
+
+	return m, nil
+}
+
+// WasMsgSent returns whether the passed in message has already been sent
+func (b *backend) WasMsgSent(ctx context.Context, m courier.Msg) (bool, error) {
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	return redis.Bool(rc.Do("exists", sentKey(m.ID())))
+}
+
+// MarkOutgoingMsgComplete marks the passed in message as having completed processing, freeing up a worker for that channel
+func (b *backend) MarkOutgoingMsgComplete(ctx context.Context, m courier.Msg, status courier.MsgStatus) {
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	queue.MarkComplete(rc, msgQueueName, queue.WorkerToken(m.(*msg).workerToken))
+
+	// remember that we sent this msg if it was actually wired or sent
+	if status != nil && (status.Status() == courier.MsgSent || status.Status() == courier.MsgWired) {
+		_, err := rc.Do("set", sentKey(m.ID()), "1", "ex", int(sentTTL/time.Second))
+		if err != nil {
+			logrus.WithError(err).WithField("msg", m.ID().String()).Error("unable to mark msg as sent")
+		}
+	}
+}
+
+// CheckExternalIDSeen checks if external ID has been seen in a period
+func (b *backend) CheckExternalIDSeen(cm courier.Msg) courier.Msg {
+	m := cm.(*msg)
+
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	prevUUID := checkSeen(rc, externalIDSeenKey(m), m.Text_)
+	if prevUUID != courier.NilMsgUUID {
+		// if so, use its UUID and that we've been written
+		m.UUID_ = prevUUID
+		m.alreadyWritten = true
+	}
+	return m
+}
+
+// WriteExternalIDSeen marks a external ID as seen for a period
+func (b *backend) WriteExternalIDSeen(cm courier.Msg) {
+	m := cm.(*msg)
+
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	err := writeSeen(rc, externalIDSeenKey(m), m.UUID_, m.Text_, externalIDSeenTTL)
+	if err != nil {
+		logrus.WithError(err).WithField("msg_uuid", m.UUID_.String()).Error("error recording external id as seen")
+	}
+}
+
+// Queues returns the outgoing queue of each channel which has msgs waiting to be sent
+func (b *backend) Queues(ctx context.Context) ([]*courier.ChannelQueue, error) {
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	infos, err := queue.ListQueues(rc, msgQueueName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error listing queues")
+	}
+
+	queues := make([]*courier.ChannelQueue, 0, len(infos))
+	for _, info := range infos {
+		channelUUID, err := courier.NewChannelUUID(info.Queue)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid channel uuid for queue '%s'", info.Queue)
+		}
+
+		channelType := courier.ChannelType("!!")
+		c, found := b.channels[channelUUID]
+		if found {
+			channelType = c.ChannelType()
+		}
+
+		queues = append(queues, &courier.ChannelQueue{
+			ChannelUUID: channelUUID,
+			ChannelType: channelType,
+			State:       info.State,
+			TPS:         info.TPS,
+			Workers:     info.Workers,
+			Paused:      info.Paused,
+			Breaker:     string(info.Breaker),
+			Size:        info.Size,
+			BulkSize:    info.BulkSize,
+		})
+	}
+
+	return queues, nil
+}
+
+// PeekQueue returns up to the passed in number of items from the head of a channel's outgoing queue
+func (b *backend) PeekQueue(ctx context.Context, channelUUID courier.ChannelUUID, count int) ([]*courier.QueuedItem, error) {
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	peeked, err := queue.PeekQueue(rc, msgQueueName, channelUUID.String(), count)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error peeking queue for channel %s", channelUUID)
+	}
+
+	items := make([]*courier.QueuedItem, 0, len(peeked))
+	for _, p := range peeked {
+		items = append(items, &courier.QueuedItem{
+			HighPriority: p.Priority == queue.HighPriority,
+			AvailableOn:  time.Unix(0, int64(p.Score*float64(time.Second))).UTC(),
+			Value:        json.RawMessage(p.Value),
+		})
+	}
+
+	return items, nil
+}
+
+// PurgeQueue removes all items from a channel's outgoing queue, returning the number of items removed
+func (b *backend) PurgeQueue(ctx context.Context, channelUUID courier.ChannelUUID) (int, error) {
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	purged, err := queue.PurgeQueue(rc, msgQueueName, channelUUID.String())
+	if err != nil {
+		return 0, errors.Wrapf(err, "error purging queue for channel %s", channelUUID)
+	}
+	return purged, nil
+}
+
+// PauseChannel stops msgs being sent for the passed in channel, queued msgs are kept until it is resumed
+func (b *backend) PauseChannel(ctx context.Context, channelUUID courier.ChannelUUID) error {
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	return queue.PauseQueue(rc, msgQueueName, channelUUID.String())
+}
+
+// ResumeChannel resumes sending msgs for a paused channel
+func (b *backend) ResumeChannel(ctx context.Context, channelUUID courier.ChannelUUID) error {
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	return queue.ResumeQueue(rc, msgQueueName, channelUUID.String())
+}
+
+// IsChannelPaused returns whether sending is currently paused for the passed in channel
+func (b *backend) IsChannelPaused(ctx context.Context, channelUUID courier.ChannelUUID) (bool, error) {
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	return queue.IsQueuePaused(rc, msgQueueName, channelUUID.String())
+}
+
+// InvalidateChannel is a no-op as our channels are loaded from a file at startup and never cached
+func (b *backend) InvalidateChannel(ctx context.Context, channelUUID courier.ChannelUUID) error {
+	return nil
+}
+
+// DrainChannel removes all msgs from a channel's outgoing queue and relays a failed status for each, returning the
+// number of msgs failed
+func (b *backend) DrainChannel(ctx context.Context, channelUUID courier.ChannelUUID) (int, error) {
+	c, found := b.channels[channelUUID]
+	if !found {
+		return 0, errors.Wrapf(courier.ErrChannelNotFound, "error looking up channel %s", channelUUID)
+	}
+
+	rc := b.redisPool.Get()
+	items, err := queue.DrainQueue(rc, msgQueueName, channelUUID.String())
+	rc.Close()
+	if err != nil {
+		return 0, errors.Wrapf(err, "error draining queue for channel %s", channelUUID)
+	}
+
+	failed := 0
+	for _, item := range items {
+		msgs := make([]*msg, 0)
+		err := json.Unmarshal([]byte(item.Value), &msgs)
+		if err != nil {
+			logrus.WithError(err).WithField("channel_uuid", channelUUID).WithField("value", item.Value).Error("unable to parse drained queue item")
+			continue
+		}
+
+		for _, m := range msgs {
+			err := b.WriteMsgStatus(ctx, b.NewMsgStatusForID(c, m.ID_, courier.MsgFailed))
+			if err != nil {
+				return failed, errors.Wrapf(err, "error failing msg %s", m.ID_)
+			}
+			failed++
+		}
+	}
+
+	return failed, nil
+}
+
+// Health returns a report on the health of our redis
+func (b *backend) Health() *courier.HealthReport {
+	health := courier.NewHealthReport()
+
+	rc := b.redisPool.Get()
+	_, redisErr := rc.Do("PING")
+	rc.Close()
+	health.Add("redis", redisErr)
+
+	return health
+}
+
+// Heartbeat is called every minute, we report our queue depths to our metrics sinks
+func (b *backend) Heartbeat() error {
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	infos, err := queue.ListQueues(rc, msgQueueName)
+	if err != nil {
+		return errors.Wrapf(err, "error listing queues")
+	}
+
+	prioritySize := 0
+	bulkSize := 0
+	for _, info := range infos {
+		prioritySize += info.Size
+		bulkSize += info.BulkSize
+	}
+
+	metrics.Gauge("bulk_queue", nil, float64(bulkSize))
+	metrics.Gauge("priority_queue", nil, float64(prioritySize))
+	logrus.WithField("bulk_queue", bulkSize).WithField("priority_queue", prioritySize).Info("heartbeat queue sizes calculated")
+
+	return nil
+}
+
+// Status returns information on our queue sizes, number of workers etc..
+func (b *backend) Status() string {
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	infos, err := queue.ListQueues(rc, msgQueueName)
+	if err != nil {
+		return fmt.Sprintf("unable to read queues: %v", err)
+	}
+
+	status := bytes.Buffer{}
+	status.WriteString("------------------------------------------------------------------------------------\n")
+	status.WriteString("     Size | Bulk Size | Workers | TPS | Type | Channel              \n")
+	status.WriteString("------------------------------------------------------------------------------------\n")
+
+	for _, info := range infos {
+		channelType := "!!"
+		channelUUID, _ := courier.NewChannelUUID(info.Queue)
+		c, found := b.channels[channelUUID]
+		if found {
+			channelType = c.ChannelType().String()
+		}
+
+		status.WriteString(fmt.Sprintf("% 9d   % 9d   % 7d   % 3d   % 4s   %s\n", info.Size, info.BulkSize, info.Workers, info.TPS, channelType, info.Queue))
+	}
+
+	return status.String()
+}
+
+// Start starts our relay backend, loading our channels and testing our redis connection
+func (b *backend) Start() error {
+	log := logrus.WithFields(logrus.Fields{
+		"comp":  "backend",
+		"state": "starting",
+	})
+	log.Info("starting backend")
+
+	if b.config.RelayURL == "" || b.config.RelaySecret == "" {
+		return errors.New("relay backend requires a relay URL and secret")
+	}
+
+	channels, err := static.LoadChannels(b.config.RelayChannels)
+	if err != nil {
+		return err
+	}
+	b.channels = channels
+	log.WithField("channels", len(channels)).Info("channels loaded")
+
+	// parse and test our redis config
+	redisURL, err := url.Parse(b.config.Redis)
+	if err != nil {
+		return fmt.Errorf("unable to parse Redis URL '%s': %s", b.config.Redis, err)
+	}
+
+	b.redisPool = &redis.Pool{
+		Wait:        true,              // makes callers wait for a connection
+		MaxActive:   8,                 // only open this many concurrent connections at once
+		MaxIdle:     4,                 // only keep up to this many idle
+		IdleTimeout: 240 * time.Second, // how long to wait before reaping a connection
+		Dial: func() (redis.Conn, error) {
+			conn, err := redis.Dial("tcp", redisURL.Host)
+			if err != nil {
+				return nil, err
+			}
+
+			// send auth if required
+			if redisURL.User != nil {
+				pass, authRequired := redisURL.User.Password()
+				if authRequired {
+					if _, err := conn.Do("AUTH", pass); err != nil {
+						conn.Close()
+						return nil, err
+					}
+				}
+			}
+
+			// switch to the right DB
+			_, err = conn.Do("SELECT", strings.TrimLeft(redisURL.Path, "/"))
+			return conn, err
+		},
+	}
+
+	// test our redis connection
+	conn := b.redisPool.Get()
+	defer conn.Close()
+	_, err = conn.Do("PING")
+	if err != nil {
+		log.WithError(err).Error("redis not reachable")
+	} else {
+		log.Info("redis ok")
+	}
+
+	// start relaying, spooling anything we can't relay so it can be relayed later
+	err = courier.EnsureSpoolDirPresent(b.config.SpoolDir, relaySpoolDir)
+	if err != nil {
+		return errors.Wrapf(err, "error creating relay spool directory")
+	}
+	courier.RegisterFlusher(path.Join(b.config.SpoolDir, relaySpoolDir), b.flushRelayFile)
+	b.startRelayer()
+
+	// start our dethrottler if we are going to be doing some sending
+	if b.config.MaxWorkers > 0 {
+		// make sure our queues enforce the max concurrency and burst of each of our channels
+		for _, c := range b.channels {
+			limits := courier.GetSendLimits(c)
+			err = queue.SetMaxConcurrency(conn, msgQueueName, c.UUID().String(), limits.MaxConcurrency)
+			if err != nil {
+				log.WithError(err).WithField("channel_uuid", c.UUID()).Error("error setting max concurrency")
+			}
+			err = queue.SetBurst(conn, msgQueueName, c.UUID().String(), limits.Burst)
+			if err != nil {
+				log.WithError(err).WithField("channel_uuid", c.UUID()).Error("error setting burst")
+			}
+		}
+
+		queue.StartDethrottler(b.redisPool, b.stopChan, b.waitGroup, msgQueueName)
+		queue.StartNotifier(b.redisPool, b.stopChan, b.waitGroup, msgQueueName, b.outgoingNotify)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"comp":  "backend",
+		"state": "started",
+	}).Info("backend started")
+
+	return nil
+}
+
+// Stop stops our relay backend, stopping our dethrottler and spooling anything we haven't yet relayed
+func (b *backend) Stop() error {
+	close(b.stopChan)
+	b.waitGroup.Wait()
+	return nil
+}
+
+// Cleanup closes our redis pool
+func (b *backend) Cleanup() error {
+	if b.redisPool != nil {
+		return b.redisPool.Close()
+	}
+	return nil
+}
+
+// RedisPool returns the redisPool for this backend
+func (b *backend) RedisPool() *redis.Pool {
+	return b.redisPool
+}
+
+// newBackend creates a new relay backend
+func newBackend(config *courier.Config) courier.Backend {
+	return &backend{
+		config: config,
+
+		stopChan:  make(chan bool),
+		waitGroup: &sync.WaitGroup{},
+
+		outgoingNotify: make(chan bool, 1),
+		relayBuffer:    make(chan []byte, relayBufferSize),
+	}
+}
+
+type backend struct {
+	config *courier.Config
+
+	channels       map[courier.ChannelUUID]*static.Channel
+	redisPool      *redis.Pool
+	outgoingNotify chan bool
+	relayBuffer    chan []byte
+
+	stopChan  chan bool
+	waitGroup *sync.WaitGroup
+}
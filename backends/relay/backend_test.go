@@ -0,0 +1,371 @@
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/backends/static"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/suite"
+)
+
+type BackendTestSuite struct {
+	suite.Suite
+	b *backend
+
+	// the requests received by our relay endpoint and the status codes it will respond with
+	mutex     sync.Mutex
+	requests  []*http.Request
+	bodies    []string
+	responses []int
+	server    *httptest.Server
+}
+
+func testConfig() *courier.Config {
+	config := courier.NewConfig()
+	config.Backend = "relay"
+	config.Redis = "redis://localhost:6379/0"
+	config.RelaySecret = "sesame"
+	config.RelayChannels = "testdata/channels.json"
+	config.RelayRetries = 2
+	config.MaxWorkers = 0
+	return config
+}
+
+// waitForRequests waits up to a second for our relay endpoint to have received count requests
+func (ts *BackendTestSuite) waitForRequests(count int) {
+	for i := 0; i < 100; i++ {
+		ts.mutex.Lock()
+		received := len(ts.requests)
+		ts.mutex.Unlock()
+
+		if received >= count {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	ts.Equal(count, len(ts.requests))
+}
+
+// waitForSpooled waits up to a second for count payloads to be in our relay spool, returning their filenames
+func (ts *BackendTestSuite) waitForSpooled(count int) []string {
+	var spooled []string
+	for i := 0; i < 100; i++ {
+		spooled, _ = filepath.Glob(path.Join(ts.b.config.SpoolDir, relaySpoolDir, "*.json"))
+		if len(spooled) >= count {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	ts.Equal(count, len(spooled))
+	return spooled
+}
+
+func (ts *BackendTestSuite) SetupSuite() {
+	retryBackoff = time.Millisecond
+
+	ts.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ts.mutex.Lock()
+		defer ts.mutex.Unlock()
+
+		body, _ := ioutil.ReadAll(r.Body)
+		ts.requests = append(ts.requests, r)
+		ts.bodies = append(ts.bodies, string(body))
+
+		status := http.StatusOK
+		if len(ts.responses) > 0 {
+			status, ts.responses = ts.responses[0], ts.responses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+
+	spoolDir, err := ioutil.TempDir("", "relay")
+	if err != nil {
+		panic(err)
+	}
+
+	config := testConfig()
+	config.RelayURL = ts.server.URL
+	config.SpoolDir = spoolDir
+
+	b, err := courier.NewBackend(config)
+	if err != nil {
+		panic(err)
+	}
+	ts.b = b.(*backend)
+	err = ts.b.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	// clear redis
+	rc := ts.b.redisPool.Get()
+	defer rc.Close()
+	rc.Do("flushdb")
+}
+
+func (ts *BackendTestSuite) TearDownSuite() {
+	ts.b.Stop()
+	ts.b.Cleanup()
+	ts.server.Close()
+	os.RemoveAll(ts.b.config.SpoolDir)
+	retryBackoff = time.Second
+}
+
+func (ts *BackendTestSuite) SetupTest() {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	ts.requests = nil
+	ts.bodies = nil
+	ts.responses = nil
+}
+
+func (ts *BackendTestSuite) respondWith(statuses ...int) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	ts.responses = statuses
+}
+
+func (ts *BackendTestSuite) getChannel(cType string, cUUID string) courier.Channel {
+	channelUUID, err := courier.NewChannelUUID(cUUID)
+	ts.NoError(err)
+
+	channel, err := ts.b.GetChannel(context.Background(), courier.ChannelType(cType), channelUUID)
+	ts.NoError(err, "error getting channel")
+	ts.NotNil(channel)
+
+	return channel
+}
+
+// signedRequest creates a request to our send API signed with the passed in secret and time
+func signedRequest(secret string, now time.Time, body string) *http.Request {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	r := httptest.NewRequest(http.MethodPost, "/relay/send", strings.NewReader(body))
+	r.Header.Set(timestampHeader, timestamp)
+	r.Header.Set(signatureHeader, signature(secret, timestamp, []byte(body)))
+	return r
+}
+
+func TestBackend(t *testing.T) {
+	suite.Run(t, new(BackendTestSuite))
+}
+
+func (ts *BackendTestSuite) TestSignature() {
+	now := time.Date(2019, 4, 1, 10, 0, 0, 0, time.UTC)
+	body := []byte(`{"id": 1}`)
+
+	ts.NoError(verifySignature("sesame", signedRequest("sesame", now, string(body)), body, now))
+	ts.NoError(verifySignature("sesame", signedRequest("sesame", now, string(body)), body, now.Add(time.Minute*4)))
+
+	ts.EqualError(verifySignature("sesame", signedRequest("wrong", now, string(body)), body, now), "invalid request signature")
+	ts.EqualError(verifySignature("sesame", signedRequest("sesame", now, string(body)), []byte(`{"id": 2}`), now), "invalid request signature")
+	ts.EqualError(verifySignature("sesame", signedRequest("sesame", now, string(body)), body, now.Add(time.Minute*6)), "request timestamp too far from current time")
+
+	r := signedRequest("sesame", now, string(body))
+	r.Header.Del(timestampHeader)
+	ts.EqualError(verifySignature("sesame", r, body, now), "missing or invalid X-Courier-Timestamp header")
+}
+
+func (ts *BackendTestSuite) TestContact() {
+	knChannel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	urn, _ := urns.NewTelURNForCountry("0788383383", "RW")
+
+	// contacts are identified by their URN
+	contact1, err := ts.b.GetContact(context.Background(), knChannel, urn, "", "")
+	ts.NoError(err)
+	contact2, err := ts.b.GetContact(context.Background(), knChannel, urn, "auth", "Ryan")
+	ts.NoError(err)
+	ts.Equal(contact1.UUID(), contact2.UUID())
+
+	contact3, err := ts.b.GetContact(context.Background(), knChannel, urns.URN("tel:+250788383384"), "", "")
+	ts.NoError(err)
+	ts.NotEqual(contact1.UUID(), contact3.UUID())
+}
+
+func (ts *BackendTestSuite) TestWriteMsg() {
+	ctx := context.Background()
+	knChannel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	urn, _ := urns.NewTelURNForCountry("0788383383", "RW")
+
+	msg := ts.b.NewIncomingMsg(knChannel, urn, "test123").WithExternalID("ext123")
+	ts.NoError(ts.b.WriteMsg(ctx, msg))
+
+	ts.waitForRequests(1)
+	ts.Equal("application/json", ts.requests[0].Header.Get("Content-Type"))
+	ts.NoError(verifySignature("sesame", ts.requests[0], []byte(ts.bodies[0]), time.Now()))
+
+	data := &courier.MsgReceiveData{}
+	ts.NoError(json.Unmarshal([]byte(ts.bodies[0]), data))
+	ts.Equal("msg", data.Type)
+	ts.Equal(msg.UUID(), data.MsgUUID)
+	ts.Equal(knChannel.UUID(), data.ChannelUUID)
+	ts.Equal(urn, data.URN)
+	ts.Equal("test123", data.Text)
+	ts.Equal("ext123", data.ExternalID)
+
+	// receiving the same msg again is a dupe which isn't relayed again
+	dupe := ts.b.NewIncomingMsg(knChannel, urn, "test123")
+	ts.Equal(msg.UUID(), dupe.UUID())
+	ts.NoError(ts.b.WriteMsg(ctx, dupe))
+	time.Sleep(time.Millisecond * 50)
+	ts.waitForRequests(1)
+
+	// server errors are retried
+	ts.respondWith(500, 503)
+	msg = ts.b.NewIncomingMsg(knChannel, urn, "retried")
+	ts.NoError(ts.b.WriteMsg(ctx, msg))
+	ts.waitForRequests(4)
+
+	// until we run out of retries, when it is spooled
+	ts.respondWith(500, 500, 500)
+	msg = ts.b.NewIncomingMsg(knChannel, urn, "failed")
+	ts.NoError(ts.b.WriteMsg(ctx, msg))
+	ts.waitForRequests(7)
+	spooled := ts.waitForSpooled(1)
+
+	// client errors are not retried or spooled
+	ts.respondWith(400)
+	msg = ts.b.NewIncomingMsg(knChannel, urn, "rejected")
+	ts.NoError(ts.b.WriteMsg(ctx, msg))
+	ts.waitForRequests(8)
+	ts.waitForSpooled(1)
+
+	// our spooled msg is relayed once our endpoint is back
+	ts.NoError(courier.FlushSpoolFile(spooled[0]))
+	ts.waitForRequests(9)
+	ts.waitForSpooled(0)
+
+	data = &courier.MsgReceiveData{}
+	ts.NoError(json.Unmarshal([]byte(ts.bodies[8]), data))
+	ts.Equal(msg.UUID(), data.MsgUUID)
+	ts.NoError(verifySignature("sesame", ts.requests[8], []byte(ts.bodies[8]), time.Now()))
+
+	// spooled payloads which the endpoint rejects are invalid
+	ts.respondWith(400)
+	err := ts.b.flushRelayFile("1.json", []byte(ts.bodies[8]))
+	ts.EqualError(err, "invalid spool file: relay endpoint returned status 400")
+	ts.waitForRequests(10)
+}
+
+func (ts *BackendTestSuite) TestWriteStatusAndEvent() {
+	ctx := context.Background()
+	knChannel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+
+	ts.NoError(ts.b.WriteMsgStatus(ctx, ts.b.NewMsgStatusForExternalID(knChannel, "ext1", courier.MsgDelivered)))
+	ts.waitForRequests(1)
+	ts.JSONEq(`{"type": "status", "channel_uuid": "dbc126ed-66bc-4e28-b67b-81dc3327c95d", "status": "D", "external_id": "ext1"}`, ts.bodies[0])
+
+	event := ts.b.NewChannelEvent(knChannel, courier.NewConversation, urns.URN("tel:+250788383383"))
+	event.WithOccurredOn(time.Date(2019, 4, 1, 10, 0, 0, 0, time.UTC)).WithExtra(map[string]interface{}{"ref_id": "12345"})
+	ts.NoError(ts.b.WriteChannelEvent(ctx, event))
+	ts.waitForRequests(2)
+	ts.JSONEq(`{
+		"type": "event",
+		"channel_uuid": "dbc126ed-66bc-4e28-b67b-81dc3327c95d",
+		"event_type": "new_conversation",
+		"urn": "tel:+250788383383",
+		"received_on": "2019-04-01T10:00:00Z",
+		"extra": {"ref_id": "12345"}
+	}`, ts.bodies[1])
+}
+
+func (ts *BackendTestSuite) TestSendAPI() {
+	ctx := context.Background()
+	router := chi.NewRouter()
+	ts.b.AddRoutes(router)
+
+	send := func(r *http.Request) (int, string) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w.Code, w.Body.String()
+	}
+
+	body := `{"id": 10, "channel_uuid": "dbc126ed-66bc-4e28-b67b-81dc3327c95d", "urn": "tel:+250788383383", "text": "hello", "high_priority": true}`
+
+	// unsigned and badly signed requests are rejected
+	code, _ := send(httptest.NewRequest(http.MethodPost, "/relay/send", strings.NewReader(body)))
+	ts.Equal(401, code)
+	code, _ = send(signedRequest("wrong", time.Now(), body))
+	ts.Equal(401, code)
+	code, _ = send(signedRequest("sesame", time.Now().Add(-time.Hour), body))
+	ts.Equal(401, code)
+
+	// as are invalid msgs
+	code, resp := send(signedRequest("sesame", time.Now(), `{"channel_uuid": "dbc126ed-66bc-4e28-b67b-81dc3327c95d", "urn": "tel:+250788383383", "text": "hello"}`))
+	ts.Equal(400, code)
+	ts.Contains(resp, "field 'id_' required")
+	code, resp = send(signedRequest("sesame", time.Now(), `{"id": 10, "channel_uuid": "8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "urn": "tel:+250788383383", "text": "hello"}`))
+	ts.Equal(400, code)
+	ts.Contains(resp, "no channel with uuid")
+	code, resp = send(signedRequest("sesame", time.Now(), `{"id": 10, "channel_uuid": "dbc126ed-66bc-4e28-b67b-81dc3327c95d", "urn": "tel:+250788383383"}`))
+	ts.Equal(400, code)
+	ts.Contains(resp, "msg must have text or attachments")
+
+	code, resp = send(signedRequest("sesame", time.Now(), body))
+	ts.Equal(200, code, resp)
+	ts.Contains(resp, `"msg_id":10`)
+
+	queues, err := ts.b.Queues(ctx)
+	ts.NoError(err)
+	ts.Equal(1, len(queues))
+	ts.Equal(courier.ChannelType("KN"), queues[0].ChannelType)
+	ts.Equal(1, queues[0].Size)
+
+	msg, err := ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.NotNil(msg)
+	ts.Equal(courier.NewMsgID(10), msg.ID())
+	ts.Equal("hello", msg.Text())
+	ts.True(msg.HighPriority())
+	ts.Equal(courier.ChannelType("KN"), msg.Channel().ChannelType())
+
+	sent, err := ts.b.WasMsgSent(ctx, msg)
+	ts.NoError(err)
+	ts.False(sent)
+
+	status := ts.b.NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgWired)
+	ts.b.MarkOutgoingMsgComplete(ctx, msg, status)
+
+	sent, err = ts.b.WasMsgSent(ctx, msg)
+	ts.NoError(err)
+	ts.True(sent)
+
+	// an errored status clears our sent flag so it can be retried
+	ts.NoError(ts.b.WriteMsgStatus(ctx, ts.b.NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)))
+	sent, err = ts.b.WasMsgSent(ctx, msg)
+	ts.NoError(err)
+	ts.False(sent)
+	ts.waitForRequests(1)
+}
+
+func (ts *BackendTestSuite) TestQueueMsgTPSCost() {
+	ctx := context.Background()
+	channel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d").(*static.Channel)
+
+	// msgs which will be sent as several SMS parts cost that many transactions
+	ts.NoError(ts.b.queueMsg(&msg{ID_: courier.NewMsgID(11), ChannelUUID_: channel.UUID(), URN_: "tel:+250788383383", Text_: strings.Repeat("a", 200), channel: channel}))
+
+	popped, err := ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Equal(courier.NewMsgID(11), popped.ID())
+	ts.Equal(2, popped.(*msg).TPSCost_)
+	ts.b.MarkOutgoingMsgComplete(ctx, popped, nil)
+}
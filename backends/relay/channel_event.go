@@ -0,0 +1,61 @@
+package relay
+
+import (
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/urns"
+)
+
+// newChannelEvent creates a new channel event with the passed in parameters
+func newChannelEvent(c courier.Channel, eventType courier.ChannelEventType, urn urns.URN) *channelEvent {
+	now := time.Now().UTC()
+
+	return &channelEvent{
+		ChannelUUID_: c.UUID(),
+		URN_:         urn,
+		EventType_:   eventType,
+		OccurredOn_:  now,
+		CreatedOn_:   now,
+	}
+}
+
+//-----------------------------------------------------------------------------
+// ChannelEvent implementation
+//-----------------------------------------------------------------------------
+
+// channelEvent represents an event on a channel
+type channelEvent struct {
+	ChannelUUID_ courier.ChannelUUID
+	URN_         urns.URN
+	EventType_   courier.ChannelEventType
+	Extra_       map[string]interface{}
+	ContactName_ string
+	OccurredOn_  time.Time
+	CreatedOn_   time.Time
+
+	logs []*courier.ChannelLog
+}
+
+func (e *channelEvent) EventID() int64                      { return 0 }
+func (e *channelEvent) ChannelUUID() courier.ChannelUUID    { return e.ChannelUUID_ }
+func (e *channelEvent) URN() urns.URN                       { return e.URN_ }
+func (e *channelEvent) Extra() map[string]interface{}       { return e.Extra_ }
+func (e *channelEvent) EventType() courier.ChannelEventType { return e.EventType_ }
+func (e *channelEvent) OccurredOn() time.Time               { return e.OccurredOn_ }
+func (e *channelEvent) CreatedOn() time.Time                { return e.CreatedOn_ }
+func (e *channelEvent) WithContactName(name string) courier.ChannelEvent {
+	e.ContactName_ = name
+	return e
+}
+func (e *channelEvent) WithExtra(extra map[string]interface{}) courier.ChannelEvent {
+	e.Extra_ = extra
+	return e
+}
+func (e *channelEvent) WithOccurredOn(time time.Time) courier.ChannelEvent {
+	e.OccurredOn_ = time.UTC()
+	return e
+}
+
+func (e *channelEvent) Logs() []*courier.ChannelLog    { return e.logs }
+func (e *channelEvent) AddLog(log *courier.ChannelLog) { e.logs = append(e.logs, log) }
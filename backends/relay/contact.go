@@ -0,0 +1,22 @@
+package relay
+
+import (
+	"github.com/gofrs/uuid"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/urns"
+)
+
+// contactForURN returns the contact for the passed in URN. We don't store contacts, that is up to the application
+// we relay to, so contacts are identified by a UUID derived from the identity of their URN.
+func contactForURN(urn urns.URN) *contact {
+	u := uuid.NewV5(uuid.NamespaceURL, urn.Identity().String())
+	return &contact{UUID_: courier.ContactUUID{UUID: u}}
+}
+
+// contact is our struct for a contact
+type contact struct {
+	UUID_ courier.ContactUUID
+}
+
+// UUID returns the UUID for this contact
+func (c *contact) UUID() courier.ContactUUID { return c.UUID_ }
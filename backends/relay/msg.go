@@ -0,0 +1,118 @@
+package relay
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/backends/static"
+	"github.com/nyaruka/gocommon/urns"
+)
+
+// newMsg creates a new msg for the passed in channel
+func newMsg(c *static.Channel, urn urns.URN, text string) *msg {
+	return &msg{
+		UUID_:        courier.NewMsgUUID(),
+		ChannelUUID_: c.UUID(),
+		URN_:         urn,
+		Text_:        text,
+		CreatedOn_:   time.Now().UTC(),
+
+		channel: c,
+	}
+}
+
+//-----------------------------------------------------------------------------
+// Msg implementation
+//-----------------------------------------------------------------------------
+
+// msg is our msg struct, outgoing msgs are pushed to us in this format and queued as is
+type msg struct {
+	ID_                   courier.MsgID       `json:"id"                                validate:"required"`
+	UUID_                 courier.MsgUUID     `json:"uuid"`
+	ChannelUUID_          courier.ChannelUUID `json:"channel_uuid"                      validate:"required"`
+	URN_                  urns.URN            `json:"urn"                               validate:"required"`
+	URNAuth_              string              `json:"urn_auth,omitempty"`
+	ContactName_          string              `json:"contact_name,omitempty"`
+	HighPriority_         bool                `json:"high_priority"`
+	Text_                 string              `json:"text"`
+	Attachments_          []string            `json:"attachments,omitempty"`
+	QuickReplies_         []string            `json:"quick_replies,omitempty"`
+	Metadata_             json.RawMessage     `json:"metadata,omitempty"`
+	ExternalID_           string              `json:"external_id,omitempty"`
+	ResponseToID_         courier.MsgID       `json:"response_to_id,omitempty"`
+	ResponseToExternalID_ string              `json:"response_to_external_id,omitempty"`
+	CreatedOn_            time.Time           `json:"created_on"`
+	ReceivedOn_           *time.Time          `json:"received_on,omitempty"`
+	TPSCost_              int                 `json:"tps_cost,omitempty"`
+
+	channel        *static.Channel
+	workerToken    string
+	alreadyWritten bool
+}
+
+func (m *msg) ID() courier.MsgID            { return m.ID_ }
+func (m *msg) EventID() int64               { return int64(m.ID_) }
+func (m *msg) UUID() courier.MsgUUID        { return m.UUID_ }
+func (m *msg) Text() string                 { return m.Text_ }
+func (m *msg) Attachments() []string        { return m.Attachments_ }
+func (m *msg) ExternalID() string           { return m.ExternalID_ }
+func (m *msg) URN() urns.URN                { return m.URN_ }
+func (m *msg) URNAuth() string              { return m.URNAuth_ }
+func (m *msg) ContactName() string          { return m.ContactName_ }
+func (m *msg) QuickReplies() []string       { return m.QuickReplies_ }
+func (m *msg) HighPriority() bool           { return m.HighPriority_ }
+func (m *msg) ReceivedOn() *time.Time       { return m.ReceivedOn_ }
+func (m *msg) SentOn() *time.Time           { return nil }
+func (m *msg) ResponseToID() courier.MsgID  { return m.ResponseToID_ }
+func (m *msg) ResponseToExternalID() string { return m.ResponseToExternalID_ }
+
+func (m *msg) Channel() courier.Channel { return m.channel }
+
+// Metadata returns the metadata for this message
+func (m *msg) Metadata() json.RawMessage {
+	if len(m.Metadata_) == 0 {
+		return nil
+	}
+	return m.Metadata_
+}
+
+// urnFingerprint returns a fingerprint for this msg, suitable for figuring out if this is a dupe
+func (m *msg) urnFingerprint() string {
+	return fmt.Sprintf("%s:%s", m.ChannelUUID_, m.URN_.Identity())
+}
+
+// WithContactName can be used to set the contact name on a msg
+func (m *msg) WithContactName(name string) courier.Msg { m.ContactName_ = name; return m }
+
+// WithReceivedOn can be used to set received on on a msg in a chained call
+func (m *msg) WithReceivedOn(date time.Time) courier.Msg {
+	date = date.UTC()
+	m.ReceivedOn_ = &date
+	return m
+}
+
+// WithExternalID can be used to set the external id on a msg in a chained call
+func (m *msg) WithExternalID(id string) courier.Msg { m.ExternalID_ = id; return m }
+
+// WithID can be used to set the id on a msg in a chained call
+func (m *msg) WithID(id courier.MsgID) courier.Msg { m.ID_ = id; return m }
+
+// WithUUID can be used to set the id on a msg in a chained call
+func (m *msg) WithUUID(uuid courier.MsgUUID) courier.Msg { m.UUID_ = uuid; return m }
+
+// WithMetadata can be used to add metadata to a Msg
+func (m *msg) WithMetadata(metadata json.RawMessage) courier.Msg { m.Metadata_ = metadata; return m }
+
+// WithAttachment can be used to append to the media urls for a message
+func (m *msg) WithAttachment(url string) courier.Msg {
+	m.Attachments_ = append(m.Attachments_, url)
+	return m
+}
+
+// WithURNAuth can be used to add a URN auth setting to a message
+func (m *msg) WithURNAuth(auth string) courier.Msg {
+	m.URNAuth_ = auth
+	return m
+}
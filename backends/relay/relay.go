@@ -0,0 +1,199 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/utils"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// the headers our requests are signed with
+	timestampHeader = "X-Courier-Timestamp"
+	signatureHeader = "X-Courier-Signature"
+
+	// how far a request timestamp can be from our clock before we reject it as a possible replay
+	maxTimestampSkew = time.Minute * 5
+)
+
+// how long we wait before our first retry of a failed request, doubling for each retry after that
+var retryBackoff = time.Second
+
+// signature returns the signature for the passed in timestamp and body using our secret
+func signature(secret string, timestamp string, body []byte) string {
+	return "sha256=" + utils.SignHMAC256(secret, timestamp+"."+string(body))
+}
+
+// verifySignature checks the passed in request was signed with our secret and recently
+func verifySignature(secret string, r *http.Request, body []byte, now time.Time) error {
+	timestamp := r.Header.Get(timestampHeader)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.Errorf("missing or invalid %s header", timestampHeader)
+	}
+
+	skew := now.Sub(time.Unix(seconds, 0))
+	if skew > maxTimestampSkew || skew < -maxTimestampSkew {
+		return errors.Errorf("request timestamp too far from current time")
+	}
+
+	expected := signature(secret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(r.Header.Get(signatureHeader))) {
+		return errors.Errorf("invalid request signature")
+	}
+	return nil
+}
+
+// the spool directory payloads which couldn't be relayed are written to, so they can be relayed again later
+const relaySpoolDir = "relay"
+
+// how many payloads we buffer for our relayer before spooling them instead
+const relayBufferSize = 1000
+
+// queueRelay queues the passed in payload to be relayed to our relay URL in the background, this never blocks, if our
+// buffer is full the payload is spooled to be relayed later
+func (b *backend) queueRelay(payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrapf(err, "error marshalling relay payload")
+	}
+
+	select {
+	case b.relayBuffer <- body:
+	default:
+		b.spoolRelay(errors.New("relay buffer full"), body)
+	}
+	return nil
+}
+
+// startRelayer starts our relayer, which relays queued payloads in the order they were queued, spooling any which
+// can't be relayed after retrying
+func (b *backend) startRelayer() {
+	b.waitGroup.Add(1)
+
+	go func() {
+		defer b.waitGroup.Done()
+
+		log := logrus.WithField("comp", "relayer")
+		log.WithField("state", "started").Info("relayer started")
+
+		for {
+			select {
+			case <-b.stopChan:
+				// we don't retry while stopping, anything still buffered is spooled to be relayed once we're back up
+				for len(b.relayBuffer) > 0 {
+					b.spoolRelay(errors.New("backend stopping"), <-b.relayBuffer)
+				}
+				log.WithField("state", "stopped").Info("relayer stopped")
+				return
+
+			case body := <-b.relayBuffer:
+				ctx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+				statusCode, err := b.relay(ctx, body)
+				cancel()
+
+				if err != nil {
+					// the endpoint rejected this payload so retrying it won't help
+					if !isRetryable(statusCode) {
+						log.WithError(err).WithField("body", string(body)).Error("relay endpoint rejected payload, dropping")
+						continue
+					}
+					b.spoolRelay(err, body)
+				}
+			}
+		}
+	}()
+}
+
+// spoolRelay writes the passed in body which failed to be relayed to our spool
+func (b *backend) spoolRelay(err error, body []byte) {
+	logrus.WithField("comp", "relayer").WithError(err).Error("error relaying payload, spooling")
+
+	err = courier.WriteToSpool(b.config.SpoolDir, relaySpoolDir, json.RawMessage(body))
+	if err != nil {
+		logrus.WithField("comp", "relayer").WithError(err).WithField("body", string(body)).Error("error writing relay payload to spool")
+	}
+}
+
+// parseRelayFile parses a spooled payload, returning it compacted or an invalid spool file error if it isn't valid JSON
+func parseRelayFile(contents []byte) ([]byte, error) {
+	body := &bytes.Buffer{}
+	err := json.Compact(body, contents)
+	if err != nil {
+		return nil, courier.InvalidSpoolFile(err)
+	}
+	return body.Bytes(), nil
+}
+
+// flushRelayFile tries to relay a payload which was previously spooled
+func (b *backend) flushRelayFile(filename string, contents []byte) error {
+	body, err := parseRelayFile(contents)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+	defer cancel()
+
+	statusCode, err := b.relay(ctx, body)
+	if err != nil && !isRetryable(statusCode) {
+		return courier.InvalidSpoolFile(err)
+	}
+	return err
+}
+
+// relay POSTs the passed in JSON body to our relay URL, retrying with a backoff if the request fails or the endpoint
+// returns a server error, returning the status code of the last response
+func (b *backend) relay(ctx context.Context, body []byte) (int, error) {
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		statusCode, err := b.post(ctx, body)
+		if err == nil || !isRetryable(statusCode) || attempt >= b.config.RelayRetries {
+			return statusCode, err
+		}
+
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return statusCode, errors.Wrapf(err, "context done before retry")
+		}
+	}
+}
+
+// post makes a single signed POST of the passed in body to our relay URL, returning the status code of the response
+// which is zero if we didn't get one
+func (b *backend) post(ctx context.Context, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, b.config.RelayURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(timestampHeader, timestamp)
+	req.Header.Set(signatureHeader, signature(b.config.RelaySecret, timestamp, body))
+
+	// a connection failure gives us a response with no status code and the error as its body
+	rr, _ := utils.MakeHTTPRequest(req.WithContext(ctx))
+	if rr.StatusCode == 0 {
+		return 0, errors.Errorf("error making relay request: %s", rr.Body)
+	}
+	if rr.StatusCode/100 != 2 {
+		return rr.StatusCode, errors.Errorf("relay endpoint returned status %d", rr.StatusCode)
+	}
+	return rr.StatusCode, nil
+}
+
+// isRetryable returns whether a request which failed with the passed in status code should be retried
+func isRetryable(statusCode int) bool {
+	return statusCode == 0 || statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
@@ -0,0 +1,51 @@
+package relay
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/nyaruka/courier"
+)
+
+const (
+	// how long we remember incoming msgs for to dedupe them
+	msgSeenTTL = time.Second * 4
+
+	// how long we remember external ids for to dedupe msgs
+	externalIDSeenTTL = time.Hour * 24
+
+	// how long we remember the msgs we've sent
+	sentTTL = time.Hour * 48
+)
+
+// checkSeen returns the UUID of the msg recorded under the passed in key if it had the same text
+func checkSeen(rc redis.Conn, key string, text string) courier.MsgUUID {
+	found, _ := redis.String(rc.Do("get", key))
+
+	// values are in the format uuid|text
+	parts := strings.SplitN(found, "|", 2)
+	if len(parts) == 2 && parts[1] == text {
+		return courier.NewMsgUUIDFromString(parts[0])
+	}
+	return courier.NilMsgUUID
+}
+
+// writeSeen records the passed in msg UUID and text under the passed in key for the passed in duration
+func writeSeen(rc redis.Conn, key string, uuid courier.MsgUUID, text string, ttl time.Duration) error {
+	_, err := rc.Do("set", key, fmt.Sprintf("%s|%s", uuid, text), "ex", int(ttl/time.Second))
+	return err
+}
+
+func msgSeenKey(m *msg) string {
+	return fmt.Sprintf("relay:seen:msgs:%s", m.urnFingerprint())
+}
+
+func externalIDSeenKey(m *msg) string {
+	return fmt.Sprintf("relay:seen:externalid:%s|%s", m.urnFingerprint(), m.ExternalID_)
+}
+
+func sentKey(id courier.MsgID) string {
+	return fmt.Sprintf("relay:sent:%s", id)
+}
@@ -0,0 +1,47 @@
+package relay
+
+import (
+	"github.com/nyaruka/courier"
+)
+
+// newMsgStatus creates a new msg status for the passed in msg id or external id
+func newMsgStatus(c courier.Channel, id courier.MsgID, externalID string, status courier.MsgStatusValue) *msgStatus {
+	return &msgStatus{
+		ChannelUUID_: c.UUID(),
+		ID_:          id,
+		ExternalID_:  externalID,
+		Status_:      status,
+	}
+}
+
+//-----------------------------------------------------------------------------
+// MsgStatus implementation
+//-----------------------------------------------------------------------------
+
+// msgStatus represents a status update on a msg
+type msgStatus struct {
+	ChannelUUID_ courier.ChannelUUID
+	ID_          courier.MsgID
+	ExternalID_  string
+	Status_      courier.MsgStatusValue
+
+	sendError *courier.SendError
+	logs      []*courier.ChannelLog
+}
+
+func (s *msgStatus) EventID() int64 { return int64(s.ID_) }
+
+func (s *msgStatus) ChannelUUID() courier.ChannelUUID { return s.ChannelUUID_ }
+func (s *msgStatus) ID() courier.MsgID                { return s.ID_ }
+
+func (s *msgStatus) ExternalID() string      { return s.ExternalID_ }
+func (s *msgStatus) SetExternalID(id string) { s.ExternalID_ = id }
+
+func (s *msgStatus) Logs() []*courier.ChannelLog    { return s.logs }
+func (s *msgStatus) AddLog(log *courier.ChannelLog) { s.logs = append(s.logs, log) }
+
+func (s *msgStatus) Status() courier.MsgStatusValue          { return s.Status_ }
+func (s *msgStatus) SetStatus(status courier.MsgStatusValue) { s.Status_ = status }
+
+func (s *msgStatus) SendError() *courier.SendError       { return s.sendError }
+func (s *msgStatus) SetSendError(err *courier.SendError) { s.sendError = err }
@@ -0,0 +1,538 @@
+package standalone
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/jmoiron/sqlx"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/backends/static"
+	"github.com/nyaruka/courier/metrics"
+	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	// our SQLite driver
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// our timeout for backend operations
+const backendTimeout = time.Second * 20
+
+// how often we look for outgoing msgs to queue
+const queueInterval = time.Second
+
+// how long we remember the msgs we've sent
+const sentTTL = time.Hour * 48
+
+func init() {
+	courier.RegisterBackend("standalone", newBackend)
+}
+
+// GetChannel returns the channel for the passed in type and UUID
+func (b *backend) GetChannel(ctx context.Context, ct courier.ChannelType, uuid courier.ChannelUUID) (courier.Channel, error) {
+	c, found := b.channels[uuid]
+	if !found {
+		return nil, courier.ErrChannelNotFound
+	}
+	if ct != courier.AnyChannelType && c.ChannelType() != ct {
+		return nil, courier.ErrChannelWrongType
+	}
+	return c, nil
+}
+
+// GetContact returns the contact for the passed in channel and URN
+func (b *backend) GetContact(ctx context.Context, c courier.Channel, urn urns.URN, auth string, name string) (courier.Contact, error) {
+	timeout, cancel := context.WithTimeout(ctx, backendTimeout)
+	defer cancel()
+
+	return contactForURN(timeout, b, c.(*static.Channel), urn, auth, name)
+}
+
+// AddURNtoContact adds a URN to the passed in contact
+func (b *backend) AddURNtoContact(ctx context.Context, c courier.Channel, ct courier.Contact, urn urns.URN) (urns.URN, error) {
+	err := addURNToContact(ctx, b, c.(*static.Channel), ct.(*contact), urn)
+	if err != nil {
+		return urns.NilURN, err
+	}
+	return urn, nil
+}
+
+// RemoveURNfromContact removes a URN from the passed in contact
+func (b *backend) RemoveURNfromContact(ctx context.Context, c courier.Channel, ct courier.Contact, urn urns.URN) (urns.URN, error) {
+	err := removeURNFromContact(ctx, b, ct.(*contact), urn)
+	if err != nil {
+		return urns.NilURN, err
+	}
+	return urn, nil
+}
+
+// NewIncomingMsg creates a new message from the given params
+func (b *backend) NewIncomingMsg(c courier.Channel, urn urns.URN, text string) courier.Msg {
+	// remove any control characters
+	text = utils.CleanString(text)
+
+	m := newMsg(MsgIncoming, c.(*static.Channel), urn, text)
+	m.WithReceivedOn(time.Now().UTC())
+
+	// have we seen this msg in the past period? if so use its UUID and don't write it again
+	prevUUID := b.msgsSeen.check(m.urnFingerprint(), m.Text_)
+	if prevUUID != courier.NilMsgUUID {
+		m.UUID_ = prevUUID
+		m.alreadyWritten = true
+	}
+	return m
+}
+
+// WriteMsg writes the passed in message to our store
+func (b *backend) WriteMsg(ctx context.Context, m courier.Msg) error {
+	timeout, cancel := context.WithTimeout(ctx, backendTimeout)
+	defer cancel()
+
+	return writeMsg(timeout, b, m.(*msg))
+}
+
+// NewMsgStatusForID creates a new Status object for the given message id
+func (b *backend) NewMsgStatusForID(c courier.Channel, id courier.MsgID, status courier.MsgStatusValue) courier.MsgStatus {
+	return newMsgStatus(c, id, "", status)
+}
+
+// NewMsgStatusForExternalID creates a new Status object for the given external id
+func (b *backend) NewMsgStatusForExternalID(c courier.Channel, externalID string, status courier.MsgStatusValue) courier.MsgStatus {
+	return newMsgStatus(c, courier.NilMsgID, externalID, status)
+}
+
+// WriteMsgStatus writes the passed in MsgStatus to our store
+func (b *backend) WriteMsgStatus(ctx context.Context, status courier.MsgStatus) error {
+	timeout, cancel := context.WithTimeout(ctx, backendTimeout)
+	defer cancel()
+
+	err := writeMsgStatus(timeout, b, status.(*msgStatus))
+	if err != nil {
+		return err
+	}
+
+	// if we are marking an outgoing msg as errored, then clear our sent flag so it can be retried
+	if status.ID() != courier.NilMsgID && status.Status() == courier.MsgErrored {
+		b.sentMutex.Lock()
+		delete(b.sent, status.ID())
+		b.sentMutex.Unlock()
+	}
+
+	return nil
+}
+
+// NewChannelEvent creates a new channel event with the passed in parameters
+func (b *backend) NewChannelEvent(c courier.Channel, eventType courier.ChannelEventType, urn urns.URN) courier.ChannelEvent {
+	return newChannelEvent(c.(*static.Channel), eventType, urn)
+}
+
+// WriteChannelEvent writes the passed in channel even returning any error
+func (b *backend) WriteChannelEvent(ctx context.Context, event courier.ChannelEvent) error {
+	timeout, cancel := context.WithTimeout(ctx, backendTimeout)
+	defer cancel()
+
+	return writeChannelEvent(timeout, b, event.(*channelEvent))
+}
+
+// WriteChannelLogs persists the passed in logs to our database, we swallow all errors, logging isn't critical
+func (b *backend) WriteChannelLogs(ctx context.Context, logs []*courier.ChannelLog) error {
+	timeout, cancel := context.WithTimeout(ctx, backendTimeout)
+	defer cancel()
+
+	for _, l := range logs {
+		err := writeChannelLog(timeout, b, l)
+		if err != nil {
+			logrus.WithError(err).Error("error writing channel log")
+		}
+	}
+	return nil
+}
+
+// PopNextOutgoingMsg pops the next message that needs to be sent
+func (b *backend) PopNextOutgoingMsg(ctx context.Context) (courier.Msg, error) {
+	m := b.outbox.pop()
+	if m == nil {
+		return nil, nil
+	}
+
+	// clear out our seen incoming messages
+	b.msgsSeen.clear(m.urnFingerprint())
+
+	return m, nil
+}
+
+// WasMsgSent returns whether the passed in message has already been sent
+func (b *backend) WasMsgSent(ctx context.Context, m courier.Msg) (bool, error) {
+	b.sentMutex.Lock()
+	defer b.sentMutex.Unlock()
+
+	_, sent := b.sent[m.ID()]
+	return sent, nil
+}
+
+// MarkOutgoingMsgComplete marks the passed in message as having completed processing, freeing up a worker for that channel
+func (b *backend) MarkOutgoingMsgComplete(ctx context.Context, m courier.Msg, status courier.MsgStatus) {
+	b.outbox.complete(m.Channel().UUID())
+
+	// remember that we sent this msg if it was actually wired or sent
+	if status != nil && (status.Status() == courier.MsgSent || status.Status() == courier.MsgWired) {
+		b.sentMutex.Lock()
+		b.sent[m.ID()] = time.Now()
+		b.sentMutex.Unlock()
+	}
+}
+
+// CheckExternalIDSeen checks if external ID has been seen in a period
+func (b *backend) CheckExternalIDSeen(m courier.Msg) courier.Msg {
+	sm := m.(*msg)
+
+	prevUUID := b.externalIDsSeen.check(externalIDFingerprint(sm), sm.Text_)
+	if prevUUID != courier.NilMsgUUID {
+		// if so, use its UUID and that we've been written
+		sm.UUID_ = prevUUID
+		sm.alreadyWritten = true
+	}
+	return sm
+}
+
+// WriteExternalIDSeen marks a external ID as seen for a period
+func (b *backend) WriteExternalIDSeen(m courier.Msg) {
+	b.externalIDsSeen.write(externalIDFingerprint(m.(*msg)), m.UUID(), m.Text(), externalIDSeenTTL)
+}
+
+// externalIDFingerprint returns the key we record the external id of the passed in msg under
+func externalIDFingerprint(m *msg) string {
+	return fmt.Sprintf("%s|%s", m.urnFingerprint(), m.ExternalID_)
+}
+
+// Queues returns the outgoing queue of each channel which has msgs waiting to be sent
+func (b *backend) Queues(ctx context.Context) ([]*courier.ChannelQueue, error) {
+	infos := b.outbox.list()
+
+	queues := make([]*courier.ChannelQueue, 0, len(infos))
+	for _, info := range infos {
+		channelType := courier.ChannelType("!!")
+		c, found := b.channels[info.ChannelUUID]
+		if found {
+			channelType = c.ChannelType()
+		}
+
+		state := "active"
+		if info.Paused {
+			state = "paused"
+		} else if info.Throttled {
+			state = "throttled"
+		} else if info.Saturated {
+			state = "saturated"
+		}
+
+		queues = append(queues, &courier.ChannelQueue{
+			ChannelUUID: info.ChannelUUID,
+			ChannelType: channelType,
+			State:       state,
+			TPS:         info.TPS,
+			Workers:     info.Workers,
+			Paused:      info.Paused,
+			Breaker:     "closed",
+			Size:        info.Size,
+			BulkSize:    info.BulkSize,
+		})
+	}
+
+	return queues, nil
+}
+
+// PeekQueue returns up to the passed in number of items from the head of a channel's outgoing queue
+func (b *backend) PeekQueue(ctx context.Context, channelUUID courier.ChannelUUID, count int) ([]*courier.QueuedItem, error) {
+	msgs := b.outbox.peek(channelUUID, count)
+
+	items := make([]*courier.QueuedItem, 0, len(msgs))
+	for _, m := range msgs {
+		value, err := json.Marshal(m)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error marshalling queued msg %s", m.ID_)
+		}
+
+		items = append(items, &courier.QueuedItem{
+			HighPriority: m.HighPriority_,
+			AvailableOn:  m.ModifiedOn_,
+			Value:        json.RawMessage(value),
+		})
+	}
+
+	return items, nil
+}
+
+// PurgeQueue removes all items from a channel's outgoing queue, returning the number of items removed
+func (b *backend) PurgeQueue(ctx context.Context, channelUUID courier.ChannelUUID) (int, error) {
+	return len(b.outbox.purge(channelUUID)), nil
+}
+
+// PauseChannel stops msgs being sent for the passed in channel, queued msgs are kept until it is resumed
+func (b *backend) PauseChannel(ctx context.Context, channelUUID courier.ChannelUUID) error {
+	b.outbox.pause(channelUUID)
+	return nil
+}
+
+// ResumeChannel resumes sending msgs for a paused channel
+func (b *backend) ResumeChannel(ctx context.Context, channelUUID courier.ChannelUUID) error {
+	b.outbox.resume(channelUUID)
+	return nil
+}
+
+// IsChannelPaused returns whether sending is currently paused for the passed in channel
+func (b *backend) IsChannelPaused(ctx context.Context, channelUUID courier.ChannelUUID) (bool, error) {
+	return b.outbox.isPaused(channelUUID), nil
+}
+
+// InvalidateChannel is a no-op as our channels are loaded from a file at startup and never cached
+func (b *backend) InvalidateChannel(ctx context.Context, channelUUID courier.ChannelUUID) error {
+	return nil
+}
+
+// DrainChannel removes all msgs from a channel's outgoing queue and marks them as failed, returning the number of msgs failed
+func (b *backend) DrainChannel(ctx context.Context, channelUUID courier.ChannelUUID) (int, error) {
+	c, found := b.channels[channelUUID]
+	if !found {
+		return 0, errors.Wrapf(courier.ErrChannelNotFound, "error looking up channel %s", channelUUID)
+	}
+
+	failed := 0
+	for _, m := range b.outbox.purge(channelUUID) {
+		err := b.WriteMsgStatus(ctx, b.NewMsgStatusForID(c, m.ID_, courier.MsgFailed))
+		if err != nil {
+			return failed, errors.Wrapf(err, "error failing msg %s", m.ID_)
+		}
+		failed++
+	}
+
+	return failed, nil
+}
+
+// Health returns a report on the health of our db
+func (b *backend) Health() *courier.HealthReport {
+	health := courier.NewHealthReport()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	health.Add("db", b.db.PingContext(ctx))
+	cancel()
+
+	return health
+}
+
+// Heartbeat is called every minute, we report our queue depths to our metrics sinks and forget old sends
+func (b *backend) Heartbeat() error {
+	prioritySize := 0
+	bulkSize := 0
+	for _, info := range b.outbox.list() {
+		prioritySize += info.Size
+		bulkSize += info.BulkSize
+	}
+
+	metrics.Gauge("bulk_queue", nil, float64(bulkSize))
+	metrics.Gauge("priority_queue", nil, float64(prioritySize))
+	logrus.WithField("bulk_queue", bulkSize).WithField("priority_queue", prioritySize).Info("heartbeat queue sizes calculated")
+
+	b.msgsSeen.trim()
+	b.externalIDsSeen.trim()
+
+	b.sentMutex.Lock()
+	for id, sentOn := range b.sent {
+		if time.Since(sentOn) > sentTTL {
+			delete(b.sent, id)
+		}
+	}
+	b.sentMutex.Unlock()
+
+	return nil
+}
+
+// Status returns information on our queue sizes, number of workers etc..
+func (b *backend) Status() string {
+	status := bytes.Buffer{}
+	status.WriteString("------------------------------------------------------------------------------------\n")
+	status.WriteString("     Size | Bulk Size | Workers | TPS | Type | Channel              \n")
+	status.WriteString("------------------------------------------------------------------------------------\n")
+
+	for _, info := range b.outbox.list() {
+		channelType := "!!"
+		c, found := b.channels[info.ChannelUUID]
+		if found {
+			channelType = c.ChannelType().String()
+		}
+
+		status.WriteString(fmt.Sprintf("% 9d   % 9d   % 7d   % 3d   % 4s   %s\n", info.Size, info.BulkSize, info.Workers, info.TPS, channelType, info.ChannelUUID))
+	}
+
+	// list any paused channels, these may or may not have queued msgs
+	paused := b.outbox.pausedChannels()
+	if len(paused) > 0 {
+		status.WriteString("------------------------------------------------------------------------------------\n")
+		status.WriteString(" Paused Channels\n")
+		status.WriteString("------------------------------------------------------------------------------------\n")
+		for _, uuid := range paused {
+			status.WriteString(fmt.Sprintf(" %s\n", uuid))
+		}
+	}
+
+	return status.String()
+}
+
+// Start starts our standalone backend, loading our channels, opening our db and queueing any outgoing msgs
+func (b *backend) Start() error {
+	log := logrus.WithFields(logrus.Fields{
+		"comp":  "backend",
+		"state": "starting",
+	})
+	log.Info("starting backend")
+
+	channels, err := static.LoadChannels(b.config.StandaloneChannels)
+	if err != nil {
+		return err
+	}
+	b.channels = channels
+	log.WithField("channels", len(channels)).Info("channels loaded")
+
+	db, err := sqlx.Open("sqlite3", b.config.StandaloneDB)
+	if err != nil {
+		return fmt.Errorf("unable to open SQLite database '%s': %s", b.config.StandaloneDB, err)
+	}
+
+	// SQLite only allows one writer at a time, and each connection to an in-memory database gets its own database,
+	// so we use a single connection which we never close
+	b.db = db
+	b.db.SetMaxOpenConns(1)
+	b.db.SetMaxIdleConns(1)
+	b.db.SetConnMaxLifetime(0)
+
+	_, err = b.db.Exec(schemaSQL)
+	if err != nil {
+		return errors.Wrapf(err, "error creating database schema")
+	}
+	log.Info("db ok")
+
+	// our queue only lives in memory, so queue any msgs which were queued when we last stopped
+	ctx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+	queued, err := queueOutgoingMsgs(ctx, b, true)
+	cancel()
+	if err != nil {
+		return err
+	}
+	log.WithField("queued", queued).Info("outgoing msgs queued")
+
+	if b.config.MaxWorkers > 0 {
+		startMsgQueuer(b)
+	}
+
+	// we don't use redis ourselves but some handlers cache tokens in it, so we create a pool which will only connect
+	// if one of those handlers needs it
+	b.redisPool = newRedisPool(b.config.Redis)
+
+	logrus.WithFields(logrus.Fields{
+		"comp":  "backend",
+		"state": "started",
+	}).Info("backend started")
+
+	return nil
+}
+
+// newRedisPool creates a new redis pool for the passed in URL, connections are only made when first used
+func newRedisPool(redisURL string) *redis.Pool {
+	return &redis.Pool{
+		Wait:        true,
+		MaxActive:   4,
+		MaxIdle:     2,
+		IdleTimeout: 240 * time.Second,
+		Dial: func() (redis.Conn, error) {
+			u, err := url.Parse(redisURL)
+			if err != nil {
+				return nil, errors.Wrapf(err, "unable to parse Redis URL '%s'", redisURL)
+			}
+
+			conn, err := redis.Dial("tcp", u.Host)
+			if err != nil {
+				return nil, err
+			}
+
+			// send auth if required
+			if u.User != nil {
+				pass, authRequired := u.User.Password()
+				if authRequired {
+					if _, err := conn.Do("AUTH", pass); err != nil {
+						conn.Close()
+						return nil, err
+					}
+				}
+			}
+
+			// switch to the right DB
+			_, err = conn.Do("SELECT", strings.TrimLeft(u.Path, "/"))
+			return conn, err
+		},
+	}
+}
+
+// Stop stops our standalone backend, stopping our msg queuer
+func (b *backend) Stop() error {
+	close(b.stopChan)
+	b.waitGroup.Wait()
+	return nil
+}
+
+// Cleanup closes our db and redis pool
+func (b *backend) Cleanup() error {
+	if b.db != nil {
+		b.db.Close()
+	}
+	if b.redisPool != nil {
+		return b.redisPool.Close()
+	}
+	return nil
+}
+
+// RedisPool returns the redisPool for this backend
+func (b *backend) RedisPool() *redis.Pool {
+	return b.redisPool
+}
+
+// newBackend creates a new standalone backend
+func newBackend(config *courier.Config) courier.Backend {
+	return &backend{
+		config: config,
+
+		outbox:          newOutbox(),
+		msgsSeen:        newSeenCache(),
+		externalIDsSeen: newSeenCache(),
+		sent:            make(map[courier.MsgID]time.Time),
+
+		stopChan:  make(chan bool),
+		waitGroup: &sync.WaitGroup{},
+	}
+}
+
+type backend struct {
+	config *courier.Config
+
+	channels  map[courier.ChannelUUID]*static.Channel
+	db        *sqlx.DB
+	redisPool *redis.Pool
+	outbox    *outbox
+
+	msgsSeen        *seenCache
+	externalIDsSeen *seenCache
+
+	sent      map[courier.MsgID]time.Time
+	sentMutex sync.Mutex
+
+	stopChan  chan bool
+	waitGroup *sync.WaitGroup
+}
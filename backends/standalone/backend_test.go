@@ -0,0 +1,359 @@
+package standalone
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/backends/static"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+)
+
+type BackendTestSuite struct {
+	suite.Suite
+	b *backend
+}
+
+func testConfig() *courier.Config {
+	config := courier.NewConfig()
+	config.Backend = "standalone"
+	config.StandaloneDB = ":memory:"
+	config.StandaloneChannels = "testdata/channels.json"
+	config.MaxWorkers = 0
+	return config
+}
+
+func (ts *BackendTestSuite) SetupSuite() {
+	// turn off logging
+	logrus.SetOutput(ioutil.Discard)
+
+	b, err := courier.NewBackend(testConfig())
+	if err != nil {
+		log.Fatalf("unable to create standalone backend: %v", err)
+	}
+	ts.b = b.(*backend)
+
+	err = ts.b.Start()
+	if err != nil {
+		log.Fatalf("unable to start backend for testing: %v", err)
+	}
+}
+
+func (ts *BackendTestSuite) TearDownSuite() {
+	ts.b.Stop()
+	ts.b.Cleanup()
+}
+
+func (ts *BackendTestSuite) getChannel(cType string, cUUID string) *static.Channel {
+	channelUUID, err := courier.NewChannelUUID(cUUID)
+	ts.NoError(err, "error building channel uuid")
+
+	c, err := ts.b.GetChannel(context.Background(), courier.ChannelType(cType), channelUUID)
+	ts.NoError(err, "error loading channel")
+	return c.(*static.Channel)
+}
+
+// insertOutgoingMsg inserts a new pending outgoing msg the way an application using this backend would
+func (ts *BackendTestSuite) insertOutgoingMsg(c *static.Channel, urn urns.URN, text string, highPriority bool) courier.MsgID {
+	now := time.Now().UTC()
+	result := ts.b.db.MustExec(`
+		INSERT INTO msgs(uuid, channel_uuid, urn, direction, status, high_priority, text, created_on, modified_on)
+		VALUES(?, ?, ?, 'O', 'P', ?, ?, ?, ?)`, courier.NewMsgUUID(), c.UUID(), urn, highPriority, text, now, now)
+
+	id, err := result.LastInsertId()
+	ts.NoError(err)
+	return courier.NewMsgID(id)
+}
+
+func (ts *BackendTestSuite) getMsgStatus(id courier.MsgID) (courier.MsgStatusValue, int) {
+	m := struct {
+		Status     courier.MsgStatusValue `db:"status"`
+		ErrorCount int                    `db:"error_count"`
+	}{}
+	err := ts.b.db.Get(&m, `SELECT status, error_count FROM msgs WHERE id = ?`, id)
+	ts.NoError(err)
+	return m.Status, m.ErrorCount
+}
+
+func (ts *BackendTestSuite) TestGetChannel() {
+	c := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	ts.Equal("2500", c.Address())
+	ts.Equal("RW", c.Country())
+	ts.Equal([]string{"tel"}, c.Schemes())
+	ts.Equal(10, c.TPS())
+	ts.Equal("courier.example.com", c.CallbackDomain("localhost"))
+	ts.Equal(320, c.IntConfigForKey(courier.ConfigMaxLength, 160))
+	ts.Equal("default", c.StringConfigForKey(courier.ConfigAPIKey, "default"))
+
+	fb := ts.getChannel("FB", "53e5aafa-8155-449d-9009-fcb30d54bd26")
+	ts.True(fb.IsScheme(urns.FacebookScheme))
+	ts.Equal("localhost", fb.CallbackDomain("localhost"))
+
+	// wrong type
+	_, err := ts.b.GetChannel(context.Background(), courier.ChannelType("EX"), c.UUID())
+	ts.Equal(courier.ErrChannelWrongType, err)
+
+	// doesn't exist
+	missing, _ := courier.NewChannelUUID("f2474a58-53b1-4d16-8a3e-4d8a1cbc6ae1")
+	_, err = ts.b.GetChannel(context.Background(), courier.AnyChannelType, missing)
+	ts.Equal(courier.ErrChannelNotFound, err)
+}
+
+func (ts *BackendTestSuite) TestContact() {
+	ctx := context.Background()
+	c := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	urn, _ := urns.NewTelURNForCountry("+250788383383", c.Country())
+
+	contact, err := ts.b.GetContact(ctx, c, urn, "", "Ryan")
+	ts.NoError(err)
+
+	// looking it up again gives us the same contact
+	contact2, err := ts.b.GetContact(ctx, c, urn, "", "")
+	ts.NoError(err)
+	ts.Equal(contact.UUID(), contact2.UUID())
+
+	// add a new URN to our contact
+	urn2, _ := urns.NewTelURNForCountry("+250788383384", c.Country())
+	_, err = ts.b.AddURNtoContact(ctx, c, contact, urn2)
+	ts.NoError(err)
+
+	contact3, err := ts.b.GetContact(ctx, c, urn2, "", "")
+	ts.NoError(err)
+	ts.Equal(contact.UUID(), contact3.UUID())
+
+	// remove it, now it gets a new contact
+	_, err = ts.b.RemoveURNfromContact(ctx, c, contact, urn2)
+	ts.NoError(err)
+
+	contact4, err := ts.b.GetContact(ctx, c, urn2, "", "")
+	ts.NoError(err)
+	ts.NotEqual(contact.UUID(), contact4.UUID())
+}
+
+func (ts *BackendTestSuite) TestWriteMsg() {
+	ctx := context.Background()
+	c := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	urn, _ := urns.NewTelURNForCountry("+250788383385", c.Country())
+
+	msg := ts.b.NewIncomingMsg(c, urn, "test123").WithExternalID("ext123").WithAttachment("image/jpeg:https://example.com/image.jpg")
+	err := ts.b.WriteMsg(ctx, msg)
+	ts.NoError(err)
+	ts.NotEqual(courier.NilMsgID, msg.ID())
+
+	m := struct {
+		Text        string     `db:"text"`
+		Direction   string     `db:"direction"`
+		ExternalID  string     `db:"external_id"`
+		Attachments stringList `db:"attachments"`
+	}{}
+	err = ts.b.db.Get(&m, `SELECT text, direction, external_id, attachments FROM msgs WHERE id = ?`, msg.ID())
+	ts.NoError(err)
+	ts.Equal("test123", m.Text)
+	ts.Equal("I", m.Direction)
+	ts.Equal("ext123", m.ExternalID)
+	ts.Equal(stringList{"image/jpeg:https://example.com/image.jpg"}, m.Attachments)
+
+	// receiving the same msg again right away is a dupe
+	dupe := ts.b.NewIncomingMsg(c, urn, "test123")
+	ts.Equal(msg.UUID(), dupe.UUID())
+	ts.NoError(ts.b.WriteMsg(ctx, dupe))
+	ts.Equal(courier.NilMsgID, dupe.ID())
+
+	// but not if the text is different
+	other := ts.b.NewIncomingMsg(c, urn, "test456")
+	ts.NotEqual(msg.UUID(), other.UUID())
+
+	// same for external ids we've seen
+	ts.b.WriteExternalIDSeen(msg)
+	dupe = ts.b.CheckExternalIDSeen(ts.b.NewIncomingMsg(c, urn, "test123").WithExternalID("ext123"))
+	ts.Equal(msg.UUID(), dupe.UUID())
+}
+
+func (ts *BackendTestSuite) TestOutgoingMsgs() {
+	ctx := context.Background()
+	c := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	urn, _ := urns.NewTelURNForCountry("+250788383386", c.Country())
+
+	bulkID := ts.insertOutgoingMsg(c, urn, "bulk msg", false)
+	priorityID := ts.insertOutgoingMsg(c, urn, "priority msg", true)
+
+	queued, err := queueOutgoingMsgs(ctx, ts.b, false)
+	ts.NoError(err)
+	ts.Equal(2, queued)
+
+	status, _ := ts.getMsgStatus(bulkID)
+	ts.Equal(courier.MsgQueued, status)
+
+	queues, err := ts.b.Queues(ctx)
+	ts.NoError(err)
+	ts.Equal(1, len(queues))
+	ts.Equal(1, queues[0].Size)
+	ts.Equal(1, queues[0].BulkSize)
+	ts.Contains(ts.b.Status(), "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+
+	// our high priority msg is sent first
+	msg, err := ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Equal(priorityID, msg.ID())
+	ts.Equal("priority msg", msg.Text())
+	ts.Equal(urn, msg.URN())
+	ts.True(msg.HighPriority())
+
+	sent, err := ts.b.WasMsgSent(ctx, msg)
+	ts.NoError(err)
+	ts.False(sent)
+
+	wired := ts.b.NewMsgStatusForID(c, msg.ID(), courier.MsgWired)
+	wired.SetExternalID("ext1")
+	ts.NoError(ts.b.WriteMsgStatus(ctx, wired))
+	ts.b.MarkOutgoingMsgComplete(ctx, msg, wired)
+
+	sent, err = ts.b.WasMsgSent(ctx, msg)
+	ts.NoError(err)
+	ts.True(sent)
+
+	// our delivery report comes in by external id
+	ts.NoError(ts.b.WriteMsgStatus(ctx, ts.b.NewMsgStatusForExternalID(c, "ext1", courier.MsgDelivered)))
+	status, _ = ts.getMsgStatus(priorityID)
+	ts.Equal(courier.MsgDelivered, status)
+
+	// a late sent status doesn't regress it
+	ts.NoError(ts.b.WriteMsgStatus(ctx, ts.b.NewMsgStatusForID(c, priorityID, courier.MsgSent)))
+	status, _ = ts.getMsgStatus(priorityID)
+	ts.Equal(courier.MsgDelivered, status)
+
+	// statuses for msgs we don't know about are an error
+	ts.Equal(courier.ErrMsgNotFound, ts.b.WriteMsgStatus(ctx, ts.b.NewMsgStatusForExternalID(c, "ext2", courier.MsgDelivered)))
+
+	// our bulk msg errors, it will be retried later
+	msg, err = ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Equal(bulkID, msg.ID())
+
+	errored := ts.b.NewMsgStatusForID(c, msg.ID(), courier.MsgErrored)
+	errored.SetSendError(courier.NewRateLimitedError("429", "slow down", time.Second*30))
+	ts.NoError(ts.b.WriteMsgStatus(ctx, errored))
+	ts.b.MarkOutgoingMsgComplete(ctx, msg, errored)
+
+	status, errorCount := ts.getMsgStatus(bulkID)
+	ts.Equal(courier.MsgErrored, status)
+	ts.Equal(1, errorCount)
+
+	// not yet due
+	queued, err = queueOutgoingMsgs(ctx, ts.b, false)
+	ts.NoError(err)
+	ts.Equal(0, queued)
+
+	// once it is, it is queued again
+	ts.b.db.MustExec(`UPDATE msgs SET next_attempt = ? WHERE id = ?`, time.Now().UTC().Add(-time.Second), bulkID)
+	queued, err = queueOutgoingMsgs(ctx, ts.b, false)
+	ts.NoError(err)
+	ts.Equal(1, queued)
+
+	// after our last attempt it is failed
+	msg, err = ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Equal(bulkID, msg.ID())
+
+	ts.b.db.MustExec(`UPDATE msgs SET error_count = 2 WHERE id = ?`, bulkID)
+	ts.NoError(ts.b.WriteMsgStatus(ctx, ts.b.NewMsgStatusForID(c, bulkID, courier.MsgErrored)))
+	ts.b.MarkOutgoingMsgComplete(ctx, msg, nil)
+
+	status, errorCount = ts.getMsgStatus(bulkID)
+	ts.Equal(courier.MsgFailed, status)
+	ts.Equal(3, errorCount)
+
+	msg, err = ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Nil(msg)
+}
+
+func (ts *BackendTestSuite) TestQueueAdmin() {
+	ctx := context.Background()
+	c := ts.getChannel("FB", "53e5aafa-8155-449d-9009-fcb30d54bd26")
+	urn, _ := urns.NewFacebookURN("12345678")
+
+	id1 := ts.insertOutgoingMsg(c, urn, "one", false)
+	ts.insertOutgoingMsg(c, urn, "two", false)
+
+	// queued msgs are requeued when we restart
+	ts.b.db.MustExec(`UPDATE msgs SET status = 'Q' WHERE id = ?`, id1)
+	queued, err := queueOutgoingMsgs(ctx, ts.b, true)
+	ts.NoError(err)
+	ts.Equal(2, queued)
+
+	items, err := ts.b.PeekQueue(ctx, c.UUID(), 10)
+	ts.NoError(err)
+	ts.Equal(2, len(items))
+	ts.False(items[0].HighPriority)
+
+	ts.NoError(ts.b.PauseChannel(ctx, c.UUID()))
+	paused, err := ts.b.IsChannelPaused(ctx, c.UUID())
+	ts.NoError(err)
+	ts.True(paused)
+
+	msg, err := ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Nil(msg)
+
+	ts.NoError(ts.b.ResumeChannel(ctx, c.UUID()))
+
+	// draining fails all our queued msgs
+	failed, err := ts.b.DrainChannel(ctx, c.UUID())
+	ts.NoError(err)
+	ts.Equal(2, failed)
+
+	status, _ := ts.getMsgStatus(id1)
+	ts.Equal(courier.MsgFailed, status)
+
+	items, err = ts.b.PeekQueue(ctx, c.UUID(), 10)
+	ts.NoError(err)
+	ts.Equal(0, len(items))
+}
+
+func (ts *BackendTestSuite) TestChannelEvent() {
+	ctx := context.Background()
+	c := ts.getChannel("FB", "53e5aafa-8155-449d-9009-fcb30d54bd26")
+	urn, _ := urns.NewFacebookURN("12345679")
+
+	event := ts.b.NewChannelEvent(c, courier.Referral, urn).WithExtra(map[string]interface{}{"ref_id": "12345"}).WithContactName("kermit frog")
+	err := ts.b.WriteChannelEvent(ctx, event)
+	ts.NoError(err)
+
+	e := struct {
+		EventType string `db:"event_type"`
+		Extra     string `db:"extra"`
+	}{}
+	err = ts.b.db.Get(&e, `SELECT event_type, extra FROM channel_events WHERE id = ?`, event.EventID())
+	ts.NoError(err)
+	ts.Equal("referral", e.EventType)
+	ts.Equal(`{"ref_id":"12345"}`, e.Extra)
+}
+
+func (ts *BackendTestSuite) TestChannelLog() {
+	c := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	log := courier.NewChannelLog("Message Send", c, courier.NewMsgID(12345), "POST", "https://example.com/send", 500, "request", "response", time.Second, nil)
+	log.Error = "boom"
+
+	err := ts.b.WriteChannelLogs(context.Background(), []*courier.ChannelLog{log})
+	ts.NoError(err)
+
+	var response string
+	err = ts.b.db.Get(&response, `SELECT response FROM channel_logs WHERE msg_id = 12345`)
+	ts.NoError(err)
+	ts.Equal("response\n\nError: boom", response)
+}
+
+func (ts *BackendTestSuite) TestHealth() {
+	ts.True(ts.b.Health().Healthy())
+	ts.NoError(ts.b.Heartbeat())
+}
+
+func TestBackend(t *testing.T) {
+	suite.Run(t, new(BackendTestSuite))
+}
@@ -0,0 +1,102 @@
+package standalone
+
+import (
+	"context"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/backends/static"
+	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/pkg/errors"
+)
+
+// newChannelEvent creates a new channel event with the passed in parameters
+func newChannelEvent(c *static.Channel, eventType courier.ChannelEventType, urn urns.URN) *channelEvent {
+	now := time.Now().UTC()
+
+	return &channelEvent{
+		ChannelUUID_: c.UUID(),
+		URN_:         urn,
+		EventType_:   eventType,
+		OccurredOn_:  now,
+		CreatedOn_:   now,
+
+		channel: c,
+	}
+}
+
+const insertChannelEventSQL = `
+INSERT INTO
+	channel_events(channel_uuid, contact_id, urn, event_type, extra, occurred_on, created_on)
+	VALUES(:channel_uuid, :contact_id, :urn, :event_type, :extra, :occurred_on, :created_on)
+`
+
+// writeChannelEvent writes the passed in event to our db, creating its contact if necessary
+func writeChannelEvent(ctx context.Context, b *backend, e *channelEvent) error {
+	contact, err := contactForURN(ctx, b, e.channel, e.URN_, "", e.ContactName_)
+	if err != nil {
+		return errors.Wrapf(err, "error getting contact for channel event")
+	}
+	e.ContactID_ = contact.ID_
+
+	result, err := b.db.NamedExecContext(ctx, insertChannelEventSQL, e)
+	if err != nil {
+		return errors.Wrapf(err, "error inserting channel event")
+	}
+
+	e.ID_, err = result.LastInsertId()
+	return err
+}
+
+//-----------------------------------------------------------------------------
+// ChannelEvent implementation
+//-----------------------------------------------------------------------------
+
+// channelEvent represents an event on a channel
+type channelEvent struct {
+	ID_          int64                    `json:"id"            db:"id"`
+	ChannelUUID_ courier.ChannelUUID      `json:"channel_uuid"  db:"channel_uuid"`
+	ContactID_   int64                    `json:"contact_id"    db:"contact_id"`
+	URN_         urns.URN                 `json:"urn"           db:"urn"`
+	EventType_   courier.ChannelEventType `json:"event_type"    db:"event_type"`
+	Extra_       *utils.NullMap           `json:"extra"         db:"extra"`
+	OccurredOn_  time.Time                `json:"occurred_on"   db:"occurred_on"`
+	CreatedOn_   time.Time                `json:"created_on"    db:"created_on"`
+
+	ContactName_ string `json:"contact_name"`
+
+	channel *static.Channel
+	logs    []*courier.ChannelLog
+}
+
+func (e *channelEvent) EventID() int64                   { return e.ID_ }
+func (e *channelEvent) ChannelUUID() courier.ChannelUUID { return e.ChannelUUID_ }
+func (e *channelEvent) URN() urns.URN                    { return e.URN_ }
+func (e *channelEvent) Extra() map[string]interface{} {
+	if e.Extra_ != nil {
+		return e.Extra_.Map
+	}
+	return nil
+}
+func (e *channelEvent) EventType() courier.ChannelEventType { return e.EventType_ }
+func (e *channelEvent) OccurredOn() time.Time               { return e.OccurredOn_ }
+func (e *channelEvent) CreatedOn() time.Time                { return e.CreatedOn_ }
+
+func (e *channelEvent) WithContactName(name string) courier.ChannelEvent {
+	e.ContactName_ = name
+	return e
+}
+func (e *channelEvent) WithExtra(extra map[string]interface{}) courier.ChannelEvent {
+	newExtra := utils.NewNullMap(extra)
+	e.Extra_ = &newExtra
+	return e
+}
+
+func (e *channelEvent) WithOccurredOn(time time.Time) courier.ChannelEvent {
+	e.OccurredOn_ = time.UTC()
+	return e
+}
+
+func (e *channelEvent) Logs() []*courier.ChannelLog    { return e.logs }
+func (e *channelEvent) AddLog(log *courier.ChannelLog) { e.logs = append(e.logs, log) }
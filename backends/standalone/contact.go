@@ -0,0 +1,144 @@
+package standalone
+
+import (
+	"context"
+	"database/sql"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/backends/static"
+	"github.com/nyaruka/courier/metrics"
+	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const lookupContactFromURNSQL = `
+SELECT
+	c.id, c.uuid, c.name
+FROM
+	contacts c
+	JOIN contact_urns u ON u.contact_id = c.id
+WHERE
+	u.identity = ?
+`
+
+const insertContactSQL = `
+INSERT INTO contacts(uuid, name, created_on) VALUES(?, ?, ?)
+`
+
+// inserts our URN for the passed in contact, taking it over if it already exists but has no contact
+const upsertContactURNSQL = `
+INSERT INTO
+	contact_urns(contact_id, channel_uuid, identity, scheme, path, auth)
+	VALUES(?, ?, ?, ?, ?, ?)
+ON CONFLICT(identity) DO UPDATE SET
+	contact_id = excluded.contact_id,
+	channel_uuid = excluded.channel_uuid,
+	auth = CASE WHEN excluded.auth != '' THEN excluded.auth ELSE contact_urns.auth END
+`
+
+const updateURNAuthSQL = `
+UPDATE contact_urns SET channel_uuid = ?, auth = ? WHERE identity = ?
+`
+
+// contactForURN returns the contact for the passed in URN, creating it if it doesn't exist
+func contactForURN(ctx context.Context, b *backend, c *static.Channel, urn urns.URN, auth string, name string) (*contact, error) {
+	identity := urn.Identity().String()
+
+	// try to look up our contact by URN
+	existing := &contact{}
+	err := b.db.GetContext(ctx, existing, lookupContactFromURNSQL, identity)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, errors.Wrapf(err, "error looking up contact")
+	}
+
+	// we found it, update the auth of our URN if we were given a new one
+	if err == nil {
+		if auth != "" {
+			_, err = b.db.ExecContext(ctx, updateURNAuthSQL, c.UUID(), auth, identity)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error updating urn auth")
+			}
+		}
+		return existing, nil
+	}
+
+	// no name was passed in, see if our handler can look up information for this URN, we do this outside of our
+	// transaction as it may make requests to the channel
+	if name == "" {
+		describer, isDescriber := courier.GetHandler(c.ChannelType()).(courier.URNDescriber)
+		if isDescriber {
+			atts, err := describer.DescribeURN(ctx, c, urn)
+			if err != nil {
+				logrus.WithField("channel_uuid", c.UUID()).WithField("channel_type", c.ChannelType()).WithField("urn", urn).WithError(err).Error("unable to describe URN")
+			} else {
+				name = atts["name"]
+			}
+		}
+	}
+	if utf8.RuneCountInString(name) > 128 {
+		name = string([]rune(name)[:127])
+	}
+
+	created := &contact{Name_: name}
+	created.UUID_, _ = courier.NewContactUUID(utils.NewUUID())
+
+	tx, err := b.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := tx.ExecContext(ctx, insertContactSQL, created.UUID_, created.Name_, time.Now().UTC())
+	if err != nil {
+		tx.Rollback()
+		return nil, errors.Wrapf(err, "error inserting contact")
+	}
+	created.ID_, err = result.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return nil, errors.Wrapf(err, "error getting id of inserted contact")
+	}
+
+	_, err = tx.ExecContext(ctx, upsertContactURNSQL, created.ID_, c.UUID(), identity, urn.Scheme(), urn.Path(), auth)
+	if err != nil {
+		tx.Rollback()
+		return nil, errors.Wrapf(err, "error inserting contact urn")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	metrics.Count("new_contact", nil, 1)
+	return created, nil
+}
+
+// addURNToContact adds the passed in URN to the passed in contact, taking it from any other contact which has it
+func addURNToContact(ctx context.Context, b *backend, c *static.Channel, ct *contact, urn urns.URN) error {
+	_, err := b.db.ExecContext(ctx, upsertContactURNSQL, ct.ID_, c.UUID(), urn.Identity().String(), urn.Scheme(), urn.Path(), "")
+	return err
+}
+
+const removeURNFromContactSQL = `
+UPDATE contact_urns SET contact_id = NULL WHERE contact_id = ? AND identity = ?
+`
+
+// removeURNFromContact removes the passed in URN from the passed in contact
+func removeURNFromContact(ctx context.Context, b *backend, ct *contact, urn urns.URN) error {
+	_, err := b.db.ExecContext(ctx, removeURNFromContactSQL, ct.ID_, urn.Identity().String())
+	return err
+}
+
+// contact is our struct for a contact in the database
+type contact struct {
+	ID_   int64               `db:"id"`
+	UUID_ courier.ContactUUID `db:"uuid"`
+	Name_ string              `db:"name"`
+}
+
+// UUID returns the UUID for this contact
+func (c *contact) UUID() courier.ContactUUID { return c.UUID_ }
@@ -0,0 +1,53 @@
+package standalone
+
+import (
+	"context"
+	"time"
+
+	"github.com/nyaruka/courier"
+)
+
+const insertLogSQL = `
+INSERT INTO
+	channel_logs(channel_uuid, msg_id, description, is_error, method, url, request, response, response_status, request_time, created_on)
+	VALUES(:channel_uuid, :msg_id, :description, :is_error, :method, :url, :request, :response, :response_status, :request_time, :created_on)
+`
+
+// channelLog is our db struct for channel logs
+type channelLog struct {
+	ChannelUUID    courier.ChannelUUID `db:"channel_uuid"`
+	MsgID          courier.MsgID       `db:"msg_id"`
+	Description    string              `db:"description"`
+	IsError        bool                `db:"is_error"`
+	Method         string              `db:"method"`
+	URL            string              `db:"url"`
+	Request        string              `db:"request"`
+	Response       string              `db:"response"`
+	ResponseStatus int                 `db:"response_status"`
+	RequestTime    int                 `db:"request_time"`
+	CreatedOn      time.Time           `db:"created_on"`
+}
+
+// writeChannelLog writes the passed in channel log to our db
+func writeChannelLog(ctx context.Context, b *backend, log *courier.ChannelLog) error {
+	// if we have an error, append to to our response
+	response := log.Response
+	if log.Error != "" {
+		response += "\n\nError: " + log.Error
+	}
+
+	_, err := b.db.NamedExecContext(ctx, insertLogSQL, &channelLog{
+		ChannelUUID:    log.Channel.UUID(),
+		MsgID:          log.MsgID,
+		Description:    log.Description,
+		IsError:        log.Error != "",
+		Method:         log.Method,
+		URL:            log.URL,
+		Request:        log.Request,
+		Response:       response,
+		ResponseStatus: log.StatusCode,
+		RequestTime:    int(log.Elapsed / time.Millisecond),
+		CreatedOn:      log.CreatedOn.UTC(),
+	})
+	return err
+}
@@ -0,0 +1,290 @@
+package standalone
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/backends/static"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// MsgDirection is the direction of a message
+type MsgDirection string
+
+// Possible values for MsgDirection
+const (
+	MsgIncoming MsgDirection = "I"
+	MsgOutgoing MsgDirection = "O"
+)
+
+// newMsg creates a new msg with the passed in parameters
+func newMsg(direction MsgDirection, c *static.Channel, urn urns.URN, text string) *msg {
+	now := time.Now().UTC()
+
+	return &msg{
+		UUID_:        courier.NewMsgUUID(),
+		ChannelUUID_: c.UUID(),
+		Direction_:   direction,
+		Status_:      courier.MsgPending,
+		URN_:         urn,
+		Text_:        text,
+		CreatedOn_:   now,
+		ModifiedOn_:  now,
+
+		channel: c,
+	}
+}
+
+const insertMsgSQL = `
+INSERT INTO
+	msgs(uuid, channel_uuid, contact_id, urn, direction, status, high_priority, text, attachments, quick_replies, metadata,
+	     external_id, created_on, modified_on, sent_on)
+	VALUES(:uuid, :channel_uuid, :contact_id, :urn, :direction, :status, :high_priority, :text, :attachments, :quick_replies, :metadata,
+	       :external_id, :created_on, :modified_on, :sent_on)
+`
+
+// writeMsg writes the passed in incoming msg to our db, creating its contact if necessary
+func writeMsg(ctx context.Context, b *backend, m *msg) error {
+	// this msg has already been written (we received it twice), we are a no op
+	if m.alreadyWritten {
+		return nil
+	}
+
+	contact, err := contactForURN(ctx, b, m.channel, m.URN_, m.URNAuth_, m.ContactName_)
+	if err != nil {
+		return errors.Wrapf(err, "error getting contact for msg")
+	}
+	m.ContactID_ = contact.ID_
+
+	result, err := b.db.NamedExecContext(ctx, insertMsgSQL, m)
+	if err != nil {
+		return errors.Wrapf(err, "error inserting msg")
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return errors.Wrapf(err, "error getting id of inserted msg")
+	}
+	m.ID_ = courier.NewMsgID(id)
+
+	// mark this msg as having been seen
+	b.msgsSeen.write(m.urnFingerprint(), m.UUID_, m.Text_, msgSeenTTL)
+	return nil
+}
+
+const selectOutgoingMsgsSQL = `
+SELECT
+	m.id, m.uuid, m.channel_uuid, m.urn, m.direction, m.status, m.high_priority, m.text, m.attachments, m.quick_replies,
+	COALESCE(m.metadata, '') AS metadata, m.external_id, m.response_to_id, m.response_to_external_id, m.error_count, m.created_on, m.modified_on,
+	COALESCE(u.auth, '') AS urn_auth, COALESCE(c.name, '') AS contact_name
+FROM
+	msgs m
+	LEFT JOIN contact_urns u ON u.identity = m.urn
+	LEFT JOIN contacts c ON c.id = u.contact_id
+WHERE
+	m.direction = 'O' AND (m.status = 'P' OR (m.status = 'E' AND m.next_attempt <= ?) OR (m.status = 'Q' AND ?))
+ORDER BY
+	m.id
+LIMIT
+	1000
+`
+
+const updateMsgQueuedSQL = `
+UPDATE msgs SET status = 'Q', modified_on = ? WHERE id = ? AND status IN ('P', 'E', 'Q')
+`
+
+// queueOutgoingMsgs queues any pending outgoing msgs and any errored msgs whose next attempt is due, if requeue is true
+// then msgs which were already queued are also queued, we do this when starting as our queue is only held in memory
+func queueOutgoingMsgs(ctx context.Context, b *backend, requeue bool) (int, error) {
+	now := time.Now().UTC()
+
+	msgs := make([]*msg, 0)
+	err := b.db.SelectContext(ctx, &msgs, selectOutgoingMsgsSQL, now, requeue)
+	if err != nil {
+		return 0, errors.Wrapf(err, "error selecting outgoing msgs")
+	}
+
+	queued := 0
+	for _, m := range msgs {
+		c, found := b.channels[m.ChannelUUID_]
+		if !found {
+			logrus.WithField("msg_id", m.ID_).WithField("channel_uuid", m.ChannelUUID_).Error("unable to queue msg for unknown channel, failing")
+			_, err = b.db.ExecContext(ctx, `UPDATE msgs SET status = 'F', modified_on = ? WHERE id = ?`, now, m.ID_)
+			if err != nil {
+				return queued, errors.Wrapf(err, "error failing msg")
+			}
+			continue
+		}
+
+		_, err = b.db.ExecContext(ctx, updateMsgQueuedSQL, now, m.ID_)
+		if err != nil {
+			return queued, errors.Wrapf(err, "error marking msg as queued")
+		}
+
+		m.channel = c
+		m.Status_ = courier.MsgQueued
+		m.ModifiedOn_ = now
+		b.outbox.push(m)
+		queued++
+	}
+
+	return queued, nil
+}
+
+// startMsgQueuer starts a goroutine which queues outgoing msgs every second until we are stopped
+func startMsgQueuer(b *backend) {
+	b.waitGroup.Add(1)
+
+	go func() {
+		defer b.waitGroup.Done()
+
+		log := logrus.WithField("comp", "msg queuer")
+		for {
+			select {
+			case <-b.stopChan:
+				log.Info("msg queuer stopped")
+				return
+
+			case <-time.After(queueInterval):
+				ctx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+				queued, err := queueOutgoingMsgs(ctx, b, false)
+				cancel()
+
+				if err != nil {
+					log.WithError(err).Error("error queuing outgoing msgs")
+				} else if queued > 0 {
+					log.WithField("queued", queued).Debug("queued outgoing msgs")
+				}
+			}
+		}
+	}()
+}
+
+//-----------------------------------------------------------------------------
+// Our implementation of Msg interface
+//-----------------------------------------------------------------------------
+
+// stringList is a list of strings we store in the db as a JSON array
+type stringList []string
+
+// Value returns the db value, a JSON array
+func (l stringList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal([]string(l))
+	return string(b), err
+}
+
+// Scan scans from the db value, a JSON array
+func (l *stringList) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), (*[]string)(l))
+	case []byte:
+		return json.Unmarshal(v, (*[]string)(l))
+	}
+	return errors.Errorf("unable to scan %T as string list", value)
+}
+
+// msg is our struct to represent msgs both in our JSON and db representations
+type msg struct {
+	ID_                   courier.MsgID          `json:"id"                       db:"id"`
+	UUID_                 courier.MsgUUID        `json:"uuid"                     db:"uuid"`
+	ChannelUUID_          courier.ChannelUUID    `json:"channel_uuid"             db:"channel_uuid"`
+	ContactID_            int64                  `json:"contact_id,omitempty"     db:"contact_id"`
+	URN_                  urns.URN               `json:"urn"                      db:"urn"`
+	URNAuth_              string                 `json:"urn_auth,omitempty"       db:"urn_auth"`
+	ContactName_          string                 `json:"contact_name,omitempty"   db:"contact_name"`
+	Direction_            MsgDirection           `json:"direction"                db:"direction"`
+	Status_               courier.MsgStatusValue `json:"status"                   db:"status"`
+	HighPriority_         bool                   `json:"high_priority"            db:"high_priority"`
+	Text_                 string                 `json:"text"                     db:"text"`
+	Attachments_          stringList             `json:"attachments,omitempty"    db:"attachments"`
+	QuickReplies_         stringList             `json:"quick_replies,omitempty"  db:"quick_replies"`
+	Metadata_             json.RawMessage        `json:"metadata,omitempty"       db:"metadata"`
+	ExternalID_           string                 `json:"external_id,omitempty"    db:"external_id"`
+	ResponseToID_         courier.MsgID          `json:"response_to_id,omitempty" db:"response_to_id"`
+	ResponseToExternalID_ string                 `json:"response_to_external_id"  db:"response_to_external_id"`
+	ErrorCount_           int                    `json:"error_count"              db:"error_count"`
+	CreatedOn_            time.Time              `json:"created_on"               db:"created_on"`
+	ModifiedOn_           time.Time              `json:"modified_on"              db:"modified_on"`
+	SentOn_               *time.Time             `json:"sent_on,omitempty"        db:"sent_on"`
+
+	channel        *static.Channel
+	alreadyWritten bool
+}
+
+func (m *msg) ID() courier.MsgID            { return m.ID_ }
+func (m *msg) EventID() int64               { return int64(m.ID_) }
+func (m *msg) UUID() courier.MsgUUID        { return m.UUID_ }
+func (m *msg) Text() string                 { return m.Text_ }
+func (m *msg) Attachments() []string        { return []string(m.Attachments_) }
+func (m *msg) ExternalID() string           { return m.ExternalID_ }
+func (m *msg) URN() urns.URN                { return m.URN_ }
+func (m *msg) URNAuth() string              { return m.URNAuth_ }
+func (m *msg) ContactName() string          { return m.ContactName_ }
+func (m *msg) QuickReplies() []string       { return []string(m.QuickReplies_) }
+func (m *msg) HighPriority() bool           { return m.HighPriority_ }
+func (m *msg) ReceivedOn() *time.Time       { return m.SentOn_ }
+func (m *msg) SentOn() *time.Time           { return m.SentOn_ }
+func (m *msg) ResponseToID() courier.MsgID  { return m.ResponseToID_ }
+func (m *msg) ResponseToExternalID() string { return m.ResponseToExternalID_ }
+
+func (m *msg) Channel() courier.Channel { return m.channel }
+
+// Metadata returns the metadata for this message
+func (m *msg) Metadata() json.RawMessage {
+	if len(m.Metadata_) == 0 {
+		return nil
+	}
+	return m.Metadata_
+}
+
+// urnFingerprint returns a fingerprint for this msg, suitable for figuring out if this is a dupe
+func (m *msg) urnFingerprint() string {
+	return fmt.Sprintf("%s:%s", m.ChannelUUID_, m.URN_.Identity())
+}
+
+// WithContactName can be used to set the contact name on a msg
+func (m *msg) WithContactName(name string) courier.Msg { m.ContactName_ = name; return m }
+
+// WithReceivedOn can be used to set sent_on on a msg in a chained call
+func (m *msg) WithReceivedOn(date time.Time) courier.Msg {
+	date = date.UTC()
+	m.SentOn_ = &date
+	return m
+}
+
+// WithExternalID can be used to set the external id on a msg in a chained call
+func (m *msg) WithExternalID(id string) courier.Msg { m.ExternalID_ = id; return m }
+
+// WithID can be used to set the id on a msg in a chained call
+func (m *msg) WithID(id courier.MsgID) courier.Msg { m.ID_ = id; return m }
+
+// WithUUID can be used to set the id on a msg in a chained call
+func (m *msg) WithUUID(uuid courier.MsgUUID) courier.Msg { m.UUID_ = uuid; return m }
+
+// WithMetadata can be used to add metadata to a Msg
+func (m *msg) WithMetadata(metadata json.RawMessage) courier.Msg { m.Metadata_ = metadata; return m }
+
+// WithAttachment can be used to append to the media urls for a message
+func (m *msg) WithAttachment(url string) courier.Msg {
+	m.Attachments_ = append(m.Attachments_, url)
+	return m
+}
+
+// WithURNAuth can be used to add a URN auth setting to a message
+func (m *msg) WithURNAuth(auth string) courier.Msg {
+	m.URNAuth_ = auth
+	return m
+}
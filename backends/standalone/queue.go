@@ -0,0 +1,266 @@
+package standalone
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/backends/static"
+)
+
+// outbox is our in-process queue of outgoing msgs. Each channel has its own queue which is sent from at no more than
+// the TPS of the channel, using a token bucket like our Redis queues so it can't burst past it across a second, with no more than its max concurrency being sent at once, and with high priority msgs always
+// sent before bulk msgs. We pop from channels in turn so that
+// a channel with a large backlog can't starve the others.
+type outbox struct {
+	mutex  sync.Mutex
+	queues map[courier.ChannelUUID]*channelQueue
+	order  []courier.ChannelUUID
+	next   int
+	paused map[courier.ChannelUUID]bool
+
+	// used to get the current time, overridden in tests
+	now func() time.Time
+}
+
+// channelQueue is the outgoing queue of a single channel
+type channelQueue struct {
+	uuid           courier.ChannelUUID
+	tps            int
+	burst          float64
+	maxConcurrency int
+	high           []*msg
+	bulk           []*msg
+	workers        int
+
+	// the tokens in our bucket when it was last updated
+	tokens  float64
+	updated time.Time
+}
+
+// queueInfo describes the state of a single channel's queue
+type queueInfo struct {
+	ChannelUUID courier.ChannelUUID
+	TPS         int
+	Workers     int
+	Paused      bool
+	Throttled   bool
+	Saturated   bool
+	Size        int
+	BulkSize    int
+}
+
+func newOutbox() *outbox {
+	return &outbox{
+		queues: make(map[courier.ChannelUUID]*channelQueue),
+		paused: make(map[courier.ChannelUUID]bool),
+		now:    time.Now,
+	}
+}
+
+// queueFor returns the queue for the passed in channel, creating it if it doesn't exist, callers must hold our lock
+func (o *outbox) queueFor(c *static.Channel) *channelQueue {
+	q, found := o.queues[c.UUID()]
+	if !found {
+		limits := courier.GetSendLimits(c)
+
+		// our burst defaults to our TPS, and we can always send at least one msg
+		burst := limits.Burst
+		if burst == 0 {
+			burst = c.TPS()
+		}
+
+		q = &channelQueue{uuid: c.UUID(), tps: c.TPS(), burst: math.Max(float64(burst), 1), maxConcurrency: limits.MaxConcurrency}
+		o.queues[c.UUID()] = q
+		o.order = append(o.order, c.UUID())
+	}
+	return q
+}
+
+// push adds the passed in msg to the end of the queue for its channel
+func (o *outbox) push(m *msg) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	q := o.queueFor(m.channel)
+	if m.HighPriority_ {
+		q.high = append(q.high, m)
+	} else {
+		q.bulk = append(q.bulk, m)
+	}
+}
+
+// pop returns the next msg which can be sent without exceeding the TPS or max concurrency of its channel, or nil if
+// there is none
+func (o *outbox) pop() *msg {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	now := o.now()
+
+	for i := 0; i < len(o.order); i++ {
+		idx := (o.next + i) % len(o.order)
+		q := o.queues[o.order[idx]]
+
+		if o.paused[q.uuid] || q.size() == 0 {
+			continue
+		}
+
+		// skip channels whose bucket doesn't have a token for us
+		tokens := q.bucketTokens(now)
+		if q.tps > 0 && tokens < 1 {
+			continue
+		}
+
+		// skip channels which are already sending as many msgs as they can at once
+		if q.maxConcurrency > 0 && q.workers >= q.maxConcurrency {
+			continue
+		}
+
+		var m *msg
+		if len(q.high) > 0 {
+			m, q.high = q.high[0], q.high[1:]
+		} else {
+			m, q.bulk = q.bulk[0], q.bulk[1:]
+		}
+
+		if q.tps > 0 {
+			q.tokens = tokens - 1
+			q.updated = now
+		}
+		q.workers++
+
+		// start with the next channel on our next pop
+		o.next = idx + 1
+		return m
+	}
+
+	return nil
+}
+
+// complete marks a msg popped from the queue of the passed in channel as having been dealt with
+func (o *outbox) complete(channelUUID courier.ChannelUUID) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	q, found := o.queues[channelUUID]
+	if found && q.workers > 0 {
+		q.workers--
+	}
+}
+
+// peek returns up to count msgs from the head of the queue for the passed in channel
+func (o *outbox) peek(channelUUID courier.ChannelUUID, count int) []*msg {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	msgs := make([]*msg, 0, count)
+	q, found := o.queues[channelUUID]
+	if !found {
+		return msgs
+	}
+
+	for _, m := range append(q.high, q.bulk...) {
+		if len(msgs) == count {
+			break
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs
+}
+
+// purge removes all msgs from the queue for the passed in channel, returning them
+func (o *outbox) purge(channelUUID courier.ChannelUUID) []*msg {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	q, found := o.queues[channelUUID]
+	if !found {
+		return nil
+	}
+
+	msgs := append(q.high, q.bulk...)
+	q.high = nil
+	q.bulk = nil
+	return msgs
+}
+
+// pause stops msgs being popped from the queue for the passed in channel
+func (o *outbox) pause(channelUUID courier.ChannelUUID) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.paused[channelUUID] = true
+}
+
+// resume resumes popping msgs from the queue for the passed in channel
+func (o *outbox) resume(channelUUID courier.ChannelUUID) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	delete(o.paused, channelUUID)
+}
+
+// isPaused returns whether the queue for the passed in channel is paused
+func (o *outbox) isPaused(channelUUID courier.ChannelUUID) bool {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	return o.paused[channelUUID]
+}
+
+// pausedChannels returns the UUIDs of all paused channels, sorted
+func (o *outbox) pausedChannels() []courier.ChannelUUID {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	uuids := make([]courier.ChannelUUID, 0, len(o.paused))
+	for uuid := range o.paused {
+		uuids = append(uuids, uuid)
+	}
+	sort.Slice(uuids, func(i, j int) bool { return uuids[i].String() < uuids[j].String() })
+	return uuids
+}
+
+// list returns information on every queue which has msgs waiting or being sent, sorted by channel UUID
+func (o *outbox) list() []*queueInfo {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	now := o.now()
+	infos := make([]*queueInfo, 0, len(o.queues))
+	for _, q := range o.queues {
+		if q.size() == 0 && q.workers == 0 {
+			continue
+		}
+
+		infos = append(infos, &queueInfo{
+			ChannelUUID: q.uuid,
+			TPS:         q.tps,
+			Workers:     q.workers,
+			Paused:      o.paused[q.uuid],
+			Throttled:   q.tps > 0 && q.bucketTokens(now) < 1,
+			Saturated:   q.maxConcurrency > 0 && q.workers >= q.maxConcurrency,
+			Size:        len(q.high),
+			BulkSize:    len(q.bulk),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ChannelUUID.String() < infos[j].ChannelUUID.String() })
+	return infos
+}
+
+// bucketTokens returns the tokens in our bucket at the passed in time, it holds up to our burst in tokens and refills
+// smoothly at our TPS
+func (q *channelQueue) bucketTokens(now time.Time) float64 {
+	if q.updated.IsZero() {
+		return q.burst
+	}
+	return math.Min(q.burst, q.tokens+now.Sub(q.updated).Seconds()*float64(q.tps))
+}
+
+// size returns the total number of msgs in this queue
+func (q *channelQueue) size() int {
+	return len(q.high) + len(q.bulk)
+}
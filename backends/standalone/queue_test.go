@@ -0,0 +1,130 @@
+package standalone
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/backends/static"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutbox(t *testing.T) {
+	now := time.Date(2019, 4, 1, 10, 0, 0, 0, time.UTC)
+	o := newOutbox()
+	o.now = func() time.Time { return now }
+
+	uuid1, _ := courier.NewChannelUUID("dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	uuid2, _ := courier.NewChannelUUID("53e5aafa-8155-449d-9009-fcb30d54bd26")
+	channel1 := &static.Channel{UUID_: uuid1, ChannelType_: "EX", TPS_: 2}
+	channel2 := &static.Channel{UUID_: uuid2, ChannelType_: "EX"}
+
+	newTestMsg := func(c *static.Channel, id int64, highPriority bool) *msg {
+		return &msg{ID_: courier.NewMsgID(id), ChannelUUID_: c.UUID(), HighPriority_: highPriority, channel: c}
+	}
+
+	// nothing to pop yet
+	assert.Nil(t, o.pop())
+
+	o.push(newTestMsg(channel1, 1, false))
+	o.push(newTestMsg(channel1, 2, false))
+	o.push(newTestMsg(channel1, 3, true))
+	o.push(newTestMsg(channel1, 4, false))
+	o.push(newTestMsg(channel2, 5, false))
+	o.push(newTestMsg(channel2, 6, false))
+
+	peeked := o.peek(uuid1, 2)
+	assert.Equal(t, 2, len(peeked))
+	assert.Equal(t, courier.NewMsgID(3), peeked[0].ID_)
+	assert.Equal(t, courier.NewMsgID(1), peeked[1].ID_)
+
+	infos := o.list()
+	assert.Equal(t, 2, len(infos))
+	assert.Equal(t, uuid2, infos[0].ChannelUUID)
+	assert.Equal(t, 2, infos[0].BulkSize)
+	assert.Equal(t, uuid1, infos[1].ChannelUUID)
+	assert.Equal(t, 1, infos[1].Size)
+	assert.Equal(t, 3, infos[1].BulkSize)
+
+	// we alternate between channels, with high priority msgs first, until channel 1 hits its TPS
+	assert.Equal(t, courier.NewMsgID(3), o.pop().ID_)
+	assert.Equal(t, courier.NewMsgID(5), o.pop().ID_)
+	assert.Equal(t, courier.NewMsgID(1), o.pop().ID_)
+	assert.Equal(t, courier.NewMsgID(6), o.pop().ID_)
+	assert.Nil(t, o.pop())
+
+	infos = o.list()
+	assert.Equal(t, 2, len(infos))
+	assert.Equal(t, 2, infos[0].Workers)
+	assert.True(t, infos[1].Throttled)
+
+	// completing msgs frees up their workers, channel 2 is now empty and no longer listed
+	o.complete(uuid2)
+	o.complete(uuid2)
+	infos = o.list()
+	assert.Equal(t, 1, len(infos))
+	assert.Equal(t, uuid1, infos[0].ChannelUUID)
+
+	// a second later channel 1 can send again
+	now = now.Add(time.Second)
+	assert.Equal(t, courier.NewMsgID(2), o.pop().ID_)
+
+	// unless it is paused
+	o.pause(uuid1)
+	assert.True(t, o.isPaused(uuid1))
+	assert.Equal(t, []courier.ChannelUUID{uuid1}, o.pausedChannels())
+	assert.Nil(t, o.pop())
+
+	o.resume(uuid1)
+	assert.False(t, o.isPaused(uuid1))
+	assert.Equal(t, courier.NewMsgID(4), o.pop().ID_)
+	assert.Nil(t, o.pop())
+
+	// purging removes everything queued
+	o.push(newTestMsg(channel1, 7, false))
+	o.push(newTestMsg(channel1, 8, true))
+	purged := o.purge(uuid1)
+	assert.Equal(t, 2, len(purged))
+	assert.Equal(t, 0, len(o.peek(uuid1, 10)))
+	assert.Equal(t, 0, len(o.purge(uuid2)))
+
+	// channels with a max concurrency are only popped from while they have fewer workers than that
+	uuid3, _ := courier.NewChannelUUID("8eb23e93-5ecb-45ba-b726-3b064e0c568c")
+	channel3 := &static.Channel{UUID_: uuid3, ChannelType_: "EX", Config_: map[string]interface{}{courier.ConfigMaxConcurrency: float64(1)}}
+	o.push(newTestMsg(channel3, 9, false))
+	o.push(newTestMsg(channel3, 10, false))
+
+	assert.Equal(t, courier.NewMsgID(9), o.pop().ID_)
+	assert.Nil(t, o.pop())
+
+	infos = o.list()
+	assert.Equal(t, uuid3, infos[0].ChannelUUID)
+	assert.True(t, infos[0].Saturated)
+
+	o.complete(uuid3)
+	assert.Equal(t, courier.NewMsgID(10), o.pop().ID_)
+
+	// channels can burst, then are limited to their TPS even across a second boundary
+	uuid4, _ := courier.NewChannelUUID("ebc9e7a8-bfdd-4bde-96ea-ea0a2bb3ef64")
+	channel4 := &static.Channel{UUID_: uuid4, ChannelType_: "EX", TPS_: 2, Config_: map[string]interface{}{courier.ConfigTPSBurst: float64(3)}}
+	for i := int64(11); i <= 16; i++ {
+		o.push(newTestMsg(channel4, i, false))
+	}
+
+	now = time.Date(2019, 4, 1, 10, 0, 10, 900000000, time.UTC)
+	assert.Equal(t, courier.NewMsgID(11), o.pop().ID_)
+	assert.Equal(t, courier.NewMsgID(12), o.pop().ID_)
+	assert.Equal(t, courier.NewMsgID(13), o.pop().ID_)
+	assert.Nil(t, o.pop())
+
+	// our next token is half a second away, in the next second
+	now = now.Add(200 * time.Millisecond)
+	assert.Nil(t, o.pop())
+	infos = o.list()
+	assert.Equal(t, uuid4, infos[2].ChannelUUID)
+	assert.True(t, infos[2].Throttled)
+
+	now = now.Add(300 * time.Millisecond)
+	assert.Equal(t, courier.NewMsgID(14), o.pop().ID_)
+	assert.Nil(t, o.pop())
+}
@@ -0,0 +1,77 @@
+package standalone
+
+// our SQLite schema, created when we start if it doesn't already exist. Outgoing msgs are created by inserting rows into
+// msgs with a direction of 'O' and a status of 'P', these are queued to be sent within a second.
+const schemaSQL = `
+CREATE TABLE IF NOT EXISTS contacts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    uuid TEXT NOT NULL UNIQUE,
+    name TEXT NOT NULL DEFAULT '',
+    created_on DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS contact_urns (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    contact_id INTEGER REFERENCES contacts(id),
+    channel_uuid TEXT,
+    identity TEXT NOT NULL UNIQUE,
+    scheme TEXT NOT NULL,
+    path TEXT NOT NULL,
+    auth TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS msgs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    uuid TEXT NOT NULL,
+    channel_uuid TEXT NOT NULL,
+    contact_id INTEGER REFERENCES contacts(id),
+    urn TEXT NOT NULL,
+    direction TEXT NOT NULL,
+    status TEXT NOT NULL,
+    high_priority BOOLEAN NOT NULL DEFAULT 0,
+    text TEXT NOT NULL DEFAULT '',
+    attachments TEXT NOT NULL DEFAULT '[]',
+    quick_replies TEXT NOT NULL DEFAULT '[]',
+    metadata TEXT,
+    external_id TEXT NOT NULL DEFAULT '',
+    response_to_id INTEGER,
+    response_to_external_id TEXT NOT NULL DEFAULT '',
+    error_count INTEGER NOT NULL DEFAULT 0,
+    error_code TEXT,
+    provider_error_code TEXT,
+    error_description TEXT,
+    next_attempt DATETIME,
+    created_on DATETIME NOT NULL,
+    modified_on DATETIME NOT NULL,
+    sent_on DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS msgs_channel_external_id ON msgs(channel_uuid, external_id);
+CREATE INDEX IF NOT EXISTS msgs_outgoing_status ON msgs(direction, status);
+
+CREATE TABLE IF NOT EXISTS channel_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    channel_uuid TEXT NOT NULL,
+    contact_id INTEGER REFERENCES contacts(id),
+    urn TEXT NOT NULL,
+    event_type TEXT NOT NULL,
+    extra TEXT,
+    occurred_on DATETIME NOT NULL,
+    created_on DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS channel_logs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    channel_uuid TEXT NOT NULL,
+    msg_id INTEGER,
+    description TEXT NOT NULL,
+    is_error BOOLEAN NOT NULL,
+    method TEXT NOT NULL,
+    url TEXT NOT NULL,
+    request TEXT NOT NULL,
+    response TEXT NOT NULL,
+    response_status INTEGER NOT NULL,
+    request_time INTEGER NOT NULL,
+    created_on DATETIME NOT NULL
+);
+`
@@ -0,0 +1,72 @@
+package standalone
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nyaruka/courier"
+)
+
+// how long we remember incoming msgs for so that we can ignore duplicates sent by channels
+const msgSeenTTL = time.Second * 4
+
+// how long we remember the external ids of incoming msgs for
+const externalIDSeenTTL = time.Hour * 24
+
+// seenCache is an in-memory record of the msgs we've recently received, we use it to detect duplicates. As we are
+// a single process we don't need to share this the way the rapidpro backend does in Redis.
+type seenCache struct {
+	mutex   sync.Mutex
+	entries map[string]*seenEntry
+}
+
+type seenEntry struct {
+	uuid    courier.MsgUUID
+	text    string
+	expires time.Time
+}
+
+func newSeenCache() *seenCache {
+	return &seenCache{entries: make(map[string]*seenEntry)}
+}
+
+// check returns the UUID of the msg seen with the passed in key if it had the same text, otherwise NilMsgUUID
+func (c *seenCache) check(key string, text string) courier.MsgUUID {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, found := c.entries[key]
+	if !found || time.Now().After(entry.expires) || entry.text != text {
+		return courier.NilMsgUUID
+	}
+	return entry.uuid
+}
+
+// write records that we've seen the msg with the passed in key, UUID and text
+func (c *seenCache) write(key string, uuid courier.MsgUUID, text string, ttl time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.entries[key] = &seenEntry{uuid: uuid, text: text, expires: time.Now().Add(ttl)}
+}
+
+// clear forgets the msg seen with the passed in key
+func (c *seenCache) clear(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.entries, key)
+}
+
+// trim removes all expired entries
+func (c *seenCache) trim() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package standalone
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// newMsgStatus creates a new msg status for the passed in msg id or external id
+func newMsgStatus(c courier.Channel, id courier.MsgID, externalID string, status courier.MsgStatusValue) *msgStatus {
+	return &msgStatus{
+		ChannelUUID_: c.UUID(),
+		ID_:          id,
+		ExternalID_:  externalID,
+		Status_:      status,
+	}
+}
+
+const selectMsgForIDSQL = `
+SELECT id, status, error_count FROM msgs WHERE id = ? AND channel_uuid = ? AND direction = 'O'
+`
+
+const selectMsgForExternalIDSQL = `
+SELECT id, status, error_count FROM msgs WHERE external_id = ? AND channel_uuid = ? AND direction = 'O' ORDER BY id DESC LIMIT 1
+`
+
+const updateMsgStatusSQL = `
+UPDATE msgs SET
+	status = :status,
+	error_count = :error_count,
+	next_attempt = :next_attempt,
+	sent_on = CASE WHEN :status = 'W' THEN :modified_on ELSE sent_on END,
+	external_id = CASE WHEN :external_id != '' THEN :external_id ELSE external_id END,
+	error_code = CASE WHEN :error_code != '' THEN :error_code WHEN :status IN ('W', 'S', 'D', 'R') THEN NULL ELSE error_code END,
+	provider_error_code = CASE WHEN :error_code != '' THEN NULLIF(:provider_error_code, '') WHEN :status IN ('W', 'S', 'D', 'R') THEN NULL ELSE provider_error_code END,
+	error_description = CASE WHEN :error_code != '' THEN NULLIF(:error_description, '') WHEN :status IN ('W', 'S', 'D', 'R') THEN NULL ELSE error_description END,
+	modified_on = :modified_on
+WHERE
+	id = :id
+`
+
+// the values we update a msg with when writing a status for it
+type statusUpdate struct {
+	ID                courier.MsgID          `db:"id"`
+	Status            courier.MsgStatusValue `db:"status"`
+	ErrorCount        int                    `db:"error_count"`
+	NextAttempt       *time.Time             `db:"next_attempt"`
+	ExternalID        string                 `db:"external_id"`
+	ErrorCode         courier.ErrorCode      `db:"error_code"`
+	ProviderErrorCode string                 `db:"provider_error_code"`
+	ErrorDescription  string                 `db:"error_description"`
+	ModifiedOn        time.Time              `db:"modified_on"`
+}
+
+// writeMsgStatus writes the passed in status to our db. Errored msgs are retried according to the retry policy of
+// their channel and statuses which would regress a msg, usually callbacks arriving out of order, are ignored.
+func writeMsgStatus(ctx context.Context, b *backend, status *msgStatus) error {
+	c, found := b.channels[status.ChannelUUID_]
+	if !found {
+		return courier.ErrChannelNotFound
+	}
+
+	tx, err := b.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	current := &statusUpdate{}
+	if status.ID_ != courier.NilMsgID {
+		err = tx.GetContext(ctx, current, selectMsgForIDSQL, status.ID_, status.ChannelUUID_)
+	} else {
+		err = tx.GetContext(ctx, current, selectMsgForExternalIDSQL, status.ExternalID_, status.ChannelUUID_)
+	}
+	if err == sql.ErrNoRows {
+		return courier.ErrMsgNotFound
+	}
+	if err != nil {
+		return errors.Wrapf(err, "error looking up msg for status")
+	}
+
+	if !courier.IsValidMsgStatusTransition(current.Status, status.Status_) {
+		logrus.WithField("msg_id", current.ID).WithField("from", current.Status).WithField("to", status.Status_).Warn("rejected msg status regression")
+		return nil
+	}
+
+	now := time.Now().UTC()
+	update := &statusUpdate{
+		ID:         current.ID,
+		Status:     status.Status_,
+		ErrorCount: current.ErrorCount,
+		ExternalID: status.ExternalID_,
+		ModifiedOn: now,
+	}
+	if status.sendError != nil {
+		update.ErrorCode = status.sendError.Code
+		update.ProviderErrorCode = status.sendError.ProviderCode
+		update.ErrorDescription = status.sendError.Description
+	}
+
+	// errored msgs are either retried later or failed if they have used up their attempts
+	if status.Status_ == courier.MsgErrored {
+		update.ErrorCount++
+
+		delay, retry := courier.GetRetryPolicy(c).NextDelay(update.ErrorCount)
+		if !retry || current.Status == courier.MsgFailed {
+			update.Status = courier.MsgFailed
+		} else {
+			if status.sendError != nil && status.sendError.RetryAfter > 0 {
+				delay = status.sendError.RetryAfter
+			}
+			nextAttempt := now.Add(delay)
+			update.NextAttempt = &nextAttempt
+		}
+	}
+
+	_, err = tx.NamedExecContext(ctx, updateMsgStatusSQL, update)
+	if err != nil {
+		return errors.Wrapf(err, "error updating msg status")
+	}
+
+	return tx.Commit()
+}
+
+//-----------------------------------------------------------------------------
+// MsgStatus implementation
+//-----------------------------------------------------------------------------
+
+// msgStatus represents a status update on a message
+type msgStatus struct {
+	ChannelUUID_ courier.ChannelUUID    `json:"channel_uuid"`
+	ID_          courier.MsgID          `json:"msg_id,omitempty"`
+	ExternalID_  string                 `json:"external_id,omitempty"`
+	Status_      courier.MsgStatusValue `json:"status"`
+
+	sendError *courier.SendError
+	logs      []*courier.ChannelLog
+}
+
+func (s *msgStatus) EventID() int64 { return int64(s.ID_) }
+
+func (s *msgStatus) ChannelUUID() courier.ChannelUUID { return s.ChannelUUID_ }
+func (s *msgStatus) ID() courier.MsgID                { return s.ID_ }
+
+func (s *msgStatus) ExternalID() string      { return s.ExternalID_ }
+func (s *msgStatus) SetExternalID(id string) { s.ExternalID_ = id }
+
+func (s *msgStatus) Logs() []*courier.ChannelLog    { return s.logs }
+func (s *msgStatus) AddLog(log *courier.ChannelLog) { s.logs = append(s.logs, log) }
+
+func (s *msgStatus) Status() courier.MsgStatusValue          { return s.Status_ }
+func (s *msgStatus) SetStatus(status courier.MsgStatusValue) { s.Status_ = status }
+
+func (s *msgStatus) SendError() *courier.SendError       { return s.sendError }
+func (s *msgStatus) SetSendError(err *courier.SendError) { s.sendError = err }
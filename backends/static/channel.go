@@ -0,0 +1,153 @@
+// Package static provides channels which are loaded from a JSON file, for backends which have no database of channels
+package static
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strconv"
+
+	"github.com/nyaruka/courier"
+	"github.com/pkg/errors"
+)
+
+// channelsFile is the format of the JSON file we load our channels from, see the README for an example
+type channelsFile struct {
+	Channels []*Channel `json:"channels"`
+}
+
+// LoadChannels loads the channels in the passed in JSON file
+func LoadChannels(filename string) (map[courier.ChannelUUID]*Channel, error) {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading channels file")
+	}
+
+	file := &channelsFile{}
+	err = json.Unmarshal(contents, file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing channels file")
+	}
+
+	channels := make(map[courier.ChannelUUID]*Channel, len(file.Channels))
+	for i, c := range file.Channels {
+		if c.UUID_ == courier.NilChannelUUID {
+			return nil, errors.Errorf("channel %d in channels file has no uuid", i)
+		}
+		if c.ChannelType_ == courier.AnyChannelType {
+			return nil, errors.Errorf("channel %s in channels file has no channel_type", c.UUID_)
+		}
+		if _, found := channels[c.UUID_]; found {
+			return nil, errors.Errorf("channel %s is in channels file more than once", c.UUID_)
+		}
+		if len(c.Schemes_) == 0 {
+			c.Schemes_ = []string{"tel"}
+		}
+		channels[c.UUID_] = c
+	}
+
+	return channels, nil
+}
+
+//-----------------------------------------------------------------------------
+// Channel implementation
+//-----------------------------------------------------------------------------
+
+// Channel is a channel loaded from a channels file
+type Channel struct {
+	UUID_        courier.ChannelUUID    `json:"uuid"`
+	ChannelType_ courier.ChannelType    `json:"channel_type"`
+	Name_        string                 `json:"name"`
+	Address_     string                 `json:"address"`
+	Country_     string                 `json:"country"`
+	Schemes_     []string               `json:"schemes"`
+	TPS_         int                    `json:"tps"`
+	Config_      map[string]interface{} `json:"config"`
+	OrgConfig_   map[string]interface{} `json:"org_config"`
+}
+
+// ChannelType returns the type of this channel
+func (c *Channel) ChannelType() courier.ChannelType { return c.ChannelType_ }
+
+// Name returns the name of this channel
+func (c *Channel) Name() string { return c.Name_ }
+
+// Schemes returns the schemes this channels supports
+func (c *Channel) Schemes() []string { return c.Schemes_ }
+
+// UUID returns the UUID of this channel
+func (c *Channel) UUID() courier.ChannelUUID { return c.UUID_ }
+
+// Address returns the address of this channel
+func (c *Channel) Address() string { return c.Address_ }
+
+// Country returns the country code for this channel if any
+func (c *Channel) Country() string { return c.Country_ }
+
+// TPS returns the maximum number of msgs this channel can send per second, 0 means no limit
+func (c *Channel) TPS() int { return c.TPS_ }
+
+// IsScheme returns whether this channel serves only the passed in scheme
+func (c *Channel) IsScheme(scheme string) bool {
+	return len(c.Schemes_) == 1 && c.Schemes_[0] == scheme
+}
+
+// ConfigForKey returns the config value for the passed in key, or defaultValue if it isn't found
+func (c *Channel) ConfigForKey(key string, defaultValue interface{}) interface{} {
+	value, found := c.Config_[key]
+	if !found {
+		return defaultValue
+	}
+	return value
+}
+
+// OrgConfigForKey returns the org config value for the passed in key, or defaultValue if it isn't found
+func (c *Channel) OrgConfigForKey(key string, defaultValue interface{}) interface{} {
+	value, found := c.OrgConfig_[key]
+	if !found {
+		return defaultValue
+	}
+	return value
+}
+
+// CallbackDomain returns the callback domain to use for this channel
+func (c *Channel) CallbackDomain(fallbackDomain string) string {
+	return c.StringConfigForKey(courier.ConfigCallbackDomain, fallbackDomain)
+}
+
+// StringConfigForKey returns the config value for the passed in key, or defaultValue if it isn't found
+func (c *Channel) StringConfigForKey(key string, defaultValue string) string {
+	str, isStr := c.ConfigForKey(key, defaultValue).(string)
+	if !isStr {
+		return defaultValue
+	}
+	return str
+}
+
+// BoolConfigForKey returns the config value for the passed in key, or defaultValue if it isn't found
+func (c *Channel) BoolConfigForKey(key string, defaultValue bool) bool {
+	b, isBool := c.ConfigForKey(key, defaultValue).(bool)
+	if !isBool {
+		return defaultValue
+	}
+	return b
+}
+
+// IntConfigForKey returns the config value for the passed in key
+func (c *Channel) IntConfigForKey(key string, defaultValue int) int {
+	val := c.ConfigForKey(key, defaultValue)
+
+	// golang unmarshals number literals in JSON into float64s by default
+	f, isFloat := val.(float64)
+	if isFloat {
+		return int(f)
+	}
+
+	str, isStr := val.(string)
+	if isStr {
+		i, err := strconv.Atoi(str)
+		if err == nil {
+			return i
+		}
+	}
+	return defaultValue
+}
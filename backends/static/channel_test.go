@@ -0,0 +1,39 @@
+package static
+
+import (
+	"testing"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadChannels(t *testing.T) {
+	channels, err := LoadChannels("testdata/channels.json")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(channels))
+
+	knUUID, _ := courier.NewChannelUUID("dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	kn := channels[knUUID]
+	assert.Equal(t, courier.ChannelType("KN"), kn.ChannelType())
+	assert.Equal(t, "Test Channel", kn.Name())
+	assert.Equal(t, "2500", kn.Address())
+	assert.Equal(t, "RW", kn.Country())
+	assert.Equal(t, []string{"tel"}, kn.Schemes())
+	assert.Equal(t, 10, kn.TPS())
+	assert.Equal(t, "courier.example.com", kn.CallbackDomain("localhost"))
+	assert.Equal(t, 320, kn.IntConfigForKey(courier.ConfigMaxLength, 160))
+	assert.Equal(t, "default", kn.StringConfigForKey(courier.ConfigAPIKey, "default"))
+
+	fbUUID, _ := courier.NewChannelUUID("53e5aafa-8155-449d-9009-fcb30d54bd26")
+	fb := channels[fbUUID]
+	assert.True(t, fb.IsScheme(urns.FacebookScheme))
+	assert.Equal(t, "localhost", fb.CallbackDomain("localhost"))
+	assert.Equal(t, "sesame", fb.StringConfigForKey(courier.ConfigAuthToken, ""))
+
+	_, err = LoadChannels("testdata/missing.json")
+	assert.Error(t, err)
+
+	_, err = LoadChannels("testdata/duplicate.json")
+	assert.EqualError(t, err, "channel dbc126ed-66bc-4e28-b67b-81dc3327c95d is in channels file more than once")
+}
@@ -30,8 +30,32 @@ type Value interface {
 // ErrorCallback lets callers get a callback when a value fails to be committed
 type ErrorCallback func(err error, value Value)
 
+// AppliedCallback lets callers get a callback when a value with a row id is returned by the statement which commits it
+type AppliedCallback func(value Value)
+
+// SkippedCallback lets callers get a callback when a value with a row id isn't returned by the statement which commits
+// it, ex: an update whose conditions didn't match its row
+type SkippedCallback func(value Value)
+
+// SQLFunc lets callers decide the statement values are committed with each time a batch is committed
+type SQLFunc func() string
+
 // NewCommitter creates a new committer that will commit items in batches as quickly as possible.
 func NewCommitter(label string, db *sqlx.DB, sql string, timeout time.Duration, wg *sync.WaitGroup, callback ErrorCallback) Committer {
+	return newCommitter(label, db, func() string { return sql }, timeout, wg, callback)
+}
+
+// NewUpdateCommitter creates a new committer for updates which return the id of each row they update, values whose
+// row was updated are passed to the applied callback and those whose row wasn't to the skipped callback. The statement
+// is asked for each batch, so callers can change it while we are running.
+func NewUpdateCommitter(label string, db *sqlx.DB, sql SQLFunc, timeout time.Duration, wg *sync.WaitGroup, callback ErrorCallback, applied AppliedCallback, skipped SkippedCallback) Committer {
+	c := newCommitter(label, db, sql, timeout, wg, callback)
+	c.applied = applied
+	c.skipped = skipped
+	return c
+}
+
+func newCommitter(label string, db *sqlx.DB, sql SQLFunc, timeout time.Duration, wg *sync.WaitGroup, callback ErrorCallback) *committer {
 	return &committer{
 		db:       db,
 		label:    label,
@@ -133,16 +157,22 @@ func (c *committer) flush(size int) bool {
 		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
 		defer cancel()
 
-		err := batchSQL(ctx, c.label, c.db, c.sql, batch)
+		sql := c.sql()
+		returned, err := batchSQL(ctx, c.label, c.db, sql, batch)
+		if err == nil {
+			c.checkReturned(batch, returned)
+		}
 
 		// if we received an error, try again one at a time (in case it is one value hanging us up)
 		if err != nil {
 			for _, v := range batch {
-				err = batchSQL(ctx, c.label, c.db, c.sql, []interface{}{v})
+				returned, err = batchSQL(ctx, c.label, c.db, sql, []interface{}{v})
 				if err != nil {
 					if c.callback != nil {
 						c.callback(errors.Wrapf(err, "%s: error comitting value", c.label), v.(Value))
 					}
+				} else {
+					c.checkReturned([]interface{}{v}, returned)
 				}
 			}
 		}
@@ -154,26 +184,81 @@ func (c *committer) flush(size int) bool {
 	return true
 }
 
+// checkReturned calls our applied callback for any of the passed in values whose row id was returned, and our skipped
+// callback for those whose row id wasn't
+func (c *committer) checkReturned(batch []interface{}, returned map[string]bool) {
+	for _, v := range batch {
+		id := v.(Value).RowID()
+		if id == "" {
+			continue
+		}
+		if returned[id] {
+			if c.applied != nil {
+				c.applied(v.(Value))
+			}
+		} else if c.skipped != nil {
+			c.skipped(v.(Value))
+		}
+	}
+}
+
 type committer struct {
 	db       *sqlx.DB
 	label    string
-	sql      string
+	sql      SQLFunc
 	timeout  time.Duration
 	callback ErrorCallback
+	applied  AppliedCallback
+	skipped  SkippedCallback
 
 	wg     *sync.WaitGroup
 	stop   chan bool
 	buffer chan Value
 }
 
-func batchSQL(ctx context.Context, label string, db *sqlx.DB, sql string, vs []interface{}) error {
+// batchSQL commits the passed in values in a single statement, returning the set of ids returned by it, if any
+func batchSQL(ctx context.Context, label string, db *sqlx.DB, sql string, vs []interface{}) (map[string]bool, error) {
 	// no values, nothing to do
 	if len(vs) == 0 {
-		return nil
+		return nil, nil
 	}
 
 	start := time.Now()
 
+	bulkSQL, args, err := buildBulkSQL(db, sql, vs)
+	if err != nil {
+		return nil, err
+	}
+
+	// insert them all at once
+	rows, err := db.QueryxContext(ctx, bulkSQL, args...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error during bulk insert")
+	}
+	defer rows.Close()
+
+	// read the ids of any rows returned
+	returned := make(map[string]bool, len(vs))
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err == nil {
+			returned[id] = true
+		}
+	}
+
+	// check for any error
+	if rows.Err() != nil {
+		return nil, errors.Wrapf(rows.Err(), "error in row cursor")
+	}
+
+	logrus.WithField("elapsed", time.Since(start)).WithField("rows", len(vs)).Infof("%s bulk sql complete", label)
+
+	return returned, nil
+}
+
+// buildBulkSQL builds a single statement and its arguments for the passed in values, repeating the VALUES clause of
+// the passed in SQL for each value
+func buildBulkSQL(db *sqlx.DB, sql string, vs []interface{}) (string, []interface{}, error) {
 	// this will be our SQL placeholders ($1, $2,..) for values in our final query, built dynamically
 	values := strings.Builder{}
 	values.Grow(7 * len(vs))
@@ -185,13 +270,13 @@ func batchSQL(ctx context.Context, label string, db *sqlx.DB, sql string, vs []i
 	for i, value := range vs {
 		valueSQL, valueArgs, err := sqlx.Named(sql, value)
 		if err != nil {
-			return errors.Wrapf(err, "error converting bulk insert args")
+			return "", nil, errors.Wrapf(err, "error converting bulk insert args")
 		}
 
 		args = append(args, valueArgs...)
 		argValues, err := extractValues(valueSQL)
 		if err != nil {
-			return errors.Wrapf(err, "error extracting values from sql: %s", valueSQL)
+			return "", nil, errors.Wrapf(err, "error extracting values from sql: %s", valueSQL)
 		}
 
 		// append to our global values, adding comma if necessary
@@ -203,30 +288,10 @@ func batchSQL(ctx context.Context, label string, db *sqlx.DB, sql string, vs []i
 
 	valuesSQL, err := extractValues(sql)
 	if err != nil {
-		return errors.Wrapf(err, "error extracting values from sql: %s", sql)
-	}
-
-	bulkInsert := db.Rebind(strings.Replace(sql, valuesSQL, values.String(), -1))
-
-	// insert them all at once
-	rows, err := db.QueryxContext(ctx, bulkInsert, args...)
-	if err != nil {
-		return errors.Wrapf(err, "error during bulk insert")
-	}
-	defer rows.Close()
-
-	// iterate our remaining rows
-	for rows.Next() {
+		return "", nil, errors.Wrapf(err, "error extracting values from sql: %s", sql)
 	}
 
-	// check for any error
-	if rows.Err() != nil {
-		return errors.Wrapf(rows.Err(), "error in row cursor")
-	}
-
-	logrus.WithField("elapsed", time.Since(start)).WithField("rows", len(vs)).Infof("%s bulk sql complete", label)
-
-	return nil
+	return db.Rebind(strings.Replace(sql, valuesSQL, values.String(), -1)), args, nil
 }
 
 // extractValues extracts the portion between `VALUE(` and `)` in the passed in string. (leaving VALUE but not the parentheses)
@@ -1,6 +1,7 @@
 package batch
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"testing"
@@ -23,6 +24,10 @@ func (l *Label) RowID() string {
 	return ""
 }
 
+func (l *Label) InsertKey() string {
+	return l.Label
+}
+
 func TestBatchInsert(t *testing.T) {
 	db := sqlx.MustConnect("postgres", "postgres://courier@localhost/courier_test?sslmode=disable")
 	db.MustExec("DROP TABLE IF EXISTS labels;")
@@ -108,3 +113,147 @@ func TestBatchUpdate(t *testing.T) {
 	db.Get(&label, "SELECT label FROM labels WHERE id = 3;")
 	assert.Equal(t, "label03", label)
 }
+
+func TestBatchUpdateSkipped(t *testing.T) {
+	db := sqlx.MustConnect("postgres", "postgres://courier@localhost/courier_test?sslmode=disable")
+	db.MustExec("DROP TABLE IF EXISTS labels;")
+	db.MustExec("CREATE TABLE labels(id serial primary key, label text not null unique);")
+	db.MustExec("INSERT INTO labels(label) VALUES('label1'), ('locked'), ('label3');")
+
+	applied := make([]Value, 0)
+	skipped := make([]Value, 0)
+	committer := NewUpdateCommitter("labels", db, func() string {
+		return `
+	UPDATE 
+	  labels 
+	SET 
+	  label = l.status
+	FROM 
+	  (VALUES(:id, :label)) 
+	AS 
+	  l(id, status) 
+	WHERE 
+	  labels.id = l.id::int AND labels.label != 'locked'
+	RETURNING
+	  labels.id
+	`
+	}, time.Millisecond*250, &sync.WaitGroup{}, nil, func(value Value) {
+		applied = append(applied, value)
+	}, func(value Value) {
+		skipped = append(skipped, value)
+	})
+
+	committer.Queue(&Label{1, "label01"})
+	committer.Queue(&Label{2, "label02"})
+	committer.Queue(&Label{3, "label03"})
+	committer.Queue(&Label{4, "label04"})
+
+	committer.Start()
+	defer committer.Stop()
+
+	time.Sleep(time.Second)
+
+	// our locked label and the one which doesn't exist weren't updated
+	assert.Equal(t, []Value{&Label{1, "label01"}, &Label{3, "label03"}}, applied)
+	assert.Equal(t, []Value{&Label{2, "label02"}, &Label{4, "label04"}}, skipped)
+
+	label := ""
+	db.Get(&label, "SELECT label FROM labels WHERE id = 2;")
+	assert.Equal(t, "locked", label)
+
+	db.Get(&label, "SELECT label FROM labels WHERE id = 3;")
+	assert.Equal(t, "label03", label)
+}
+
+func TestInserter(t *testing.T) {
+	db := sqlx.MustConnect("postgres", "postgres://courier@localhost/courier_test?sslmode=disable")
+	db.MustExec("DROP TABLE IF EXISTS labels;")
+	db.MustExec("CREATE TABLE labels(id serial primary key, label text not null unique);")
+
+	inserted := make([]string, 0)
+	wg := &sync.WaitGroup{}
+	inserter := NewInserter("labels", db, "INSERT INTO labels(label) VALUES(:label) RETURNING id, label", "label", time.Second*5, wg, func(values []Value) {
+		for _, v := range values {
+			inserted = append(inserted, v.(*Label).Label)
+		}
+	})
+	inserter.Start()
+
+	// insert from several callers at once, each gets back the id of its row
+	labels := []*Label{{0, "label1"}, {0, "label2"}, {0, "label3"}, {0, "label1"}}
+	errs := make([]error, len(labels))
+	insertWG := &sync.WaitGroup{}
+	for i := range labels {
+		insertWG.Add(1)
+		go func(i int) {
+			defer insertWG.Done()
+			errs[i] = inserter.Insert(labels[i])
+		}(i)
+	}
+	insertWG.Wait()
+	inserter.Stop()
+	wg.Wait()
+
+	count := 0
+	db.Get(&count, "SELECT count(*) FROM labels;")
+	assert.Equal(t, 3, count)
+	assert.Equal(t, 3, len(inserted))
+
+	// one of our duplicates fails, the rest are assigned their ids
+	failed := 0
+	for i, l := range labels {
+		if errs[i] != nil {
+			assert.Contains(t, errs[i].Error(), "labels: error inserting value")
+			assert.Equal(t, 0, l.ID)
+			failed++
+			continue
+		}
+
+		label := ""
+		db.Get(&label, "SELECT label FROM labels WHERE id = $1;", l.ID)
+		assert.Equal(t, l.Label, label)
+	}
+	assert.Equal(t, 1, failed)
+
+	// once stopped, inserts fail rather than blocking
+	err := inserter.Insert(&Label{0, "label4"})
+	assert.EqualError(t, err, "labels: error inserting value: inserter stopped")
+}
+
+func TestInsertSQLMatchesByKey(t *testing.T) {
+	db := sqlx.MustConnect("postgres", "postgres://courier@localhost/courier_test?sslmode=disable")
+	db.MustExec("DROP TABLE IF EXISTS labels;")
+	db.MustExec("CREATE TABLE labels(id serial primary key, label text not null unique);")
+
+	// return our rows in the opposite order to our values, each is still scanned into the right value
+	labels := []KeyedValue{&Label{0, "a"}, &Label{0, "b"}, &Label{0, "c"}}
+	err := insertSQL(context.Background(), db, `
+	WITH inserted AS (INSERT INTO labels(label) VALUES(:label) RETURNING id, label) 
+	SELECT id, label FROM inserted ORDER BY label DESC`, "label", labels)
+	assert.NoError(t, err)
+
+	for _, l := range labels {
+		id := 0
+		db.Get(&id, "SELECT id FROM labels WHERE label = $1;", l.(*Label).Label)
+		assert.Equal(t, id, l.(*Label).ID)
+	}
+
+	// the key column must be returned
+	err = insertSQL(context.Background(), db, "INSERT INTO labels(label) VALUES(:label) RETURNING id", "label", []KeyedValue{&Label{0, "d"}})
+	assert.EqualError(t, err, "error scanning inserted rows: key column label not returned by insert")
+
+	// if scanning fails part way through, values whose rows were already scanned aren't left with rolled back ids
+	labels = []KeyedValue{&Label{0, "e"}, &Label{0, "f"}}
+	err = insertSQL(context.Background(), db, `
+	WITH inserted AS (INSERT INTO labels(label) VALUES(:label) RETURNING id, label) 
+	SELECT id, label FROM (SELECT id, label FROM inserted UNION ALL SELECT 0, 'z') r ORDER BY label`, "label", labels)
+	assert.EqualError(t, err, "error scanning inserted rows: no value with key z to match returned row to")
+
+	for _, l := range labels {
+		assert.Equal(t, 0, l.(*Label).ID)
+	}
+
+	count := 0
+	db.Get(&count, "SELECT count(*) FROM labels WHERE label IN ('e', 'f');")
+	assert.Equal(t, 0, count)
+}
@@ -0,0 +1,292 @@
+package batch
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Inserter inserts items in a background thread, grouping the items of concurrent callers into a single statement.
+// Unlike a Committer, callers block until their item has been inserted and any columns returned by the statement,
+// such as the id, have been scanned back into it.
+type Inserter interface {
+	Start()
+	Insert(KeyedValue) error
+	Stop()
+}
+
+// KeyedValue is a value which can be inserted by an inserter, InsertKey should return the value of the key column
+// returned by the insert for it, such as its UUID, which is used to match each returned row to its value
+type KeyedValue interface {
+	Value
+	InsertKey() string
+}
+
+// ErrInserterStopped is returned for values inserted after an inserter has been stopped
+var ErrInserterStopped = errors.New("inserter stopped")
+
+// InsertedCallback lets callers get a callback with each batch of values which were inserted, before the callers
+// waiting on them are released
+type InsertedCallback func(values []Value)
+
+// NewInserter creates a new inserter that will insert items in batches as quickly as possible. Values must be pointers
+// to structs which the columns returned by the passed in SQL can be scanned into, and those columns must include the
+// passed in key column.
+func NewInserter(label string, db *sqlx.DB, sql string, keyColumn string, timeout time.Duration, wg *sync.WaitGroup, callback InsertedCallback) Inserter {
+	return &inserter{
+		db:        db,
+		label:     label,
+		sql:       sql,
+		keyColumn: keyColumn,
+		timeout:   timeout,
+		callback:  callback,
+
+		wg:     wg,
+		stop:   make(chan bool),
+		buffer: make(chan *insertRequest, 1000),
+	}
+}
+
+// Start starts our inserter
+func (i *inserter) Start() {
+	i.wg.Add(1)
+
+	go func() {
+		defer i.wg.Done()
+
+		for {
+			select {
+			case <-i.stop:
+				for len(i.buffer) > 0 {
+					i.flush(<-i.buffer)
+				}
+				logrus.WithField("label", i.label).Info("inserter flushed and exiting")
+				return
+
+			case r := <-i.buffer:
+				i.flush(r)
+			}
+		}
+	}()
+}
+
+// Insert inserts the passed in value, blocking until it has been inserted or has failed to be
+func (i *inserter) Insert(value KeyedValue) error {
+	// we hold our lock while queueing so that we can't be stopped between checking and queueing, which would leave our
+	// request in a buffer which is no longer being read
+	i.mutex.RLock()
+	if i.stopped {
+		i.mutex.RUnlock()
+		return errors.Wrapf(ErrInserterStopped, "%s: error inserting value", i.label)
+	}
+
+	// our buffer is full, log an error but continue
+	if len(i.buffer) >= cap(i.buffer) {
+		logrus.WithField("label", i.label).Error("buffer full, inserts are backing up")
+	}
+
+	r := &insertRequest{value: value, done: make(chan error, 1)}
+	i.buffer <- r
+	i.mutex.RUnlock()
+
+	return <-r.done
+}
+
+// Stop stops our inserter, callers can use the WaitGroup used during initialization to block for stop. Values already
+// queued are still inserted, inserting any more returns ErrInserterStopped.
+func (i *inserter) Stop() {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if !i.stopped {
+		i.stopped = true
+		close(i.stop)
+	}
+}
+
+// flush inserts the passed in request along with up to a batch of any others waiting in our buffer, we never wait for
+// more requests, under load batches grow naturally while the previous batch is being inserted
+func (i *inserter) flush(first *insertRequest) {
+	start := time.Now()
+	requests := []*insertRequest{first}
+
+gather:
+	for len(requests) < batchSize {
+		select {
+		case r := <-i.buffer:
+			requests = append(requests, r)
+		default:
+			break gather
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), i.timeout)
+	defer cancel()
+
+	values := make([]KeyedValue, len(requests))
+	for j, r := range requests {
+		values[j] = r.value
+	}
+
+	// insert our batch, if that fails try again one at a time in case it is one value hanging us up
+	errs := make([]error, len(requests))
+	err := insertSQL(ctx, i.db, i.sql, i.keyColumn, values)
+	if err != nil && len(values) > 1 {
+		for j, v := range values {
+			errs[j] = insertSQL(ctx, i.db, i.sql, i.keyColumn, []KeyedValue{v})
+		}
+	} else {
+		for j := range errs {
+			errs[j] = err
+		}
+	}
+
+	inserted := make([]Value, 0, len(values))
+	for j, v := range values {
+		if errs[j] == nil {
+			inserted = append(inserted, v)
+		} else {
+			errs[j] = errors.Wrapf(errs[j], "%s: error inserting value", i.label)
+		}
+	}
+
+	if len(inserted) > 0 && i.callback != nil {
+		i.callback(inserted)
+	}
+
+	for j, r := range requests {
+		r.done <- errs[j]
+	}
+
+	logrus.WithField("elapsed", time.Since(start)).WithField("label", i.label).WithField("count", len(requests)).Debug("batch inserted")
+}
+
+type insertRequest struct {
+	value KeyedValue
+	done  chan error
+}
+
+type inserter struct {
+	db        *sqlx.DB
+	label     string
+	sql       string
+	keyColumn string
+	timeout   time.Duration
+	callback  InsertedCallback
+
+	wg      *sync.WaitGroup
+	mutex   sync.RWMutex
+	stopped bool
+	stop    chan bool
+	buffer  chan *insertRequest
+}
+
+// insertSQL inserts the passed in values in a single statement, scanning the rows it returns back into them. Each row is
+// matched to its value by the passed in key column, as Postgres doesn't guarantee rows are returned in any order.
+func insertSQL(ctx context.Context, db *sqlx.DB, sql string, keyColumn string, vs []KeyedValue) error {
+	values := make([]interface{}, len(vs))
+	byKey := make(map[string][]KeyedValue, len(vs))
+	for i, v := range vs {
+		values[i] = v
+		byKey[v.InsertKey()] = append(byKey[v.InsertKey()], v)
+	}
+
+	bulkSQL, args, err := buildBulkSQL(db, sql, values)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrapf(err, "error starting transaction")
+	}
+
+	rows, err := tx.QueryxContext(ctx, bulkSQL, args...)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrapf(err, "error during bulk insert")
+	}
+
+	// rows are scanned into copies of our values which are only copied back once we've committed, so that values
+	// aren't left with the ids of rows which were rolled back
+	scanned := make([]KeyedValue, 0, len(vs))
+	scans := make([]reflect.Value, 0, len(vs))
+	for rows.Next() && err == nil {
+		var v KeyedValue
+		v, err = matchInsertedRow(rows, keyColumn, byKey)
+		if err == nil {
+			scan := reflect.New(reflect.TypeOf(v).Elem())
+			scan.Elem().Set(reflect.ValueOf(v).Elem())
+			err = rows.StructScan(scan.Interface())
+			scanned = append(scanned, v)
+			scans = append(scans, scan)
+		}
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	rows.Close()
+
+	if err == nil && len(scanned) != len(vs) {
+		err = errors.Errorf("expected %d rows to be returned but got %d", len(vs), len(scanned))
+	}
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrapf(err, "error scanning inserted rows")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrapf(err, "error committing bulk insert")
+	}
+
+	for i, v := range scanned {
+		reflect.ValueOf(v).Elem().Set(scans[i].Elem())
+	}
+	return nil
+}
+
+// matchInsertedRow returns the value the current row was inserted for, by the value of its key column, removing it
+// from the passed in values by key so that values with the same key are each matched once
+func matchInsertedRow(rows *sqlx.Rows, keyColumn string, byKey map[string][]KeyedValue) (KeyedValue, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	row := make([]interface{}, len(columns))
+	keyIndex := -1
+	for i, c := range columns {
+		row[i] = new(interface{})
+		if c == keyColumn {
+			keyIndex = i
+		}
+	}
+	if keyIndex == -1 {
+		return nil, errors.Errorf("key column %s not returned by insert", keyColumn)
+	}
+
+	err = rows.Scan(row...)
+	if err != nil {
+		return nil, err
+	}
+
+	key := *row[keyIndex].(*interface{})
+	if keyBytes, isBytes := key.([]byte); isBytes {
+		key = string(keyBytes)
+	}
+	keyStr := fmt.Sprintf("%v", key)
+
+	matches := byKey[keyStr]
+	if len(matches) == 0 {
+		return nil, errors.Errorf("no value with key %s to match returned row to", keyStr)
+	}
+	byKey[keyStr] = matches[1:]
+	return matches[0], nil
+}
@@ -26,12 +26,30 @@ const (
 	// ConfigContentType is a constant key for channel configs
 	ConfigContentType = "content_type"
 
+	// ConfigMaxConcurrency is the maximum number of msgs that can be sent on the channel at once
+	ConfigMaxConcurrency = "max_concurrency"
+
 	// ConfigMaxLength is the maximum size of a message in characters
 	ConfigMaxLength = "max_length"
 
 	// ConfigPassword is a constant key for channel configs
 	ConfigPassword = "password"
 
+	// ConfigRetryBackoff is how the delay between retries of errored msgs grows, either linear or exponential
+	ConfigRetryBackoff = "retry_backoff"
+
+	// ConfigRetryDelay is the number of seconds before the first retry of an errored msg
+	ConfigRetryDelay = "retry_delay"
+
+	// ConfigRetryJitter is the fraction the delay between retries is randomly varied by, ex: 0.2
+	ConfigRetryJitter = "retry_jitter"
+
+	// ConfigRetryMaxAttempts is the number of attempts to send a msg before it is failed, up to MaxRetryAttempts
+	ConfigRetryMaxAttempts = "retry_max_attempts"
+
+	// ConfigRetryMaxDelay is the maximum number of seconds between retries of an errored msg
+	ConfigRetryMaxDelay = "retry_max_delay"
+
 	// ConfigSecret is the secret used for signing commands by the channel
 	ConfigSecret = "secret"
 
@@ -44,9 +62,15 @@ const (
 	// ConfigSendMethod is a constant key for channel configs
 	ConfigSendMethod = "method"
 
+	// ConfigSendTimeout is the number of seconds an individual send on the channel can take before it is cancelled
+	ConfigSendTimeout = "send_timeout"
+
 	// ConfigSendURL is a constant key for channel configs
 	ConfigSendURL = "send_url"
 
+	// ConfigTPSBurst is the number of msgs which can be sent on the channel in a burst before it is limited to its TPS
+	ConfigTPSBurst = "tps_burst"
+
 	// ConfigUsername is a constant key for channel configs
 	ConfigUsername = "username"
 )
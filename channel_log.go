@@ -47,6 +47,8 @@ func NewChannelLogFromRR(description string, channel Channel, msgID MsgID, rr *u
 		Response:    rr.Response,
 		CreatedOn:   time.Now(),
 		Elapsed:     rr.Elapsed,
+
+		ConnectionFailure: rr.Status == utils.RRConnectionFailure,
 	}
 
 	return log
@@ -95,4 +97,7 @@ type ChannelLog struct {
 	Response    string
 	Elapsed     time.Duration
 	CreatedOn   time.Time
+
+	// whether the request failed to connect at all, ex: a DNS failure or timeout
+	ConnectionFailure bool
 }
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/nyaruka/courier"
+	"github.com/sirupsen/logrus"
+
+	// load available backends
+	_ "github.com/nyaruka/courier/backends/rapidpro"
+	_ "github.com/nyaruka/courier/backends/relay"
+	_ "github.com/nyaruka/courier/backends/standalone"
+)
+
+const usage = `courier-spool inspects and replays the files courier writes to its spool when it can't write to its backend
+
+Configuration is read from courier.toml and COURIER_ environment variables in the same way as courier.
+
+Usage:
+  courier-spool list [-dead] [spool...]       list spooled files with a summary of each
+  courier-spool validate [-dead] [spool...]   check that spooled files can be parsed, exits with 1 if any can't
+  courier-spool flush [-force] <file>...      flush files to the backend, removing each once flushed
+  courier-spool kill <file>...                move files to the dead directory of their spool
+  courier-spool revive <file>...              move files out of the dead directory of their spool
+  courier-spool export [-dead] [spool...]     write spooled files to stdout as JSON lines
+
+Spools are the subdirectories of the spool directory, such as msgs, statuses or events, all are included if none are given.
+
+Only dead files can be flushed unless -force is given, as files still waiting in a spool may be flushed by a running
+courier at the same time.
+`
+
+func main() {
+	// our arguments are our own, configuration comes from our config file and environment
+	args := os.Args[1:]
+	os.Args = os.Args[:1]
+	config := courier.LoadConfig("courier.toml")
+
+	logrus.SetOutput(os.Stderr)
+	logrus.SetLevel(logrus.WarnLevel)
+
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(1)
+	}
+
+	var err error
+	switch args[0] {
+	case "list":
+		err = runList(config, args[1:])
+	case "validate":
+		err = runValidate(config, args[1:])
+	case "flush":
+		err = runFlush(config, args[1:])
+	case "kill":
+		err = moveFiles(args[1:], true)
+	case "revive":
+		err = moveFiles(args[1:], false)
+	case "export":
+		err = runExport(config, args[1:])
+	default:
+		err = fmt.Errorf("unknown command '%s'", args[0])
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// spoolFile is a single file in one of our spools
+type spoolFile struct {
+	Spool     string    `json:"spool"`
+	Filename  string    `json:"filename"`
+	Dead      bool      `json:"dead"`
+	CreatedOn time.Time `json:"created_on"`
+
+	contents []byte
+}
+
+// parseSpoolArgs parses the arguments of the commands which read spools, returning the files in the requested spools
+func parseSpoolArgs(config *courier.Config, command string, args []string) ([]*spoolFile, error) {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	dead := flags.Bool("dead", false, "only include files in dead directories")
+	flags.Parse(args)
+
+	spools := flags.Args()
+	if len(spools) == 0 {
+		dirs, err := ioutil.ReadDir(config.SpoolDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, dir := range dirs {
+			if dir.IsDir() && !strings.HasPrefix(dir.Name(), ".") {
+				spools = append(spools, dir.Name())
+			}
+		}
+	}
+
+	files := make([]*spoolFile, 0)
+	for _, spool := range spools {
+		dir := path.Join(config.SpoolDir, spool)
+		if *dead {
+			dir = path.Join(dir, courier.SpoolDeadDir)
+		}
+
+		filenames, err := filepath.Glob(path.Join(dir, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(filenames)
+
+		for _, filename := range filenames {
+			file := &spoolFile{Spool: spool, Filename: filename, Dead: *dead}
+
+			info, err := os.Stat(filename)
+			if err != nil {
+				return nil, err
+			}
+			file.CreatedOn = info.ModTime().UTC()
+
+			file.contents, err = ioutil.ReadFile(filename)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// the fields we include in summaries, in the order we include them, these cover msgs, statuses, events and published events
+var summaryFields = []string{"type", "event_type", "uuid", "msg_id", "id", "external_id", "status", "channel_uuid", "urn", "text"}
+
+// summarize returns a one line summary of the passed in file
+func summarize(file *spoolFile) string {
+	contents := make(map[string]interface{})
+	err := json.Unmarshal(file.contents, &contents)
+	if err != nil {
+		return fmt.Sprintf("invalid JSON: %s", err)
+	}
+
+	summary := make([]string, 0, len(summaryFields))
+	for _, field := range summaryFields {
+		value, found := contents[field]
+		if !found || value == nil || value == "" {
+			continue
+		}
+
+		text := fmt.Sprintf("%v", value)
+		if len(text) > 40 {
+			text = text[:37] + "..."
+		}
+		summary = append(summary, fmt.Sprintf("%s=%q", field, text))
+	}
+	return strings.Join(summary, " ")
+}
+
+func runList(config *courier.Config, args []string) error {
+	files, err := parseSpoolArgs(config, "list", args)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		age := time.Since(file.CreatedOn).Round(time.Second)
+		fmt.Printf("%s\t%s\t%s\n", file.Filename, age, summarize(file))
+	}
+	fmt.Printf("%d files\n", len(files))
+	return nil
+}
+
+func runValidate(config *courier.Config, args []string) error {
+	files, err := parseSpoolArgs(config, "validate", args)
+	if err != nil {
+		return err
+	}
+
+	invalid := 0
+	for _, file := range files {
+		// check the file using the same parsing its flusher does
+		err := courier.ValidateSpoolFile(file.Spool, file.contents)
+		if err != nil {
+			fmt.Printf("%s\t%s\n", file.Filename, err)
+			invalid++
+		}
+	}
+	fmt.Printf("%d files, %d invalid\n", len(files), invalid)
+
+	if invalid > 0 {
+		os.Exit(1)
+	}
+	return nil
+}
+
+// runFlush starts our backend, which registers its flushers, then flushes each of the passed in files with them
+func runFlush(config *courier.Config, args []string) error {
+	flags := flag.NewFlagSet("flush", flag.ExitOnError)
+	force := flags.Bool("force", false, "also flush files which aren't in dead directories")
+	flags.Parse(args)
+
+	filenames := flags.Args()
+	if len(filenames) == 0 {
+		return fmt.Errorf("no files to flush")
+	}
+
+	// files which aren't dead may be flushed by a running courier at the same time as us, which would write them twice
+	if !*force {
+		for _, filename := range filenames {
+			if filepath.Base(filepath.Dir(filename)) != courier.SpoolDeadDir {
+				return fmt.Errorf("%s isn't dead and may be flushed by courier, use -force to flush it anyway", filename)
+			}
+		}
+	}
+
+	// we don't want to send anything while we're running
+	config.MaxWorkers = 0
+
+	backend, err := courier.NewBackend(config)
+	if err != nil {
+		return err
+	}
+	err = backend.Start()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		backend.Stop()
+		backend.Cleanup()
+	}()
+
+	failed := 0
+	for _, filename := range filenames {
+		err := courier.FlushSpoolFile(filename)
+		if err != nil {
+			fmt.Printf("%s\terror: %s\n", filename, err)
+			failed++
+		} else {
+			fmt.Printf("%s\tflushed\n", filename)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed to flush", failed, len(filenames))
+	}
+	return nil
+}
+
+// moveFiles moves the passed in files into or out of the dead directory of their spool. Their failed attempts are moved
+// with them when they are killed, and removed when they are revived so they get as many attempts as a new file.
+func moveFiles(filenames []string, toDead bool) error {
+	if len(filenames) == 0 {
+		return fmt.Errorf("no files to move")
+	}
+
+	for _, filename := range filenames {
+		dir := filepath.Dir(filename)
+		isDead := filepath.Base(dir) == courier.SpoolDeadDir
+
+		var dest string
+		if toDead && !isDead {
+			dest = path.Join(dir, courier.SpoolDeadDir)
+		} else if !toDead && isDead {
+			dest = filepath.Dir(dir)
+		} else {
+			return fmt.Errorf("%s is already where it should be", filename)
+		}
+
+		err := os.MkdirAll(dest, 0770)
+		if err == nil {
+			err = os.Rename(filename, path.Join(dest, filepath.Base(filename)))
+		}
+		if err != nil {
+			return err
+		}
+
+		attemptsFilename := courier.SpoolAttemptsFilename(filename)
+		if toDead {
+			err = os.Rename(attemptsFilename, courier.SpoolAttemptsFilename(path.Join(dest, filepath.Base(filename))))
+		} else {
+			err = os.Remove(attemptsFilename)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		fmt.Printf("%s\tmoved to %s\n", filename, dest)
+	}
+	return nil
+}
+
+func runExport(config *courier.Config, args []string) error {
+	files, err := parseSpoolArgs(config, "export", args)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	for _, file := range files {
+		// files which aren't valid JSON are exported as strings so nothing is lost
+		var contents interface{} = json.RawMessage(file.contents)
+		if !json.Valid(file.contents) {
+			contents = string(file.contents)
+		}
+
+		err := encoder.Encode(&struct {
+			*spoolFile
+			Contents interface{} `json:"contents"`
+		}{file, contents})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
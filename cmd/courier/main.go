@@ -61,6 +61,8 @@ import (
 
 	// load available backends
 	_ "github.com/nyaruka/courier/backends/rapidpro"
+	_ "github.com/nyaruka/courier/backends/relay"
+	_ "github.com/nyaruka/courier/backends/standalone"
 )
 
 var version = "Dev"
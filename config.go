@@ -1,17 +1,36 @@
 package courier
 
-import "github.com/nyaruka/ezconf"
+import (
+	"strconv"
+	"strings"
+
+	"github.com/nyaruka/ezconf"
+)
 
 // Config is our top level configuration object
 type Config struct {
-	Backend            string `help:"the backend that will be used by courier (currently only rapidpro is supported)"`
+	Backend            string `help:"the backend that will be used by courier (rapidpro, standalone or relay)"`
 	SentryDSN          string `help:"the DSN used for logging errors to Sentry"`
 	Domain             string `help:"the domain courier is exposed on"`
 	Address            string `help:"the network interface address courier will bind to"`
 	Port               int    `help:"the port courier will listen on"`
 	DB                 string `help:"URL describing how to connect to the RapidPro database"`
 	Redis              string `help:"URL describing how to connect to Redis"`
+	StandaloneDB       string `help:"the SQLite database file used by the standalone backend, :memory: for an in-memory database"`
+	StandaloneChannels string `help:"the JSON file the standalone backend loads its channels from"`
+	RelayURL           string `help:"the URL the relay backend POSTs received msgs, statuses and events to"`
+	RelaySecret        string `help:"the secret the relay backend signs its requests with and verifies requests to its API with"`
+	RelayChannels      string `help:"the JSON file the relay backend loads its channels from"`
+	RelayRetries       int    `help:"the number of times the relay backend retries a request to its relay URL which failed"`
+	EventsURL          string `help:"the URL of the NATS server received msgs, statuses, channel events and channel logs are published to, ex: nats://localhost:4222 (leave empty to disable)"`
+	EventsPrefix       string `help:"the prefix of the subjects events are published to, ex: courier.msg_received"`
 	SpoolDir           string `help:"the local directory where courier will write statuses or msgs that need to be retried (needs to be writable)"`
+	SpoolMaxAttempts   int    `help:"the number of times courier tries to flush a spooled file before moving it to the dead directory (set to 0 to retry forever)"`
+	MediaStorage       string `help:"where attachments are written to (s3, s3compatible or local)"`
+	MediaDir           string `help:"the local directory attachments are written to when using local media storage"`
+	MediaSecret        string `help:"the secret used to sign the URLs attachments are served from under /media/, required unless S3 media uses pre-signed URLs"`
+	MediaURLExpiration int    `help:"the number of hours the URLs of attachments are valid for, at least 1"`
+	S3MediaURLs        string `help:"how attachments written to S3 are served, proxy to serve them from courier under /media/ or presigned to use pre-signed S3 URLs which requires a media URL expiration"`
 	S3Endpoint         string `help:"the S3 endpoint we will write attachments to"`
 	S3Region           string `help:"the S3 region we will write attachments to"`
 	S3MediaBucket      string `help:"the S3 bucket we will write attachments to"`
@@ -20,7 +39,14 @@ type Config struct {
 	S3ForcePathStyle   bool   `help:"whether we force S3 path style. Should generally need to default to False unless you're hosting an S3 compatible service"`
 	AWSAccessKeyID     string `help:"the access key id to use when authenticating S3"`
 	AWSSecretAccessKey string `help:"the secret access key id to use when authenticating S3"`
+	MaxMediaSize       int    `help:"the maximum size in megabytes of attachments that will be downloaded, larger attachments are replaced by a placeholder"`
+	MaxMediaSizes      string `help:"comma separated maximum attachment sizes in megabytes for specific channel types, ex: WA:16,TG:20"`
 	MaxWorkers         int    `help:"the maximum number of go routines that will be used for sending (set to 0 to disable sending)"`
+	NotifiedPollMax    int    `help:"the most milliseconds between looks for outgoing msgs when our backend publishes notifications, only raise this if everything which queues msgs publishes to msgs:notify"`
+	OrgWeights         string `help:"comma separated weights of specific orgs when sharing workers between orgs, orgs default to 1, ex: 12:2,34:0.5"`
+	BreakerThreshold   int    `help:"the number of consecutive errored sends after which a channel stops sending (set to 0 to disable)"`
+	BreakerCooldown    int    `help:"the number of seconds a channel stops sending for before a single msg is sent as a probe"`
+	Metrics            string `help:"comma separated list of sinks metrics will be reported to (librato, prometheus)"`
 	LibratoUsername    string `help:"the username that will be used to authenticate to Librato"`
 	LibratoToken       string `help:"the token that will be used to authenticate to Librato"`
 	StatusUsername     string `help:"the username that is needed to authenticate against the /status endpoint"`
@@ -44,7 +70,17 @@ func NewConfig() *Config {
 		Port:               8080,
 		DB:                 "postgres://courier@localhost/courier?sslmode=disable",
 		Redis:              "redis://localhost:6379/0",
+		StandaloneDB:       "courier.db",
+		StandaloneChannels: "channels.json",
+		RelayChannels:      "channels.json",
+		RelayRetries:       3,
+		EventsPrefix:       "courier",
 		SpoolDir:           "/var/spool/courier",
+		SpoolMaxAttempts:   10,
+		MediaStorage:       "s3",
+		MediaDir:           "/var/lib/courier/media",
+		MediaURLExpiration: 24,
+		S3MediaURLs:        "proxy",
 		S3Endpoint:         "https://s3.amazonaws.com",
 		S3Region:           "us-east-1",
 		S3MediaBucket:      "courier-media",
@@ -53,7 +89,12 @@ func NewConfig() *Config {
 		S3ForcePathStyle:   false,
 		AWSAccessKeyID:     "missing_aws_access_key_id",
 		AWSSecretAccessKey: "missing_aws_secret_access_key",
+		MaxMediaSize:       100,
 		MaxWorkers:         32,
+		NotifiedPollMax:    250,
+		BreakerThreshold:   10,
+		BreakerCooldown:    60,
+		Metrics:            "librato",
 		LogLevel:           "error",
 		Version:            "Dev",
 	}
@@ -71,3 +112,37 @@ func LoadConfig(filename string) *Config {
 	loader.MustLoad()
 	return config
 }
+
+// MaxMediaSizeFor returns the maximum size in bytes of attachments that will be downloaded for the passed in channel
+// type, which is the size set for it in MaxMediaSizes if there is one, otherwise MaxMediaSize
+func (c *Config) MaxMediaSizeFor(channelType ChannelType) int64 {
+	for _, override := range strings.Split(c.MaxMediaSizes, ",") {
+		parts := strings.Split(strings.TrimSpace(override), ":")
+		if len(parts) != 2 || !strings.EqualFold(parts[0], string(channelType)) {
+			continue
+		}
+
+		size, err := strconv.Atoi(parts[1])
+		if err == nil {
+			return int64(size) * 1024 * 1024
+		}
+	}
+	return int64(c.MaxMediaSize) * 1024 * 1024
+}
+
+// ParseOrgWeights returns the weights set for specific orgs in OrgWeights keyed by org id, invalid weights are skipped
+func (c *Config) ParseOrgWeights() map[string]float64 {
+	weights := make(map[string]float64)
+	for _, weight := range strings.Split(c.OrgWeights, ",") {
+		parts := strings.Split(strings.TrimSpace(weight), ":")
+		if len(parts) != 2 {
+			continue
+		}
+
+		w, err := strconv.ParseFloat(parts[1], 64)
+		if err == nil && w > 0 {
+			weights[parts[0]] = w
+		}
+	}
+	return weights
+}
@@ -0,0 +1,26 @@
+package courier
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaxMediaSizeFor(t *testing.T) {
+	config := NewConfig()
+	assert.Equal(t, int64(100*1024*1024), config.MaxMediaSizeFor(ChannelType("WA")))
+
+	config.MaxMediaSizes = "WA:16, tg:20,XX,EX:big"
+	assert.Equal(t, int64(16*1024*1024), config.MaxMediaSizeFor(ChannelType("WA")))
+	assert.Equal(t, int64(20*1024*1024), config.MaxMediaSizeFor(ChannelType("TG")))
+	assert.Equal(t, int64(100*1024*1024), config.MaxMediaSizeFor(ChannelType("EX")))
+	assert.Equal(t, int64(100*1024*1024), config.MaxMediaSizeFor(ChannelType("KN")))
+}
+
+func TestParseOrgWeights(t *testing.T) {
+	config := NewConfig()
+	assert.Equal(t, map[string]float64{}, config.ParseOrgWeights())
+
+	config.OrgWeights = "12:2, 34:0.5,56,78:heavy,90:-1"
+	assert.Equal(t, map[string]float64{"12": 2, "34": 0.5}, config.ParseOrgWeights())
+}
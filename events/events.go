@@ -0,0 +1,160 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// the types of events we publish
+const (
+	TypeMsgReceived  = "msg_received"
+	TypeMsgStatus    = "msg_status"
+	TypeChannelEvent = "channel_event"
+	TypeChannelLog   = "channel_log"
+)
+
+// Event is a single event published to our message bus, the subject it is published to is our prefix and its type
+type Event struct {
+	Type      string          `json:"type"`
+	CreatedOn time.Time       `json:"created_on"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// NewEvent creates a new event of the passed in type, with the passed in data marshalled as JSON
+func NewEvent(eventType string, data interface{}) (*Event, error) {
+	dataJSON, err := json.Marshal(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error marshalling %s event", eventType)
+	}
+	return &Event{Type: eventType, CreatedOn: time.Now().UTC(), Data: dataJSON}, nil
+}
+
+// Publisher is the interface for a message bus events can be published to
+type Publisher interface {
+	// Publish publishes the passed in payload to the passed in subject
+	Publish(subject string, payload []byte) error
+
+	// Close closes any connection to the message bus
+	Close() error
+}
+
+// NewPublisher creates a new publisher for the message bus at the passed in URL, only NATS is currently supported
+func NewPublisher(busURL string) (Publisher, error) {
+	u, err := url.Parse(busURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse events URL '%s'", busURL)
+	}
+
+	switch u.Scheme {
+	case "nats":
+		return newNATSPublisher(u), nil
+	default:
+		return nil, errors.Errorf("unsupported events URL scheme '%s', only nats is supported", u.Scheme)
+	}
+}
+
+// ErrorCallback lets callers get a callback when an event fails to be published
+type ErrorCallback func(err error, event *Event)
+
+// Fanout publishes events to a message bus in a background thread so that publishing never blocks the caller
+type Fanout interface {
+	Start()
+	Queue(*Event)
+	Stop()
+
+	// Publish synchronously publishes the passed in event, used to retry events which previously failed
+	Publish(*Event) error
+}
+
+// NewFanout creates a new fanout which publishes events to the passed in publisher under the passed in subject prefix,
+// events which fail to be published, or which can't be buffered, are passed to the callback
+func NewFanout(publisher Publisher, prefix string, wg *sync.WaitGroup, callback ErrorCallback) Fanout {
+	return &fanout{
+		publisher: publisher,
+		prefix:    prefix,
+		callback:  callback,
+
+		wg:     wg,
+		stop:   make(chan bool),
+		buffer: make(chan *Event, 1000),
+	}
+}
+
+// Start starts our fanout
+func (f *fanout) Start() {
+	f.wg.Add(1)
+
+	go func() {
+		defer f.wg.Done()
+
+		for {
+			select {
+			case <-f.stop:
+				for len(f.buffer) > 0 {
+					f.publish(<-f.buffer)
+				}
+				f.publisher.Close()
+				logrus.WithField("comp", "fanout").Info("fanout flushed and exiting")
+				return
+
+			case e := <-f.buffer:
+				f.publish(e)
+			}
+		}
+	}()
+}
+
+// Queue queues the passed in event to be published, this never blocks, if our buffer is full or we have been stopped
+// the event is passed straight to our callback
+func (f *fanout) Queue(e *Event) {
+	select {
+	case <-f.stop:
+		f.callback(errors.New("fanout stopped"), e)
+		return
+	default:
+	}
+
+	select {
+	case f.buffer <- e:
+	default:
+		f.callback(errors.New("fanout buffer full"), e)
+	}
+}
+
+// Stop stops our fanout, callers can use the WaitGroup used during initialization to block for stop
+func (f *fanout) Stop() {
+	close(f.stop)
+}
+
+// Publish synchronously publishes the passed in event
+func (f *fanout) Publish(e *Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrapf(err, "error marshalling event")
+	}
+	return f.publisher.Publish(fmt.Sprintf("%s.%s", f.prefix, e.Type), payload)
+}
+
+// publish publishes the passed in event, passing it to our callback if that fails
+func (f *fanout) publish(e *Event) {
+	err := f.Publish(e)
+	if err != nil {
+		f.callback(errors.Wrapf(err, "error publishing %s event", e.Type), e)
+	}
+}
+
+type fanout struct {
+	publisher Publisher
+	prefix    string
+	callback  ErrorCallback
+
+	wg     *sync.WaitGroup
+	stop   chan bool
+	buffer chan *Event
+}
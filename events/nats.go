@@ -0,0 +1,146 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// how long we wait to connect to or hear back from our NATS server
+const natsTimeout = time.Second * 5
+
+// natsPublisher publishes to a NATS server using its text protocol, see https://docs.nats.io/reference/reference-protocols/nats-protocol
+// We connect lazily and reconnect on the next publish if our connection is lost.
+type natsPublisher struct {
+	address string
+	user    string
+	pass    string
+
+	mutex sync.Mutex
+	conn  net.Conn
+}
+
+func newNATSPublisher(u *url.URL) *natsPublisher {
+	p := &natsPublisher{address: u.Host}
+	if u.User != nil {
+		p.user = u.User.Username()
+		p.pass, _ = u.User.Password()
+	}
+	return p
+}
+
+// the options we send when connecting, we don't want +OK acks for each publish
+type natsConnect struct {
+	Verbose  bool   `json:"verbose"`
+	Pedantic bool   `json:"pedantic"`
+	Name     string `json:"name"`
+	User     string `json:"user,omitempty"`
+	Pass     string `json:"pass,omitempty"`
+}
+
+// Publish publishes the passed in payload to the passed in subject
+func (p *natsPublisher) Publish(subject string, payload []byte) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.conn == nil {
+		err := p.connect()
+		if err != nil {
+			return err
+		}
+	}
+
+	p.conn.SetWriteDeadline(time.Now().Add(natsTimeout))
+	_, err := fmt.Fprintf(p.conn, "PUB %s %d\r\n%s\r\n", subject, len(payload), payload)
+	if err != nil {
+		p.conn.Close()
+		p.conn = nil
+		return errors.Wrapf(err, "error publishing to NATS")
+	}
+	return nil
+}
+
+// Close closes our connection if we have one
+func (p *natsPublisher) Close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn = nil
+	return err
+}
+
+// connect connects to our server, callers must hold our lock
+func (p *natsPublisher) connect() error {
+	conn, err := net.DialTimeout("tcp", p.address, natsTimeout)
+	if err != nil {
+		return errors.Wrapf(err, "error connecting to NATS")
+	}
+	conn.SetDeadline(time.Now().Add(natsTimeout))
+	reader := bufio.NewReader(conn)
+
+	// the server starts by telling us about itself
+	line, err := reader.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "INFO") {
+		conn.Close()
+		return errors.Errorf("unexpected NATS greeting: %s", strings.TrimSpace(line))
+	}
+
+	options, _ := json.Marshal(&natsConnect{Name: "courier", User: p.user, Pass: p.pass})
+
+	// send our options followed by a ping, the server replies with a pong once it has accepted them
+	_, err = fmt.Fprintf(conn, "CONNECT %s\r\nPING\r\n", options)
+	if err == nil {
+		line, err = reader.ReadString('\n')
+	}
+	if err != nil || !strings.HasPrefix(line, "PONG") {
+		conn.Close()
+		return errors.Errorf("error connecting to NATS: %s", strings.TrimSpace(line))
+	}
+	conn.SetDeadline(time.Time{})
+
+	p.conn = conn
+	go p.read(conn, reader)
+	return nil
+}
+
+// read reads from our connection until it is closed, the server pings us periodically and drops us if we don't reply
+func (p *natsPublisher) read(conn net.Conn, reader *bufio.Reader) {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			break
+		}
+
+		if strings.HasPrefix(line, "PING") {
+			p.mutex.Lock()
+			conn.SetWriteDeadline(time.Now().Add(natsTimeout))
+			_, err = conn.Write([]byte("PONG\r\n"))
+			p.mutex.Unlock()
+		} else if strings.HasPrefix(line, "-ERR") {
+			logrus.WithField("comp", "fanout").WithField("error", strings.TrimSpace(line)).Error("error from NATS server")
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	// forget this connection so we reconnect on our next publish
+	p.mutex.Lock()
+	if p.conn == conn {
+		p.conn.Close()
+		p.conn = nil
+	}
+	p.mutex.Unlock()
+}
@@ -1,6 +1,9 @@
 package gsm7
 
-import "bytes"
+import (
+	"bytes"
+	"unicode/utf16"
+)
 
 // base gsm7 characters in our normal table
 var baseGSM7 = map[rune]byte{
@@ -244,6 +247,22 @@ func ReplaceSubstitutions(text string) string {
 	return output.String()
 }
 
+// Segments returns the number of SMS parts the passed in text will be sent as. GSM7 texts fit 160 characters
+// in a single part, or 153 in each part of a longer text, with extended characters counting as two. Any
+// other texts are sent as UCS2 which fits 70 UTF-16 code units in a single part, or 67 in each part of a
+// longer text. Empty texts are still sent as a single part.
+func Segments(text string) int {
+	length, single, multi := len(Encode(text)), 160, 153
+	if !IsValid(text) {
+		length, single, multi = len(utf16.Encode([]rune(text))), 70, 67
+	}
+
+	if length <= single {
+		return 1
+	}
+	return (length + multi - 1) / multi
+}
+
 // Encode encodes the given UTF-8 text into a string composed of GSM7 bytes. Each 7 bit
 // GSM7 char is encoded in a single byte
 func Encode(str string) []byte {
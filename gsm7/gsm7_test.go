@@ -1,6 +1,7 @@
 package gsm7
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -56,3 +57,28 @@ func TestSubstitutions(t *testing.T) {
 		assert.Equal(t, tc.exp, ReplaceSubstitutions(tc.str), tc.str)
 	}
 }
+
+func TestSegments(t *testing.T) {
+	tcs := []struct {
+		str      string
+		segments int
+	}{
+		{"", 1},
+		{"hello", 1},
+		{strings.Repeat("a", 160), 1},
+		{strings.Repeat("a", 161), 2},
+		{strings.Repeat("a", 306), 2},
+		{strings.Repeat("a", 307), 3},
+		{strings.Repeat("{", 80), 1},
+		{strings.Repeat("{", 81), 2},
+		{strings.Repeat("☺", 70), 1},
+		{strings.Repeat("☺", 71), 2},
+		{strings.Repeat("☺", 134), 2},
+		{strings.Repeat("☺", 135), 3},
+		{strings.Repeat("😀", 35), 1},
+		{strings.Repeat("😀", 36), 2},
+	}
+	for _, tc := range tcs {
+		assert.Equal(t, tc.segments, Segments(tc.str), tc.str)
+	}
+}
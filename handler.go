@@ -36,6 +36,16 @@ type MediaDownloadRequestBuilder interface {
 	BuildDownloadMediaRequest(context.Context, Backend, Channel, string) (*http.Request, error)
 }
 
+// RetryPolicyProvider is the interface handlers which retry errored msgs differently to our default policy should satisfy
+type RetryPolicyProvider interface {
+	RetryPolicy() RetryPolicy
+}
+
+// SendLimitsProvider is the interface handlers which send with different limits to our defaults should satisfy
+type SendLimitsProvider interface {
+	SendLimits() SendLimits
+}
+
 // RegisterHandler adds a new handler for a channel type, this is called by individual handlers when they are initialized
 func RegisterHandler(handler ChannelHandler) {
 	registeredHandlers[handler.ChannelType()] = handler
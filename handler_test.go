@@ -1,6 +1,9 @@
 package courier
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 func init() {
 	RegisterHandler(NewHandler())
@@ -30,3 +33,13 @@ func (h *dummyHandler) Initialize(s Server) error {
 func (h *dummyHandler) SendMsg(ctx context.Context, msg Msg) (MsgStatus, error) {
 	return h.backend.NewMsgStatusForID(msg.Channel(), msg.ID(), MsgSent), nil
 }
+
+// RetryPolicy returns how errored msgs for this channel type are retried
+func (h *dummyHandler) RetryPolicy() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 5, Delay: time.Minute, Backoff: RetryBackoffExponential, MaxDelay: time.Hour}
+}
+
+// SendLimits returns how msgs for this channel type are sent
+func (h *dummyHandler) SendLimits() SendLimits {
+	return SendLimits{MaxConcurrency: 2, Timeout: time.Minute}
+}
@@ -2,7 +2,10 @@ package handlers
 
 import (
 	"testing"
+	"time"
 
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/utils"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -43,3 +46,27 @@ func TestSplitMsg(t *testing.T) {
 	assert.Equal([]string{" "}, SplitMsg(" ", 20))
 	assert.Equal([]string{"This is a message", "longer than 10"}, SplitMsg("This is a message   longer than 10", 20))
 }
+
+func TestParseRetryAfter(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Date(2018, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	assert.Equal(time.Duration(0), ParseRetryAfter("", now))
+	assert.Equal(time.Duration(0), ParseRetryAfter("soon", now))
+	assert.Equal(time.Duration(0), ParseRetryAfter("-5", now))
+	assert.Equal(time.Second*30, ParseRetryAfter(" 30 ", now))
+	assert.Equal(time.Minute*2, ParseRetryAfter("Mon, 01 Oct 2018 12:02:00 GMT", now))
+	assert.Equal(time.Duration(0), ParseRetryAfter("Mon, 01 Oct 2018 11:58:00 GMT", now))
+}
+
+func TestNewSendErrorFromStatus(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(courier.NewSendError(courier.ErrorUnknown, "", "boom"), NewSendErrorFromStatus(nil, "boom"))
+	assert.Equal(courier.NewSendError(courier.ErrorAuthentication, "401", ""), NewSendErrorFromStatus(&utils.RequestResponse{StatusCode: 401}, ""))
+	assert.Equal(courier.NewSendError(courier.ErrorProvider, "503", ""), NewSendErrorFromStatus(&utils.RequestResponse{StatusCode: 503}, ""))
+	assert.Equal(courier.NewSendError(courier.ErrorUnknown, "400", ""), NewSendErrorFromStatus(&utils.RequestResponse{StatusCode: 400}, ""))
+
+	rr := &utils.RequestResponse{StatusCode: 429, Header: map[string][]string{"Retry-After": {"10"}}}
+	assert.Equal(courier.NewRateLimitedError("429", "", time.Second*10), NewSendErrorFromStatus(rr, ""))
+}
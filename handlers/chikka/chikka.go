@@ -137,7 +137,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 
 		req, _ := http.NewRequest(http.MethodPost, sendURL, strings.NewReader(form.Encode()))
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-		rr, err := utils.MakeHTTPRequest(req)
+		rr, err := utils.MakeHTTPRequest(req.WithContext(ctx))
 
 		if rr.StatusCode == 400 {
 			message, _ := jsonparser.GetString([]byte(rr.Body), "message")
@@ -149,7 +149,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 
 				req, _ = http.NewRequest(http.MethodPost, sendURL, strings.NewReader(form.Encode()))
 				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-				rr, err = utils.MakeHTTPRequest(req)
+				rr, err = utils.MakeHTTPRequest(req.WithContext(ctx))
 
 			}
 
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -177,6 +178,10 @@ type moPayload struct {
 				Watermark int64    `json:"watermark"`
 				Seq       int      `json:"seq"`
 			} `json:"delivery"`
+
+			Read *struct {
+				Watermark int64 `json:"watermark"`
+			} `json:"read"`
 		} `json:"messaging"`
 	} `json:"entry"`
 }
@@ -368,6 +373,27 @@ func (h *handler) receiveEvent(ctx context.Context, channel courier.Channel, w h
 				data = append(data, courier.NewStatusData(event))
 			}
 
+		} else if msg.Read != nil {
+			// this is a read receipt, these don't tell us which msg was read, only that everything we sent before the
+			// watermark has been, so mark those as read if our backend supports that
+			watermarker, isWatermarker := h.Backend().(courier.ReadWatermarker)
+			if !isWatermarker {
+				data = append(data, courier.NewInfoData("ignoring read receipt, backend doesn't support read watermarks"))
+				continue
+			}
+
+			watermark := time.Unix(0, msg.Read.Watermark*1000000).UTC()
+			statuses, err := watermarker.WriteMsgsReadBefore(ctx, channel, urn, watermark)
+			if err != nil {
+				return nil, err
+			}
+
+			data = append(data, courier.NewInfoData(fmt.Sprintf("marked %d msgs read", len(statuses))))
+			for _, status := range statuses {
+				events = append(events, status)
+				data = append(data, courier.NewStatusData(status))
+			}
+
 		} else {
 			data = append(data, courier.NewInfoData("ignoring unknown entry type"))
 		}
@@ -489,12 +515,15 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 		req, _ := http.NewRequest(http.MethodPost, msgURL.String(), bytes.NewReader(jsonBody))
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("Accept", "application/json")
-		rr, err := utils.MakeHTTPRequest(req)
+		rr, err := utils.MakeHTTPRequest(req.WithContext(ctx))
 
 		// record our status and log
 		log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
 		status.AddLog(log)
 		if err != nil {
+			if rr.Status == utils.RRStatusFailure {
+				status.SetSendError(newSendError(rr))
+			}
 			return status, nil
 		}
 
@@ -554,6 +583,58 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 	return status, nil
 }
 
+// mapping from Facebook error codes and subcodes to our own, see
+// https://developers.facebook.com/docs/messenger-platform/reference/send-api/error-codes
+var fbErrorCodes = map[int64]courier.ErrorCode{
+	4:   courier.ErrorRateLimited,          // application request limit reached
+	10:  courier.ErrorAuthentication,       // permission denied
+	17:  courier.ErrorRateLimited,          // user request limit reached
+	32:  courier.ErrorRateLimited,          // page request limit reached
+	100: courier.ErrorInvalidContent,       // invalid parameter
+	102: courier.ErrorAuthentication,       // session expired
+	190: courier.ErrorAuthentication,       // invalid access token
+	200: courier.ErrorAuthentication,       // permission error
+	551: courier.ErrorRecipientUnreachable, // this person isn't available right now
+	613: courier.ErrorRateLimited,          // calls exceeded the rate limit
+}
+
+var fbErrorSubcodes = map[int64]courier.ErrorCode{
+	1545041: courier.ErrorRecipientUnreachable, // recipient is not available
+	2018001: courier.ErrorInvalidRecipient,     // no matching user found
+	2018108: courier.ErrorRecipientUnreachable, // user can't receive messages from this page
+	2018278: courier.ErrorRecipientUnreachable, // sent outside of the allowed window
+}
+
+// newSendError maps the error returned by Facebook for a send to a send error
+func newSendError(rr *utils.RequestResponse) *courier.SendError {
+	code, _ := jsonparser.GetInt(rr.Body, "error", "code")
+	subcode, _ := jsonparser.GetInt(rr.Body, "error", "error_subcode")
+	message, _ := jsonparser.GetString(rr.Body, "error", "message")
+
+	if code == 0 {
+		return handlers.NewSendErrorFromStatus(rr, message)
+	}
+
+	providerCode := strconv.FormatInt(code, 10)
+	if subcode != 0 {
+		providerCode = fmt.Sprintf("%d/%d", code, subcode)
+	}
+
+	errorCode, found := fbErrorSubcodes[subcode]
+	if !found {
+		errorCode, found = fbErrorCodes[code]
+	}
+	if !found {
+		errorCode = courier.ErrorProvider
+	}
+
+	sendErr := courier.NewSendError(errorCode, providerCode, message)
+	if errorCode == courier.ErrorRateLimited {
+		sendErr.RetryAfter = handlers.RetryAfter(rr)
+	}
+	return sendErr
+}
+
 // ReceiveVerify handles Facebook's webhook verification callback
 func (h *handler) DescribeURN(ctx context.Context, channel courier.Channel, urn urns.URN) (map[string]string, error) {
 	// can't do anything with facebook refs, ignore them
@@ -328,6 +328,26 @@ var dlr = `{
 	}]
 }`
 
+var read = `{
+	"object":"page",
+	"entry": [{
+	  "id": "208685479508187",
+	  "messaging": [{
+			"read":{
+				"watermark":1458668856253
+			},
+			"recipient": {
+			  "id": "1234"
+			},
+			"sender": {
+			  "id": "5678"
+			},
+			"timestamp": 1459991487970
+	  }],
+	  "time": 1459991487970
+	}]
+}`
+
 var notPage = `{
 	"object":"notpage",
 	"entry": [{}]
@@ -392,6 +412,8 @@ var testCases = []ChannelHandleTestCase{
 
 	{Label: "Receive DLR", URL: "/c/fb/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive", Data: dlr, Status: 200, Response: "Handled",
 		Date: Tp(time.Date(2016, 4, 7, 1, 11, 27, 970000000, time.UTC)), MsgStatus: Sp(courier.MsgDelivered), ExternalID: Sp("mid.1458668856218:ed81099e15d3f4f233")},
+	{Label: "Receive Read", URL: "/c/fb/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive", Data: read, Status: 200, Response: "marked 0 msgs read",
+		URN: Sp("facebook:5678"), ReadWatermark: Tp(time.Unix(0, 1458668856253*1000000).UTC())},
 
 	{Label: "Different Page", URL: "/c/fb/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive", Data: differentPage, Status: 200, Response: `"data":[]`},
 	{Label: "Echo", URL: "/c/fb/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive", Data: echo, Status: 200, Response: `ignoring echo`},
@@ -550,6 +572,25 @@ var defaultSendTestCases = []ChannelSendTestCase{
 		Text: "Error", URN: "facebook:12345",
 		Status:       "E",
 		ResponseBody: `{ "is_error": true }`, ResponseStatus: 403,
+		ErrorCode: "auth_failure", ProviderErrorCode: "403",
+		SendPrep: setSendURL},
+	{Label: "Recipient Unavailable",
+		Text: "Error", URN: "facebook:12345",
+		Status:       "E",
+		ResponseBody: `{ "error": {"message": "(#100) No matching user found", "code": 100, "error_subcode": 2018001} }`, ResponseStatus: 400,
+		ErrorCode: "invalid_recipient", ProviderErrorCode: "100/2018001",
+		SendPrep: setSendURL},
+	{Label: "Rate Limited",
+		Text: "Error", URN: "facebook:12345",
+		Status:       "E",
+		ResponseBody: `{ "error": {"message": "(#613) Calls to this api have exceeded the rate limit.", "code": 613} }`, ResponseStatus: 400,
+		ErrorCode: "rate_limited", ProviderErrorCode: "613",
+		SendPrep: setSendURL},
+	{Label: "Invalid Token",
+		Text: "Error", URN: "facebook:12345",
+		Status:       "E",
+		ResponseBody: `{ "error": {"message": "Invalid OAuth access token.", "type": "OAuthException", "code": 190} }`, ResponseStatus: 400,
+		ErrorCode: "auth_failure", ProviderErrorCode: "190",
 		SendPrep: setSendURL},
 }
 
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -40,6 +41,12 @@ func (h *handler) Initialize(s courier.Server) error {
 	return nil
 }
 
+// the status groups Infobip returns for msgs which will never be delivered
+const (
+	ibGroupUndeliverable = 2
+	ibGroupRejected      = 5
+)
+
 var statusMapping = map[string]courier.MsgStatusValue{
 	"PENDING":       courier.MsgSent,
 	"EXPIRED":       courier.MsgSent,
@@ -175,6 +182,45 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 	return handlers.WriteMsgsAndResponse(ctx, h, msgs, w, r)
 }
 
+// mapping from the status names returned by Infobip to our error codes
+var ibErrorCodes = map[string]courier.ErrorCode{
+	"REJECTED_FLOODING_FILTER":            courier.ErrorRateLimited,
+	"REJECTED_NOT_ENOUGH_CREDITS":         courier.ErrorAccount,
+	"REJECTED_PREPAID_PACKAGE_EXPIRED":    courier.ErrorAccount,
+	"REJECTED_SOURCE":                     courier.ErrorAccount,
+	"REJECTED_SENDER":                     courier.ErrorAccount,
+	"REJECTED_ROUTE_NOT_AVAILABLE":        courier.ErrorAccount,
+	"REJECTED_DESTINATION":                courier.ErrorInvalidRecipient,
+	"REJECTED_DESTINATION_NOT_REGISTERED": courier.ErrorInvalidRecipient,
+	"REJECTED_PREFIX_MISSING":             courier.ErrorInvalidRecipient,
+	"MISSING_TO":                          courier.ErrorInvalidRecipient,
+	"REJECTED_DND":                        courier.ErrorRecipientOptedOut,
+	"REJECTED_NETWORK":                    courier.ErrorRecipientUnreachable,
+}
+
+// newSendError maps the status group and name returned by Infobip for a send to a send error, see
+// https://dev.infobip.com/getting-started/response-status-and-error-codes
+func newSendError(rr *utils.RequestResponse, groupID int64) *courier.SendError {
+	name, _ := jsonparser.GetString(rr.Body, "messages", "[0]", "status", "name")
+	description, _ := jsonparser.GetString(rr.Body, "messages", "[0]", "status", "description")
+
+	code := name
+	if code == "" {
+		code = strconv.FormatInt(groupID, 10)
+	}
+
+	errorCode, found := ibErrorCodes[name]
+	if !found {
+		switch groupID {
+		case ibGroupUndeliverable, ibGroupRejected:
+			errorCode = courier.ErrorRecipientUnreachable
+		default:
+			errorCode = courier.ErrorProvider
+		}
+	}
+	return courier.NewSendError(errorCode, code, description)
+}
+
 // SendMsg sends the passed in message, returning any error
 func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStatus, error) {
 	username := msg.Channel().StringConfigForKey(courier.ConfigUsername, "")
@@ -222,7 +268,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
 	req.SetBasicAuth(username, password)
-	rr, err := utils.MakeHTTPRequest(req)
+	rr, err := utils.MakeHTTPRequest(req.WithContext(ctx))
 
 	// record our status and log
 	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
@@ -230,12 +276,16 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 	status.AddLog(log)
 	if err != nil {
 		log.WithError("Message Send Error", err)
+		status.SetSendError(handlers.NewSendErrorFromStatus(rr, ""))
 		return status, nil
 	}
 
 	groupID, err := jsonparser.GetInt(rr.Body, "messages", "[0]", "status", "groupId")
 	if err != nil || (groupID != 1 && groupID != 3) {
 		log.WithError("Message Send Error", errors.Errorf("received error status: '%d'", groupID))
+		if err == nil {
+			status.SetSendError(newSendError(rr, groupID))
+		}
 		return status, nil
 	}
 
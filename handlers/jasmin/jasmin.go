@@ -39,6 +39,11 @@ func (h *handler) Initialize(s courier.Server) error {
 	return nil
 }
 
+// RetryPolicy returns how errored msgs are retried on Jasmin channels, the same as other SMSC gateways
+func (h *handler) RetryPolicy() courier.RetryPolicy {
+	return courier.SMSCRetryPolicy
+}
+
 type statusForm struct {
 	ID        string `name:"id"     validate:"required"`
 	Delivered int    `name:"dlvrd"`
@@ -159,7 +164,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 	fullURL.RawQuery = form.Encode()
 
 	req, _ := http.NewRequest(http.MethodGet, fullURL.String(), nil)
-	rr, err := utils.MakeHTTPRequest(req)
+	rr, err := utils.MakeHTTPRequest(req.WithContext(ctx))
 
 	// record our status and log
 	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
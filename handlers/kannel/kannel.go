@@ -50,6 +50,11 @@ func (h *handler) Initialize(s courier.Server) error {
 	return nil
 }
 
+// RetryPolicy returns how errored msgs are retried on Kannel channels, the same as other SMSC gateways
+func (h *handler) RetryPolicy() courier.RetryPolicy {
+	return courier.SMSCRetryPolicy
+}
+
 type moForm struct {
 	ID      string `validate:"required" name:"id"`
 	TS      int64  `validate:"required" name:"ts"`
@@ -196,9 +201,9 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 	var rr *utils.RequestResponse
 
 	if verifySSL {
-		rr, err = utils.MakeHTTPRequest(req)
+		rr, err = utils.MakeHTTPRequest(req.WithContext(ctx))
 	} else {
-		rr, err = utils.MakeInsecureHTTPRequest(req)
+		rr, err = utils.MakeInsecureHTTPRequest(req.WithContext(ctx))
 	}
 
 	// record our status and log
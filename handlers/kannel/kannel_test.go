@@ -7,6 +7,7 @@ import (
 
 	"github.com/nyaruka/courier"
 	. "github.com/nyaruka/courier/handlers"
+	"github.com/stretchr/testify/assert"
 )
 
 var (
@@ -111,6 +112,16 @@ var nationalSendTestCases = []ChannelSendTestCase{
 		SendPrep:  setSendURL},
 }
 
+func TestRetryPolicy(t *testing.T) {
+	// our channels retry more times than our default, over about four hours
+	policy := courier.GetRetryPolicy(testChannels[0])
+	assert.Equal(t, 8, policy.MaxAttempts)
+	assert.Equal(t, 0.1, policy.Jitter)
+
+	policy.Jitter = 0
+	assert.Equal(t, []time.Duration{2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 16 * time.Minute, 32 * time.Minute, time.Hour, time.Hour}, policy.Delays())
+}
+
 func TestSending(t *testing.T) {
 	var defaultChannel = courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "KN", "2020", "US",
 		map[string]interface{}{
@@ -58,6 +58,7 @@ type statusForm struct {
 	To        string `name:"to"`
 	MessageID string `name:"messageID"`
 	Status    string `name:"status"`
+	ErrCode   string `name:"err-code"`
 }
 
 var statusMappings = map[string]courier.MsgStatusValue{
@@ -86,6 +87,15 @@ func (h *handler) receiveStatus(ctx context.Context, channel courier.Channel, w
 
 	status := h.Backend().NewMsgStatusForExternalID(channel, form.MessageID, msgStatus)
 
+	// record why this msg wasn't delivered if nexmo told us
+	if form.ErrCode != "" && form.ErrCode != "0" {
+		errorCode, found := dlrErrorCodes[form.ErrCode]
+		if !found {
+			errorCode = courier.ErrorProvider
+		}
+		status.SetSendError(courier.NewSendError(errorCode, form.ErrCode, ""))
+	}
+
 	return handlers.WriteMsgStatusAndResponse(ctx, h, channel, status, w, r)
 }
 
@@ -117,6 +127,69 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 	return handlers.WriteMsgsAndResponse(ctx, h, []courier.Msg{msg}, w, r)
 }
 
+// mapping from the statuses Nexmo returns when sending to our error codes, see https://developer.nexmo.com/api/sms#status-codes
+var sendErrorCodes = map[string]courier.ErrorCode{
+	"1":  courier.ErrorRateLimited,          // throttled
+	"2":  courier.ErrorInvalidContent,       // missing parameters
+	"3":  courier.ErrorInvalidContent,       // invalid parameters
+	"4":  courier.ErrorAuthentication,       // invalid credentials
+	"5":  courier.ErrorProvider,             // internal error
+	"6":  courier.ErrorInvalidContent,       // invalid message
+	"7":  courier.ErrorRecipientUnreachable, // number barred
+	"8":  courier.ErrorAccount,              // partner account barred
+	"9":  courier.ErrorAccount,              // partner quota exceeded
+	"10": courier.ErrorRateLimited,          // too many existing binds
+	"11": courier.ErrorAccount,              // account not enabled for REST
+	"12": courier.ErrorContentTooLong,       // message too long
+	"14": courier.ErrorAuthentication,       // invalid signature
+	"15": courier.ErrorAccount,              // invalid sender address
+	"22": courier.ErrorInvalidRecipient,     // invalid network code
+	"29": courier.ErrorInvalidRecipient,     // non-whitelisted destination
+	"32": courier.ErrorAuthentication,       // signature and API secret disallowed
+	"33": courier.ErrorInvalidRecipient,     // number de-activated
+}
+
+// mapping from the error codes in Nexmo delivery receipts to ours, see https://developer.nexmo.com/messaging/sms/guides/delivery-receipts
+var dlrErrorCodes = map[string]courier.ErrorCode{
+	"1":  courier.ErrorUnknown,              // unknown
+	"2":  courier.ErrorRecipientUnreachable, // absent subscriber, temporary
+	"3":  courier.ErrorInvalidRecipient,     // absent subscriber, permanent
+	"4":  courier.ErrorRecipientOptedOut,    // call barred by user
+	"5":  courier.ErrorProvider,             // portability error
+	"6":  courier.ErrorInvalidContent,       // anti-spam rejection
+	"7":  courier.ErrorRecipientUnreachable, // handset busy
+	"8":  courier.ErrorProvider,             // network error
+	"9":  courier.ErrorInvalidRecipient,     // illegal number
+	"10": courier.ErrorInvalidContent,       // illegal message
+	"11": courier.ErrorRecipientUnreachable, // unroutable
+	"12": courier.ErrorRecipientUnreachable, // destination unreachable
+	"13": courier.ErrorRecipientUnreachable, // subscriber age restriction
+	"14": courier.ErrorRecipientUnreachable, // number blocked by carrier
+	"15": courier.ErrorRecipientUnreachable, // prepaid insufficient funds
+	"99": courier.ErrorProvider,             // general error
+}
+
+// newSendError maps the status returned by Nexmo for a send to a send error
+func newSendError(rr *utils.RequestResponse, nexmoStatus string) *courier.SendError {
+	errorText, _ := jsonparser.GetString([]byte(rr.Body), "messages", "[0]", "error-text")
+
+	errorCode, found := sendErrorCodes[nexmoStatus]
+	if !found {
+		errorCode = courier.ErrorProvider
+	}
+	sendErr := courier.NewSendError(errorCode, nexmoStatus, errorText)
+
+	// use the delay Nexmo asked us to wait if it gave one
+	if errorCode == courier.ErrorRateLimited {
+		matched := throttledRE.FindStringSubmatch(string(rr.Body))
+		if len(matched) > 1 {
+			wait, _ := strconv.Atoi(matched[1])
+			sendErr.RetryAfter = time.Duration(wait) * time.Millisecond
+		}
+	}
+	return sendErr
+}
+
 // SendMsg sends the passed in message, returning any error
 func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStatus, error) {
 	nexmoAPIKey := msg.Channel().StringConfigForKey(configNexmoAPIKey, "")
@@ -159,7 +232,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 			req, _ := http.NewRequest(http.MethodPost, sendURL, strings.NewReader(form.Encode()))
 			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-			rr, requestErr = utils.MakeHTTPRequest(req)
+			rr, requestErr = utils.MakeHTTPRequest(req.WithContext(ctx))
 			matched := throttledRE.FindAllStringSubmatch(string([]byte(rr.Body)), -1)
 			if len(matched) > 0 && len(matched[0]) > 0 {
 				sleepTime, _ := strconv.Atoi(matched[0][1])
@@ -174,12 +247,18 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 		status.AddLog(log)
 		if requestErr != nil {
 			log.WithError("Message Send Error", requestErr)
+			if rr.StatusCode == http.StatusUnauthorized {
+				status.SetSendError(handlers.NewSendErrorFromStatus(rr, string(rr.Body)))
+			}
 			return status, nil
 		}
 
 		nexmoStatus, err := jsonparser.GetString([]byte(rr.Body), "messages", "[0]", "status")
 		if err != nil || nexmoStatus != "0" {
 			log.WithError("Message Send Error", errors.Errorf("failed to send message, received error status [%s]", nexmoStatus))
+			if nexmoStatus != "" {
+				status.SetSendError(newSendError(rr, nexmoStatus))
+			}
 			return status, nil
 		}
 
@@ -3,6 +3,7 @@ package nexmo
 import (
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/nyaruka/courier"
 	. "github.com/nyaruka/courier/handlers"
@@ -22,7 +23,7 @@ var (
 
 	statusDelivered  = "/c/nx/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/status?to=2020&messageId=external1&status=delivered"
 	statusExpired    = "/c/nx/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/status?to=2020&messageId=external1&status=expired"
-	statusFailed     = "/c/nx/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/status?to=2020&messageId=external1&status=failed"
+	statusFailed     = "/c/nx/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/status?to=2020&messageId=external1&status=failed&err-code=9"
 	statusAccepted   = "/c/nx/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/status?to=2020&messageId=external1&status=accepted"
 	statusBuffered   = "/c/nx/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/status?to=2020&messageId=external1&status=buffered"
 	statusUnexpected = "/c/nx/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/status?to=2020&messageId=external1&status=unexpected"
@@ -39,7 +40,8 @@ var testCases = []ChannelHandleTestCase{
 
 	{Label: "Status delivered", URL: statusDelivered, Status: 200, Response: `"status":"D"`, ExternalID: Sp("external1")},
 	{Label: "Status expired", URL: statusExpired, Status: 200, Response: `"status":"F"`, ExternalID: Sp("external1")},
-	{Label: "Status failed", URL: statusFailed, Status: 200, Response: `"status":"F"`, ExternalID: Sp("external1")},
+	{Label: "Status failed", URL: statusFailed, Status: 200, Response: `"status":"F"`, ExternalID: Sp("external1"),
+		MsgErrorCode: Sp("invalid_recipient")},
 	{Label: "Status accepted", URL: statusAccepted, Status: 200, Response: `"status":"S"`, ExternalID: Sp("external1")},
 	{Label: "Status buffered", URL: statusBuffered, Status: 200, Response: `"status":"S"`, ExternalID: Sp("external1")},
 	{Label: "Status unexpected", URL: statusUnexpected, Status: 200, Response: "ignoring unknown status report", ExternalID: Sp("external1")},
@@ -89,6 +91,14 @@ var defaultSendTestCases = []ChannelSendTestCase{
 		Status:       "E",
 		PostParams:   map[string]string{"text": "Error status", "to": "250788383383", "from": "2020", "api_key": "nexmo-api-key", "api_secret": "nexmo-api-secret", "status-report-req": "1", "type": "text"},
 		ResponseBody: `{"messages":[{"status":"10"}]}`, ResponseStatus: 200,
+		ErrorCode: "rate_limited", ProviderErrorCode: "10",
+		SendPrep: setSendURL},
+	{Label: "Barred Number",
+		Text: "Error status", URN: "tel:+250788383383",
+		Status:       "E",
+		PostParams:   map[string]string{"text": "Error status", "to": "250788383383", "from": "2020", "api_key": "nexmo-api-key", "api_secret": "nexmo-api-secret", "status-report-req": "1", "type": "text"},
+		ResponseBody: `{"messages":[{"status":"7","error-text":"Number barred."}]}`, ResponseStatus: 200,
+		ErrorCode: "recipient_unreachable", ProviderErrorCode: "7",
 		SendPrep: setSendURL},
 	{Label: "Error Sending",
 		Text: "Error Message", URN: "tel:+250788383383",
@@ -101,12 +111,14 @@ var defaultSendTestCases = []ChannelSendTestCase{
 		Status:       "E",
 		PostParams:   map[string]string{"text": "Simple Message", "to": "250788383383", "from": "2020", "api_key": "nexmo-api-key", "api_secret": "nexmo-api-secret", "status-report-req": "1", "type": "text"},
 		ResponseBody: "Invalid API token", ResponseStatus: 401,
+		ErrorCode: "auth_failure", ProviderErrorCode: "401",
 		SendPrep: setSendURL},
 	{Label: "Throttled by Nexmo",
 		Text: "Simple Message", URN: "tel:+250788383383",
 		Status:       "E",
 		PostParams:   map[string]string{"text": "Simple Message", "to": "250788383383", "from": "2020", "api_key": "nexmo-api-key", "api_secret": "nexmo-api-secret", "status-report-req": "1", "type": "text"},
 		ResponseBody: `{"messages":[{"status":"1","error-text":"Throughput Rate Exceeded - please wait [ 250 ] and retry"}]}`, ResponseStatus: 200,
+		ErrorCode: "rate_limited", ProviderErrorCode: "1", RetryAfter: time.Millisecond * 250,
 		SendPrep: setSendURL},
 }
 
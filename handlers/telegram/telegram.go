@@ -131,7 +131,7 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 	return handlers.WriteMsgsAndResponse(ctx, h, []courier.Msg{msg}, w, r)
 }
 
-func (h *handler) sendMsgPart(msg courier.Msg, token string, path string, form url.Values, replies string) (string, *courier.ChannelLog, error) {
+func (h *handler) sendMsgPart(ctx context.Context, msg courier.Msg, token string, path string, form url.Values, replies string) (string, *courier.ChannelLog, error) {
 	// either include or remove our keyboard depending on whether we have quick replies
 	if replies == "" {
 		form.Add("reply_markup", `{"remove_keyboard":true}`)
@@ -143,7 +143,7 @@ func (h *handler) sendMsgPart(msg courier.Msg, token string, path string, form u
 	req, _ := http.NewRequest(http.MethodPost, sendURL, strings.NewReader(form.Encode()))
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
-	rr, err := utils.MakeHTTPRequest(req)
+	rr, err := utils.MakeHTTPRequest(req.WithContext(ctx))
 
 	// build our channel log
 	log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
@@ -151,6 +151,9 @@ func (h *handler) sendMsgPart(msg courier.Msg, token string, path string, form u
 	// was this request successful?
 	ok, err := jsonparser.GetBoolean([]byte(rr.Body), "ok")
 	if err != nil || !ok {
+		if _, err := jsonparser.GetInt([]byte(rr.Body), "error_code"); err == nil {
+			return "", log, newSendError(rr)
+		}
 		return "", log, errors.Errorf("response not 'ok'")
 	}
 
@@ -163,6 +166,42 @@ func (h *handler) sendMsgPart(msg courier.Msg, token string, path string, form u
 	return strconv.FormatInt(externalID, 10), log, nil
 }
 
+// newSendError maps the error code and description returned by Telegram for a send to a send error
+func newSendError(rr *utils.RequestResponse) *courier.SendError {
+	errorCode, _ := jsonparser.GetInt([]byte(rr.Body), "error_code")
+	description, _ := jsonparser.GetString([]byte(rr.Body), "description")
+	code := strconv.FormatInt(errorCode, 10)
+
+	switch errorCode {
+	case http.StatusForbidden:
+		// bot was blocked or kicked, the user is deactivated or we can't initiate a conversation with them
+		return courier.NewSendError(courier.ErrorRecipientUnreachable, code, description)
+	case http.StatusUnauthorized, http.StatusNotFound:
+		// telegram returns a 404 for bot tokens which don't exist
+		return courier.NewAuthFailureError(code, description)
+	case http.StatusTooManyRequests:
+		retryAfter, _ := jsonparser.GetInt([]byte(rr.Body), "parameters", "retry_after")
+		return courier.NewRateLimitedError(code, description, time.Duration(retryAfter)*time.Second)
+	case http.StatusBadRequest:
+		if strings.Contains(description, "chat not found") || strings.Contains(description, "user not found") {
+			return courier.NewPermanentRecipientError(code, description)
+		}
+		if strings.Contains(description, "too long") {
+			return courier.NewSendError(courier.ErrorContentTooLong, code, description)
+		}
+		return courier.NewPermanentContentError(code, description)
+	}
+
+	return courier.NewTransientError(code, description)
+}
+
+// setSendError records the typed send error behind the passed in error, if any, on our status
+func setSendError(status courier.MsgStatus, err error) {
+	if sendErr, isSendErr := courier.AsSendError(err); isSendErr {
+		status.SetSendError(sendErr)
+	}
+}
+
 // SendMsg sends the passed in message, returning any error
 func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStatus, error) {
 	confAuth := msg.Channel().ConfigForKey(courier.ConfigAuthToken, "")
@@ -208,10 +247,11 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 			"text":    []string{msg.Text()},
 		}
 
-		externalID, log, err := h.sendMsgPart(msg, authToken, "sendMessage", form, replies)
+		externalID, log, err := h.sendMsgPart(ctx, msg, authToken, "sendMessage", form, replies)
 		status.SetExternalID(externalID)
 		hasError = err != nil
 		status.AddLog(log)
+		setSendError(status, err)
 
 		// clear our replies, they've been sent
 		replies = ""
@@ -227,10 +267,11 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 				"photo":   []string{mediaURL},
 				"caption": []string{caption},
 			}
-			externalID, log, err := h.sendMsgPart(msg, authToken, "sendPhoto", form, replies)
+			externalID, log, err := h.sendMsgPart(ctx, msg, authToken, "sendPhoto", form, replies)
 			status.SetExternalID(externalID)
 			hasError = err != nil
 			status.AddLog(log)
+			setSendError(status, err)
 
 		case "video":
 			form := url.Values{
@@ -238,10 +279,11 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 				"video":   []string{mediaURL},
 				"caption": []string{caption},
 			}
-			externalID, log, err := h.sendMsgPart(msg, authToken, "sendVideo", form, replies)
+			externalID, log, err := h.sendMsgPart(ctx, msg, authToken, "sendVideo", form, replies)
 			status.SetExternalID(externalID)
 			hasError = err != nil
 			status.AddLog(log)
+			setSendError(status, err)
 
 		case "audio":
 			form := url.Values{
@@ -249,10 +291,11 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 				"audio":   []string{mediaURL},
 				"caption": []string{caption},
 			}
-			externalID, log, err := h.sendMsgPart(msg, authToken, "sendAudio", form, replies)
+			externalID, log, err := h.sendMsgPart(ctx, msg, authToken, "sendAudio", form, replies)
 			status.SetExternalID(externalID)
 			hasError = err != nil
 			status.AddLog(log)
+			setSendError(status, err)
 
 		default:
 			status.AddLog(courier.NewChannelLog("Unknown media type: "+mediaType, msg.Channel(), msg.ID(), "", "", courier.NilStatusCode,
@@ -596,6 +596,20 @@ var defaultSendTestCases = []ChannelSendTestCase{
 		ResponseBody: `{ "ok": false }`, ResponseStatus: 403,
 		PostParams: map[string]string{"text": `Error`, "chat_id": "12345"},
 		SendPrep:   setSendURL},
+	{Label: "Bot Blocked",
+		Text: "Blocked", URN: "telegram:12345",
+		Status:       "E",
+		ResponseBody: `{ "ok": false, "error_code": 403, "description": "Forbidden: bot was blocked by the user" }`, ResponseStatus: 403,
+		PostParams: map[string]string{"text": `Blocked`, "chat_id": "12345"},
+		ErrorCode:  "recipient_unreachable", ProviderErrorCode: "403",
+		SendPrep: setSendURL},
+	{Label: "Too Many Requests",
+		Text: "Slow Down", URN: "telegram:12345",
+		Status:       "E",
+		ResponseBody: `{ "ok": false, "error_code": 429, "description": "Too Many Requests: retry after 12", "parameters": { "retry_after": 12 } }`, ResponseStatus: 429,
+		PostParams: map[string]string{"text": `Slow Down`, "chat_id": "12345"},
+		ErrorCode:  "rate_limited", ProviderErrorCode: "429", RetryAfter: time.Second * 12,
+		SendPrep: setSendURL},
 	{Label: "Send Photo",
 		Text: "My pic!", URN: "telegram:12345", Attachments: []string{"image/jpeg:https://foo.bar/image.jpg"},
 		Status:       "W",
@@ -41,7 +41,9 @@ type ChannelHandleTestCase struct {
 	Attachments []string
 	Date        *time.Time
 
-	MsgStatus *string
+	MsgStatus     *string
+	MsgErrorCode  *string
+	ReadWatermark *time.Time
 
 	ChannelEvent      *string
 	ChannelEventExtra map[string]interface{}
@@ -83,9 +85,10 @@ type ChannelSendTestCase struct {
 	ResponseToExternalID string
 	Metadata             json.RawMessage
 
-	ResponseStatus int
-	ResponseBody   string
-	Responses      map[MockedRequest]MockedResponse
+	ResponseStatus  int
+	ResponseBody    string
+	ResponseHeaders map[string]string
+	Responses       map[MockedRequest]MockedResponse
 
 	Path        string
 	URLParams   map[string]string
@@ -97,6 +100,10 @@ type ChannelSendTestCase struct {
 	Status     string
 	ExternalID string
 
+	ErrorCode         string
+	ProviderErrorCode string
+	RetryAfter        time.Duration
+
 	Stopped bool
 
 	ContactURNs map[string]bool
@@ -211,6 +218,9 @@ func RunChannelSendTestCases(t *testing.T, channel courier.Channel, handler cour
 				testRequest = httptest.NewRequest(r.Method, r.URL.String(), bytes.NewBuffer(body))
 				testRequest.Header = r.Header
 				if (len(testCase.Responses)) == 0 {
+					for k, v := range testCase.ResponseHeaders {
+						w.Header().Set(k, v)
+					}
 					w.WriteHeader(testCase.ResponseStatus)
 					w.Write([]byte(testCase.ResponseBody))
 				} else {
@@ -296,6 +306,16 @@ func RunChannelSendTestCases(t *testing.T, channel courier.Channel, handler cour
 				require.Equal(testCase.Status, string(status.Status()))
 			}
 
+			if testCase.ErrorCode != "" {
+				require.NotNil(status, "status should not be nil")
+				require.NotNil(status.SendError(), "send error should not be nil")
+				require.Equal(testCase.ErrorCode, string(status.SendError().Code))
+				if testCase.ProviderErrorCode != "" {
+					require.Equal(testCase.ProviderErrorCode, status.SendError().ProviderCode)
+				}
+				require.Equal(testCase.RetryAfter, status.SendError().RetryAfter)
+			}
+
 			if testCase.Stopped {
 				evt, err := mb.GetLastChannelEvent()
 				require.NoError(err)
@@ -347,6 +367,7 @@ func RunChannelTestCases(t *testing.T, channels []courier.Channel, handler couri
 			msg, _ := mb.GetLastQueueMsg()
 			event, _ := mb.GetLastChannelEvent()
 			status, _ := mb.GetLastMsgStatus()
+			watermark := mb.GetLastReadWatermark()
 
 			if testCase.Status == 200 {
 				if testCase.Name != nil {
@@ -368,6 +389,8 @@ func RunChannelTestCases(t *testing.T, channels []courier.Channel, handler couri
 						require.Equal(*testCase.URN, string(msg.URN()))
 					} else if event != nil {
 						require.Equal(*testCase.URN, string(event.URN()))
+					} else if testCase.ReadWatermark != nil && watermark != nil {
+						require.Equal(*testCase.URN, string(watermark.URN))
 					} else {
 						require.Equal(*testCase.URN, "")
 					}
@@ -390,6 +413,15 @@ func RunChannelTestCases(t *testing.T, channels []courier.Channel, handler couri
 					require.NotNil(status)
 					require.Equal(*testCase.MsgStatus, string(status.Status()))
 				}
+				if testCase.ReadWatermark != nil {
+					require.NotNil(watermark)
+					require.Equal(*testCase.ReadWatermark, watermark.Watermark)
+				}
+				if testCase.MsgErrorCode != nil {
+					require.NotNil(status)
+					require.NotNil(status.SendError(), "status error should not be nil")
+					require.Equal(*testCase.MsgErrorCode, string(status.SendError().Code))
+				}
 				if testCase.ID != 0 {
 					if status != nil {
 						require.Equal(testCase.ID, int64(status.ID()))
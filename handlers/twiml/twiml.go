@@ -46,6 +46,57 @@ var (
 // error code twilio returns when a contact has sent "stop"
 const errorStopped = 21610
 
+// mapping from Twilio error codes to our own, see https://www.twilio.com/docs/api/errors
+var twilioErrorCodes = map[int64]courier.ErrorCode{
+	14107:        courier.ErrorRateLimited,          // send rate limit exceeded
+	20003:        courier.ErrorAuthentication,       // authentication error
+	20005:        courier.ErrorAccount,              // account not active
+	20429:        courier.ErrorRateLimited,          // too many requests
+	21211:        courier.ErrorInvalidRecipient,     // invalid 'To' number
+	21212:        courier.ErrorAccount,              // invalid 'From' number
+	21217:        courier.ErrorInvalidRecipient,     // phone number does not appear to be valid
+	21401:        courier.ErrorInvalidRecipient,     // invalid phone number
+	21407:        courier.ErrorInvalidRecipient,     // number type not supported
+	21408:        courier.ErrorAccount,              // permission to send to region not enabled
+	21602:        courier.ErrorInvalidContent,       // message body is required
+	errorStopped: courier.ErrorRecipientOptedOut,    // contact has sent stop
+	21612:        courier.ErrorRecipientUnreachable, // 'To' number is not currently reachable
+	21614:        courier.ErrorInvalidRecipient,     // 'To' number is not a valid mobile number
+	21617:        courier.ErrorContentTooLong,       // concatenated message body exceeds the limit
+	21619:        courier.ErrorInvalidContent,       // a text message body or media url must be specified
+	21620:        courier.ErrorInvalidMedia,         // invalid media url
+	21623:        courier.ErrorInvalidMedia,         // number of media files exceeds allowed limit
+	30003:        courier.ErrorRecipientUnreachable, // unreachable destination handset
+	30004:        courier.ErrorRecipientUnreachable, // message blocked
+	30005:        courier.ErrorInvalidRecipient,     // unknown destination handset
+	30006:        courier.ErrorRecipientUnreachable, // landline or unreachable carrier
+	30007:        courier.ErrorInvalidContent,       // carrier violation, filtered as spam
+	30008:        courier.ErrorProvider,             // unknown error
+}
+
+// newSendError maps the error code returned by Twilio, or failing that the HTTP status of the response, to a send error
+func newSendError(rr *utils.RequestResponse, code int64) *courier.SendError {
+	message, _ := jsonparser.GetString(rr.Body, "message")
+	if code == 0 {
+		return handlers.NewSendErrorFromStatus(rr, message)
+	}
+
+	sendErr := newSendErrorForCode(code, message)
+	if sendErr.Code == courier.ErrorRateLimited {
+		sendErr.RetryAfter = handlers.RetryAfter(rr)
+	}
+	return sendErr
+}
+
+// newSendErrorForCode creates a send error for the passed in Twilio error code
+func newSendErrorForCode(code int64, message string) *courier.SendError {
+	errorCode, found := twilioErrorCodes[code]
+	if !found {
+		errorCode = courier.ErrorProvider
+	}
+	return courier.NewSendError(errorCode, strconv.FormatInt(code, 10), message)
+}
+
 type handler struct {
 	handlers.BaseHandler
 	validateSignatures bool
@@ -181,6 +232,12 @@ func (h *handler) receiveStatus(ctx context.Context, channel courier.Channel, w
 	if status == nil {
 		status = h.Backend().NewMsgStatusForExternalID(channel, form.MessageSID, msgStatus)
 	}
+
+	// record why this msg failed if twilio told us
+	errorCode, _ := strconv.ParseInt(form.ErrorCode, 10, 64)
+	if errorCode != 0 {
+		status.SetSendError(newSendErrorForCode(errorCode, ""))
+	}
 	return handlers.WriteMsgStatusAndResponse(ctx, h, channel, status, w, r)
 }
 
@@ -247,7 +304,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 		req.SetBasicAuth(accountSID, accountToken)
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 		req.Header.Set("Accept", "application/json")
-		rr, err := utils.MakeHTTPRequest(req)
+		rr, err := utils.MakeHTTPRequest(req.WithContext(ctx))
 
 		// record our status and log
 		log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
@@ -257,6 +314,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 		if err != nil && rr.Body != nil {
 			errorCode, _ := jsonparser.GetInt([]byte(rr.Body), "code")
 			if errorCode != 0 {
+				status.SetSendError(newSendError(rr, errorCode))
 				if errorCode == errorStopped {
 					status.SetStatus(courier.MsgFailed)
 
@@ -274,6 +332,9 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 
 		// fail if we received an error
 		if err != nil {
+			if rr.Status == utils.RRStatusFailure {
+				status.SetSendError(newSendError(rr, 0))
+			}
 			return status, nil
 		}
 
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"fmt"
 
@@ -56,6 +57,7 @@ var (
 
 	statusInvalid = "MessageSid=SMe287d7109a5a925f182f0e07fe5b223b&MessageStatus=huh"
 	statusValid   = "MessageSid=SMe287d7109a5a925f182f0e07fe5b223b&MessageStatus=delivered"
+	statusFailed  = "MessageSid=SMe287d7109a5a925f182f0e07fe5b223b&MessageStatus=undelivered&ErrorCode=30006"
 
 	tmsStatusExtra  = "SmsStatus=sent&MessageStatus=sent&To=2021&MessagingServiceSid=MGdb23ec0f89ee2632e46e91d8128f5e2b&MessageSid=SM0b6e2697aae04182a9f5b5c7a8994c7f&AccountSid=acctid&From=%2B14133881111&ApiVersion=2010-04-01"
 	tmsReceiveExtra = "ToCountry=US&ToState=&SmsMessageSid=SMbbf29aeb9d380ce2a1c0ae4635ff9dab&NumMedia=0&ToCity=&FromZip=27609&SmsSid=SMbbf29aeb9d380ce2a1c0ae4635ff9dab&FromState=NC&SmsStatus=received&FromCity=RALEIGH&Body=John+Cruz&FromCountry=US&To=384387&ToZip=&NumSegments=1&MessageSid=SMbbf29aeb9d380ce2a1c0ae4635ff9dab&AccountSid=acctid&From=%2B14133881111&ApiVersion=2010-04-01"
@@ -91,6 +93,8 @@ var testCases = []ChannelHandleTestCase{
 		PrepRequest: addValidSignature},
 	{Label: "Status ID Invalid", URL: statusInvalidIDURL, Data: statusValid, Status: 200, Response: `"status":"D"`, ExternalID: Sp("SMe287d7109a5a925f182f0e07fe5b223b"),
 		PrepRequest: addValidSignature},
+	{Label: "Status Failed With Error Code", URL: statusURL, Data: statusFailed, Status: 200, Response: `"status":"F"`, ExternalID: Sp("SMe287d7109a5a925f182f0e07fe5b223b"),
+		MsgStatus: Sp("F"), MsgErrorCode: Sp("recipient_unreachable"), PrepRequest: addValidSignature},
 }
 
 var tmsTestCases = []ChannelHandleTestCase{
@@ -257,7 +261,8 @@ var defaultSendTestCases = []ChannelSendTestCase{
 		Status:       "E",
 		ResponseBody: `{ "error": "out of credits" }`, ResponseStatus: 401,
 		PostParams: map[string]string{"Body": "Error Message", "To": "+250788383383", "From": "2020", "StatusCallback": "https://localhost/c/t/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/status?id=10&action=callback"},
-		SendPrep:   setSendURL},
+		ErrorCode:  "auth_failure", ProviderErrorCode: "401",
+		SendPrep: setSendURL},
 	{Label: "Error Code",
 		Text: "Error Code", URN: "tel:+250788383383",
 		Status:       "E",
@@ -269,8 +274,24 @@ var defaultSendTestCases = []ChannelSendTestCase{
 		Status:       "F",
 		ResponseBody: `{ "code": 21610 }`, ResponseStatus: 400,
 		PostParams: map[string]string{"Body": "Stopped Contact", "To": "+250788383383", "From": "2020", "StatusCallback": "https://localhost/c/t/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/status?id=10&action=callback"},
-		SendPrep:   setSendURL,
-		Stopped:    true},
+		ErrorCode:  "recipient_opted_out", ProviderErrorCode: "21610",
+		SendPrep: setSendURL,
+		Stopped:  true},
+	{Label: "Invalid Number Code",
+		Text: "Invalid Number", URN: "tel:+250788383383",
+		Status:       "E",
+		ResponseBody: `{ "code": 21211, "message": "The 'To' number is not a valid phone number." }`, ResponseStatus: 400,
+		PostParams: map[string]string{"Body": "Invalid Number", "To": "+250788383383", "From": "2020", "StatusCallback": "https://localhost/c/t/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/status?id=10&action=callback"},
+		ErrorCode:  "invalid_recipient", ProviderErrorCode: "21211",
+		SendPrep: setSendURL},
+	{Label: "Rate Limited Code",
+		Text: "Rate Limited", URN: "tel:+250788383383",
+		Status:       "E",
+		ResponseBody: `{ "code": 20429, "message": "Too Many Requests" }`, ResponseStatus: 429,
+		ResponseHeaders: map[string]string{"Retry-After": "5"},
+		PostParams:      map[string]string{"Body": "Rate Limited", "To": "+250788383383", "From": "2020", "StatusCallback": "https://localhost/c/t/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/status?id=10&action=callback"},
+		ErrorCode:       "rate_limited", ProviderErrorCode: "20429", RetryAfter: time.Second * 5,
+		SendPrep: setSendURL},
 	{Label: "No SID",
 		Text: "No SID", URN: "tel:+250788383383",
 		Status:       "E",
@@ -297,7 +297,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 			mimeType, s3url := handlers.SplitAttachment(attachment)
 			mediaID := ""
 			if strings.HasPrefix(mimeType, "image") || strings.HasPrefix(mimeType, "video") {
-				mediaID, logs, err = uploadMediaToTwitter(msg, mediaURL, mimeType, s3url, client)
+				mediaID, logs, err = uploadMediaToTwitter(ctx, msg, mediaURL, mimeType, s3url, client)
 				if err != nil {
 					duration := time.Now().Sub(start)
 					logs = append(logs, courier.NewChannelLogFromError("Unable to upload media to Twitter server", msg.Channel(), msg.ID(), duration, err))
@@ -345,7 +345,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 		req, _ := http.NewRequest(http.MethodPost, sendURL, bytes.NewReader(jsonBody))
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("Accept", "application/json")
-		rr, err := utils.MakeHTTPRequestWithClient(req, client)
+		rr, err := utils.MakeHTTPRequestWithClient(req.WithContext(ctx), client)
 
 		// record our status and log
 		log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
@@ -381,13 +381,13 @@ func generateSignature(secret string, content string) string {
 	return base64.StdEncoding.EncodeToString(h.Sum(nil))
 }
 
-func uploadMediaToTwitter(msg courier.Msg, mediaUrl string, attachmentMimeType string, attachmentURL string, client *http.Client) (string, []*courier.ChannelLog, error) {
+func uploadMediaToTwitter(ctx context.Context, msg courier.Msg, mediaUrl string, attachmentMimeType string, attachmentURL string, client *http.Client) (string, []*courier.ChannelLog, error) {
 	start := time.Now()
 	logs := make([]*courier.ChannelLog, 0, 1)
 
 	// retrieve the media to be sent from S3
 	req, _ := http.NewRequest(http.MethodGet, attachmentURL, nil)
-	s3rr, err := utils.MakeHTTPRequest(req)
+	s3rr, err := utils.MakeHTTPRequest(req.WithContext(ctx))
 	log := courier.NewChannelLogFromRR("Media Fetch", msg.Channel(), msg.ID(), s3rr)
 	if err != nil {
 		log.WithError("Media Fetch Error", err)
@@ -420,7 +420,7 @@ func uploadMediaToTwitter(msg courier.Msg, mediaUrl string, attachmentMimeType s
 	twReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	twReq.Header.Set("Accept", "application/json")
 	twReq.Header.Set("User-Agent", utils.HTTPUserAgent)
-	twrr, err := utils.MakeHTTPRequestWithClient(twReq, client)
+	twrr, err := utils.MakeHTTPRequestWithClient(twReq.WithContext(ctx), client)
 	log = courier.NewChannelLogFromRR("Media Upload INIT", msg.Channel(), msg.ID(), twrr)
 	if err != nil {
 		log.WithError("Media Upload INIT Error", err)
@@ -474,7 +474,7 @@ func uploadMediaToTwitter(msg courier.Msg, mediaUrl string, attachmentMimeType s
 	twReq.Header.Set("Content-Type", contentType)
 	twReq.Header.Set("Accept", "application/json")
 	twReq.Header.Set("User-Agent", utils.HTTPUserAgent)
-	twrr, err = utils.MakeHTTPRequestWithClient(twReq, client)
+	twrr, err = utils.MakeHTTPRequestWithClient(twReq.WithContext(ctx), client)
 	log = courier.NewChannelLogFromRR("Media Upload APPEND request", msg.Channel(), msg.ID(), twrr)
 	if err != nil {
 		log = log.WithError("Media Upload APPEND request Error", err)
@@ -491,7 +491,7 @@ func uploadMediaToTwitter(msg courier.Msg, mediaUrl string, attachmentMimeType s
 	twReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	twReq.Header.Set("Accept", "application/json")
 	twReq.Header.Set("User-Agent", utils.HTTPUserAgent)
-	twrr, err = utils.MakeHTTPRequestWithClient(twReq, client)
+	twrr, err = utils.MakeHTTPRequestWithClient(twReq.WithContext(ctx), client)
 
 	log = courier.NewChannelLogFromRR("Media Upload FINALIZE", msg.Channel(), msg.ID(), twrr)
 
@@ -528,7 +528,7 @@ func uploadMediaToTwitter(msg courier.Msg, mediaUrl string, attachmentMimeType s
 		twReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 		twReq.Header.Set("Accept", "application/json")
 		twReq.Header.Set("User-Agent", utils.HTTPUserAgent)
-		twrr, err = utils.MakeHTTPRequestWithClient(twReq, client)
+		twrr, err = utils.MakeHTTPRequestWithClient(twReq.WithContext(ctx), client)
 		log = courier.NewChannelLogFromRR("Media Upload STATUS", msg.Channel(), msg.ID(), twrr)
 		if err != nil {
 			log.WithError("Media Upload STATUS Error", err)
@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"encoding/base64"
 	"fmt"
+	"net/http"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/utils"
@@ -136,3 +138,55 @@ func StrictTelForCountry(number string, country string) (urns.URN, error) {
 
 	return urn, nil
 }
+
+// NewSendErrorFromStatus creates a send error based on the HTTP status of the passed in response, for use when
+// a provider doesn't give us an error code of its own
+func NewSendErrorFromStatus(rr *utils.RequestResponse, description string) *courier.SendError {
+	if rr == nil || rr.StatusCode == 0 {
+		return courier.NewSendError(courier.ErrorUnknown, "", description)
+	}
+
+	code := strconv.Itoa(rr.StatusCode)
+	switch {
+	case rr.StatusCode == http.StatusUnauthorized || rr.StatusCode == http.StatusForbidden:
+		return courier.NewAuthFailureError(code, description)
+	case rr.StatusCode == http.StatusTooManyRequests:
+		return courier.NewRateLimitedError(code, description, RetryAfter(rr))
+	case rr.StatusCode == http.StatusRequestEntityTooLarge:
+		return courier.NewSendError(courier.ErrorContentTooLong, code, description)
+	case rr.StatusCode >= 500:
+		return courier.NewTransientError(code, description)
+	}
+	return courier.NewSendError(courier.ErrorUnknown, code, description)
+}
+
+// RetryAfter returns the delay a provider asked us to wait before retrying through the Retry-After header of the
+// passed in response, which can be either a number of seconds or an HTTP date. Returns zero if there is no hint.
+func RetryAfter(rr *utils.RequestResponse) time.Duration {
+	if rr == nil || rr.Header == nil {
+		return 0
+	}
+	return ParseRetryAfter(rr.Header.Get("Retry-After"), time.Now())
+}
+
+// ParseRetryAfter parses the passed in Retry-After header value relative to now, returning zero if it is invalid or in the past
+func ParseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil || !date.After(now) {
+		return 0
+	}
+	return date.Sub(now)
+}
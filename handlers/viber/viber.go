@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -170,13 +171,17 @@ func (h *handler) receiveEvent(ctx context.Context, channel courier.Channel, w h
 		return []courier.Event{channelEvent}, courier.WriteChannelEventSuccess(ctx, w, r, channelEvent)
 
 	case "failed":
-		msgStatus := h.Backend().NewMsgStatusForExternalID(channel, string(payload.MessageToken), courier.MsgFailed)
+		msgStatus := h.Backend().NewMsgStatusForExternalID(channel, strconv.FormatInt(payload.MessageToken, 10), courier.MsgFailed)
 		return handlers.WriteMsgStatusAndResponse(ctx, h, channel, msgStatus, w, r)
 
 	case "delivered":
 		// we ignore delivered events for viber as they send these for incoming messages too and its not worth the db hit to verify that
 		return nil, handlers.WriteAndLogRequestIgnored(ctx, h, channel, w, r, "ignoring delivered status")
 
+	case "seen":
+		msgStatus := h.Backend().NewMsgStatusForExternalID(channel, strconv.FormatInt(payload.MessageToken, 10), courier.MsgRead)
+		return handlers.WriteMsgStatusAndResponse(ctx, h, channel, msgStatus, w, r)
+
 	case "message":
 		sender := payload.Sender.ID
 		if sender == "" {
@@ -372,7 +377,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 				if err != nil {
 					return nil, err
 				}
-				rr, err := utils.MakeHTTPRequest(req)
+				rr, err := utils.MakeHTTPRequest(req.WithContext(ctx))
 				if err != nil {
 					return nil, err
 				}
@@ -386,7 +391,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 				if err != nil {
 					return nil, err
 				}
-				rr, err := utils.MakeHTTPRequest(req)
+				rr, err := utils.MakeHTTPRequest(req.WithContext(ctx))
 				if err != nil {
 					return nil, err
 				}
@@ -425,7 +430,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 		req, _ := http.NewRequest(http.MethodPost, sendURL, requestBody)
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("Accept", "application/json")
-		rr, err := utils.MakeHTTPRequest(req)
+		rr, err := utils.MakeHTTPRequest(req.WithContext(ctx))
 
 		// record log
 		log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
@@ -444,6 +449,12 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 			return status, nil
 		}
 
+		// record the token of our first part so we can match read receipts to our msg
+		messageToken, err := jsonparser.GetInt(rr.Body, "message_token")
+		if err == nil && status.ExternalID() == "" {
+			status.SetExternalID(strconv.FormatInt(messageToken, 10))
+		}
+
 		status.SetStatus(courier.MsgWired)
 		replies = nil
 	}
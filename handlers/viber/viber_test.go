@@ -46,7 +46,7 @@ func buildMockAttachmentService(testCases []ChannelSendTestCase) *httptest.Serve
 var defaultSendTestCases = []ChannelSendTestCase{
 	{Label: "Plain Send",
 		Text: "Simple Message", URN: "viber:xy5/5y6O81+/kbWHpLhBoA==",
-		Status: "W", ResponseStatus: 200,
+		Status: "W", ExternalID: "4987381194038857789", ResponseStatus: 200,
 		ResponseBody: `{"status":0,"status_message":"ok","message_token":4987381194038857789}`,
 		Headers: map[string]string{
 			"Content-Type": "application/json",
@@ -416,6 +416,13 @@ var (
 		"desc": "failure description"
 	}`
 
+	seenStatusReport = `{
+		"event": "seen",
+		"timestamp": 1457764197627,
+		"message_token": 4912661846655238145,
+		"user_id": "01234567890A="
+	}`
+
 	deliveredStatusReport = `{
 		"event": "delivered",
 		"timestamp": 1457764197627,
@@ -440,6 +447,7 @@ var testCases = []ChannelHandleTestCase{
 	{Label: "Webhook validation", URL: receiveURL, Data: webhookCheck, Status: 200, Response: "webhook valid", PrepRequest: addValidSignature},
 	{Label: "Failed Status Report", URL: receiveURL, Data: failedStatusReport, Status: 200, Response: `"status":"F"`, PrepRequest: addValidSignature},
 	{Label: "Delivered Status Report", URL: receiveURL, Data: deliveredStatusReport, Status: 200, Response: `Ignored`, PrepRequest: addValidSignature},
+	{Label: "Seen Status Report", URL: receiveURL, Data: seenStatusReport, Status: 200, Response: `"status":"R"`, PrepRequest: addValidSignature},
 	{Label: "Subcribe", URL: receiveURL, Data: validSubscribed, Status: 200, Response: "Accepted", PrepRequest: addValidSignature},
 	{Label: "Subcribe Invalid URN", URL: receiveURL, Data: invalidURNSubscribed, Status: 400, Response: "invalid viber id", PrepRequest: addValidSignature},
 	{Label: "Unsubcribe", URL: receiveURL, Data: validUnsubscribed, Status: 200, Response: "Accepted", ChannelEvent: Sp(string(courier.StopContact)), PrepRequest: addValidSignature},
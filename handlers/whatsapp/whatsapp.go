@@ -42,6 +42,17 @@ func (h *handler) Initialize(s courier.Server) error {
 	return nil
 }
 
+// SendLimits returns how msgs are sent on WhatsApp channels, sends with media need to upload it first so can take a while
+func (h *handler) SendLimits() courier.SendLimits {
+	return courier.SendLimits{Timeout: 2 * time.Minute}
+}
+
+// RetryPolicy returns how errored msgs are retried on WhatsApp channels, errors are usually the API being overloaded so
+// we back off quickly and jitter our retries so they don't all arrive at once
+func (h *handler) RetryPolicy() courier.RetryPolicy {
+	return courier.RetryPolicy{MaxAttempts: 5, Delay: time.Minute, Backoff: courier.RetryBackoffExponential, MaxDelay: 30 * time.Minute, Jitter: 0.2}
+}
+
 // {
 //   "statuses": [{
 //     "id": "9712A34B4A8B6AD50F",
@@ -118,6 +129,10 @@ type eventPayload struct {
 		RecipientID string `json:"recipient_id" validate:"required"`
 		Timestamp   string `json:"timestamp"    validate:"required"`
 		Status      string `json:"status"       validate:"required"`
+		Errors      []struct {
+			Code  int64  `json:"code"`
+			Title string `json:"title"`
+		} `json:"errors"`
 	} `json:"statuses"`
 }
 
@@ -211,6 +226,9 @@ func (h *handler) receiveEvent(ctx context.Context, channel courier.Channel, w h
 		}
 
 		event := h.Backend().NewMsgStatusForExternalID(channel, status.ID, msgStatus)
+		if len(status.Errors) > 0 {
+			event.SetSendError(newSendError(nil, status.Errors[0].Code, status.Errors[0].Title))
+		}
 		err := h.Backend().WriteMsgStatus(ctx, event)
 
 		// we don't know about this message, just tell them we ignored it
@@ -263,7 +281,7 @@ var waStatusMapping = map[string]courier.MsgStatusValue{
 	"sending":   courier.MsgWired,
 	"sent":      courier.MsgSent,
 	"delivered": courier.MsgDelivered,
-	"read":      courier.MsgDelivered,
+	"read":      courier.MsgRead,
 	"failed":    courier.MsgFailed,
 }
 
@@ -384,7 +402,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 
 			mimeType, s3url := handlers.SplitAttachment(attachment)
 			mediaID := ""
-			mediaID, log, err = uploadMediaToWhatsApp(msg, mediaURL, token, mimeType, s3url)
+			mediaID, log, err = uploadMediaToWhatsApp(ctx, msg, mediaURL, token, mimeType, s3url)
 			status.AddLog(log)
 
 			if err != nil {
@@ -399,7 +417,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 					Type: "audio",
 				}
 				payload.Audio = &mediaObject{ID: mediaID}
-				externalID, log, err = sendWhatsAppMsg(msg, sendURL, token, payload)
+				externalID, log, err = sendWhatsAppMsg(ctx, msg, sendURL, token, payload)
 
 			} else if strings.HasPrefix(mimeType, "application") {
 				payload := mtDocumentPayload{
@@ -412,7 +430,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 				} else {
 					payload.Document = &captionedMediaObject{ID: mediaID}
 				}
-				externalID, log, err = sendWhatsAppMsg(msg, sendURL, token, payload)
+				externalID, log, err = sendWhatsAppMsg(ctx, msg, sendURL, token, payload)
 
 			} else if strings.HasPrefix(mimeType, "image") {
 				payload := mtImagePayload{
@@ -424,7 +442,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 				} else {
 					payload.Image = &captionedMediaObject{ID: mediaID}
 				}
-				externalID, log, err = sendWhatsAppMsg(msg, sendURL, token, payload)
+				externalID, log, err = sendWhatsAppMsg(ctx, msg, sendURL, token, payload)
 			} else if strings.HasPrefix(mimeType, "video") {
 				payload := mtVideoPayload{
 					To:   msg.URN().Path(),
@@ -435,7 +453,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 				} else {
 					payload.Video = &captionedMediaObject{ID: mediaID}
 				}
-				externalID, log, err = sendWhatsAppMsg(msg, sendURL, token, payload)
+				externalID, log, err = sendWhatsAppMsg(ctx, msg, sendURL, token, payload)
 			} else {
 				duration := time.Since(start)
 				err = fmt.Errorf("unknown attachment mime type: %s", mimeType)
@@ -484,11 +502,12 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 				payload.HSM.LocalizableParams = append(payload.HSM.LocalizableParams, LocalizableParam{Default: v})
 			}
 
-			externalID, log, err := sendWhatsAppMsg(msg, sendURL, token, payload)
+			externalID, log, err := sendWhatsAppMsg(ctx, msg, sendURL, token, payload)
 			status.AddLog(log)
 
 			if err != nil {
 				log.WithError("Error sending message", err)
+				setSendError(status, err)
 				return status, nil
 			}
 
@@ -503,7 +522,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 				}
 				payload.Text.Body = part
 
-				externalID, log, err = sendWhatsAppMsg(msg, sendURL, token, payload)
+				externalID, log, err = sendWhatsAppMsg(ctx, msg, sendURL, token, payload)
 				status.AddLog(log)
 
 				if err != nil {
@@ -523,14 +542,58 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 	if err == nil {
 		status.SetStatus(courier.MsgWired)
 	}
+	setSendError(status, err)
 
 	return status, nil
 }
 
-func uploadMediaToWhatsApp(msg courier.Msg, url string, token string, attachmentMimeType string, attachmentURL string) (string, *courier.ChannelLog, error) {
+// mapping from WhatsApp error codes to our own, see https://developers.facebook.com/docs/whatsapp/api/errors
+var waErrorCodes = map[int64]courier.ErrorCode{
+	470:  courier.ErrorRecipientUnreachable, // outside the 24 hour window
+	1005: courier.ErrorAuthentication,       // access denied
+	1008: courier.ErrorInvalidContent,       // required parameter missing
+	1009: courier.ErrorInvalidContent,       // parameter value is not valid
+	1013: courier.ErrorInvalidRecipient,     // user is not valid
+	1015: courier.ErrorRateLimited,          // too many requests
+	1021: courier.ErrorInvalidRecipient,     // bad user, sending to ourselves
+	1024: courier.ErrorAuthentication,       // password must be changed
+	1026: courier.ErrorRecipientUnreachable, // receiver incapable
+	1031: courier.ErrorAuthentication,       // sender account locked
+	2000: courier.ErrorInvalidTemplate,      // template param count mismatch
+	2001: courier.ErrorInvalidTemplate,      // template missing
+	2003: courier.ErrorInvalidTemplate,      // template packing failed
+	2004: courier.ErrorInvalidTemplate,      // template param format mismatch
+}
+
+// newSendError maps the error code returned by WhatsApp, or failing that the HTTP status of the response, to a send error
+func newSendError(rr *utils.RequestResponse, code int64, title string) *courier.SendError {
+	if code == 0 {
+		return handlers.NewSendErrorFromStatus(rr, title)
+	}
+
+	errorCode, found := waErrorCodes[code]
+	if !found {
+		errorCode = courier.ErrorProvider
+	}
+
+	sendErr := courier.NewSendError(errorCode, strconv.FormatInt(code, 10), title)
+	if errorCode == courier.ErrorRateLimited {
+		sendErr.RetryAfter = handlers.RetryAfter(rr)
+	}
+	return sendErr
+}
+
+// setSendError records the typed send error behind the passed in error, if any, on our status
+func setSendError(status courier.MsgStatus, err error) {
+	if sendErr, isSendErr := courier.AsSendError(err); isSendErr {
+		status.SetSendError(sendErr)
+	}
+}
+
+func uploadMediaToWhatsApp(ctx context.Context, msg courier.Msg, url string, token string, attachmentMimeType string, attachmentURL string) (string, *courier.ChannelLog, error) {
 	// retrieve the media to be sent from S3
 	req, _ := http.NewRequest(http.MethodGet, attachmentURL, nil)
-	s3rr, err := utils.MakeHTTPRequest(req)
+	s3rr, err := utils.MakeHTTPRequest(req.WithContext(ctx))
 	if err != nil {
 		return "", courier.NewChannelLogFromRR("Media Fetch", msg.Channel(), msg.ID(), s3rr), err
 	}
@@ -540,7 +603,7 @@ func uploadMediaToWhatsApp(msg courier.Msg, url string, token string, attachment
 	waReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 	waReq.Header.Set("Content-Type", attachmentMimeType)
 	waReq.Header.Set("User-Agent", utils.HTTPUserAgent)
-	wArr, err := utils.MakeHTTPRequest(waReq)
+	wArr, err := utils.MakeHTTPRequest(waReq.WithContext(ctx))
 
 	log := courier.NewChannelLogFromRR("Media Upload success", msg.Channel(), msg.ID(), wArr)
 
@@ -556,7 +619,7 @@ func uploadMediaToWhatsApp(msg courier.Msg, url string, token string, attachment
 	return mediaID, log, nil
 }
 
-func sendWhatsAppMsg(msg courier.Msg, url string, token string, payload interface{}) (string, *courier.ChannelLog, error) {
+func sendWhatsAppMsg(ctx context.Context, msg courier.Msg, url string, token string, payload interface{}) (string, *courier.ChannelLog, error) {
 	jsonBody, err := json.Marshal(payload)
 	if err != nil {
 		log := courier.NewChannelLog("unable to build JSON body", msg.Channel(), msg.ID(), "", "", courier.NilStatusCode, "", "", time.Duration(0), err)
@@ -568,19 +631,23 @@ func sendWhatsAppMsg(msg courier.Msg, url string, token string, payload interfac
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 	req.Header.Set("User-Agent", utils.HTTPUserAgent)
-	rr, err := utils.MakeHTTPRequest(req)
+	rr, err := utils.MakeHTTPRequest(req.WithContext(ctx))
 
 	log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
 
 	errorTitle, err := jsonparser.GetString(rr.Body, "errors", "[0]", "title")
 	if errorTitle != "" {
-		err = errors.Errorf("received error from send endpoint: %s", errorTitle)
+		errorCode, _ := jsonparser.GetInt(rr.Body, "errors", "[0]", "code")
+		err = errors.Wrapf(newSendError(rr, errorCode, errorTitle), "received error from send endpoint")
 		return "", log, err
 	}
 
 	// grab the id
 	externalID, err := jsonparser.GetString(rr.Body, "messages", "[0]", "id")
 	if err != nil {
+		if rr.Status == utils.RRStatusFailure {
+			return "", log, errors.Wrapf(newSendError(rr, 0, ""), "received error from send endpoint")
+		}
 		err := errors.Errorf("unable to get message id from response body")
 		return "", log, err
 	}
@@ -195,6 +195,17 @@ var validStatus = `
 }
 `
 
+var readStatus = `
+{
+  "statuses": [{
+    "id": "9712A34B4A8B6AD50F",
+    "recipient_id": "16315555555",
+    "status": "read",
+    "timestamp": "1518694700"
+  }]
+}
+`
+
 var invalidStatus = `
 {
   "statuses": [{
@@ -240,6 +251,8 @@ var testCases = []ChannelHandleTestCase{
 
 	{Label: "Receive Valid Status", URL: "/c/wa/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive", Data: validStatus, Status: 200, Response: `"type":"status"`,
 		MsgStatus: Sp("S"), ExternalID: Sp("9712A34B4A8B6AD50F")},
+	{Label: "Receive Read Status", URL: "/c/wa/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive", Data: readStatus, Status: 200, Response: `"type":"status"`,
+		MsgStatus: Sp("R"), ExternalID: Sp("9712A34B4A8B6AD50F")},
 	{Label: "Receive Invalid JSON", URL: "/c/wa/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive", Data: "not json", Status: 400, Response: "unable to parse"},
 	{Label: "Receive Invalid Status", URL: "/c/wa/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive", Data: invalidStatus, Status: 400, Response: `"unknown status: in_orbit"`},
 	{Label: "Receive Ignore Status", URL: "/c/wa/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive", Data: ignoreStatus, Status: 200, Response: `"ignoring status: deleted"`},
@@ -301,7 +314,30 @@ var defaultSendTestCases = []ChannelSendTestCase{
 		Status:       "E",
 		ResponseBody: `{ "errors": [{ "title": "Error Sending" }] }`, ResponseStatus: 403,
 		RequestBody: `{"to":"250788123123","type":"text","text":{"body":"Error"}}`,
-		SendPrep:    setSendURL},
+		ErrorCode:   "auth_failure", ProviderErrorCode: "403",
+		SendPrep: setSendURL},
+	{Label: "Invalid User",
+		Text: "Error", URN: "whatsapp:250788123123",
+		Status:       "E",
+		ResponseBody: `{ "errors": [{ "code": 1013, "title": "User is not valid" }] }`, ResponseStatus: 400,
+		RequestBody: `{"to":"250788123123","type":"text","text":{"body":"Error"}}`,
+		ErrorCode:   "invalid_recipient", ProviderErrorCode: "1013",
+		SendPrep: setSendURL},
+	{Label: "Rate Limited",
+		Text: "Error", URN: "whatsapp:250788123123",
+		Status:       "E",
+		ResponseBody: `{ "errors": [{ "code": 1015, "title": "Too many requests" }] }`, ResponseStatus: 429,
+		ResponseHeaders: map[string]string{"Retry-After": "30"},
+		RequestBody:     `{"to":"250788123123","type":"text","text":{"body":"Error"}}`,
+		ErrorCode:       "rate_limited", ProviderErrorCode: "1015", RetryAfter: time.Second * 30,
+		SendPrep: setSendURL},
+	{Label: "Unauthorized",
+		Text: "Error", URN: "whatsapp:250788123123",
+		Status:       "E",
+		ResponseBody: `{ "meta": {} }`, ResponseStatus: 401,
+		RequestBody: `{"to":"250788123123","type":"text","text":{"body":"Error"}}`,
+		ErrorCode:   "auth_failure", ProviderErrorCode: "401",
+		SendPrep: setSendURL},
 	{Label: "No Message ID",
 		Text: "Error", URN: "whatsapp:250788123123",
 		Status:       "E",
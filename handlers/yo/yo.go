@@ -128,7 +128,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 			req, _ := http.NewRequest(http.MethodGet, sendURL.String(), nil)
 			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-			rr, err := utils.MakeHTTPRequest(req)
+			rr, err := utils.MakeHTTPRequest(req.WithContext(ctx))
 			log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
 			status.AddLog(log)
 
@@ -0,0 +1,90 @@
+package courier
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// HealthCheck is the result of checking a single dependency, such as our database or spool directory. Optional
+// checks are for dependencies we can keep receiving without, their failures degrade us but don't make us unready.
+type HealthCheck struct {
+	Name     string                 `json:"name"`
+	OK       bool                   `json:"ok"`
+	Optional bool                   `json:"optional,omitempty"`
+	Error    string                 `json:"error,omitempty"`
+	Details  map[string]interface{} `json:"details,omitempty"`
+}
+
+// WithDetail adds the passed in detail to this check, returning the check for chaining
+func (c *HealthCheck) WithDetail(key string, value interface{}) *HealthCheck {
+	if c.Details == nil {
+		c.Details = make(map[string]interface{})
+	}
+	c.Details[key] = value
+	return c
+}
+
+// HealthReport is a structured report on the health of each of our dependencies
+type HealthReport struct {
+	Checks []*HealthCheck `json:"checks"`
+}
+
+// NewHealthReport creates a new empty health report
+func NewHealthReport() *HealthReport {
+	return &HealthReport{Checks: []*HealthCheck{}}
+}
+
+// Add adds a new check with the passed in name to this report, the check fails if err is not nil
+func (r *HealthReport) Add(name string, err error) *HealthCheck {
+	check := &HealthCheck{Name: name, OK: err == nil}
+	if err != nil {
+		check.Error = err.Error()
+	}
+	r.Checks = append(r.Checks, check)
+	return check
+}
+
+// AddOptional adds a new optional check with the passed in name to this report, the check fails if err is not nil
+// but only degrades the report
+func (r *HealthReport) AddOptional(name string, err error) *HealthCheck {
+	check := r.Add(name, err)
+	check.Optional = true
+	return check
+}
+
+// AddCheck adds an existing check to this report, such as one we cached from an earlier report
+func (r *HealthReport) AddCheck(check *HealthCheck) *HealthCheck {
+	r.Checks = append(r.Checks, check)
+	return check
+}
+
+// Healthy returns whether all the checks in this report which aren't optional passed
+func (r *HealthReport) Healthy() bool {
+	for _, c := range r.Checks {
+		if !c.OK && !c.Optional {
+			return false
+		}
+	}
+	return true
+}
+
+// Degraded returns whether any of the optional checks in this report failed
+func (r *HealthReport) Degraded() bool {
+	for _, c := range r.Checks {
+		if !c.OK && c.Optional {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns a description of any failed checks, or empty string if all is well
+func (r *HealthReport) String() string {
+	health := bytes.Buffer{}
+	for _, c := range r.Checks {
+		if !c.OK {
+			health.WriteString(fmt.Sprintf("\n% 16s: %v", c.Name+" err", c.Error))
+		}
+	}
+	return health.String()
+}
@@ -0,0 +1,28 @@
+package courier
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthReport(t *testing.T) {
+	health := NewHealthReport()
+	health.Add("db", nil)
+	health.AddOptional("s3", nil)
+	assert.True(t, health.Healthy())
+	assert.False(t, health.Degraded())
+	assert.Equal(t, "", health.String())
+
+	// failed optional checks only degrade us
+	health.AddOptional("spool", errors.New("spool not writable"))
+	assert.True(t, health.Healthy())
+	assert.True(t, health.Degraded())
+
+	// other failed checks make us unhealthy
+	health.Add("redis", errors.New("connection refused"))
+	assert.False(t, health.Healthy())
+	assert.Contains(t, health.String(), "spool not writable")
+	assert.Contains(t, health.String(), "connection refused")
+}
@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"strings"
+	"time"
+
+	"github.com/nyaruka/librato"
+)
+
+// NewLibratoSink creates a new sink which reports to Librato. Librato must already be configured and started.
+//
+// Librato only deals in gauges, so every metric is reported as one, named with the values of its labels appended,
+// ex: a timing for msg_receive with a channel_type of TG is reported as courier.msg_receive_TG
+func NewLibratoSink() Sink {
+	return &libratoSink{}
+}
+
+type libratoSink struct{}
+
+func (s *libratoSink) Gauge(name string, labels Labels, value float64) {
+	librato.Gauge(libratoName(name, labels), value)
+}
+
+func (s *libratoSink) Count(name string, labels Labels, delta float64) {
+	librato.Gauge(libratoName(name, labels), delta)
+}
+
+func (s *libratoSink) Timing(name string, labels Labels, elapsed time.Duration) {
+	librato.Gauge(libratoName(name, labels), float64(elapsed)/float64(time.Second))
+}
+
+func libratoName(name string, labels Labels) string {
+	parts := append([]string{"courier." + name}, labels.values()...)
+	return strings.Join(parts, "_")
+}
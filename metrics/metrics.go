@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Labels are the dimensions a metric value is recorded against, such as the channel type
+type Labels map[string]string
+
+// Sink is the interface for a destination metrics are reported to, such as Librato or Prometheus
+type Sink interface {
+	// Gauge records the current value of something, such as the size of a queue
+	Gauge(name string, labels Labels, value float64)
+
+	// Count increments the counter with the passed in name by delta
+	Count(name string, labels Labels, delta float64)
+
+	// Timing records how long an operation such as receiving or sending a message took
+	Timing(name string, labels Labels, elapsed time.Duration)
+}
+
+// RegisterSink adds the passed in sink to the sinks all metrics are reported to
+func RegisterSink(sink Sink) {
+	sinksMutex.Lock()
+	sinks = append(sinks, sink)
+	sinksMutex.Unlock()
+}
+
+// ClearSinks removes all registered sinks, metrics reported afterwards are discarded
+func ClearSinks() {
+	sinksMutex.Lock()
+	sinks = nil
+	sinksMutex.Unlock()
+}
+
+// Gauge reports the current value of the passed in gauge to all our sinks
+func Gauge(name string, labels Labels, value float64) {
+	sinksMutex.RLock()
+	defer sinksMutex.RUnlock()
+
+	for _, s := range sinks {
+		s.Gauge(name, labels, value)
+	}
+}
+
+// Count increments the passed in counter by delta on all our sinks
+func Count(name string, labels Labels, delta float64) {
+	sinksMutex.RLock()
+	defer sinksMutex.RUnlock()
+
+	for _, s := range sinks {
+		s.Count(name, labels, delta)
+	}
+}
+
+// Timing reports the time an operation took to all our sinks
+func Timing(name string, labels Labels, elapsed time.Duration) {
+	sinksMutex.RLock()
+	defer sinksMutex.RUnlock()
+
+	for _, s := range sinks {
+		s.Timing(name, labels, elapsed)
+	}
+}
+
+// ChannelType is a convenience for building the labels of metrics which are broken down by channel type
+func ChannelType(channelType string) Labels {
+	return Labels{"channel_type": channelType}
+}
+
+// With returns a copy of these labels with the passed in label added
+func (l Labels) With(name string, value string) Labels {
+	labels := make(Labels, len(l)+1)
+	for k, v := range l {
+		labels[k] = v
+	}
+	labels[name] = value
+	return labels
+}
+
+// names returns the names of these labels in a stable (sorted) order
+func (l Labels) names() []string {
+	names := make([]string, 0, len(l))
+	for k := range l {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// values returns the values of these labels, ordered by label name
+func (l Labels) values() []string {
+	names := l.names()
+	values := make([]string, len(names))
+	for i, n := range names {
+		values[i] = l[n]
+	}
+	return values
+}
+
+// key returns a string uniquely identifying this set of labels
+func (l Labels) key() string {
+	pairs := make([]string, 0, len(l))
+	for _, n := range l.names() {
+		pairs = append(pairs, n+"="+l[n])
+	}
+	return strings.Join(pairs, ",")
+}
+
+var sinksMutex sync.RWMutex
+var sinks []Sink
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLibratoName(t *testing.T) {
+	assert.Equal(t, "courier.bulk_queue", libratoName("bulk_queue", nil))
+	assert.Equal(t, "courier.msg_receive_TG", libratoName("msg_receive", ChannelType("TG")))
+	assert.Equal(t, "courier.msgs_sent_TG_errored", libratoName("msgs_sent", ChannelType("TG").With("outcome", "errored")))
+}
+
+func TestPrometheusSink(t *testing.T) {
+	sink := NewPrometheusSink()
+	RegisterSink(sink)
+	defer ClearSinks()
+
+	Count("msgs_sent", ChannelType("TG").With("outcome", "sent"), 1)
+	Count("msgs_sent", ChannelType("TG").With("outcome", "sent"), 1)
+	Count("msgs_sent", ChannelType("TG").With("outcome", "errored"), 1)
+	Gauge("queue_size", Labels{"state": "active", "priority": "bulk"}, 12)
+	Gauge("queue_size", Labels{"state": "active", "priority": "bulk"}, 10)
+	Timing("msg_send", ChannelType("TG"), 200*time.Millisecond)
+	Timing("msg_send", ChannelType("TG"), 3*time.Second)
+
+	server := httptest.NewServer(sink)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "text/plain; version=0.0.4", resp.Header.Get("Content-Type"))
+
+	assert.Equal(t, `# TYPE courier_msgs_sent_total counter
+courier_msgs_sent_total{channel_type="TG",outcome="errored"} 1
+courier_msgs_sent_total{channel_type="TG",outcome="sent"} 2
+# TYPE courier_queue_size gauge
+courier_queue_size{priority="bulk",state="active"} 10
+# TYPE courier_msg_send_duration_seconds histogram
+courier_msg_send_duration_seconds_bucket{channel_type="TG",le="0.005"} 0
+courier_msg_send_duration_seconds_bucket{channel_type="TG",le="0.01"} 0
+courier_msg_send_duration_seconds_bucket{channel_type="TG",le="0.025"} 0
+courier_msg_send_duration_seconds_bucket{channel_type="TG",le="0.05"} 0
+courier_msg_send_duration_seconds_bucket{channel_type="TG",le="0.1"} 0
+courier_msg_send_duration_seconds_bucket{channel_type="TG",le="0.25"} 1
+courier_msg_send_duration_seconds_bucket{channel_type="TG",le="0.5"} 1
+courier_msg_send_duration_seconds_bucket{channel_type="TG",le="1"} 1
+courier_msg_send_duration_seconds_bucket{channel_type="TG",le="2.5"} 1
+courier_msg_send_duration_seconds_bucket{channel_type="TG",le="5"} 2
+courier_msg_send_duration_seconds_bucket{channel_type="TG",le="10"} 2
+courier_msg_send_duration_seconds_bucket{channel_type="TG",le="30"} 2
+courier_msg_send_duration_seconds_bucket{channel_type="TG",le="+Inf"} 2
+courier_msg_send_duration_seconds_sum{channel_type="TG"} 3.2
+courier_msg_send_duration_seconds_count{channel_type="TG"} 2
+`, string(sink.Expose()))
+
+	// once cleared, nothing more is recorded
+	ClearSinks()
+	Count("new_contact", nil, 1)
+	assert.NotContains(t, string(sink.Expose()), "new_contact")
+
+	// labels are escaped
+	sink.Gauge("escaped", Labels{"name": "a \"quoted\"\nvalue"}, 1)
+	assert.Contains(t, string(sink.Expose()), `courier_escaped{name="a \"quoted\"\nvalue"} 1`)
+}
@@ -0,0 +1,204 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds (in seconds) of the buckets our timing histograms are broken into
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// PrometheusSink is a sink which keeps metrics in memory and exposes them in the Prometheus text
+// exposition format, it satisfies http.Handler so it can be served directly as /metrics
+//
+// Counters are exposed as courier_<name>_total, gauges as courier_<name> and timings as
+// histograms named courier_<name>_duration_seconds
+type PrometheusSink struct {
+	buckets []float64
+
+	mutex      sync.Mutex
+	counters   map[string]*promSeries
+	gauges     map[string]*promSeries
+	histograms map[string]*promHistogram
+}
+
+// NewPrometheusSink creates a new empty Prometheus sink using our default buckets
+func NewPrometheusSink() *PrometheusSink {
+	return &PrometheusSink{
+		buckets:    DefaultBuckets,
+		counters:   make(map[string]*promSeries),
+		gauges:     make(map[string]*promSeries),
+		histograms: make(map[string]*promHistogram),
+	}
+}
+
+// Gauge sets the value of the passed in gauge
+func (s *PrometheusSink) Gauge(name string, labels Labels, value float64) {
+	name = promName(name, "")
+
+	s.mutex.Lock()
+	series := s.series(s.gauges, name, labels)
+	series.value = value
+	s.mutex.Unlock()
+}
+
+// Count increments the passed in counter by delta
+func (s *PrometheusSink) Count(name string, labels Labels, delta float64) {
+	name = promName(name, "_total")
+
+	s.mutex.Lock()
+	series := s.series(s.counters, name, labels)
+	series.value += delta
+	s.mutex.Unlock()
+}
+
+// Timing observes the passed in duration in the histogram for this name and labels
+func (s *PrometheusSink) Timing(name string, labels Labels, elapsed time.Duration) {
+	name = promName(name, "_duration_seconds")
+	seconds := float64(elapsed) / float64(time.Second)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := name + "|" + labels.key()
+	h, found := s.histograms[key]
+	if !found {
+		h = &promHistogram{name: name, labels: labels, counts: make([]uint64, len(s.buckets))}
+		s.histograms[key] = h
+	}
+
+	for i, upper := range s.buckets {
+		if seconds <= upper {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// ServeHTTP writes all our current metrics in the Prometheus text format
+func (s *PrometheusSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(s.Expose())
+}
+
+// Expose returns all our current metrics in the Prometheus text format
+func (s *PrometheusSink) Expose() []byte {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	out := &bytes.Buffer{}
+
+	writeSeries(out, "counter", s.counters)
+	writeSeries(out, "gauge", s.gauges)
+
+	histograms := make([]*promHistogram, 0, len(s.histograms))
+	for _, h := range s.histograms {
+		histograms = append(histograms, h)
+	}
+	sort.Slice(histograms, func(i, j int) bool {
+		if histograms[i].name != histograms[j].name {
+			return histograms[i].name < histograms[j].name
+		}
+		return histograms[i].labels.key() < histograms[j].labels.key()
+	})
+
+	lastName := ""
+	for _, h := range histograms {
+		if h.name != lastName {
+			fmt.Fprintf(out, "# TYPE %s histogram\n", h.name)
+			lastName = h.name
+		}
+		for i, upper := range s.buckets {
+			fmt.Fprintf(out, "%s_bucket%s %d\n", h.name, promLabels(h.labels.With("le", formatFloat(upper))), h.counts[i])
+		}
+		fmt.Fprintf(out, "%s_bucket%s %d\n", h.name, promLabels(h.labels.With("le", "+Inf")), h.count)
+		fmt.Fprintf(out, "%s_sum%s %s\n", h.name, promLabels(h.labels), formatFloat(h.sum))
+		fmt.Fprintf(out, "%s_count%s %d\n", h.name, promLabels(h.labels), h.count)
+	}
+
+	return out.Bytes()
+}
+
+// series returns the series for the passed in name and labels, creating it if necessary, callers must hold our lock
+func (s *PrometheusSink) series(all map[string]*promSeries, name string, labels Labels) *promSeries {
+	key := name + "|" + labels.key()
+	series, found := all[key]
+	if !found {
+		series = &promSeries{name: name, labels: labels}
+		all[key] = series
+	}
+	return series
+}
+
+type promSeries struct {
+	name   string
+	labels Labels
+	value  float64
+}
+
+type promHistogram struct {
+	name   string
+	labels Labels
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func writeSeries(out *bytes.Buffer, metricType string, all map[string]*promSeries) {
+	series := make([]*promSeries, 0, len(all))
+	for _, s := range all {
+		series = append(series, s)
+	}
+	sort.Slice(series, func(i, j int) bool {
+		if series[i].name != series[j].name {
+			return series[i].name < series[j].name
+		}
+		return series[i].labels.key() < series[j].labels.key()
+	})
+
+	lastName := ""
+	for _, s := range series {
+		if s.name != lastName {
+			fmt.Fprintf(out, "# TYPE %s %s\n", s.name, metricType)
+			lastName = s.name
+		}
+		fmt.Fprintf(out, "%s%s %s\n", s.name, promLabels(s.labels), formatFloat(s.value))
+	}
+}
+
+// promName builds a valid Prometheus metric name from our metric name and the passed in suffix
+func promName(name string, suffix string) string {
+	name = strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+	return "courier_" + name + suffix
+}
+
+// promLabels formats the passed in labels as a Prometheus label set, ex: {channel_type="TG"}
+func promLabels(labels Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(labels))
+	for _, name := range labels.names() {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(labels[name])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,130 @@
+package queue
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// BreakerState is the state of the circuit breaker of a queue
+type BreakerState string
+
+const (
+	// BreakerClosed means items in the queue are popped as normal
+	BreakerClosed = BreakerState("closed")
+
+	// BreakerOpen means too many consecutive failures were recorded and the queue won't be popped until its cooldown is over
+	BreakerOpen = BreakerState("open")
+
+	// BreakerHalfOpen means the cooldown is over and the next item popped will be a probe which decides whether we close or reopen
+	BreakerHalfOpen = BreakerState("half_open")
+)
+
+// Breaker describes the circuit breaker of a single queue
+type Breaker struct {
+	Queue     string
+	State     BreakerState
+	Failures  int
+	OpenUntil time.Time
+}
+
+var luaRecordFailure = redis.NewScript(6, `-- KEYS: [EpochMS, QueueType, Queue, Threshold, Cooldown, ProbeTimeout]
+	local failures = redis.call("hincrby", KEYS[2] .. ":failures", KEYS[3], 1)
+	local openUntil = redis.call("hget", KEYS[2] .. ":breakers", KEYS[3])
+	local probe = redis.call("hget", KEYS[2] .. ":probes", KEYS[3])
+
+	-- a failed probe reopens our breaker, otherwise we open once we hit our threshold
+	if probe or (not openUntil and failures >= tonumber(KEYS[4])) then
+		redis.call("hset", KEYS[2] .. ":breakers", KEYS[3], tonumber(KEYS[1]) + tonumber(KEYS[5]))
+		redis.call("hset", KEYS[2] .. ":probe_timeouts", KEYS[3], KEYS[6])
+		redis.call("hdel", KEYS[2] .. ":probes", KEYS[3])
+		return 1
+	end
+
+	return 0
+`)
+
+// RecordFailure records a failure for the passed in queue, such as a send that errored. Once threshold
+// consecutive failures are recorded the circuit breaker for the queue opens and the queue won't be popped
+// from for the cooldown duration, after which a single item will be popped as a probe. No other probe is
+// popped until the outcome of that one is recorded or probeTimeout passes, so it should be as long as a
+// send can take. Returns whether this failure opened the breaker.
+func RecordFailure(conn redis.Conn, qType string, queue string, threshold int, cooldown time.Duration, probeTimeout time.Duration) (bool, error) {
+	opened, err := redis.Int(luaRecordFailure.Do(conn, epochMS(), qType, queue, threshold, cooldown.Seconds(), probeTimeout.Seconds()))
+	return opened == 1, err
+}
+
+var luaRecordSuccess = redis.NewScript(2, luaActive+`-- KEYS: [QueueType, Queue]
+	redis.call("hdel", KEYS[1] .. ":failures", KEYS[2])
+	redis.call("hdel", KEYS[1] .. ":probes", KEYS[2])
+	redis.call("hdel", KEYS[1] .. ":probe_timeouts", KEYS[2])
+	local closed = redis.call("hdel", KEYS[1] .. ":breakers", KEYS[2])
+
+	-- move any of our broken queues back to active
+	local prefix = KEYS[1] .. ":" .. KEYS[2] .. "|"
+	local broken = redis.call("zrange", KEYS[1] .. ":broken", 0, -1, "WITHSCORES")
+	for i=1,#broken,2 do
+		if string.sub(broken[i], 1, string.len(prefix)) == prefix then
+			activate(KEYS[1], broken[i], broken[i+1])
+			redis.call("zrem", KEYS[1] .. ":broken", broken[i])
+			redis.call("publish", KEYS[1] .. ":notify", broken[i])
+		end
+	end
+
+	return closed
+`)
+
+// RecordSuccess records a success for the passed in queue, resetting its consecutive failures and closing
+// its circuit breaker if it was open, in which case the queue can be popped from straight away. Returns
+// whether this success closed the breaker.
+func RecordSuccess(conn redis.Conn, qType string, queue string) (bool, error) {
+	closed, err := redis.Int(luaRecordSuccess.Do(conn, qType, queue))
+	return closed == 1, err
+}
+
+// ListBreakers returns the circuit breakers of all the queues of the passed in type which have recorded
+// failures, keyed by queue name
+func ListBreakers(conn redis.Conn, qType string) (map[string]*Breaker, error) {
+	conn.Send("hgetall", qType+":failures")
+	conn.Send("hgetall", qType+":breakers")
+	conn.Flush()
+
+	failures, err := redis.IntMap(conn.Receive())
+	if err != nil {
+		return nil, err
+	}
+	openUntils, err := redis.StringMap(conn.Receive())
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	breakers := make(map[string]*Breaker, len(failures))
+	for queue, count := range failures {
+		breakers[queue] = &Breaker{Queue: queue, State: BreakerClosed, Failures: count}
+	}
+
+	for queue, openUntil := range openUntils {
+		breaker, found := breakers[queue]
+		if !found {
+			breaker = &Breaker{Queue: queue}
+			breakers[queue] = breaker
+		}
+
+		until, err := strconv.ParseFloat(openUntil, 64)
+		if err != nil {
+			return nil, err
+		}
+		breaker.OpenUntil = time.Unix(0, int64(until*float64(time.Second)))
+
+		// we are half open once our cooldown is over, even if no probe has been popped yet
+		if breaker.OpenUntil.After(now) {
+			breaker.State = BreakerOpen
+		} else {
+			breaker.State = BreakerHalfOpen
+		}
+	}
+
+	return breakers, nil
+}
@@ -0,0 +1,141 @@
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreaker(t *testing.T) {
+	assert := assert.New(t)
+	pool := getPool()
+	conn := pool.Get()
+	defer conn.Close()
+
+	dethrottle := func() {
+		_, err := luaDethrottle.Do(conn, "msgs", epochMS(), "1")
+		assert.NoError(err)
+	}
+
+	assert.NoError(PushOntoQueue(conn, "msgs", "chan1", 0, `[{"id":1}]`, HighPriority, time.Time{}))
+	assert.NoError(PushOntoQueue(conn, "msgs", "chan1", 0, `[{"id":2}]`, HighPriority, time.Time{}))
+	assert.NoError(PushOntoQueue(conn, "msgs", "chan1", 0, `[{"id":3}]`, HighPriority, time.Time{}))
+
+	// two failures don't open our breaker
+	for i := 0; i < 2; i++ {
+		opened, err := RecordFailure(conn, "msgs", "chan1", 3, time.Second, time.Second*2)
+		assert.NoError(err)
+		assert.False(opened)
+	}
+
+	breakers, err := ListBreakers(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(&Breaker{Queue: "chan1", State: BreakerClosed, Failures: 2}, breakers["chan1"])
+
+	// but a third does
+	opened, err := RecordFailure(conn, "msgs", "chan1", 3, time.Second, time.Second*2)
+	assert.NoError(err)
+	assert.True(opened)
+
+	breakers, err = ListBreakers(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(BreakerOpen, breakers["chan1"].State)
+	assert.Equal(3, breakers["chan1"].Failures)
+
+	// further failures while open don't reopen it
+	opened, err = RecordFailure(conn, "msgs", "chan1", 3, time.Second, time.Second*2)
+	assert.NoError(err)
+	assert.False(opened)
+
+	// nothing can be popped while we are open
+	token, _, err := PopFromQueue(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(Retry, token)
+	token, _, err = PopFromQueue(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(EmptyQueue, token)
+
+	queues, err := ListQueues(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal([]*Info{
+		{Queue: "chan1", TPS: 0, State: StateBroken, Workers: 0, Breaker: BreakerOpen, Size: 3, BulkSize: 0},
+	}, queues)
+
+	// dethrottling doesn't reactivate us until our cooldown is over
+	dethrottle()
+	token, _, err = PopFromQueue(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(EmptyQueue, token)
+
+	time.Sleep(time.Millisecond * 1100)
+	dethrottle()
+
+	breakers, err = ListBreakers(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(BreakerHalfOpen, breakers["chan1"].State)
+
+	// we can now pop a single probe
+	token, value, err := PopFromQueue(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(WorkerToken("msgs:chan1|0"), token)
+	assert.Equal(`{"id":1}`, value)
+
+	// which has as long as our probe timeout to report back
+	probe, err := redis.Float64(conn.Do("hget", "msgs:probes", "chan1"))
+	assert.NoError(err)
+	assert.InDelta(float64(time.Now().UnixNano())/float64(time.Second)+2, probe, 0.1)
+
+	token, _, err = PopFromQueue(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(Retry, token)
+	token, _, err = PopFromQueue(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(EmptyQueue, token)
+
+	// our probe fails, reopening our breaker
+	opened, err = RecordFailure(conn, "msgs", "chan1", 3, time.Second, time.Second*2)
+	assert.NoError(err)
+	assert.True(opened)
+	assert.NoError(MarkComplete(conn, "msgs", "msgs:chan1|0"))
+
+	// wait out our cooldown again and pop another probe
+	time.Sleep(time.Millisecond * 1100)
+	dethrottle()
+
+	token, value, err = PopFromQueue(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(WorkerToken("msgs:chan1|0"), token)
+	assert.Equal(`{"id":2}`, value)
+
+	// our queue is broken while our probe is out
+	token, _, err = PopFromQueue(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(Retry, token)
+
+	queues, err = ListQueues(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(StateBroken, queues[0].State)
+
+	// this time it succeeds, closing our breaker and making our queue active again
+	closed, err := RecordSuccess(conn, "msgs", "chan1")
+	assert.NoError(err)
+	assert.True(closed)
+	assert.NoError(MarkComplete(conn, "msgs", "msgs:chan1|0"))
+
+	breakers, err = ListBreakers(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(0, len(breakers))
+
+	// and we pop as normal
+	token, value, err = PopFromQueue(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(WorkerToken("msgs:chan1|0"), token)
+	assert.Equal(`{"id":3}`, value)
+
+	// a success when already closed doesn't close anything
+	closed, err = RecordSuccess(conn, "msgs", "chan1")
+	assert.NoError(err)
+	assert.False(closed)
+}
@@ -0,0 +1,94 @@
+package queue
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// OrgInfo describes how many workers are popping from the queues of a single org
+type OrgInfo struct {
+	Org     string
+	Workers int
+	Weight  float64
+}
+
+// SetQueueOrg sets the org the passed in queue belongs to, an empty org means it doesn't belong to one. Workers are
+// shared fairly between orgs, so that an org with lots of busy queues can't take them from orgs with few. If the queue
+// is active it is rescheduled with its new org when it is next chosen to be popped from.
+func SetQueueOrg(conn redis.Conn, qType string, queue string, org string) error {
+	var err error
+	if org != "" {
+		_, err = conn.Do("hset", qType+":orgs", queue, org)
+	} else {
+		_, err = conn.Do("hdel", qType+":orgs", queue)
+	}
+	return err
+}
+
+// SetOrgWeights sets the weights of orgs when sharing workers between them, replacing any set previously. Orgs
+// without a weight have a weight of 1, so an org with a weight of 2 is given twice as many workers as them when
+// they both have items to pop.
+func SetOrgWeights(conn redis.Conn, qType string, weights map[string]float64) error {
+	conn.Send("MULTI")
+	conn.Send("del", qType+":org_weights")
+	for org, weight := range weights {
+		conn.Send("hset", qType+":org_weights", org, weight)
+	}
+	_, err := conn.Do("EXEC")
+	if err != nil {
+		return err
+	}
+
+	// rescore our active orgs with their new weights
+	_, err = luaUpdateOrgLoads.Do(conn, qType)
+	return err
+}
+
+var luaUpdateOrgLoads = redis.NewScript(1, luaActive+`-- KEYS: [QueueType]
+	local orgs = redis.call("zrange", KEYS[1] .. ":active_orgs", 0, -1)
+	for i=1,#orgs do
+		if string.sub(orgs[i], 1, 4) == "org:" then
+			updateOrgLoad(KEYS[1], string.sub(orgs[i], 5))
+		end
+	end
+`)
+
+// ListOrgs returns info on all the orgs of the passed in type which have workers or a weight, sorted by org
+func ListOrgs(conn redis.Conn, qType string) ([]*OrgInfo, error) {
+	conn.Send("hgetall", qType+":org_workers")
+	conn.Send("hgetall", qType+":org_weights")
+	conn.Flush()
+
+	workers, err := redis.IntMap(conn.Receive())
+	if err != nil {
+		return nil, err
+	}
+	weights, err := redis.StringMap(conn.Receive())
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make(map[string]*OrgInfo)
+	for org, count := range workers {
+		infos[org] = &OrgInfo{Org: org, Workers: count, Weight: 1}
+	}
+	for org, value := range weights {
+		weight, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+		if infos[org] == nil {
+			infos[org] = &OrgInfo{Org: org}
+		}
+		infos[org].Weight = weight
+	}
+
+	orgs := make([]*OrgInfo, 0, len(infos))
+	for _, info := range infos {
+		orgs = append(orgs, info)
+	}
+	sort.Slice(orgs, func(i, j int) bool { return orgs[i].Org < orgs[j].Org })
+	return orgs, nil
+}
@@ -1,7 +1,10 @@
 package queue
 
 import (
+	"fmt"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -32,143 +35,369 @@ const (
 	Retry = WorkerToken("retry")
 )
 
-var luaPush = redis.NewScript(6, `-- KEYS: [EpochMS, QueueType, QueueName, TPS, Priority, Value]
+// NotifyChannel returns the name of the Redis channel notifications are published to whenever
+// a queue of the passed in type may have items which are ready to be popped. Producers which push
+// onto queues without PushOntoQueue should publish the name of the queue to this channel too, else
+// consumers only find their items when they next poll.
+func NotifyChannel(qType string) string {
+	return qType + ":notify"
+}
+
+// luaBucket defines the functions our scripts use for the token bucket of each queue with a TPS. Buckets hold up to
+// their burst in tokens, refill smoothly at their TPS and each value popped takes its cost in tokens, so a value which
+// will be sent as three parts can cost three tokens. The burst of a queue defaults to its TPS.
+const luaBucket = `-- bucket functions
+	local function bucketBurst(qType, name, tps)
+		local burst = tonumber(redis.call("hget", qType .. ":bursts", name)) or tps
+		return math.max(burst, 1)
+	end
+
+	local function bucketTokens(queueKey, now, tps, burst)
+		local bucket = redis.call("hmget", queueKey .. ":bucket", "tokens", "updated")
+		if not bucket[1] then
+			return burst
+		end
+		return math.min(burst, tonumber(bucket[1]) + (now - tonumber(bucket[2])) * tps)
+	end
+`
+
+var luaPush = redis.NewScript(7, luaBucket+luaActive+`-- KEYS: [EpochMS, QueueType, QueueName, TPS, Priority, Value, NotBefore]
 	-- first push onto our specific queue
 	-- our queue name is built from the type, name and tps, usually something like: "msgs:uuid1-uuid2-uuid3-uuid4|tps"
 	local queueKey = KEYS[2] .. ":" .. KEYS[3] .. "|" .. KEYS[4]
 
+	-- items are scored by when they can be popped, which is now unless they are being held until later
+	local score = KEYS[1]
+	local delayed = tonumber(KEYS[7]) > tonumber(KEYS[1])
+	if delayed then
+		score = KEYS[7]
+	end
+
 	-- our priority queue name also includes the priority of the message (we have one queue for default and one for bulk)
 	local priorityQueueKey = queueKey .. "/" .. KEYS[5]
-	redis.call("zadd", priorityQueueKey, KEYS[1], KEYS[6])
+	redis.call("zadd", priorityQueueKey, score, KEYS[6])
+
+	-- queues which are saturated, paused or broken hold their workers until they are moved back to active, which
+	-- happens once they can be popped from again, so we leave them where they are
+	if redis.call("zscore", KEYS[2] .. ":saturated", queueKey) or redis.call("zscore", KEYS[2] .. ":paused", queueKey) or redis.call("zscore", KEYS[2] .. ":broken", queueKey) then
+	  return 0
+	end
 
 	local tps = tonumber(KEYS[4])
 
-	-- if we have a TPS, check whether we are currently throttled
-	local curr = -1
+	-- if we have a TPS, check whether we are currently throttled, which is when our bucket doesn't have a token
+	local tokens = 1
 	if tps > 0 then
-   	    local tpsKey = queueKey .. ":tps:" .. math.floor(KEYS[1])
-	    curr = tonumber(redis.call("get", tpsKey))
+	    tokens = bucketTokens(queueKey, tonumber(KEYS[1]), tps, bucketBurst(KEYS[2], KEYS[3], tps))
 	end
+	local throttled = tokens < 1
 
-	-- if we aren't then add to our active
-	if not curr or curr < tps then
-	  redis.call("zincrby", KEYS[2] .. ":active", 0, queueKey)
+	-- if we aren't then add to our active and let anybody waiting on us know there is something to pop, delayed
+	-- items will move our queue to future when popped if there is nothing else in it
+	if not throttled then
+	  activate(KEYS[2], queueKey, 0)
+	  if not delayed then
+	    redis.call("publish", KEYS[2] .. ":notify", queueKey)
+	  end
 	  return 1
-	else 
+	else
+	  -- otherwise make sure we are woken once our bucket has refilled, unless we are still active
+	  if not redis.call("zscore", KEYS[2] .. ":active", queueKey) then
+	    redis.call("zincrby", KEYS[2] .. ":throttled", 0, queueKey)
+	    redis.call("zadd", KEYS[2] .. ":throttled_until", tonumber(KEYS[1]) + (1 - tokens) / tps, queueKey)
+	  end
 	  return 0
     end
 `)
 
 // PushOntoQueue pushes the passed in value to the passed in queue, making sure that no more than the
-// specified transactions per second are popped off at a time. A tps value of 0 means there is no
-// limit to the rate that messages can be consumed
-func PushOntoQueue(conn redis.Conn, qType string, queue string, tps int, value string, priority Priority) error {
-	epochMS := strconv.FormatFloat(float64(time.Now().UnixNano()/int64(time.Microsecond))/float64(1000000), 'f', 6, 64)
-	_, err := redis.Int(luaPush.Do(conn, epochMS, qType, queue, tps, priority, value))
+// specified transactions per second are popped off at a time, with bursts of up to the burst set with
+// SetBurst. A tps value of 0 means there is no limit to the rate that messages can be consumed. Values
+// which are JSON objects with a tps_cost, such as msgs which will be sent as several parts, count as that
+// many transactions. The value won't be popped before notBefore, a zero time means it can be popped
+// straight away. If the queue can be popped from straight away a notification is published to the
+// NotifyChannel of the queue type.
+func PushOntoQueue(conn redis.Conn, qType string, queue string, tps int, value string, priority Priority, notBefore time.Time) error {
+	_, err := redis.Int(luaPush.Do(conn, epochMS(), qType, queue, tps, priority, value, epochMSFor(notBefore)))
 	return err
 }
 
-var luaPop = redis.NewScript(2, `-- KEYS: [EpochMS QueueType]
-	-- get the first key off our active list
-	local result = redis.call("zrange", KEYS[2] .. ":active", 0, 0, "WITHSCORES")
-	local queue = result[1]
-	local workers = result[2]
+// Requeue pushes the passed in value back onto the queue it was popped from with the passed in token, to be popped
+// again no sooner than notBefore, and marks the task of that token as complete
+func Requeue(conn redis.Conn, qType string, token WorkerToken, value string, priority Priority, notBefore time.Time) error {
+	queue, tps, err := parseQueueKey(qType, string(token))
+	if err != nil {
+		return err
+	}
 
-	-- nothing? return nothing
-	if not queue then
-		return {"empty", ""}
+	err = PushOntoQueue(conn, qType, queue, tps, value, priority, notBefore)
+	if err != nil {
+		return err
+	}
+	return MarkComplete(conn, qType, token)
+}
+
+// luaActive defines the functions our scripts use to make queues active or inactive. As well as our active list,
+// which is scored by the workers of each queue, active queues are scheduled fairly between orgs. Our active orgs list
+// holds each org with active queues, scored by its workers for its weight, and each queue without an org, scored by
+// its workers. Each org has a list of its active queues, scored by their workers. Applications which push onto our
+// queues themselves only add them to our active list, so any queues missing from our schedule are added when popping.
+const luaActive = `-- active functions
+	local function queueOrg(qType, queueKey)
+		local delim = string.find(queueKey, "|")
+		local org = redis.call("hget", qType .. ":orgs", string.sub(queueKey, string.len(qType) + 2, (delim or 0) - 1))
+		if not org then
+			return nil
+		end
+		return org
 	end
 
-	-- figure out our max transaction per second
-	local delim = string.find(queue, "|")
-	local tps = 0
-	local tpsKey = ""
-	if delim then
-	    tps = tonumber(string.sub(queue, delim+1))
+	local function updateOrgLoad(qType, org)
+		local workers = tonumber(redis.call("hget", qType .. ":org_workers", org)) or 0
+		local weight = tonumber(redis.call("hget", qType .. ":org_weights", org)) or 1
+		redis.call("zadd", qType .. ":active_orgs", "XX", workers / weight, "org:" .. org)
 	end
 
-	-- if we have a tps, then check whether we exceed it
-	if tps > 0 then
-	    tpsKey = queue .. ":tps:" .. math.floor(KEYS[1])
-	    local curr = redis.call("get", tpsKey)
-	    
-		-- we are at or above our tps, move to our throttled queue
-		if curr and tonumber(curr) >= tps then 
-			redis.call("zincrby", KEYS[2] .. ":throttled", workers, queue)
-			redis.call("zrem", KEYS[2] .. ":active", queue)
-			return {"retry", ""}
-  	    end
+	local function unschedule(qType, queueKey, org)
+		redis.call("srem", qType .. ":scheduled", queueKey)
+		if org then
+			redis.call("zrem", qType .. ":org_queues:" .. org, queueKey)
+			if redis.call("zcard", qType .. ":org_queues:" .. org) == 0 then
+				redis.call("zrem", qType .. ":active_orgs", "org:" .. org)
+			end
+		else
+			redis.call("zrem", qType .. ":active_orgs", queueKey)
+		end
+	end
+
+	-- adds the passed in number of workers to the passed in queue, making it active if it isn't already
+	local function activate(qType, queueKey, workers)
+		local score = tonumber(redis.call("zincrby", qType .. ":active", workers, queueKey))
+		if score < 0 then
+			score = 0
+			redis.call("zadd", qType .. ":active", 0, queueKey)
+		end
+
+		redis.call("sadd", qType .. ":scheduled", queueKey)
+		local org = queueOrg(qType, queueKey)
+		if org then
+			redis.call("zadd", qType .. ":org_queues:" .. org, score, queueKey)
+			redis.call("zadd", qType .. ":active_orgs", "NX", 0, "org:" .. org)
+			updateOrgLoad(qType, org)
+		else
+			redis.call("zadd", qType .. ":active_orgs", score, queueKey)
+		end
+	end
+
+	-- removes the passed in queue from our active list
+	local function deactivate(qType, queueKey)
+		redis.call("zrem", qType .. ":active", queueKey)
+		unschedule(qType, queueKey, queueOrg(qType, queueKey))
+	end
+
+	-- schedules any active queues which were made active without us
+	local function scheduleActive(qType)
+		if redis.call("zcard", qType .. ":active") == redis.call("scard", qType .. ":scheduled") then
+			return
+		end
+
+		local active = redis.call("zrange", qType .. ":active", 0, -1)
+		for i=1,#active do
+			if redis.call("sismember", qType .. ":scheduled", active[i]) == 0 then
+				activate(qType, active[i], 0)
+			end
+		end
 	end
+`
+
+// luaPopOne pops a single value, it takes the KEYS of the scripts which use it as an argument so that it reads the
+// same as a script of its own. It returns the queue and the value popped, or "retry" if the caller should try again
+// because the queue chosen from our active list couldn't be popped from, or "empty" if nothing is active.
+const luaPopOne = luaBucket + luaActive + `-- KEYS: [EpochMS QueueType]
+	local function popOne(KEYS)
+		scheduleActive(KEYS[2])
+
+		-- choose the org with the fewest workers for its weight, so an org with lots of busy queues can't take all our
+		-- workers from orgs with few, queues without an org are treated as an org of their own
+		local chosen = redis.call("zrange", KEYS[2] .. ":active_orgs", 0, 0)
+
+		-- nothing? return nothing
+		if not chosen[1] then
+			return {"empty", ""}
+		end
+
+		-- then the queue of that org with the fewest workers
+		local queue, org
+		if string.sub(chosen[1], 1, 4) == "org:" then
+			org = string.sub(chosen[1], 5)
+			queue = redis.call("zrange", KEYS[2] .. ":org_queues:" .. org, 0, 0)[1]
+			if not queue then
+				redis.call("zrem", KEYS[2] .. ":active_orgs", chosen[1])
+				return {"retry", ""}
+			end
+		else
+			queue = chosen[1]
+		end
 
-	-- pop our next value out, first from our default queue
-	local resultQueue = queue .. "/1"
-	local result = redis.call("zrangebyscore", resultQueue, 0, "+inf", "WITHSCORES", "LIMIT", 0, 1)
+		-- if our queue is no longer active or its org has changed since it was scheduled, reschedule it
+		local workers = redis.call("zscore", KEYS[2] .. ":active", queue)
+		if not workers or queueOrg(KEYS[2], queue) ~= org then
+			unschedule(KEYS[2], queue, org)
+			if workers then
+				activate(KEYS[2], queue, 0)
+			end
+			return {"retry", ""}
+		end
+
+		-- figure out our max transaction per second
+		local delim = string.find(queue, "|")
+		local tps = 0
+		local burst = 0
+		local probing = false
+		if delim then
+		    tps = tonumber(string.sub(queue, delim+1))
+
+		    -- if this queue is paused, move it to our paused list until it is resumed
+		    local name = string.sub(queue, string.len(KEYS[2]) + 2, delim-1)
+		    if tps > 0 then
+		        burst = bucketBurst(KEYS[2], name, tps)
+		    end
+		    if redis.call("sismember", KEYS[2] .. ":pauses", name) == 1 then
+		        redis.call("zincrby", KEYS[2] .. ":paused", workers, queue)
+		        deactivate(KEYS[2], queue)
+		        return {"retry", ""}
+		    end
+
+		    -- if our circuit breaker is open, or a probe is already out, move to our broken list
+		    local openUntil = redis.call("hget", KEYS[2] .. ":breakers", name)
+		    if openUntil then
+		        local probe = redis.call("hget", KEYS[2] .. ":probes", name)
+		        if tonumber(openUntil) > tonumber(KEYS[1]) or (probe and tonumber(probe) > tonumber(KEYS[1])) then
+		            redis.call("zincrby", KEYS[2] .. ":broken", workers, queue)
+		            deactivate(KEYS[2], queue)
+		            return {"retry", ""}
+		        end
+
+		        -- our cooldown is over, whatever we pop will be our probe
+		        probing = name
+		    end
+
+		    -- if we already have as many workers as our max concurrency allows, move to our saturated list until one completes
+		    local maxConcurrency = tonumber(redis.call("hget", KEYS[2] .. ":concurrency", name))
+		    if maxConcurrency and maxConcurrency > 0 and tonumber(workers) >= maxConcurrency then
+		        redis.call("zincrby", KEYS[2] .. ":saturated", workers, queue)
+		        deactivate(KEYS[2], queue)
+		        return {"retry", ""}
+		    end
+		end
+
+		-- if we have a tps, then check whether our bucket has a token for us
+		local tokens = 0
+		if tps > 0 then
+		    tokens = bucketTokens(queue, tonumber(KEYS[1]), tps, burst)
+
+			-- it doesn't, move to our throttled queue until it will have refilled enough to be popped from
+			if tokens < 1 then
+				redis.call("zincrby", KEYS[2] .. ":throttled", workers, queue)
+				redis.call("zadd", KEYS[2] .. ":throttled_until", tonumber(KEYS[1]) + (1 - tokens) / tps, queue)
+				deactivate(KEYS[2], queue)
+				return {"retry", ""}
+	  	    end
+		end
+
+		-- pop our next value out, first from our default queue
+		local resultQueue = queue .. "/1"
+		local result = redis.call("zrangebyscore", resultQueue, 0, "+inf", "WITHSCORES", "LIMIT", 0, 1)
 	
-	-- keep track as to whether this result is in the future (and therefore ineligible)
-	local isFutureResult = result[1] and tonumber(result[2]) > tonumber(KEYS[1])
-
-	-- if we didn't find one, try again from our bulk queue
-	if not result[1] or isFutureResult then
-		local bulkQueue = queue .. "/0"
-		local bulkResult = redis.call("zrangebyscore", bulkQueue, 0, "+inf", "WITHSCORES", "LIMIT", 0, 1)
-
-		-- if we got a result
-		if bulkResult[1] then
-			-- if it is in the future, set ourselves as in the future
-			if tonumber(bulkResult[2]) > tonumber(KEYS[1]) then
-				isFutureResult = true
+		-- keep track as to whether this result is in the future (and therefore ineligible)
+		local isFutureResult = result[1] and tonumber(result[2]) > tonumber(KEYS[1])
+
+		-- if we didn't find one, try again from our bulk queue
+		if not result[1] or isFutureResult then
+			local bulkQueue = queue .. "/0"
+			local bulkResult = redis.call("zrangebyscore", bulkQueue, 0, "+inf", "WITHSCORES", "LIMIT", 0, 1)
+
+			-- if we got a result
+			if bulkResult[1] then
+				-- if it is in the future, set ourselves as in the future
+				if tonumber(bulkResult[2]) > tonumber(KEYS[1]) then
+					isFutureResult = true
 			
-			-- otherwise, this is a valid result
-			else 
-				redis.call("echo", "found result")
-				isFutureResult = false
-				result = bulkResult
-				resultQueue = bulkQueue
+				-- otherwise, this is a valid result
+				else 
+					redis.call("echo", "found result")
+					isFutureResult = false
+					result = bulkResult
+					resultQueue = bulkQueue
+				end
 			end
 		end
-	end
 
-	-- if we found one
-	if result[1] and not isFutureResult then
-		-- then remove it from the queue
-		redis.call('zremrangebyrank', resultQueue, 0, 0)
+		-- if we found one
+		if result[1] and not isFutureResult then
+			-- then remove it from the queue
+			redis.call('zremrangebyrank', resultQueue, 0, 0)
 
-		-- and add a worker to this queue
-		redis.call("zincrby", KEYS[2] .. ":active", 1, queue)
+			-- if this is a probe for our circuit breaker, give it as long as a send can take to report back before
+			-- allowing another, breakers opened without a probe timeout give it a minute
+			if probing then
+			    local probeTimeout = tonumber(redis.call("hget", KEYS[2] .. ":probe_timeouts", probing)) or 60
+			    redis.call("hset", KEYS[2] .. ":probes", probing, tonumber(KEYS[1]) + probeTimeout)
+			end
 
-		-- parse it as JSON to get the first element out
-		local valueList = cjson.decode(result[1])
-		local popValue = cjson.encode(valueList[1])
-		table.remove(valueList, 1)
+			-- and add a worker to this queue and its org
+			if org then
+			    redis.call("hincrby", KEYS[2] .. ":org_workers", org, 1)
+			end
+			activate(KEYS[2], queue, 1)
 
-		-- increment our tps for this second if we have a limit
-		if tps > 0 then 
-		    redis.call("incrby", tpsKey, popValue["tps_cost"] or 1)
-		    redis.call("expire", tpsKey, 10)
-		end 
+			-- parse it as JSON to get the first element out
+			local valueList = cjson.decode(result[1])
+			local popValue = cjson.encode(valueList[1])
+			local popped = table.remove(valueList, 1)
 
-		-- encode it back if there is anything left
-		if table.getn(valueList) > 0 then
-		    local remaining = cjson.encode(valueList)
+			-- take our cost from our bucket if we have a limit, our bucket can go into debt for values which cost more
+			-- than it holds, and expires once it would have refilled anyway
+			if tps > 0 then
+			    local cost = 1
+			    if type(popped) == "table" then
+			        cost = math.max(tonumber(popped["tps_cost"]) or 1, 1)
+			    end
+			    redis.call("hmset", queue .. ":bucket", "tokens", tokens - cost, "updated", KEYS[1])
+			    redis.call("expire", queue .. ":bucket", math.ceil((burst - tokens + cost) / tps) + 1)
+			end
+
+			-- encode it back if there is anything left
+			if table.getn(valueList) > 0 then
+			    local remaining = cjson.encode(valueList)
 	        
-            -- schedule it in the future 3 seconds on our main queue
-            redis.call("zadd", queue .. "/1", tonumber(KEYS[1]) + 3, remaining)
-            redis.call("zincrby", KEYS[2] .. ":future", 0, queue)
-		end
+	            -- schedule it in the future 3 seconds on our main queue
+	            redis.call("zadd", queue .. "/1", tonumber(KEYS[1]) + 3, remaining)
+	            redis.call("zincrby", KEYS[2] .. ":future", 0, queue)
+			end
 
-		return {queue, popValue}
+			-- our worker token includes the org we counted its worker against so that it is freed from the same one
+			if org then
+			    return {queue .. "#" .. org, popValue}
+			end
+			return {queue, popValue}
 
-	-- otherwise, the queue only contains future results, remove from active and add to future, have the caller retry
-	elseif isFutureResult then
-	    redis.call("zincrby", KEYS[2] .. ":future", 0, queue)
-	    redis.call("zrem", KEYS[2] .. ":active", queue)
-		return {"retry", ""}
+		-- otherwise, the queue only contains future results, remove from active and add to future, have the caller retry
+		elseif isFutureResult then
+		    redis.call("zincrby", KEYS[2] .. ":future", 0, queue)
+		    deactivate(KEYS[2], queue)
+			return {"retry", ""}
 	
-	-- otherwise, the queue is empty, remove it from active
-	else
-		redis.call("zrem", KEYS[2] .. ":active", queue)
-		return {"retry", ""}
+		-- otherwise, the queue is empty, remove it from active
+		else
+			deactivate(KEYS[2], queue)
+			return {"retry", ""}
+		end
 	end
+`
+
+var luaPop = redis.NewScript(2, luaPopOne+`-- KEYS: [EpochMS QueueType]
+	return popOne(KEYS)
 `)
 
 // PopFromQueue pops the next available message from the passed in queue. If QueueRetry
@@ -176,8 +405,7 @@ var luaPop = redis.NewScript(2, `-- KEYS: [EpochMS QueueType]
 // worker token of EmptyQueue will be returned if there are no more items to retrive.
 // Otherwise the WorkerToken should be saved in order to mark the task as complete later.
 func PopFromQueue(conn redis.Conn, qType string) (WorkerToken, string, error) {
-	epochMS := strconv.FormatFloat(float64(time.Now().UnixNano()/int64(time.Microsecond))/float64(1000000), 'f', 6, 64)
-	values, err := redis.Strings(luaPop.Do(conn, epochMS, qType))
+	values, err := redis.Strings(luaPop.Do(conn, epochMS(), qType))
 	if err != nil {
 		logrus.Error(err)
 		return "", "", err
@@ -185,22 +413,93 @@ func PopFromQueue(conn redis.Conn, qType string) (WorkerToken, string, error) {
 	return WorkerToken(values[0]), values[1], nil
 }
 
-var luaComplete = redis.NewScript(2, `-- KEYS: [QueueType, Queue]
+var luaPopMany = redis.NewScript(3, luaPopOne+`-- KEYS: [EpochMS QueueType Count]
+	-- pop until we have as many values as we were asked for or nothing is left active, every retry removes a
+	-- queue from our active list or reschedules one which was scheduled wrongly so this always ends
+	local popped = {}
+	while #popped < tonumber(KEYS[3]) * 2 do
+		local result = popOne({KEYS[1], KEYS[2]})
+		if result[1] == "empty" then
+			break
+		elseif result[1] ~= "retry" then
+			table.insert(popped, result[1])
+			table.insert(popped, result[2])
+		end
+	end
+	return popped
+`)
+
+// PopManyFromQueue pops up to count available messages from the passed in queue in a single
+// call, returning the worker token of each alongside its value. Unlike PopFromQueue, retries
+// are taken care of for the caller, if no tokens are returned there are no items to retrieve.
+func PopManyFromQueue(conn redis.Conn, qType string, count int) ([]WorkerToken, []string, error) {
+	values, err := redis.Strings(luaPopMany.Do(conn, epochMS(), qType, count))
+	if err != nil {
+		logrus.Error(err)
+		return nil, nil, err
+	}
+
+	tokens := make([]WorkerToken, 0, len(values)/2)
+	popped := make([]string, 0, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		tokens = append(tokens, WorkerToken(values[i]))
+		popped = append(popped, values[i+1])
+	}
+	return tokens, popped, nil
+}
+
+var luaComplete = redis.NewScript(2, luaActive+`-- KEYS: [QueueType, Token]
+	-- our token is our queue, followed by the org its worker was counted against if it has one, decrement its workers
+	local queue = KEYS[2]
+	local orgDelim = string.find(KEYS[2], "#")
+	if orgDelim then
+		queue = string.sub(KEYS[2], 1, orgDelim - 1)
+		local org = string.sub(KEYS[2], orgDelim + 1)
+		if redis.call("hincrby", KEYS[1] .. ":org_workers", org, -1) <= 0 then
+			redis.call("hdel", KEYS[1] .. ":org_workers", org)
+		end
+		updateOrgLoad(KEYS[1], org)
+	end
+
 	-- decrement throttled if present
-	local throttled = tonumber(redis.call("zadd", KEYS[1] .. ":throttled", "XX", "CH", "INCR", -1, KEYS[2]))
+	local throttled = tonumber(redis.call("zadd", KEYS[1] .. ":throttled", "XX", "CH", "INCR", -1, queue))
 
-	-- if we didn't decrement anything, do so to our active set
+	-- otherwise decrement saturated if present, a worker is now free so it can go back to being active
+	local saturated = false
 	if not throttled or throttled == 0 then
-		local active = tonumber(redis.call("zincrby", KEYS[1] .. ":active", -1, KEYS[2]))
-		
-		-- reset to zero if we somehow go below
-		if active < 0 then
-			redis.call("zadd", KEYS[1] .. ":active", 0, KEYS[2])
+		saturated = redis.call("zadd", KEYS[1] .. ":saturated", "XX", "INCR", -1, queue)
+		if saturated then
+			activate(KEYS[1], queue, math.max(tonumber(saturated), 0))
+			redis.call("zrem", KEYS[1] .. ":saturated", queue)
+			redis.call("publish", KEYS[1] .. ":notify", queue)
 		end
 	end
+
+	-- otherwise decrement paused if present
+	local paused = false
+	if (not throttled or throttled == 0) and not saturated then
+		paused = redis.call("zadd", KEYS[1] .. ":paused", "XX", "INCR", -1, queue)
+		if paused and tonumber(paused) < 0 then
+			redis.call("zadd", KEYS[1] .. ":paused", 0, queue)
+		end
+	end
+
+	-- otherwise decrement broken if present
+	local broken = false
+	if (not throttled or throttled == 0) and not saturated and not paused then
+		broken = redis.call("zadd", KEYS[1] .. ":broken", "XX", "INCR", -1, queue)
+		if broken and tonumber(broken) < 0 then
+			redis.call("zadd", KEYS[1] .. ":broken", 0, queue)
+		end
+	end
+
+	-- if we didn't decrement anything, do so to our active set
+	if (not throttled or throttled == 0) and not saturated and not paused and not broken then
+		activate(KEYS[1], queue, -1)
+	end
 `)
 
-// MarkComplete marks a task as complete for the passed in queue and queue result. It is
+// MarkComplete marks a task as complete for the passed in worker token. It is
 // important for callers to call this so that workers are evenly spread across all
 // queues with jobs in them
 func MarkComplete(conn redis.Conn, qType string, token WorkerToken) error {
@@ -208,40 +507,130 @@ func MarkComplete(conn redis.Conn, qType string, token WorkerToken) error {
 	return err
 }
 
-var luaDethrottle = redis.NewScript(1, `-- KEYS: [QueueType]
-	-- get all the keys from our throttle list
-	local throttled = redis.call("zrange", KEYS[1] .. ":throttled", 0, -1, "WITHSCORES")
+var luaDethrottle = redis.NewScript(3, luaActive+`-- KEYS: [QueueType, EpochMS, Sweep]
+	local woken = 0
 
-	-- add them to our active list
-	if next(throttled) then
-		local activeKey = KEYS[1] .. ":active"
-		for i=1,#throttled,2 do
-			redis.call("zincrby", activeKey, throttled[i+1], throttled[i])
+	-- move any throttled queues whose buckets have refilled enough to be popped from back to active
+	local refilled = redis.call("zrangebyscore", KEYS[1] .. ":throttled_until", "-inf", KEYS[2])
+	for i=1,#refilled do
+		local workers = redis.call("zscore", KEYS[1] .. ":throttled", refilled[i])
+		if workers then
+			activate(KEYS[1], refilled[i], workers)
+			redis.call("zrem", KEYS[1] .. ":throttled", refilled[i])
+			woken = woken + 1
 		end
-		redis.call("del", KEYS[1] .. ":throttled")
+		redis.call("zrem", KEYS[1] .. ":throttled_until", refilled[i])
 	end
 
-	-- get all the keys in the future
-	local future = redis.call("zrange", KEYS[1] .. ":future", 0, -1, "WITHSCORES")
+	-- everything else is only checked when we are asked to sweep, which is once a second
+	if KEYS[3] == "1" then
+		-- move any throttled queues which don't know when they'll be ready back to active
+		local throttled = redis.call("zrange", KEYS[1] .. ":throttled", 0, -1, "WITHSCORES")
+		for i=1,#throttled,2 do
+			if not redis.call("zscore", KEYS[1] .. ":throttled_until", throttled[i]) then
+				activate(KEYS[1], throttled[i], throttled[i+1])
+				redis.call("zrem", KEYS[1] .. ":throttled", throttled[i])
+				woken = woken + 1
+			end
+		end
+
+		-- get all the keys in the future
+		local future = redis.call("zrange", KEYS[1] .. ":future", 0, -1, "WITHSCORES")
+
+		-- add them to our active list, unless they are saturated, paused or broken in which case they'll find their
+		-- future items once they are moved back to active
+		if next(future) then
+			for i=1,#future,2 do
+				if not redis.call("zscore", KEYS[1] .. ":saturated", future[i]) and not redis.call("zscore", KEYS[1] .. ":paused", future[i]) and not redis.call("zscore", KEYS[1] .. ":broken", future[i]) then
+					activate(KEYS[1], future[i], future[i+1])
+					woken = woken + 1
+				end
+			end
+			redis.call("del", KEYS[1] .. ":future")
+		end
+
+		-- move any paused queues back to active if they are no longer paused
+		local paused = redis.call("zrange", KEYS[1] .. ":paused", 0, -1, "WITHSCORES")
+		for i=1,#paused,2 do
+			local delim = string.find(paused[i], "|")
+			if delim then
+				local name = string.sub(paused[i], string.len(KEYS[1]) + 2, delim-1)
+				if redis.call("sismember", KEYS[1] .. ":pauses", name) == 0 then
+					activate(KEYS[1], paused[i], paused[i+1])
+					redis.call("zrem", KEYS[1] .. ":paused", paused[i])
+					woken = woken + 1
+				end
+			end
+		end
+
+		-- move any broken queues back to active if their circuit breakers have closed or can be probed
+		local broken = redis.call("zrange", KEYS[1] .. ":broken", 0, -1, "WITHSCORES")
+		for i=1,#broken,2 do
+			local delim = string.find(broken[i], "|")
+			if delim then
+				local name = string.sub(broken[i], string.len(KEYS[1]) + 2, delim-1)
+				local ready = true
 
-	-- add them to our active list
-	if next(future) then
-		local activeKey = KEYS[1] .. ":active"
-		for i=1,#future,2 do
-			redis.call("zincrby", activeKey, future[i+1], future[i])
+				local openUntil = redis.call("hget", KEYS[1] .. ":breakers", name)
+				local probe = redis.call("hget", KEYS[1] .. ":probes", name)
+				if openUntil and tonumber(openUntil) > tonumber(KEYS[2]) then
+					ready = false
+				elseif openUntil and probe and tonumber(probe) > tonumber(KEYS[2]) then
+					ready = false
+				end
+
+				if ready then
+					activate(KEYS[1], broken[i], broken[i+1])
+					redis.call("zrem", KEYS[1] .. ":broken", broken[i])
+					woken = woken + 1
+				end
+			end
+		end
+
+		-- move any saturated queues back to active if their max concurrency has been raised or removed since they were saturated
+		local saturated = redis.call("zrange", KEYS[1] .. ":saturated", 0, -1, "WITHSCORES")
+		for i=1,#saturated,2 do
+			local delim = string.find(saturated[i], "|")
+			if delim then
+				local name = string.sub(saturated[i], string.len(KEYS[1]) + 2, delim-1)
+				local maxConcurrency = tonumber(redis.call("hget", KEYS[1] .. ":concurrency", name))
+
+				if not maxConcurrency or maxConcurrency <= 0 or tonumber(saturated[i+1]) < maxConcurrency then
+					activate(KEYS[1], saturated[i], saturated[i+1])
+					redis.call("zrem", KEYS[1] .. ":saturated", saturated[i])
+					woken = woken + 1
+				end
+			end
 		end
-		redis.call("del", KEYS[1] .. ":future")
 	end
+
+	-- if any queues can be popped from again, let anybody waiting on us know
+	if woken > 0 then
+		redis.call("publish", KEYS[1] .. ":notify", woken)
+	end
+
+	-- return when the next throttled queue will be ready, as a string as numbers are returned as integers
+	local upcoming = redis.call("zrange", KEYS[1] .. ":throttled_until", 0, 0, "WITHSCORES")
+	if upcoming[2] then
+		return upcoming[2]
+	end
+	return "0"
 `)
 
-// StartDethrottler starts a goroutine responsible for dethrottling any queues that were
-// throttled every second. The passed in quitter chan can be used to shut down the goroutine
-func StartDethrottler(redis *redis.Pool, quitter chan bool, wg *sync.WaitGroup, qType string) {
+// the shortest time our dethrottler waits between runs, so a queue which is about to refill doesn't keep it busy
+var dethrottleMinDelay = 10 * time.Millisecond
+
+// StartDethrottler starts a goroutine responsible for dethrottling any queues that were throttled as soon
+// as their buckets have refilled enough to be popped from again, and for moving queues which were in the
+// future, paused or saturated back to active every second. The passed in quitter chan can be used to shut
+// down the goroutine
+func StartDethrottler(pool *redis.Pool, quitter chan bool, wg *sync.WaitGroup, qType string) {
 	go func() {
 		wg.Add(1)
 
-		// figure out our next delay, we want to land just on the other side of a second boundary
-		delay := time.Second - time.Duration(time.Now().UnixNano()%int64(time.Second))
+		// figure out our next sweep, we want to land just on the other side of a second boundary
+		nextSweep := time.Now().Truncate(time.Second).Add(time.Second)
+		delay := nextSweep.Sub(time.Now())
 
 		for true {
 			select {
@@ -250,15 +639,441 @@ func StartDethrottler(redis *redis.Pool, quitter chan bool, wg *sync.WaitGroup,
 				return
 
 			case <-time.After(delay):
-				conn := redis.Get()
-				_, err := luaDethrottle.Do(conn, qType)
+				sweep := "0"
+				if !time.Now().Before(nextSweep) {
+					sweep = "1"
+					nextSweep = time.Now().Truncate(time.Second).Add(time.Second)
+				}
+
+				conn := pool.Get()
+				ready, err := redis.Float64(luaDethrottle.Do(conn, qType, epochMS(), sweep))
 				if err != nil {
 					logrus.WithError(err).Error("error dethrottling")
 				}
 				conn.Close()
 
-				delay = time.Second - time.Duration(time.Now().UnixNano()%int64(time.Second))
+				// wait until our next sweep, or until the next throttled queue will be ready if that is sooner
+				next := nextSweep
+				if ready > 0 {
+					readyAt := time.Unix(0, int64(ready*float64(time.Second)))
+					if readyAt.Before(next) {
+						next = readyAt
+					}
+				}
+
+				delay = next.Sub(time.Now())
+				if delay < dethrottleMinDelay {
+					delay = dethrottleMinDelay
+				}
 			}
 		}
 	}()
 }
+
+// StartNotifier starts a goroutine which subscribes to the notifications published for queues of the passed
+// in type, sending to the passed in notify chan for each without blocking, so a chan with a buffer of one will
+// hold a single pending notification. If our subscription is lost we resubscribe, sending a notification in
+// case we missed any. The passed in quitter chan can be used to shut down the goroutine
+func StartNotifier(pool *redis.Pool, quitter chan bool, wg *sync.WaitGroup, qType string, notify chan bool) {
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		for {
+			err := listenForNotifications(pool, quitter, qType, notify)
+
+			select {
+			case <-quitter:
+				return
+
+			case <-time.After(time.Second * 5):
+				logrus.WithError(err).WithField("queue_type", qType).Error("queue notification subscription lost, resubscribing")
+			}
+		}
+	}()
+}
+
+// listenForNotifications subscribes to the notifications of the passed in queue type, returning when our
+// connection errors or we are told to quit
+func listenForNotifications(pool *redis.Pool, quitter chan bool, qType string, notify chan bool) error {
+	// we need a connection of our own as receiving blocks, and closing a pooled connection doesn't unblock it
+	conn, err := pool.Dial()
+	if err != nil {
+		return err
+	}
+	psc := redis.PubSubConn{Conn: conn}
+
+	// close our connection when we are told to quit
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		select {
+		case <-quitter:
+		case <-done:
+		}
+		psc.Close()
+	}()
+
+	err = psc.Subscribe(NotifyChannel(qType))
+	if err != nil {
+		return err
+	}
+
+	for {
+		switch v := psc.Receive().(type) {
+		case redis.Message, redis.Subscription:
+			select {
+			case notify <- true:
+			default:
+			}
+
+		case error:
+			return v
+		}
+	}
+}
+
+// Queue states, a queue is active while it can be popped from, throttled while its token bucket
+// refills after using up its burst, saturated while it has as many workers as its max concurrency allows,
+// future when it only contains items scheduled for later, paused when it has been paused and will not
+// be popped from until resumed and broken while its circuit breaker is open or waiting on a probe
+const (
+	StateActive    = "active"
+	StateThrottled = "throttled"
+	StateSaturated = "saturated"
+	StateFuture    = "future"
+	StatePaused    = "paused"
+	StateBroken    = "broken"
+)
+
+// queueStates are all the states a queue can be in
+var queueStates = []string{StateActive, StateThrottled, StateSaturated, StateFuture, StatePaused, StateBroken}
+
+// SetMaxConcurrency sets the maximum number of workers which can be popping from the passed in queue at once,
+// a value of 0 means there is no limit. Once a queue has that many workers it won't be popped from again until
+// one of them is marked as complete.
+func SetMaxConcurrency(conn redis.Conn, qType string, queue string, maxConcurrency int) error {
+	var err error
+	if maxConcurrency > 0 {
+		_, err = conn.Do("hset", qType+":concurrency", queue, maxConcurrency)
+	} else {
+		_, err = conn.Do("hdel", qType+":concurrency", queue)
+	}
+	return err
+}
+
+// SetBurst sets how many items can be popped from the passed in queue in a burst, before it is limited to
+// its TPS, a value of 0 means the burst is the same as its TPS. Items are popped smoothly at its TPS once
+// its burst has been used up.
+func SetBurst(conn redis.Conn, qType string, queue string, burst int) error {
+	var err error
+	if burst > 0 {
+		_, err = conn.Do("hset", qType+":bursts", queue, burst)
+	} else {
+		_, err = conn.Do("hdel", qType+":bursts", queue)
+	}
+	return err
+}
+
+// PauseQueue pauses the passed in queue, items will remain in the queue but will not be popped until
+// the queue is resumed
+func PauseQueue(conn redis.Conn, qType string, queue string) error {
+	_, err := conn.Do("sadd", qType+":pauses", queue)
+	return err
+}
+
+var luaResume = redis.NewScript(2, luaActive+`-- KEYS: [QueueType, Queue]
+	redis.call("srem", KEYS[1] .. ":pauses", KEYS[2])
+
+	-- move any of our paused queues back to active
+	local prefix = KEYS[1] .. ":" .. KEYS[2] .. "|"
+	local paused = redis.call("zrange", KEYS[1] .. ":paused", 0, -1, "WITHSCORES")
+	for i=1,#paused,2 do
+		if string.sub(paused[i], 1, string.len(prefix)) == prefix then
+			activate(KEYS[1], paused[i], paused[i+1])
+			redis.call("zrem", KEYS[1] .. ":paused", paused[i])
+			redis.call("publish", KEYS[1] .. ":notify", paused[i])
+		end
+	end
+`)
+
+// ResumeQueue resumes the passed in queue, making its items available to be popped again
+func ResumeQueue(conn redis.Conn, qType string, queue string) error {
+	_, err := luaResume.Do(conn, qType, queue)
+	return err
+}
+
+// IsQueuePaused returns whether the passed in queue is currently paused
+func IsQueuePaused(conn redis.Conn, qType string, queue string) (bool, error) {
+	return redis.Bool(conn.Do("sismember", qType+":pauses", queue))
+}
+
+// PausedQueues returns the names of all the queues of the passed in type which are currently paused
+func PausedQueues(conn redis.Conn, qType string) ([]string, error) {
+	paused, err := redis.Strings(conn.Do("smembers", qType+":pauses"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paused)
+	return paused, nil
+}
+
+// Info describes the current state of a single queue
+type Info struct {
+	Queue    string
+	TPS      int
+	State    string
+	Workers  int
+	Paused   bool
+	Breaker  BreakerState
+	Size     int
+	BulkSize int
+}
+
+// Item is a single item in a queue, usually a JSON list of values which are popped one at a time
+type Item struct {
+	Priority Priority
+	Score    float64
+	Value    string
+}
+
+// ListQueues returns info on all the active, throttled, saturated, future and paused queues of the passed in type
+func ListQueues(conn redis.Conn, qType string) ([]*Info, error) {
+	infos := make([]*Info, 0)
+
+	paused, err := PausedQueues(conn, qType)
+	if err != nil {
+		return nil, err
+	}
+
+	breakers, err := ListBreakers(conn, qType)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, state := range queueStates {
+		values, err := redis.Values(conn.Do("zrevrangebyscore", qType+":"+state, "+inf", "-inf", "withscores"))
+		if err != nil {
+			return nil, err
+		}
+
+		for len(values) > 0 {
+			var queueKey string
+			var workers float64
+
+			values, err = redis.Scan(values, &queueKey, &workers)
+			if err != nil {
+				return nil, err
+			}
+
+			queue, tps, err := parseQueueKey(qType, queueKey)
+			if err != nil {
+				return nil, err
+			}
+
+			conn.Send("zcard", queueKey+"/1")
+			conn.Send("zcard", queueKey+"/0")
+			conn.Flush()
+
+			size, err := redis.Int(conn.Receive())
+			if err != nil {
+				return nil, err
+			}
+			bulkSize, err := redis.Int(conn.Receive())
+			if err != nil {
+				return nil, err
+			}
+
+			breaker := BreakerClosed
+			if breakers[queue] != nil {
+				breaker = breakers[queue].State
+			}
+
+			infos = append(infos, &Info{
+				Queue:    queue,
+				TPS:      tps,
+				State:    state,
+				Workers:  int(workers),
+				Paused:   isPaused(paused, queue),
+				Breaker:  breaker,
+				Size:     size,
+				BulkSize: bulkSize,
+			})
+		}
+	}
+
+	return infos, nil
+}
+
+// PeekQueue returns up to count of the next items in the passed in queue without removing them,
+// high priority items are returned before bulk items
+func PeekQueue(conn redis.Conn, qType string, queue string, count int) ([]*Item, error) {
+	items := make([]*Item, 0, count)
+	if count <= 0 {
+		return items, nil
+	}
+
+	queueKeys, err := findQueueKeys(conn, qType, queue)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, priority := range []Priority{HighPriority, LowPriority} {
+		for _, queueKey := range queueKeys {
+			if len(items) >= count {
+				return items, nil
+			}
+
+			values, err := redis.Values(conn.Do("zrange", fmt.Sprintf("%s/%d", queueKey, priority), 0, count-len(items)-1, "withscores"))
+			if err != nil {
+				return nil, err
+			}
+
+			for len(values) > 0 {
+				item := &Item{Priority: priority}
+				values, err = redis.Scan(values, &item.Value, &item.Score)
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, item)
+			}
+		}
+	}
+
+	return items, nil
+}
+
+// PurgeQueue removes all the items in the passed in queue, regardless of their priority, returning
+// the number of items removed
+func PurgeQueue(conn redis.Conn, qType string, queue string) (int, error) {
+	items, err := DrainQueue(conn, qType, queue)
+	return len(items), err
+}
+
+// DrainQueue removes all the items in the passed in queue, regardless of their priority, returning
+// the items removed with high priority items first
+func DrainQueue(conn redis.Conn, qType string, queue string) ([]*Item, error) {
+	queueKeys, err := findQueueKeys(conn, qType, queue)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*Item, 0)
+	for _, queueKey := range queueKeys {
+		conn.Send("MULTI")
+		conn.Send("zrange", queueKey+"/1", 0, -1, "withscores")
+		conn.Send("zrange", queueKey+"/0", 0, -1, "withscores")
+		conn.Send("del", queueKey+"/1", queueKey+"/0")
+		for _, state := range queueStates {
+			conn.Send("zrem", qType+":"+state, queueKey)
+		}
+		conn.Send("zrem", qType+":throttled_until", queueKey)
+		results, err := redis.Values(conn.Do("EXEC"))
+		if err != nil {
+			return items, err
+		}
+
+		for i, priority := range []Priority{HighPriority, LowPriority} {
+			values, err := redis.Values(results[i], nil)
+			if err != nil {
+				return items, err
+			}
+
+			for len(values) > 0 {
+				item := &Item{Priority: priority}
+				values, err = redis.Scan(values, &item.Value, &item.Score)
+				if err != nil {
+					return items, err
+				}
+				items = append(items, item)
+			}
+		}
+	}
+
+	return items, nil
+}
+
+func isPaused(paused []string, queue string) bool {
+	for _, p := range paused {
+		if p == queue {
+			return true
+		}
+	}
+	return false
+}
+
+// findQueueKeys returns the keys of all queues with the passed in name, a queue may exist under more than one
+// key if its TPS has changed while it still contained items
+func findQueueKeys(conn redis.Conn, qType string, queue string) ([]string, error) {
+	prefix := qType + ":" + queue + "|"
+	found := make(map[string]bool)
+	queueKeys := make([]string, 0, 1)
+
+	cursor := 0
+	for {
+		values, err := redis.Values(conn.Do("scan", cursor, "match", prefix+"*", "count", 1000))
+		if err != nil {
+			return nil, err
+		}
+
+		var keys []string
+		_, err = redis.Scan(values, &cursor, &keys)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range keys {
+			// only consider our priority queues, ex: msgs:uuid|10/1
+			slash := strings.LastIndex(key, "/")
+			if slash < 0 || strings.Contains(key[len(prefix):], ":") {
+				continue
+			}
+
+			queueKey := key[:slash]
+			if !found[queueKey] {
+				found[queueKey] = true
+				queueKeys = append(queueKeys, queueKey)
+			}
+		}
+
+		if cursor == 0 {
+			break
+		}
+	}
+
+	sort.Strings(queueKeys)
+	return queueKeys, nil
+}
+
+// epochMS returns the current time as seconds since the epoch with microsecond precision, this is the
+// format all our scripts expect times in
+func epochMS() string {
+	return epochMSFor(time.Now())
+}
+
+// epochMSFor returns the passed in time in the same format as epochMS, zero times are returned as 0
+func epochMSFor(t time.Time) string {
+	if t.IsZero() {
+		return "0"
+	}
+	return strconv.FormatFloat(float64(t.UnixNano()/int64(time.Microsecond))/float64(1000000), 'f', 6, 64)
+}
+
+// parseQueueKey splits a queue key such as msgs:uuid|10 into its queue name and TPS
+func parseQueueKey(qType string, queueKey string) (string, int, error) {
+	// worker tokens are queue keys which may be followed by the org of their worker
+	queueKey = strings.SplitN(queueKey, "#", 2)[0]
+
+	parts := strings.Split(strings.TrimPrefix(queueKey, qType+":"), "|")
+	if len(parts) != 2 {
+		return "", 0, fmt.Errorf("error parsing queue name '%s'", queueKey)
+	}
+
+	tps, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return "", 0, fmt.Errorf("error parsing tps for queue '%s'", queueKey)
+	}
+
+	return parts[0], tps, nil
+}
@@ -51,7 +51,7 @@ func TestLua(t *testing.T) {
 
 	rate := 10
 	for i := 0; i < 20; i++ {
-		err := PushOntoQueue(conn, "msgs", "chan1", rate, fmt.Sprintf(`[{"id":%d}]`, i), LowPriority)
+		err := PushOntoQueue(conn, "msgs", "chan1", rate, fmt.Sprintf(`[{"id":%d}]`, i), LowPriority, time.Time{})
 		assert.NoError(err)
 	}
 
@@ -87,7 +87,7 @@ func TestLua(t *testing.T) {
 	if value != "" && queue != EmptyQueue {
 		t.Fatal("Should be throttled")
 	}
-	err = PushOntoQueue(conn, "msgs", "chan1", rate, `[{"id":30}]`, LowPriority)
+	err = PushOntoQueue(conn, "msgs", "chan1", rate, `[{"id":30}]`, LowPriority, time.Time{})
 	assert.NoError(err)
 
 	count, err = redis.Int(conn.Do("zcard", "msgs:throttled"))
@@ -100,7 +100,7 @@ func TestLua(t *testing.T) {
 
 	// but if we wait, our next msg should be our highest priority
 	time.Sleep(time.Second)
-	err = PushOntoQueue(conn, "msgs", "chan1", rate, `[{"id":31}]`, HighPriority)
+	err = PushOntoQueue(conn, "msgs", "chan1", rate, `[{"id":31}]`, HighPriority, time.Time{})
 	assert.NoError(err)
 
 	queue, value, err = PopFromQueue(conn, "msgs")
@@ -117,7 +117,7 @@ func TestLua(t *testing.T) {
 	}
 
 	// push on a compound message
-	err = PushOntoQueue(conn, "msgs", "chan1", rate, `[{"id":32}, {"id":33}]`, HighPriority)
+	err = PushOntoQueue(conn, "msgs", "chan1", rate, `[{"id":32}, {"id":33}]`, HighPriority, time.Time{})
 
 	queue, value, err = PopFromQueue(conn, "msgs")
 	assert.NoError(err)
@@ -184,7 +184,7 @@ func nTestThrottle(t *testing.T) {
 
 	// insert items with our set limit
 	for i := 0; i < insertCount; i++ {
-		err := PushOntoQueue(conn, "msgs", "chan1", rate, fmt.Sprintf(`[{"id":%d}]`, i), HighPriority)
+		err := PushOntoQueue(conn, "msgs", "chan1", rate, fmt.Sprintf(`[{"id":%d}]`, i), HighPriority, time.Time{})
 		assert.NoError(err)
 		time.Sleep(1 * time.Microsecond)
 	}
@@ -228,6 +228,496 @@ func nTestThrottle(t *testing.T) {
 	wg.Wait()
 }
 
+func TestInspect(t *testing.T) {
+	assert := assert.New(t)
+	pool := getPool()
+	conn := pool.Get()
+	defer conn.Close()
+
+	// nothing queued yet
+	queues, err := ListQueues(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(0, len(queues))
+
+	assert.NoError(PushOntoQueue(conn, "msgs", "chan1", 10, `[{"id":1}]`, LowPriority, time.Time{}))
+	assert.NoError(PushOntoQueue(conn, "msgs", "chan1", 10, `[{"id":2}]`, HighPriority, time.Time{}))
+	assert.NoError(PushOntoQueue(conn, "msgs", "chan1", 10, `[{"id":3}]`, LowPriority, time.Time{}))
+	assert.NoError(PushOntoQueue(conn, "msgs", "chan2", 0, `[{"id":4}]`, HighPriority, time.Time{}))
+
+	// pop one off of chan2 so that it has a worker
+	token, _, err := PopFromQueue(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(WorkerToken("msgs:chan2|0"), token)
+
+	queues, err = ListQueues(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal([]*Info{
+		{Queue: "chan2", TPS: 0, State: StateActive, Workers: 1, Breaker: BreakerClosed, Size: 0, BulkSize: 0},
+		{Queue: "chan1", TPS: 10, State: StateActive, Workers: 0, Breaker: BreakerClosed, Size: 1, BulkSize: 2},
+	}, queues)
+
+	// peek at chan1, high priority items come first
+	items, err := PeekQueue(conn, "msgs", "chan1", 2)
+	assert.NoError(err)
+	assert.Equal(2, len(items))
+	assert.Equal(Priority(HighPriority), items[0].Priority)
+	assert.Equal(`[{"id":2}]`, items[0].Value)
+	assert.Equal(Priority(LowPriority), items[1].Priority)
+	assert.Equal(`[{"id":1}]`, items[1].Value)
+
+	// peeking doesn't remove anything
+	items, err = PeekQueue(conn, "msgs", "chan1", 10)
+	assert.NoError(err)
+	assert.Equal(3, len(items))
+
+	// peeking an unknown queue returns nothing
+	items, err = PeekQueue(conn, "msgs", "chan3", 10)
+	assert.NoError(err)
+	assert.Equal(0, len(items))
+
+	// purge chan1
+	purged, err := PurgeQueue(conn, "msgs", "chan1")
+	assert.NoError(err)
+	assert.Equal(3, purged)
+
+	queues, err = ListQueues(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(1, len(queues))
+	assert.Equal("chan2", queues[0].Queue)
+
+	// popping returns nothing from chan1
+	assert.NoError(MarkComplete(conn, "msgs", token))
+	token, _, err = PopFromQueue(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(Retry, token)
+	token, _, err = PopFromQueue(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(EmptyQueue, token)
+}
+
+func TestPause(t *testing.T) {
+	assert := assert.New(t)
+	pool := getPool()
+	conn := pool.Get()
+	defer conn.Close()
+
+	assert.NoError(PushOntoQueue(conn, "msgs", "chan1", 0, `[{"id":1}]`, HighPriority, time.Time{}))
+	assert.NoError(PushOntoQueue(conn, "msgs", "chan1", 0, `[{"id":2}]`, HighPriority, time.Time{}))
+	assert.NoError(PushOntoQueue(conn, "msgs", "chan2", 0, `[{"id":3}]`, HighPriority, time.Time{}))
+
+	// pop one off of chan1 so it has a worker, then pause it
+	token, value, err := PopFromQueue(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(WorkerToken("msgs:chan1|0"), token)
+	assert.Equal(`{"id":1}`, value)
+
+	assert.NoError(PauseQueue(conn, "msgs", "chan1"))
+
+	paused, err := PausedQueues(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal([]string{"chan1"}, paused)
+
+	isPaused, err := IsQueuePaused(conn, "msgs", "chan1")
+	assert.NoError(err)
+	assert.True(isPaused)
+
+	// pops everything which can be popped, marking each complete
+	popAll := func() []string {
+		values := []string{}
+		for {
+			token, value, err := PopFromQueue(conn, "msgs")
+			assert.NoError(err)
+			if token == EmptyQueue {
+				return values
+			}
+			if token != Retry {
+				values = append(values, value)
+				assert.NoError(MarkComplete(conn, "msgs", token))
+			}
+		}
+	}
+
+	// chan1 is skipped, chan2 is still popped
+	assert.Equal([]string{`{"id":3}`}, popAll())
+
+	// chan1 still has its item but is paused
+	queues, err := ListQueues(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal([]*Info{
+		{Queue: "chan1", TPS: 0, State: StatePaused, Workers: 1, Breaker: BreakerClosed, Paused: true, Size: 1, BulkSize: 0},
+	}, queues)
+
+	// completing our first msg decrements our paused workers
+	assert.NoError(MarkComplete(conn, "msgs", token))
+
+	queues, err = ListQueues(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(0, queues[0].Workers)
+
+	// pushing onto a paused queue doesn't make it poppable
+	assert.NoError(PushOntoQueue(conn, "msgs", "chan1", 0, `[{"id":4}]`, HighPriority, time.Time{}))
+	assert.Equal([]string{}, popAll())
+
+	// resume and we can pop again
+	assert.NoError(ResumeQueue(conn, "msgs", "chan1"))
+
+	paused, err = PausedQueues(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal([]string{}, paused)
+
+	token, value, err = PopFromQueue(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(WorkerToken("msgs:chan1|0"), token)
+	assert.Equal(`{"id":2}`, value)
+
+	// drain what is left
+	items, err := DrainQueue(conn, "msgs", "chan1")
+	assert.NoError(err)
+	assert.Equal(1, len(items))
+	assert.Equal(`[{"id":4}]`, items[0].Value)
+}
+
+func TestMaxConcurrency(t *testing.T) {
+	assert := assert.New(t)
+	pool := getPool()
+	conn := pool.Get()
+	defer conn.Close()
+
+	assert.NoError(SetMaxConcurrency(conn, "msgs", "chan1", 2))
+	for i := 1; i <= 4; i++ {
+		assert.NoError(PushOntoQueue(conn, "msgs", "chan1", 0, fmt.Sprintf(`[{"id":%d}]`, i), HighPriority, time.Time{}))
+	}
+	assert.NoError(PushOntoQueue(conn, "msgs", "chan2", 0, `[{"id":5}]`, HighPriority, time.Time{}))
+
+	// chan1 can only have two workers at once, after which it is saturated and only chan2 is popped from
+	tokens, values, err := PopManyFromQueue(conn, "msgs", 10)
+	assert.NoError(err)
+	assert.Equal([]WorkerToken{"msgs:chan1|0", "msgs:chan2|0", "msgs:chan1|0"}, tokens)
+	assert.Equal([]string{`{"id":1}`, `{"id":5}`, `{"id":2}`}, values)
+
+	queues, err := ListQueues(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal([]*Info{
+		{Queue: "chan1", TPS: 0, State: StateSaturated, Workers: 2, Breaker: BreakerClosed, Size: 2, BulkSize: 0},
+	}, queues)
+
+	token, _, err := PopFromQueue(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(EmptyQueue, token)
+
+	// pushing onto it while it is saturated doesn't make it active again
+	assert.NoError(PushOntoQueue(conn, "msgs", "chan1", 0, `[{"id":6}]`, HighPriority, time.Time{}))
+
+	queues, err = ListQueues(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal([]*Info{
+		{Queue: "chan1", TPS: 0, State: StateSaturated, Workers: 2, Breaker: BreakerClosed, Size: 3, BulkSize: 0},
+	}, queues)
+
+	token, _, err = PopFromQueue(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(EmptyQueue, token)
+
+	// completing one of its msgs makes it active again with a single worker
+	assert.NoError(MarkComplete(conn, "msgs", tokens[0]))
+
+	queues, err = ListQueues(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(StateActive, queues[0].State)
+	assert.Equal("chan1", queues[0].Queue)
+	assert.Equal(1, queues[0].Workers)
+
+	token, value, err := PopFromQueue(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(WorkerToken("msgs:chan1|0"), token)
+	assert.Equal(`{"id":3}`, value)
+
+	// removing our limit lets our dethrottler move it back to active without waiting on a worker
+	token, _, err = PopFromQueue(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(Retry, token)
+
+	assert.NoError(SetMaxConcurrency(conn, "msgs", "chan1", 0))
+	_, err = luaDethrottle.Do(conn, "msgs", epochMS(), "1")
+	assert.NoError(err)
+
+	token, value, err = PopFromQueue(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(WorkerToken("msgs:chan1|0"), token)
+	assert.Equal(`{"id":4}`, value)
+}
+
+func TestDelayed(t *testing.T) {
+	assert := assert.New(t)
+	pool := getPool()
+	conn := pool.Get()
+	defer conn.Close()
+
+	// hold our first item for a second, our second can be popped straight away
+	assert.NoError(PushOntoQueue(conn, "msgs", "chan1", 0, `[{"id":1}]`, HighPriority, time.Now().Add(time.Second)))
+	assert.NoError(PushOntoQueue(conn, "msgs", "chan1", 0, `[{"id":2}]`, HighPriority, time.Time{}))
+
+	token, value, err := PopFromQueue(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(WorkerToken("msgs:chan1|0"), token)
+	assert.Equal(`{"id":2}`, value)
+
+	// our delayed item is skipped, moving our queue to future
+	token, _, err = PopFromQueue(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(Retry, token)
+
+	queues, err := ListQueues(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal([]*Info{
+		{Queue: "chan1", TPS: 0, State: StateFuture, Workers: 0, Breaker: BreakerClosed, Size: 1, BulkSize: 0},
+	}, queues)
+
+	// requeuing an item we popped holds it too, and frees up our worker
+	assert.NoError(Requeue(conn, "msgs", WorkerToken("msgs:chan1|0"), `[{"id":2}]`, HighPriority, time.Now().Add(time.Second*2)))
+
+	items, err := PeekQueue(conn, "msgs", "chan1", 10)
+	assert.NoError(err)
+	assert.Equal(2, len(items))
+	assert.Equal(`[{"id":1}]`, items[0].Value)
+	assert.Equal(`[{"id":2}]`, items[1].Value)
+
+	// once our first item's time has come and our dethrottler has run, it can be popped
+	time.Sleep(time.Second)
+	_, err = luaDethrottle.Do(conn, "msgs", epochMS(), "1")
+	assert.NoError(err)
+
+	token, value, err = PopFromQueue(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(WorkerToken("msgs:chan1|0"), token)
+	assert.Equal(`{"id":1}`, value)
+
+	token, _, err = PopFromQueue(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(Retry, token)
+}
+
+func TestTokenBucket(t *testing.T) {
+	assert := assert.New(t)
+	pool := getPool()
+	conn := pool.Get()
+	defer conn.Close()
+
+	// our queue can burst three items, then refills at two items a second
+	assert.NoError(SetBurst(conn, "msgs", "chan1", 3))
+	for i := 1; i <= 5; i++ {
+		assert.NoError(PushOntoQueue(conn, "msgs", "chan1", 2, fmt.Sprintf(`[{"id":%d}]`, i), HighPriority, time.Time{}))
+	}
+
+	for i := 1; i <= 3; i++ {
+		_, value, err := PopFromQueue(conn, "msgs")
+		assert.NoError(err)
+		assert.Equal(fmt.Sprintf(`{"id":%d}`, i), value)
+	}
+
+	// our burst is used up, so we're throttled until our next token in half a second
+	token, _, err := PopFromQueue(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(Retry, token)
+
+	now := float64(time.Now().UnixNano()) / float64(time.Second)
+	ready, err := redis.Float64(luaDethrottle.Do(conn, "msgs", epochMS(), "0"))
+	assert.NoError(err)
+	assert.InDelta(now+0.5, ready, 0.1)
+
+	token, _, err = PopFromQueue(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(EmptyQueue, token)
+
+	// once it has refilled we are released without waiting for a second boundary, and can pop a single item
+	time.Sleep(500 * time.Millisecond)
+	ready, err = redis.Float64(luaDethrottle.Do(conn, "msgs", epochMS(), "0"))
+	assert.NoError(err)
+	assert.Equal(float64(0), ready)
+
+	token, value, err := PopFromQueue(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(WorkerToken("msgs:chan1|2"), token)
+	assert.Equal(`{"id":4}`, value)
+
+	token, _, err = PopFromQueue(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(Retry, token)
+
+	// items with a cost take that many tokens, our burst defaults to our TPS
+	assert.NoError(PushOntoQueue(conn, "msgs", "chan2", 10, `[{"id":6,"tps_cost":3}]`, HighPriority, time.Time{}))
+
+	token, _, err = PopFromQueue(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal(WorkerToken("msgs:chan2|10"), token)
+
+	tokens, err := redis.Float64(conn.Do("hget", "msgs:chan2|10:bucket", "tokens"))
+	assert.NoError(err)
+	assert.InDelta(7, tokens, 0.01)
+}
+
+func TestFairScheduling(t *testing.T) {
+	assert := assert.New(t)
+	pool := getPool()
+	conn := pool.Get()
+	defer conn.Close()
+
+	// org 1 has three busy channels, org 2 just one
+	for i, queue := range []string{"chan1", "chan2", "chan3"} {
+		assert.NoError(SetQueueOrg(conn, "msgs", queue, "1"))
+		assert.NoError(PushOntoQueue(conn, "msgs", queue, 0, fmt.Sprintf(`[{"id":%d}]`, i*2+1), HighPriority, time.Time{}))
+		assert.NoError(PushOntoQueue(conn, "msgs", queue, 0, fmt.Sprintf(`[{"id":%d}]`, i*2+2), HighPriority, time.Time{}))
+	}
+	assert.NoError(SetQueueOrg(conn, "msgs", "chan4", "2"))
+	assert.NoError(PushOntoQueue(conn, "msgs", "chan4", 0, `[{"id":7}]`, HighPriority, time.Time{}))
+	assert.NoError(PushOntoQueue(conn, "msgs", "chan4", 0, `[{"id":8}]`, HighPriority, time.Time{}))
+
+	// we alternate between orgs rather than channels, so org 2 gets as many workers as org 1
+	tokens, values, err := PopManyFromQueue(conn, "msgs", 5)
+	assert.NoError(err)
+	assert.Equal([]string{`{"id":1}`, `{"id":7}`, `{"id":3}`, `{"id":8}`, `{"id":5}`}, values)
+
+	orgs, err := ListOrgs(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal([]*OrgInfo{{Org: "1", Workers: 3, Weight: 1}, {Org: "2", Workers: 2, Weight: 1}}, orgs)
+
+	// completing org 2's msgs frees up its workers
+	assert.NoError(MarkComplete(conn, "msgs", tokens[1]))
+	assert.NoError(MarkComplete(conn, "msgs", tokens[3]))
+
+	orgs, err = ListOrgs(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal([]*OrgInfo{{Org: "1", Workers: 3, Weight: 1}}, orgs)
+
+	// weighted orgs get more workers, org 1 can have four workers for each one of org 2's
+	assert.NoError(SetOrgWeights(conn, "msgs", map[string]float64{"1": 4}))
+	assert.NoError(PushOntoQueue(conn, "msgs", "chan4", 0, `[{"id":9}]`, HighPriority, time.Time{}))
+
+	_, values, err = PopManyFromQueue(conn, "msgs", 3)
+	assert.NoError(err)
+	assert.Equal([]string{`{"id":9}`, `{"id":2}`, `{"id":4}`}, values)
+
+	orgs, err = ListOrgs(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal([]*OrgInfo{{Org: "1", Workers: 5, Weight: 4}, {Org: "2", Workers: 1, Weight: 1}}, orgs)
+
+	// queues without an org are popped from as before, once they've been rescheduled without their old org
+	assert.NoError(SetQueueOrg(conn, "msgs", "chan3", ""))
+	tokens, values, err = PopManyFromQueue(conn, "msgs", 1)
+	assert.NoError(err)
+	assert.Equal([]WorkerToken{"msgs:chan3|0"}, tokens)
+	assert.Equal([]string{`{"id":6}`}, values)
+
+	// but the workers they had before still belong to their old org until they complete
+	orgs, err = ListOrgs(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal([]*OrgInfo{{Org: "1", Workers: 5, Weight: 4}, {Org: "2", Workers: 1, Weight: 1}}, orgs)
+
+	assert.NoError(MarkComplete(conn, "msgs", "msgs:chan3|0#1"))
+	assert.NoError(MarkComplete(conn, "msgs", tokens[0]))
+
+	orgs, err = ListOrgs(conn, "msgs")
+	assert.NoError(err)
+	assert.Equal([]*OrgInfo{{Org: "1", Workers: 4, Weight: 4}, {Org: "2", Workers: 1, Weight: 1}}, orgs)
+}
+
+func TestFairSchedulingManyQueues(t *testing.T) {
+	assert := assert.New(t)
+	pool := getPool()
+	conn := pool.Get()
+	defer conn.Close()
+
+	// org 1 has lots of channels with a msg each, org 2 a single channel with two
+	for i := 0; i < 60; i++ {
+		queue := fmt.Sprintf("chan%02d", i)
+		assert.NoError(SetQueueOrg(conn, "msgs", queue, "1"))
+		assert.NoError(PushOntoQueue(conn, "msgs", queue, 0, fmt.Sprintf(`[{"id":%d}]`, 100+i), HighPriority, time.Time{}))
+	}
+	assert.NoError(SetQueueOrg(conn, "msgs", "other", "2"))
+	assert.NoError(PushOntoQueue(conn, "msgs", "other", 0, `[{"id":1}]`, HighPriority, time.Time{}))
+	assert.NoError(PushOntoQueue(conn, "msgs", "other", 0, `[{"id":2}]`, HighPriority, time.Time{}))
+
+	// org 2 still gets its share though org 1 has more idle channels than we'd look at if we looked at channels first
+	tokens, values, err := PopManyFromQueue(conn, "msgs", 4)
+	assert.NoError(err)
+	assert.Equal([]string{`{"id":100}`, `{"id":1}`, `{"id":101}`, `{"id":2}`}, values)
+	assert.Equal(WorkerToken("msgs:other|0#2"), tokens[1])
+
+	// queues made active by something else pushing onto them are scheduled too
+	_, err = conn.Do("zadd", "msgs:other|0/1", 0, `[{"id":3}]`)
+	assert.NoError(err)
+	_, err = conn.Do("zincrby", "msgs:active", 0, "msgs:new|0")
+	assert.NoError(err)
+	_, err = conn.Do("zadd", "msgs:new|0/1", 0, `[{"id":4}]`)
+	assert.NoError(err)
+
+	tokens, values, err = PopManyFromQueue(conn, "msgs", 1)
+	assert.NoError(err)
+	assert.Equal([]WorkerToken{"msgs:new|0"}, tokens)
+	assert.Equal([]string{`{"id":4}`}, values)
+}
+
+func TestPopMany(t *testing.T) {
+	assert := assert.New(t)
+	pool := getPool()
+	conn := pool.Get()
+	defer conn.Close()
+
+	// nothing to pop
+	tokens, values, err := PopManyFromQueue(conn, "msgs", 5)
+	assert.NoError(err)
+	assert.Equal([]WorkerToken{}, tokens)
+	assert.Equal([]string{}, values)
+
+	assert.NoError(PushOntoQueue(conn, "msgs", "chan1", 0, `[{"id":1},{"id":2}]`, HighPriority, time.Time{}))
+	assert.NoError(PushOntoQueue(conn, "msgs", "chan2", 0, `[{"id":3}]`, HighPriority, time.Time{}))
+	assert.NoError(PushOntoQueue(conn, "msgs", "chan3", 0, `[{"id":4}]`, LowPriority, time.Time{}))
+	assert.NoError(PauseQueue(conn, "msgs", "chan3"))
+
+	// we pop across our queues, skipping those that can't be popped from
+	tokens, values, err = PopManyFromQueue(conn, "msgs", 5)
+	assert.NoError(err)
+	assert.Equal([]WorkerToken{"msgs:chan1|0", "msgs:chan2|0"}, tokens)
+	assert.Equal([]string{`{"id":1}`, `{"id":3}`}, values)
+
+	// and no more than we ask for
+	assert.NoError(ResumeQueue(conn, "msgs", "chan3"))
+	tokens, values, err = PopManyFromQueue(conn, "msgs", 1)
+	assert.NoError(err)
+	assert.Equal([]WorkerToken{"msgs:chan3|0"}, tokens)
+	assert.Equal([]string{`{"id":4}`}, values)
+}
+
+func TestNotifier(t *testing.T) {
+	assert := assert.New(t)
+	pool := getPool()
+	conn := pool.Get()
+	defer conn.Close()
+
+	quitter := make(chan bool)
+	wg := &sync.WaitGroup{}
+	notify := make(chan bool, 1)
+	StartNotifier(pool, quitter, wg, "msgs", notify)
+
+	// we are notified once we subscribe in case we missed anything
+	select {
+	case <-notify:
+	case <-time.After(time.Second):
+		assert.Fail("no notification on subscribe")
+	}
+
+	// and whenever something is pushed that can be popped
+	assert.NoError(PushOntoQueue(conn, "msgs", "chan1", 0, `[{"id":1}]`, HighPriority, time.Time{}))
+	select {
+	case <-notify:
+	case <-time.After(time.Second):
+		assert.Fail("no notification on push")
+	}
+
+	close(quitter)
+	wg.Wait()
+}
+
 func BenchmarkQueue(b *testing.B) {
 	assert := assert.New(b)
 	pool := getPool()
@@ -236,7 +726,7 @@ func BenchmarkQueue(b *testing.B) {
 
 	for i := 0; i < b.N; i++ {
 		insertValue := fmt.Sprintf(`{"id":%d}`, i)
-		err := PushOntoQueue(conn, "msgs", "chan1", 0, "["+insertValue+"]", HighPriority)
+		err := PushOntoQueue(conn, "msgs", "chan1", 0, "["+insertValue+"]", HighPriority, time.Time{})
 		assert.NoError(err)
 
 		queue, value, err := PopFromQueue(conn, "msgs")
@@ -0,0 +1,149 @@
+package courier
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// RetryBackoff is how the delay between retries of an errored msg grows
+type RetryBackoff string
+
+const (
+	// RetryBackoffLinear means the delay grows by our base delay with each attempt, ex: 5m, 10m, 15m
+	RetryBackoffLinear = RetryBackoff("linear")
+
+	// RetryBackoffExponential means the delay doubles with each attempt, ex: 5m, 10m, 20m
+	RetryBackoffExponential = RetryBackoff("exponential")
+)
+
+// RetryPolicy describes how msgs which errored while sending are retried and when they are failed
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts to send a msg before it is failed, 1 means errored msgs are never retried,
+	// and no more than MaxRetryAttempts
+	MaxAttempts int
+
+	// Delay is the delay before the first retry
+	Delay time.Duration
+
+	// Backoff is how the delay grows with each subsequent attempt
+	Backoff RetryBackoff
+
+	// MaxDelay caps the delay between attempts, zero means no limit
+	MaxDelay time.Duration
+
+	// Jitter is the fraction by which delays are randomly varied, ex: 0.2 varies them by up to 20% either way
+	Jitter float64
+}
+
+// MaxRetryAttempts is the most attempts to send a msg any retry policy can make, larger values in channel config are
+// clamped to it
+const MaxRetryAttempts = 20
+
+// DefaultRetryPolicy is the policy used for channel types which don't declare their own, errored msgs are
+// retried after 5 and then 10 minutes before being failed
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	Delay:       5 * time.Minute,
+	Backoff:     RetryBackoffLinear,
+}
+
+// SMSCRetryPolicy is the policy for channel types which send through a gateway to an SMSC, such as Kannel and Jasmin.
+// Gateways often lose their connection to their SMSC for a while, so errored msgs are retried more times over a longer
+// period than our default
+var SMSCRetryPolicy = RetryPolicy{
+	MaxAttempts: 8,
+	Delay:       2 * time.Minute,
+	Backoff:     RetryBackoffExponential,
+	MaxDelay:    time.Hour,
+	Jitter:      0.1,
+}
+
+// NextDelay returns how long to wait before the next attempt to send a msg which has errored the passed in number
+// of times (including this error), and whether there should be another attempt at all
+func (p RetryPolicy) NextDelay(errorCount int) (time.Duration, bool) {
+	if errorCount >= p.MaxAttempts {
+		return 0, false
+	}
+
+	// calculate as a float so that large attempts can't overflow our duration
+	delay := float64(p.Delay) * float64(errorCount)
+	if p.Backoff == RetryBackoffExponential {
+		delay = float64(p.Delay) * math.Pow(2, float64(errorCount-1))
+	}
+
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+
+	if p.Jitter > 0 {
+		delay = delay * (1 + p.Jitter*(2*rand.Float64()-1))
+	}
+
+	return clampDuration(delay), true
+}
+
+// clampDuration converts the passed in number of nanoseconds to a duration, clamping it to between zero and the
+// longest duration we can represent
+func clampDuration(nanos float64) time.Duration {
+	if nanos <= 0 {
+		return 0
+	} else if nanos >= math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(nanos)
+}
+
+// Delays returns the delay before each retry of a msg, one for each attempt after the first, so a policy with
+// three attempts returns two delays. Backends which schedule retries in their database write statuses with
+// these so that the same policy is applied everywhere.
+func (p RetryPolicy) Delays() []time.Duration {
+	delays := make([]time.Duration, 0)
+	for errorCount := 1; ; errorCount++ {
+		delay, retry := p.NextDelay(errorCount)
+		if !retry {
+			return delays
+		}
+		delays = append(delays, delay)
+	}
+}
+
+// GetRetryPolicy returns the retry policy for the passed in channel. This is the policy declared by the handler for
+// the channel's type, or our default policy, with any values set in the channel's config taking precedence.
+func GetRetryPolicy(channel Channel) RetryPolicy {
+	policy := DefaultRetryPolicy
+
+	provider, isProvider := GetHandler(channel.ChannelType()).(RetryPolicyProvider)
+	if isProvider {
+		policy = provider.RetryPolicy()
+	}
+
+	policy.MaxAttempts = channel.IntConfigForKey(ConfigRetryMaxAttempts, policy.MaxAttempts)
+	policy.Delay = time.Duration(channel.IntConfigForKey(ConfigRetryDelay, int(policy.Delay/time.Second))) * time.Second
+	policy.MaxDelay = time.Duration(channel.IntConfigForKey(ConfigRetryMaxDelay, int(policy.MaxDelay/time.Second))) * time.Second
+
+	backoff := RetryBackoff(channel.StringConfigForKey(ConfigRetryBackoff, string(policy.Backoff)))
+	if backoff == RetryBackoffLinear || backoff == RetryBackoffExponential {
+		policy.Backoff = backoff
+	}
+
+	switch jitter := channel.ConfigForKey(ConfigRetryJitter, policy.Jitter).(type) {
+	case float64:
+		policy.Jitter = jitter
+	case string:
+		f, err := strconv.ParseFloat(jitter, 64)
+		if err == nil {
+			policy.Jitter = f
+		}
+	}
+
+	// we always make at least one attempt, and never more than our max
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	} else if policy.MaxAttempts > MaxRetryAttempts {
+		policy.MaxAttempts = MaxRetryAttempts
+	}
+
+	return policy
+}
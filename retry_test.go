@@ -0,0 +1,90 @@
+package courier
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	tcs := []struct {
+		policy     RetryPolicy
+		errorCount int
+		delay      time.Duration
+		retry      bool
+	}{
+		{DefaultRetryPolicy, 1, 5 * time.Minute, true},
+		{DefaultRetryPolicy, 2, 10 * time.Minute, true},
+		{DefaultRetryPolicy, 3, 0, false},
+		{RetryPolicy{MaxAttempts: 1, Delay: time.Minute}, 1, 0, false},
+		{RetryPolicy{MaxAttempts: 10, Delay: time.Minute, Backoff: RetryBackoffExponential}, 1, time.Minute, true},
+		{RetryPolicy{MaxAttempts: 10, Delay: time.Minute, Backoff: RetryBackoffExponential}, 4, 8 * time.Minute, true},
+		{RetryPolicy{MaxAttempts: 10, Delay: time.Minute, Backoff: RetryBackoffExponential, MaxDelay: 5 * time.Minute}, 4, 5 * time.Minute, true},
+
+		// large attempts don't overflow, they're capped by our max delay or the longest duration we have
+		{RetryPolicy{MaxAttempts: 100, Delay: time.Minute, Backoff: RetryBackoffExponential, MaxDelay: time.Hour}, 30, time.Hour, true},
+		{RetryPolicy{MaxAttempts: 100, Delay: time.Minute, Backoff: RetryBackoffExponential}, 80, time.Duration(math.MaxInt64), true},
+		{RetryPolicy{MaxAttempts: 100, Delay: time.Duration(math.MaxInt64 / 2), MaxDelay: time.Hour}, 3, time.Hour, true},
+	}
+
+	for _, tc := range tcs {
+		delay, retry := tc.policy.NextDelay(tc.errorCount)
+		assert.Equal(t, tc.delay, delay, "delay mismatch for %+v with %d errors", tc.policy, tc.errorCount)
+		assert.Equal(t, tc.retry, retry, "retry mismatch for %+v with %d errors", tc.policy, tc.errorCount)
+	}
+
+	// delays are one for each retry
+	assert.Equal(t, []time.Duration{5 * time.Minute, 10 * time.Minute}, DefaultRetryPolicy.Delays())
+	assert.Equal(t, []time.Duration{}, RetryPolicy{MaxAttempts: 1, Delay: time.Minute}.Delays())
+	assert.Equal(t, []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute}, RetryPolicy{MaxAttempts: 5, Delay: time.Minute, Backoff: RetryBackoffExponential, MaxDelay: 5 * time.Minute}.Delays())
+
+	// jitter varies our delay within its bounds
+	policy := RetryPolicy{MaxAttempts: 3, Delay: 10 * time.Minute, Jitter: 0.2}
+	for i := 0; i < 100; i++ {
+		delay, _ := policy.NextDelay(1)
+		assert.True(t, delay >= 8*time.Minute && delay <= 12*time.Minute, "delay %s outside of jitter bounds", delay)
+	}
+
+	// and never makes it negative
+	policy = RetryPolicy{MaxAttempts: 3, Delay: 10 * time.Minute, Jitter: 5}
+	for i := 0; i < 100; i++ {
+		delay, _ := policy.NextDelay(1)
+		assert.True(t, delay >= 0, "delay %s is negative", delay)
+	}
+}
+
+func TestGetRetryPolicy(t *testing.T) {
+	// channel types without a declared policy use our default
+	xxChannel := NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "XX", "2020", "US", map[string]interface{}{})
+	assert.Equal(t, DefaultRetryPolicy, GetRetryPolicy(xxChannel))
+
+	// otherwise use the one declared by their handler
+	dmChannel := NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "DM", "2020", "US", map[string]interface{}{})
+	assert.Equal(t, RetryPolicy{MaxAttempts: 5, Delay: time.Minute, Backoff: RetryBackoffExponential, MaxDelay: time.Hour}, GetRetryPolicy(dmChannel))
+
+	// with channel config taking precedence
+	dmChannel = NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "DM", "2020", "US", map[string]interface{}{
+		ConfigRetryMaxAttempts: 8,
+		ConfigRetryDelay:       float64(30),
+		ConfigRetryBackoff:     "linear",
+		ConfigRetryMaxDelay:    "600",
+		ConfigRetryJitter:      0.1,
+	})
+	assert.Equal(t, RetryPolicy{MaxAttempts: 8, Delay: 30 * time.Second, Backoff: RetryBackoffLinear, MaxDelay: 10 * time.Minute, Jitter: 0.1}, GetRetryPolicy(dmChannel))
+
+	// max attempts are clamped to our max
+	xxChannel = NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "XX", "2020", "US", map[string]interface{}{
+		ConfigRetryMaxAttempts: 1000000,
+	})
+	assert.Equal(t, MaxRetryAttempts, GetRetryPolicy(xxChannel).MaxAttempts)
+	assert.Equal(t, MaxRetryAttempts-1, len(GetRetryPolicy(xxChannel).Delays()))
+
+	// invalid values are ignored, and we always make at least one attempt
+	xxChannel = NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "XX", "2020", "US", map[string]interface{}{
+		ConfigRetryMaxAttempts: 0,
+		ConfigRetryBackoff:     "fibonacci",
+	})
+	assert.Equal(t, RetryPolicy{MaxAttempts: 1, Delay: 5 * time.Minute, Backoff: RetryBackoffLinear}, GetRetryPolicy(xxChannel))
+}
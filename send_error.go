@@ -0,0 +1,146 @@
+package courier
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SendErrorType is the type of error a handler encountered sending a msg, which decides whether and when it is retried
+type SendErrorType string
+
+const (
+	// SendErrorTransient is a temporary error, such as a provider being down, the msg will be retried
+	SendErrorTransient = SendErrorType("transient")
+
+	// SendErrorRateLimited means the provider is throttling us, the msg will be retried after any retry-after hint
+	SendErrorRateLimited = SendErrorType("rate_limited")
+
+	// SendErrorAuthFailure means our channel credentials were rejected, the msg will be retried once they are fixed
+	SendErrorAuthFailure = SendErrorType("auth_failure")
+
+	// SendErrorPermanentRecipient means the recipient can never receive this msg, ex: an invalid number or a blocked bot
+	SendErrorPermanentRecipient = SendErrorType("permanent_recipient")
+
+	// SendErrorPermanentContent means the content of the msg will never be accepted, ex: it is too long or unsupported
+	SendErrorPermanentContent = SendErrorType("permanent_content")
+)
+
+// ErrorCode is a Courier-wide code for why a msg couldn't be sent or delivered, handlers map the error codes
+// of their provider to these so that failures can be grouped by cause across providers
+type ErrorCode string
+
+// Possible values for ErrorCode
+const (
+	ErrorUnknown              ErrorCode = "unknown"
+	ErrorProvider             ErrorCode = "provider_error"
+	ErrorAccount              ErrorCode = "account_error"
+	ErrorAuthentication       ErrorCode = "auth_failure"
+	ErrorRateLimited          ErrorCode = "rate_limited"
+	ErrorInvalidRecipient     ErrorCode = "invalid_recipient"
+	ErrorRecipientOptedOut    ErrorCode = "recipient_opted_out"
+	ErrorRecipientUnreachable ErrorCode = "recipient_unreachable"
+	ErrorContentTooLong       ErrorCode = "content_too_long"
+	ErrorInvalidContent       ErrorCode = "invalid_content"
+	ErrorInvalidMedia         ErrorCode = "invalid_media"
+	ErrorInvalidTemplate      ErrorCode = "invalid_template"
+)
+
+// the type of each of our error codes, which decides how msgs which fail with them are retried
+var errorCodeTypes = map[ErrorCode]SendErrorType{
+	ErrorUnknown:              SendErrorTransient,
+	ErrorProvider:             SendErrorTransient,
+	ErrorAccount:              SendErrorTransient,
+	ErrorAuthentication:       SendErrorAuthFailure,
+	ErrorRateLimited:          SendErrorRateLimited,
+	ErrorInvalidRecipient:     SendErrorPermanentRecipient,
+	ErrorRecipientOptedOut:    SendErrorPermanentRecipient,
+	ErrorRecipientUnreachable: SendErrorPermanentRecipient,
+	ErrorContentTooLong:       SendErrorPermanentContent,
+	ErrorInvalidContent:       SendErrorPermanentContent,
+	ErrorInvalidMedia:         SendErrorPermanentContent,
+	ErrorInvalidTemplate:      SendErrorPermanentContent,
+}
+
+// Type returns the type of this error code, codes we don't know about are considered transient
+func (c ErrorCode) Type() SendErrorType {
+	errType, found := errorCodeTypes[c]
+	if !found {
+		return SendErrorTransient
+	}
+	return errType
+}
+
+// SendError is a structured error returned by a provider for a msg, made up of our own error code, the raw
+// code returned by the provider and its description.
+type SendError struct {
+	Code         ErrorCode     `json:"code"`
+	ProviderCode string        `json:"provider_code,omitempty"`
+	Description  string        `json:"description,omitempty"`
+	RetryAfter   time.Duration `json:"retry_after,omitempty"`
+}
+
+// NewSendError creates a new send error with the passed in code and the provider's raw code and description
+func NewSendError(code ErrorCode, providerCode string, description string) *SendError {
+	return &SendError{Code: code, ProviderCode: providerCode, Description: description}
+}
+
+// NewTransientError creates a new transient send error, such as the provider being down
+func NewTransientError(providerCode string, description string) *SendError {
+	return NewSendError(ErrorProvider, providerCode, description)
+}
+
+// NewRateLimitedError creates a new rate limited send error, retryAfter is the provider's hint, zero if it gave none
+func NewRateLimitedError(providerCode string, description string, retryAfter time.Duration) *SendError {
+	err := NewSendError(ErrorRateLimited, providerCode, description)
+	err.RetryAfter = retryAfter
+	return err
+}
+
+// NewAuthFailureError creates a new send error for our channel credentials being rejected
+func NewAuthFailureError(providerCode string, description string) *SendError {
+	return NewSendError(ErrorAuthentication, providerCode, description)
+}
+
+// NewPermanentRecipientError creates a new send error for a recipient which can never receive the msg
+func NewPermanentRecipientError(providerCode string, description string) *SendError {
+	return NewSendError(ErrorInvalidRecipient, providerCode, description)
+}
+
+// NewPermanentContentError creates a new send error for msg content which will never be accepted
+func NewPermanentContentError(providerCode string, description string) *SendError {
+	return NewSendError(ErrorInvalidContent, providerCode, description)
+}
+
+// Type returns the type of this error
+func (e *SendError) Type() SendErrorType {
+	return e.Code.Type()
+}
+
+// Error returns a description of this error, including our code and the provider code
+func (e *SendError) Error() string {
+	msg := string(e.Code)
+	if e.ProviderCode != "" {
+		msg = fmt.Sprintf("%s (%s)", msg, e.ProviderCode)
+	}
+	if e.Description != "" {
+		msg = fmt.Sprintf("%s: %s", msg, e.Description)
+	}
+	return msg
+}
+
+// IsPermanent returns whether retrying the msg can never succeed
+func (e *SendError) IsPermanent() bool {
+	errType := e.Type()
+	return errType == SendErrorPermanentRecipient || errType == SendErrorPermanentContent
+}
+
+// AsSendError returns the send error at the root of the passed in error, if any
+func AsSendError(err error) (*SendError, bool) {
+	if err == nil {
+		return nil, false
+	}
+	sendErr, isSendErr := errors.Cause(err).(*SendError)
+	return sendErr, isSendErr
+}
@@ -0,0 +1,46 @@
+package courier
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendError(t *testing.T) {
+	err := NewRateLimitedError("429", "Too Many Requests", time.Second*30)
+	assert.Equal(t, "rate_limited (429): Too Many Requests", err.Error())
+	assert.Equal(t, SendErrorRateLimited, err.Type())
+	assert.Equal(t, time.Second*30, err.RetryAfter)
+	assert.False(t, err.IsPermanent())
+
+	assert.Equal(t, "unknown", NewSendError(ErrorUnknown, "", "").Error())
+	assert.False(t, NewSendError(ErrorAuthentication, "401", "").IsPermanent())
+	assert.True(t, NewSendError(ErrorInvalidRecipient, "21211", "invalid number").IsPermanent())
+	assert.True(t, NewSendError(ErrorContentTooLong, "21617", "body too long").IsPermanent())
+
+	// our typed constructors are shorthands for the most general code of each type
+	assert.Equal(t, ErrorProvider, NewTransientError("500", "").Code)
+	assert.Equal(t, SendErrorAuthFailure, NewAuthFailureError("401", "").Type())
+	assert.Equal(t, ErrorInvalidRecipient, NewPermanentRecipientError("403", "bot was blocked").Code)
+	assert.Equal(t, ErrorInvalidContent, NewPermanentContentError("400", "").Code)
+	assert.True(t, NewPermanentContentError("400", "").IsPermanent())
+
+	// every code in our taxonomy has a type, and codes we don't know are transient
+	assert.Equal(t, SendErrorPermanentRecipient, ErrorRecipientOptedOut.Type())
+	assert.Equal(t, SendErrorPermanentContent, ErrorInvalidTemplate.Type())
+	assert.Equal(t, SendErrorTransient, ErrorAccount.Type())
+	assert.Equal(t, SendErrorTransient, ErrorCode("xxx").Type())
+
+	// we can find send errors even when they've been wrapped
+	sendErr, isSendErr := AsSendError(errors.Wrap(err, "error sending"))
+	assert.True(t, isSendErr)
+	assert.Equal(t, err, sendErr)
+
+	_, isSendErr = AsSendError(fmt.Errorf("boom"))
+	assert.False(t, isSendErr)
+	_, isSendErr = AsSendError(nil)
+	assert.False(t, isSendErr)
+}
@@ -0,0 +1,56 @@
+package courier
+
+import (
+	"time"
+)
+
+// SendLimits describes how msgs are sent on a channel, limiting how many can be sent at once, in a burst and for
+// how long
+type SendLimits struct {
+	// MaxConcurrency is the maximum number of msgs that can be in the process of being sent on a channel at once,
+	// zero means no limit
+	MaxConcurrency int
+
+	// Timeout is how long an individual send can take before it is cancelled, handlers make the requests of a send
+	// with the context they are passed so that they are cancelled with it
+	Timeout time.Duration
+
+	// Burst is the number of msgs which can be sent on a channel in a burst before it is limited to its TPS, zero
+	// means its TPS
+	Burst int
+}
+
+// DefaultSendLimits are the limits used for channel types which don't declare their own, msgs are sent with as
+// many workers as we have and each send can take up to 35 seconds
+var DefaultSendLimits = SendLimits{
+	MaxConcurrency: 0,
+	Timeout:        35 * time.Second,
+}
+
+// GetSendLimits returns the send limits for the passed in channel. These are the limits declared by the handler for
+// the channel's type, or our default limits, with any values set in the channel's config taking precedence.
+func GetSendLimits(channel Channel) SendLimits {
+	limits := DefaultSendLimits
+
+	provider, isProvider := GetHandler(channel.ChannelType()).(SendLimitsProvider)
+	if isProvider {
+		limits = provider.SendLimits()
+	}
+
+	limits.MaxConcurrency = channel.IntConfigForKey(ConfigMaxConcurrency, limits.MaxConcurrency)
+	limits.Timeout = time.Duration(channel.IntConfigForKey(ConfigSendTimeout, int(limits.Timeout/time.Second))) * time.Second
+	limits.Burst = channel.IntConfigForKey(ConfigTPSBurst, limits.Burst)
+
+	// negative concurrencies mean no limit, negative bursts our TPS, and every send gets some time
+	if limits.MaxConcurrency < 0 {
+		limits.MaxConcurrency = 0
+	}
+	if limits.Burst < 0 {
+		limits.Burst = 0
+	}
+	if limits.Timeout <= 0 {
+		limits.Timeout = DefaultSendLimits.Timeout
+	}
+
+	return limits
+}
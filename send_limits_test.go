@@ -0,0 +1,34 @@
+package courier
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetSendLimits(t *testing.T) {
+	// channel types without declared limits use our defaults
+	xxChannel := NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "XX", "2020", "US", map[string]interface{}{})
+	assert.Equal(t, DefaultSendLimits, GetSendLimits(xxChannel))
+
+	// otherwise use those declared by their handler
+	dmChannel := NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "DM", "2020", "US", map[string]interface{}{})
+	assert.Equal(t, SendLimits{MaxConcurrency: 2, Timeout: time.Minute}, GetSendLimits(dmChannel))
+
+	// with channel config taking precedence
+	dmChannel = NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "DM", "2020", "US", map[string]interface{}{
+		ConfigMaxConcurrency: float64(1),
+		ConfigSendTimeout:    "90",
+		ConfigTPSBurst:       float64(20),
+	})
+	assert.Equal(t, SendLimits{MaxConcurrency: 1, Timeout: 90 * time.Second, Burst: 20}, GetSendLimits(dmChannel))
+
+	// invalid values fall back to no limit and our default timeout
+	xxChannel = NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "XX", "2020", "US", map[string]interface{}{
+		ConfigMaxConcurrency: -1,
+		ConfigSendTimeout:    0,
+		ConfigTPSBurst:       "-5",
+	})
+	assert.Equal(t, SendLimits{MaxConcurrency: 0, Timeout: 35 * time.Second}, GetSendLimits(xxChannel))
+}
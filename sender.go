@@ -2,10 +2,10 @@ package courier
 
 import (
 	"context"
-	"fmt"
+	"sync/atomic"
 	"time"
 
-	"github.com/nyaruka/librato"
+	"github.com/nyaruka/courier/metrics"
 	"github.com/sirupsen/logrus"
 )
 
@@ -15,6 +15,7 @@ type Foreman struct {
 	senders          []*Sender
 	availableSenders chan *Sender
 	quit             chan bool
+	running          int32
 }
 
 // NewForeman creates a new Foreman for the passed in server with the number of max senders
@@ -50,6 +51,18 @@ func (f *Foreman) Stop() {
 	logrus.WithField("comp", "foreman").WithField("state", "stopping").Info("foreman stopping")
 }
 
+// Running returns whether our foreman is currently assigning msgs to senders
+func (f *Foreman) Running() bool {
+	return atomic.LoadInt32(&f.running) == 1
+}
+
+// how long the foreman waits before looking for msgs again after finding none, doubling each time it finds none up to
+// a maximum, which can be raised in our config when our backend notifies us of queued msgs
+var (
+	foremanPollMin = 50 * time.Millisecond
+	foremanPollMax = 250 * time.Millisecond
+)
+
 // Assign is our main loop for the Foreman, it takes care of popping the next outgoing messages from our
 // backend and assigning them to workers
 func (f *Foreman) Assign() {
@@ -57,13 +70,26 @@ func (f *Foreman) Assign() {
 	defer f.server.WaitGroup().Done()
 	log := logrus.WithField("comp", "foreman")
 
+	atomic.StoreInt32(&f.running, 1)
+	defer atomic.StoreInt32(&f.running, 0)
+
 	log.WithFields(logrus.Fields{
 		"state":   "started",
 		"senders": len(f.senders),
 	}).Info("senders started and waiting")
 
-	backend := f.server.Backend()
-	lastSleep := false
+	// if our backend can tell us when msgs are queued, we can poll less often, but not everything which queues msgs
+	// publishes notifications so by default we poll just as often as we would otherwise
+	var notifications <-chan bool
+	pollMax := foremanPollMax
+	notifier, isNotifier := f.server.Backend().(OutgoingNotifier)
+	if isNotifier {
+		notifications = notifier.OutgoingNotifications()
+		if notifiedPollMax := time.Duration(f.server.Config().NotifiedPollMax) * time.Millisecond; notifiedPollMax > 0 {
+			pollMax = notifiedPollMax
+		}
+	}
+	pollDelay := foremanPollMin
 
 	for true {
 		select {
@@ -72,35 +98,91 @@ func (f *Foreman) Assign() {
 			log.WithField("state", "stopped").Info("foreman stopped")
 			return
 
-		// otherwise, grab the next msg and assign it to a sender
+		// otherwise, grab the next msgs and assign them to our available senders
 		case sender := <-f.availableSenders:
-			// see if we have a message to work on
-			ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
-			msg, err := backend.PopNextOutgoingMsg(ctx)
-			cancel()
-
-			if err == nil && msg != nil {
-				// if so, assign it to our sender
-				sender.job <- msg
-				lastSleep = false
-			} else {
-				// we received an error getting the next message, log it
-				if err != nil {
-					log.WithError(err).Error("error popping outgoing msg")
+			senders := f.gatherSenders(sender)
+			msgs := f.popMsgs(len(senders))
+
+			for i, sender := range senders {
+				if i < len(msgs) {
+					sender.job <- msgs[i]
+				} else {
+					f.availableSenders <- sender
 				}
+			}
+
+			// we found msgs so there may be more waiting, go straight back for them
+			if len(msgs) > 0 {
+				pollDelay = foremanPollMin
+				continue
+			}
+
+			// otherwise wait until we are notified of new msgs or it is time to poll again, polling less often the
+			// longer we find nothing
+			if pollDelay == foremanPollMin {
+				log.Debug("sleeping, no messages")
+			}
+
+			select {
+			case <-f.quit:
+				log.WithField("state", "stopped").Info("foreman stopped")
+				return
 
-				// add our sender back to our queue and sleep a bit
-				if !lastSleep {
-					log.Debug("sleeping, no messages")
-					lastSleep = true
+			case <-notifications:
+				pollDelay = foremanPollMin
+
+			case <-time.After(pollDelay):
+				pollDelay *= 2
+				if pollDelay > pollMax {
+					pollDelay = pollMax
 				}
-				f.availableSenders <- sender
-				time.Sleep(250 * time.Millisecond)
 			}
 		}
 	}
 }
 
+// gatherSenders returns the passed in sender along with any others which are also waiting for work
+func (f *Foreman) gatherSenders(first *Sender) []*Sender {
+	senders := []*Sender{first}
+	for {
+		select {
+		case sender := <-f.availableSenders:
+			senders = append(senders, sender)
+		default:
+			return senders
+		}
+	}
+}
+
+// popMsgs pops up to count msgs from our backend, in a single call if our backend supports it
+func (f *Foreman) popMsgs(count int) []Msg {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	backend := f.server.Backend()
+	popper, isPopper := backend.(MultiPopper)
+	if isPopper {
+		msgs, err := popper.PopNextOutgoingMsgs(ctx, count)
+		if err != nil {
+			logrus.WithField("comp", "foreman").WithError(err).Error("error popping outgoing msgs")
+		}
+		return msgs
+	}
+
+	msgs := make([]Msg, 0, 1)
+	for len(msgs) < count {
+		msg, err := backend.PopNextOutgoingMsg(ctx)
+		if err != nil {
+			logrus.WithField("comp", "foreman").WithError(err).Error("error popping outgoing msg")
+		}
+		if msg == nil {
+			break
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
 // Sender is our type for a single goroutine that is sending messages
 type Sender struct {
 	id      int
@@ -158,8 +240,8 @@ func (w *Sender) sendMessage(msg Msg) {
 	server := w.foreman.server
 	backend := server.Backend()
 
-	// we don't want any individual send taking more than 35s
-	sendCTX, cancel := context.WithTimeout(context.Background(), time.Second*35)
+	// we don't want any individual send taking longer than our channel allows
+	sendCTX, cancel := context.WithTimeout(context.Background(), GetSendLimits(msg.Channel()).Timeout)
 	defer cancel()
 
 	msgLog := log.WithField("msg_id", msg.ID().String()).WithField("msg_text", msg.Text()).WithField("msg_urn", msg.URN().Identity())
@@ -180,15 +262,17 @@ func (w *Sender) sendMessage(msg Msg) {
 		msgLog.WithError(err).Warning("error looking up msg was sent")
 	}
 
+	labels := metrics.ChannelType(string(msg.Channel().ChannelType()))
+
 	if sent {
 		// if this message was already sent, create a wired status for it
 		status = backend.NewMsgStatusForID(msg.Channel(), msg.ID(), MsgWired)
 		msgLog.Warning("duplicate send, marking as wired")
+		metrics.Count("msgs_sent", labels.With("outcome", "duplicate"), 1)
 	} else {
 		// send our message
 		status, err = server.SendMsg(sendCTX, msg)
 		duration := time.Now().Sub(start)
-		secondDuration := float64(duration) / float64(time.Second)
 
 		if err != nil {
 			msgLog.WithError(err).WithField("elapsed", duration).Error("error sending message")
@@ -196,16 +280,27 @@ func (w *Sender) sendMessage(msg Msg) {
 				status = backend.NewMsgStatusForID(msg.Channel(), msg.ID(), MsgErrored)
 				status.AddLog(NewChannelLogFromError("Sending Error", msg.Channel(), msg.ID(), duration, err))
 			}
+
+			// handlers can also return their typed error directly
+			if sendErr, isSendErr := AsSendError(err); isSendErr && status.SendError() == nil {
+				status.SetSendError(sendErr)
+			}
+		}
+
+		applySendError(status)
+		if status.SendError() != nil {
+			msgLog = msgLog.WithField("error_code", status.SendError().Code).WithField("provider_error_code", status.SendError().ProviderCode)
 		}
 
-		// report to librato and log locally
+		// report our metrics and log locally
 		if status.Status() == MsgErrored || status.Status() == MsgFailed {
 			msgLog.WithField("elapsed", duration).Warning("msg errored")
-			librato.Gauge(fmt.Sprintf("courier.msg_send_error_%s", msg.Channel().ChannelType()), secondDuration)
+			metrics.Timing("msg_send_error", labels, duration)
 		} else {
 			msgLog.WithField("elapsed", duration).Info("msg sent")
-			librato.Gauge(fmt.Sprintf("courier.msg_send_%s", msg.Channel().ChannelType()), secondDuration)
+			metrics.Timing("msg_send", labels, duration)
 		}
+		metrics.Count("msgs_sent", labels.With("outcome", sendOutcome(status.Status())), 1)
 	}
 
 	// we allot 10 seconds to write our status to the db
@@ -226,3 +321,58 @@ func (w *Sender) sendMessage(msg Msg) {
 	// mark our send task as complete
 	backend.MarkOutgoingMsgComplete(writeCTX, msg, status)
 }
+
+// applySendError fails the passed in errored status immediately if the handler reported a permanent error, retrying
+// can never succeed for those so there's no point in waiting out our retry policy
+func applySendError(status MsgStatus) {
+	sendErr := status.SendError()
+	if sendErr != nil && sendErr.IsPermanent() && status.Status() == MsgErrored {
+		status.SetStatus(MsgFailed)
+	}
+}
+
+// sendOutcome returns the outcome label we report send metrics under for the passed in status
+func sendOutcome(status MsgStatusValue) string {
+	switch status {
+	case MsgErrored:
+		return "errored"
+	case MsgFailed:
+		return "failed"
+	case MsgWired:
+		return "wired"
+	default:
+		return "sent"
+	}
+}
+
+// BreakerOutcome is how the result of a send affects the circuit breaker of its channel
+type BreakerOutcome int
+
+const (
+	// BreakerIgnore means the send doesn't affect the breaker, ex: a msg which failed permanently
+	BreakerIgnore BreakerOutcome = iota
+
+	// BreakerSuccess means the send succeeded, resetting the breaker
+	BreakerSuccess
+
+	// BreakerFailure means the send errored or couldn't connect, counting towards opening the breaker
+	BreakerFailure
+)
+
+// NewBreakerOutcome returns how the passed in status of a send should affect the circuit breaker of its channel
+func NewBreakerOutcome(status MsgStatus) BreakerOutcome {
+	for _, log := range status.Logs() {
+		if log.ConnectionFailure {
+			return BreakerFailure
+		}
+	}
+
+	switch status.Status() {
+	case MsgErrored:
+		return BreakerFailure
+	case MsgWired, MsgSent, MsgDelivered, MsgRead:
+		return BreakerSuccess
+	default:
+		return BreakerIgnore
+	}
+}
@@ -79,3 +79,69 @@ func TestSending(t *testing.T) {
 	assert.Equal(msg.ID(), mb.msgStatuses[0].ID())
 	assert.Equal(MsgWired, mb.msgStatuses[0].Status())
 }
+
+func TestForemanNotifications(t *testing.T) {
+	config := testConfig()
+	config.NotifiedPollMax = 60000
+
+	mb := NewMockBackend()
+	s := NewServer(config, mb)
+	s.Start()
+	defer s.Stop()
+
+	// give our foreman time to back off polling as there is nothing to send
+	time.Sleep(time.Second)
+
+	dmChannel := NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "DM", "2020", "US", map[string]interface{}{})
+	for i := 1; i <= 3; i++ {
+		mb.PushOutgoingMsg(&mockMsg{channel: dmChannel, id: NewMsgID(int64(200 + i)), uuid: NilMsgUUID, text: "hello", urn: "tel:+250788383383"})
+	}
+
+	// our msgs are sent long before our next poll because pushing them notified our foreman
+	time.Sleep(time.Millisecond * 100)
+
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+	assert.Equal(t, 3, len(mb.msgStatuses))
+	assert.Equal(t, 0, len(mb.outgoingMsgs))
+}
+
+func TestBreakerOutcome(t *testing.T) {
+	channel := NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "XX", "2020", "US", map[string]interface{}{})
+	mb := NewMockBackend()
+
+	assert.Equal(t, BreakerSuccess, NewBreakerOutcome(mb.NewMsgStatusForID(channel, NewMsgID(1), MsgWired)))
+	assert.Equal(t, BreakerSuccess, NewBreakerOutcome(mb.NewMsgStatusForID(channel, NewMsgID(1), MsgSent)))
+	assert.Equal(t, BreakerFailure, NewBreakerOutcome(mb.NewMsgStatusForID(channel, NewMsgID(1), MsgErrored)))
+	assert.Equal(t, BreakerIgnore, NewBreakerOutcome(mb.NewMsgStatusForID(channel, NewMsgID(1), MsgFailed)))
+
+	// a connection failure always counts as a failure
+	status := mb.NewMsgStatusForID(channel, NewMsgID(1), MsgFailed)
+	status.AddLog(&ChannelLog{Channel: channel, ConnectionFailure: true})
+	assert.Equal(t, BreakerFailure, NewBreakerOutcome(status))
+}
+
+func TestApplySendError(t *testing.T) {
+	channel := NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "XX", "2020", "US", map[string]interface{}{})
+
+	tcs := []struct {
+		status    MsgStatusValue
+		sendError *SendError
+		expected  MsgStatusValue
+	}{
+		{MsgErrored, nil, MsgErrored},
+		{MsgErrored, NewSendError(ErrorProvider, "500", "server error"), MsgErrored},
+		{MsgErrored, NewRateLimitedError("429", "slow down", time.Minute), MsgErrored},
+		{MsgErrored, NewSendError(ErrorAuthentication, "401", "invalid token"), MsgErrored},
+		{MsgErrored, NewSendError(ErrorRecipientUnreachable, "403", "bot blocked"), MsgFailed},
+		{MsgErrored, NewSendError(ErrorContentTooLong, "1013", "too long"), MsgFailed},
+		{MsgWired, NewSendError(ErrorRecipientUnreachable, "403", "bot blocked"), MsgWired},
+	}
+
+	for _, tc := range tcs {
+		status := &mockMsgStatus{channel: channel, id: NewMsgID(1), status: tc.status}
+		status.SetSendError(tc.sendError)
+		applySendError(status)
+		assert.Equal(t, tc.expected, status.Status(), "status mismatch for %v", tc.sendError)
+	}
+}
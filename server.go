@@ -3,6 +3,7 @@ package courier
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -10,12 +11,14 @@ import (
 	"os"
 	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"sync"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
+	"github.com/nyaruka/courier/metrics"
 	"github.com/nyaruka/courier/utils"
 	"github.com/nyaruka/librato"
 	"github.com/sirupsen/logrus"
@@ -62,9 +65,8 @@ func NewServerWithLogger(config *Config, backend Backend, logger *logrus.Logger)
 	router.Use(middleware.Timeout(30 * time.Second))
 
 	chanRouter := chi.NewRouter()
-	router.Mount("/c/", chanRouter)
 
-	return &server{
+	s := &server{
 		config:  config,
 		backend: backend,
 
@@ -75,24 +77,28 @@ func NewServerWithLogger(config *Config, backend Backend, logger *logrus.Logger)
 		waitGroup: &sync.WaitGroup{},
 		stopped:   false,
 	}
+	router.Mount("/c/", s.startingGuard(chanRouter))
+
+	return s
 }
 
 // Start starts the Server listening for incoming requests and sending messages. It will return an error
 // if it encounters any unrecoverable (or ignorable) error, though its bias is to move forward despite
 // connection errors
 func (s *server) Start() error {
+	atomic.StoreInt32(&s.state, serverStarting)
+
 	// set our user agent, needs to happen before we do anything so we don't change have threading issues
 	utils.HTTPUserAgent = fmt.Sprintf("Courier/%s", s.config.Version)
 
-	// configure librato if we have configuration options for it
-	host, _ := os.Hostname()
-	if s.config.LibratoUsername != "" {
-		librato.Configure(s.config.LibratoUsername, s.config.LibratoToken, host, time.Second, s.waitGroup)
-		librato.Start()
+	// configure the sinks our metrics are reported to
+	err := s.configureMetrics()
+	if err != nil {
+		return err
 	}
 
 	// start our backend
-	err := s.backend.Start()
+	err = s.backend.Start()
 	if err != nil {
 		return err
 	}
@@ -105,9 +111,18 @@ func (s *server) Start() error {
 	s.router.MethodNotAllowed(s.handle405)
 	s.router.Get("/", s.handleIndex)
 	s.router.Get("/status", s.handleStatus)
+	s.router.Get("/health/live", s.handleHealthLive)
+	s.router.Get("/health/ready", s.handleHealthReady)
+	s.addAdminRoutes()
+	if s.prometheus != nil {
+		s.router.Get("/metrics", s.prometheus.ServeHTTP)
+	}
 
-	// initialize our handlers
-	s.initializeChannelHandlers()
+	// let our backend add any routes of its own
+	provider, isProvider := s.backend.(RouteProvider)
+	if isProvider {
+		provider.AddRoutes(s.router)
+	}
 
 	// configure timeouts on our server
 	s.httpServer = &http.Server{
@@ -131,6 +146,9 @@ func (s *server) Start() error {
 		}
 	}()
 
+	// initialize our handlers, until we are ready channel requests will be rejected
+	s.initializeChannelHandlers()
+
 	// start our heartbeat
 	go func() {
 		s.waitGroup.Add(1)
@@ -160,6 +178,7 @@ func (s *server) Start() error {
 	s.foreman = NewForeman(s, s.config.MaxWorkers)
 	s.foreman.Start()
 
+	atomic.StoreInt32(&s.state, serverReady)
 	return nil
 }
 
@@ -167,6 +186,7 @@ func (s *server) Start() error {
 func (s *server) Stop() error {
 	log := logrus.WithField("comp", "server")
 	log.WithField("state", "stopping").Info("stopping server")
+	atomic.StoreInt32(&s.state, serverStopping)
 
 	// stop our foreman
 	s.foreman.Stop()
@@ -186,8 +206,9 @@ func (s *server) Stop() error {
 		return err
 	}
 
-	// stop our librato sender
+	// stop our librato sender and stop reporting metrics
 	librato.Stop()
+	metrics.ClearSinks()
 
 	// wait for everything to stop
 	s.waitGroup.Wait()
@@ -210,6 +231,33 @@ func (s *server) SendMsg(ctx context.Context, msg Msg) (MsgStatus, error) {
 	return handler.SendMsg(ctx, msg)
 }
 
+// configureMetrics registers the metrics sinks listed in our config
+func (s *server) configureMetrics() error {
+	for _, sink := range strings.Split(s.config.Metrics, ",") {
+		switch strings.ToLower(strings.TrimSpace(sink)) {
+		case "":
+			continue
+
+		case "librato":
+			// librato is only enabled if we have credentials for it
+			if s.config.LibratoUsername != "" {
+				host, _ := os.Hostname()
+				librato.Configure(s.config.LibratoUsername, s.config.LibratoToken, host, time.Second, s.waitGroup)
+				librato.Start()
+				metrics.RegisterSink(metrics.NewLibratoSink())
+			}
+
+		case "prometheus":
+			s.prometheus = metrics.NewPrometheusSink()
+			metrics.RegisterSink(s.prometheus)
+
+		default:
+			return fmt.Errorf("unknown metrics sink: '%s'", sink)
+		}
+	}
+	return nil
+}
+
 func (s *server) WaitGroup() *sync.WaitGroup { return s.waitGroup }
 func (s *server) StopChan() chan bool        { return s.stopChan }
 func (s *server) Config() *Config            { return s.config }
@@ -229,6 +277,11 @@ type server struct {
 
 	config *Config
 
+	prometheus *metrics.PrometheusSink
+
+	// one of our server states below, accessed atomically
+	state int32
+
 	waitGroup *sync.WaitGroup
 	stopChan  chan bool
 	stopped   bool
@@ -300,7 +353,7 @@ func (s *server) channelHandleWrapper(handler ChannelHandler, handlerFunc Channe
 
 		events, err := handlerFunc(ctx, channel, ww, r)
 		duration := time.Now().Sub(start)
-		secondDuration := float64(duration) / float64(time.Second)
+		labels := metrics.ChannelType(string(channel.ChannelType()))
 
 		// if we received an error, write it out and report it
 		if err != nil {
@@ -312,10 +365,12 @@ func (s *server) channelHandleWrapper(handler ChannelHandler, handlerFunc Channe
 		if len(events) == 0 {
 			if err != nil {
 				logs = append(logs, NewChannelLog("Channel Error", channel, NilMsgID, r.Method, url, ww.Status(), string(request), prependHeaders(response.String(), ww.Status(), w), duration, err))
-				librato.Gauge(fmt.Sprintf("courier.channel_error_%s", channel.ChannelType()), secondDuration)
+				metrics.Timing("channel_error", labels, duration)
+				metrics.Count("channel_requests", labels.With("outcome", "error"), 1)
 			} else {
 				logs = append(logs, NewChannelLog("Request Ignored", channel, NilMsgID, r.Method, url, ww.Status(), string(request), prependHeaders(response.String(), ww.Status(), w), duration, err))
-				librato.Gauge(fmt.Sprintf("courier.channel_ignored_%s", channel.ChannelType()), secondDuration)
+				metrics.Timing("channel_ignored", labels, duration)
+				metrics.Count("channel_requests", labels.With("outcome", "ignored"), 1)
 			}
 		}
 
@@ -324,15 +379,18 @@ func (s *server) channelHandleWrapper(handler ChannelHandler, handlerFunc Channe
 			switch e := event.(type) {
 			case Msg:
 				logs = append(logs, NewChannelLog("Message Received", channel, e.ID(), r.Method, url, ww.Status(), string(request), prependHeaders(response.String(), ww.Status(), w), duration, err))
-				librato.Gauge(fmt.Sprintf("courier.msg_receive_%s", channel.ChannelType()), secondDuration)
+				metrics.Timing("msg_receive", labels, duration)
+				metrics.Count("channel_requests", labels.With("outcome", "msg"), 1)
 				LogMsgReceived(r, e)
 			case ChannelEvent:
 				logs = append(logs, NewChannelLog("Event Received", channel, NilMsgID, r.Method, url, ww.Status(), string(request), prependHeaders(response.String(), ww.Status(), w), duration, err))
-				librato.Gauge(fmt.Sprintf("courier.evt_receive_%s", channel.ChannelType()), secondDuration)
+				metrics.Timing("evt_receive", labels, duration)
+				metrics.Count("channel_requests", labels.With("outcome", "event"), 1)
 				LogChannelEventReceived(r, e)
 			case MsgStatus:
 				logs = append(logs, NewChannelLog("Status Updated", channel, e.ID(), r.Method, url, ww.Status(), string(request), response.String(), duration, err))
-				librato.Gauge(fmt.Sprintf("courier.msg_status_%s", channel.ChannelType()), secondDuration)
+				metrics.Timing("msg_status", labels, duration)
+				metrics.Count("channel_requests", labels.With("outcome", "status"), 1)
 				LogMsgStatusReceived(r, e)
 			}
 		}
@@ -375,7 +433,7 @@ func (s *server) handleIndex(w http.ResponseWriter, r *http.Request) {
 	buf.WriteString(splash)
 	buf.WriteString(s.config.Version)
 
-	buf.WriteString(s.backend.Health())
+	buf.WriteString(s.backend.Health().String())
 
 	buf.WriteString("\n\n")
 	buf.WriteString(strings.Join(s.routes, "\n"))
@@ -420,10 +478,75 @@ func (s *server) handleStatus(w http.ResponseWriter, r *http.Request) {
 	buf.WriteString("\n\n")
 	buf.WriteString(s.backend.Status())
 	buf.WriteString("\n\n")
+	buf.WriteString(spoolStatus())
+	buf.WriteString("\n\n")
 	buf.WriteString("</pre></body>")
 	w.Write(buf.Bytes())
 }
 
+// handleHealthLive returns whether we are alive, which is always the case if we can respond
+func (s *server) handleHealthLive(w http.ResponseWriter, r *http.Request) {
+	writeJSONResponse(r.Context(), w, http.StatusOK, &healthResponse{Status: "ok", Version: s.config.Version})
+}
+
+// handleHealthReady returns a report on the health of each of our dependencies, and whether we are ready for traffic
+func (s *server) handleHealthReady(w http.ResponseWriter, r *http.Request) {
+	health := s.backend.Health()
+
+	var startErr error
+	switch atomic.LoadInt32(&s.state) {
+	case serverStarting:
+		startErr = errors.New("server still starting")
+	case serverStopping:
+		startErr = errors.New("server stopping")
+	}
+	health.Add("server", startErr)
+
+	var foremanErr error
+	if s.foreman == nil || !s.foreman.Running() {
+		foremanErr = errors.New("foreman not running")
+	}
+	health.Add("foreman", foremanErr).WithDetail("senders", s.config.MaxWorkers)
+
+	// failed optional checks, such as our media storage, degrade us but we can still receive
+	response := &healthResponse{Status: "ok", Version: s.config.Version, Checks: health.Checks}
+	statusCode := http.StatusOK
+	if !health.Healthy() {
+		response.Status = "error"
+		statusCode = http.StatusServiceUnavailable
+	} else if health.Degraded() {
+		response.Status = "degraded"
+	}
+	writeJSONResponse(r.Context(), w, statusCode, response)
+}
+
+type healthResponse struct {
+	Status  string         `json:"status"`
+	Version string         `json:"version"`
+	Checks  []*HealthCheck `json:"checks,omitempty"`
+}
+
+// startingGuard rejects requests to the passed in handler while our server is starting and still
+// initializing its channel handlers
+func (s *server) startingGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&s.state) == serverStarting {
+			errors := []interface{}{NewErrorData("server still starting")}
+			WriteDataResponse(r.Context(), w, http.StatusServiceUnavailable, "Service Unavailable", errors)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// the states our server moves through
+const (
+	serverNew int32 = iota
+	serverStarting
+	serverReady
+	serverStopping
+)
+
 // for use in request.Context
 type contextKey int
 
@@ -16,7 +16,8 @@ func TestServer(t *testing.T) {
 	config.StatusUsername = "admin"
 	config.StatusPassword = "password123"
 
-	server := NewServerWithLogger(config, NewMockBackend(), logger)
+	mb := NewMockBackend()
+	server := NewServerWithLogger(config, mb, logger)
 	server.Start()
 	defer server.Stop()
 
@@ -42,6 +43,61 @@ func TestServer(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Contains(t, string(rr.Body), "courier")
 
+	// liveness is always ok
+	req, _ = http.NewRequest("GET", "http://localhost:8080/health/live", nil)
+	rr, err = utils.MakeHTTPRequest(req)
+	assert.NoError(t, err)
+	assert.Contains(t, string(rr.Body), `"status":"ok"`)
+
+	// we're started so should be ready
+	req, _ = http.NewRequest("GET", "http://localhost:8080/health/ready", nil)
+	rr, err = utils.MakeHTTPRequest(req)
+	assert.NoError(t, err)
+	assert.Contains(t, string(rr.Body), `"name":"server","ok":true`)
+	assert.Contains(t, string(rr.Body), `"name":"foreman","ok":true`)
+
+	// admin API without auth
+	req, _ = http.NewRequest("GET", "http://localhost:8080/admin/queues", nil)
+	rr, err = utils.MakeHTTPRequest(req)
+	assert.Error(t, err)
+	assert.Equal(t, 401, rr.StatusCode)
+
+	// admin API with auth
+	req, _ = http.NewRequest("GET", "http://localhost:8080/admin/queues", nil)
+	req.SetBasicAuth("admin", "password123")
+	rr, err = utils.MakeHTTPRequest(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "{\"queues\":[]}\n", string(rr.Body))
+
+	// peek with an invalid count
+	req, _ = http.NewRequest("GET", "http://localhost:8080/admin/queues/dbc126ed-66bc-4e28-b67b-81dc3327c95d?count=1000", nil)
+	req.SetBasicAuth("admin", "password123")
+	rr, err = utils.MakeHTTPRequest(req)
+	assert.Error(t, err)
+	assert.Equal(t, 400, rr.StatusCode)
+
+	// pause and resume a channel
+	req, _ = http.NewRequest("POST", "http://localhost:8080/admin/channels/dbc126ed-66bc-4e28-b67b-81dc3327c95d/pause", nil)
+	req.SetBasicAuth("admin", "password123")
+	rr, err = utils.MakeHTTPRequest(req)
+	assert.NoError(t, err)
+	assert.Contains(t, string(rr.Body), `"paused":true`)
+
+	req, _ = http.NewRequest("POST", "http://localhost:8080/admin/channels/dbc126ed-66bc-4e28-b67b-81dc3327c95d/resume", nil)
+	req.SetBasicAuth("admin", "password123")
+	rr, err = utils.MakeHTTPRequest(req)
+	assert.NoError(t, err)
+	assert.Contains(t, string(rr.Body), `"paused":false`)
+
+	// invalidate a channel
+	req, _ = http.NewRequest("POST", "http://localhost:8080/admin/channels/dbc126ed-66bc-4e28-b67b-81dc3327c95d/invalidate", nil)
+	req.SetBasicAuth("admin", "password123")
+	rr, err = utils.MakeHTTPRequest(req)
+	assert.NoError(t, err)
+	assert.Contains(t, string(rr.Body), `"invalidated":true`)
+	channelUUID, _ := NewChannelUUID("dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	assert.Equal(t, []ChannelUUID{channelUUID}, mb.InvalidatedChannels())
+
 	// hit an invalid path
 	req, _ = http.NewRequest("GET", "http://localhost:8080/notthere", nil)
 	rr, err = utils.MakeHTTPRequest(req)
@@ -1,6 +1,7 @@
 package courier
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,6 +12,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/nyaruka/courier/metrics"
 	"github.com/sirupsen/logrus"
 )
 
@@ -23,6 +25,26 @@ func RegisterFlusher(directory string, flusherFunc FlusherFunc) {
 	registeredFlushers = append(registeredFlushers, &flusherRegistration{directory, flusherFunc})
 }
 
+// SpoolParserFunc defines our interface for spool parsers, they are handed the contents of a spooled file and are
+// expected to return an InvalidSpoolFile error if it can never be flushed, parsing it the same way its flusher does
+type SpoolParserFunc func(contents []byte) error
+
+// RegisterSpoolParser registers the parser for files in the passed in spool, such as msgs. Unlike flushers these are
+// registered when backends are loaded rather than started so that spooled files can be checked without a backend
+func RegisterSpoolParser(spool string, parser SpoolParserFunc) {
+	registeredParsers[spool] = parser
+}
+
+// ValidateSpoolFile checks that the passed in contents of a file in the passed in spool can be parsed by the parser
+// registered for that spool
+func ValidateSpoolFile(spool string, contents []byte) error {
+	parser, found := registeredParsers[spool]
+	if !found {
+		return fmt.Errorf("no parser registered for spool %s", spool)
+	}
+	return parser(contents)
+}
+
 // WriteToSpool writes the passed in object to the passed in subdir
 func WriteToSpool(spoolDir string, subdir string, contents interface{}) error {
 	contentBytes, err := json.MarshalIndent(contents, "", "  ")
@@ -34,6 +56,52 @@ func WriteToSpool(spoolDir string, subdir string, contents interface{}) error {
 	return ioutil.WriteFile(filename, contentBytes, 0640)
 }
 
+// FlushSpoolFile flushes the passed in spool file using the flusher registered for its directory, or for the directory
+// above if it is in a dead directory, removing the file once it has been flushed
+func FlushSpoolFile(filename string) error {
+	dir := resolveSpoolDir(filepath.Dir(filename))
+	if filepath.Base(dir) == SpoolDeadDir {
+		dir = filepath.Dir(dir)
+	}
+
+	for _, reg := range registeredFlushers {
+		if resolveSpoolDir(reg.directory) != dir {
+			continue
+		}
+
+		contents, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+
+		err = reg.flusher(filename, contents)
+		if err != nil {
+			return err
+		}
+
+		// we flushed, remove our file if it is still present, along with any record of failed attempts to flush it
+		os.Remove(SpoolAttemptsFilename(filename))
+		if _, e := os.Stat(filename); e == nil {
+			err = os.Remove(filename)
+		}
+		return err
+	}
+
+	return fmt.Errorf("no flusher registered for spool directory %s", dir)
+}
+
+// resolveSpoolDir returns the absolute path of the passed in directory with any symlinks resolved, so that different
+// paths to the same directory can be compared
+func resolveSpoolDir(dir string) string {
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	return dir
+}
+
 // starts our spool flusher, which every 30 seconds tries to write our pending msgs and statuses
 func startSpoolFlushers(s Server) {
 	// create our actual flushers
@@ -60,8 +128,11 @@ func startSpoolFlushers(s Server) {
 
 			// every 30 seconds we check to see if there are any files to spool
 			case <-time.After(30 * time.Second):
+				healthy := s.Backend().Health().Healthy()
 				for _, flusher := range flushers {
+					flusher.healthy = healthy
 					filepath.Walk(flusher.directory, flusher.walker)
+					flusher.reportMetrics()
 				}
 			}
 		}
@@ -77,9 +148,104 @@ func EnsureSpoolDirPresent(spoolDir string, subdir string) (err error) {
 	return err
 }
 
+// CheckSpoolDirWritable checks that we are able to create files in the passed in spool directory
+func CheckSpoolDirWritable(dir string) error {
+	file, err := ioutil.TempFile(dir, ".health")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// SpoolBacklogSize returns the number of files in the passed in spool directory which are waiting to be flushed
+func SpoolBacklogSize(dir string) int {
+	files, err := filepath.Glob(path.Join(dir, "*.json"))
+	if err != nil {
+		return 0
+	}
+	return len(files)
+}
+
+// SpoolDeadSize returns the number of files in the passed in spool directory which failed to be flushed too many times
+// and were moved to its dead directory
+func SpoolDeadSize(dir string) int {
+	return SpoolBacklogSize(path.Join(dir, SpoolDeadDir))
+}
+
+// SpoolOldestAge returns the age of the oldest file in the passed in spool directory waiting to be flushed, or zero
+// if there are none
+func SpoolOldestAge(dir string) time.Duration {
+	files, err := filepath.Glob(path.Join(dir, "*.json"))
+	if err != nil {
+		return 0
+	}
+
+	oldest := time.Duration(0)
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err == nil && time.Since(info.ModTime()) > oldest {
+			oldest = time.Since(info.ModTime())
+		}
+	}
+	return oldest
+}
+
+// SpoolDeadDir is the subdirectory of each spool directory files are moved to once they have failed to be flushed too
+// many times, they are kept there until someone looks into why
+const SpoolDeadDir = "dead"
+
+// InvalidSpoolFile wraps the passed in error to signal that a spooled file can never be flushed, for example because
+// it can't be parsed, these are moved straight to the dead directory instead of being retried
+func InvalidSpoolFile(err error) error {
+	return &invalidSpoolFileError{err}
+}
+
+type invalidSpoolFileError struct {
+	err error
+}
+
+func (e *invalidSpoolFileError) Error() string { return fmt.Sprintf("invalid spool file: %s", e.err) }
+
+// the longest we wait between attempts to flush a file
+const maxSpoolBackoff = time.Hour
+
+// spoolBackoff returns how long we wait to flush a file again after the passed in number of failed attempts, starting
+// at 30 seconds and doubling after every attempt
+func spoolBackoff(attempts int) time.Duration {
+	backoff := 30 * time.Second
+	for i := 1; i < attempts && backoff < maxSpoolBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxSpoolBackoff {
+		backoff = maxSpoolBackoff
+	}
+	return backoff
+}
+
+// spoolStatus returns a description of the backlog of each of our registered spool directories
+func spoolStatus() string {
+	status := bytes.Buffer{}
+	status.WriteString("------------------------------------------------------------------------------------\n")
+	status.WriteString("  Backlog |  Dead | Oldest   | Spool\n")
+	status.WriteString("------------------------------------------------------------------------------------\n")
+	for _, reg := range registeredFlushers {
+		oldest := SpoolOldestAge(reg.directory).Round(time.Second)
+		status.WriteString(fmt.Sprintf(" % 8d   % 5d   % 8s   %s\n", SpoolBacklogSize(reg.directory), SpoolDeadSize(reg.directory), oldest, reg.directory))
+	}
+	return status.String()
+}
+
 // creates a new spool flusher
 func newSpoolFlusher(s Server, dir string, flusherFunc FlusherFunc) *flusher {
-	return &flusher{func(filename string, info os.FileInfo, err error) error {
+	f := &flusher{
+		directory:   dir,
+		maxAttempts: s.Config().SpoolMaxAttempts,
+		attempts:    make(map[string]*spoolAttempts),
+		healthy:     true,
+	}
+
+	f.walker = func(filename string, info os.FileInfo, err error) error {
 		if filename == dir {
 			return nil
 		}
@@ -89,6 +255,11 @@ func newSpoolFlusher(s Server, dir string, flusherFunc FlusherFunc) *flusher {
 			return errors.New("spool flush process stopped")
 		}
 
+		// this file disappeared while we were walking, nothing to do
+		if err != nil {
+			return nil
+		}
+
 		// we don't care about subdirectories
 		if info.IsDir() {
 			return filepath.SkipDir
@@ -99,34 +270,157 @@ func newSpoolFlusher(s Server, dir string, flusherFunc FlusherFunc) *flusher {
 			return nil
 		}
 
+		// this file failed recently, wait until its backoff has passed
+		if !f.isDue(filename) {
+			return nil
+		}
+
 		log := logrus.WithField("comp", "spool").WithField("filename", filename)
 
 		// otherwise, read our msg json
 		contents, err := ioutil.ReadFile(filename)
-		if err != nil {
-			log.WithError(err).Error("reading spool file")
-			return nil
+		if err == nil {
+			err = flusherFunc(filename, contents)
 		}
-
-		err = flusherFunc(filename, contents)
 		if err != nil {
 			log.WithError(err).Error("flushing spool file")
-			return err
+			f.failed(filename, err)
+
+			// keep going, one bad file shouldn't hold up those behind it
+			return nil
 		}
 		log.Info("flushed")
+		f.flushed(filename)
 
 		// we flushed, remove our file if it is still present
 		if _, e := os.Stat(filename); e == nil {
 			err = os.Remove(filename)
 		}
 		return err
-	}, dir}
+	}
+	return f
+}
+
+// getAttempts returns the failed attempts to flush the passed in file, loading them from its attempts file if we
+// haven't yet, or nil if there have been none
+func (f *flusher) getAttempts(filename string) *spoolAttempts {
+	attempts, found := f.attempts[filename]
+	if found && attempts == nil {
+		return nil
+	}
+
+	// our attempts file is removed if the file is revived, in which case we forget what we remembered
+	if found {
+		if _, err := os.Stat(SpoolAttemptsFilename(filename)); err == nil {
+			return attempts
+		}
+		attempts = nil
+	}
+
+	contents, err := ioutil.ReadFile(SpoolAttemptsFilename(filename))
+	if err == nil {
+		attempts = &spoolAttempts{}
+		err = json.Unmarshal(contents, attempts)
+		if err != nil {
+			logrus.WithField("comp", "spool").WithField("filename", filename).WithError(err).Error("error reading spool attempts file")
+			attempts = nil
+		}
+	}
+
+	f.attempts[filename] = attempts
+	return attempts
+}
+
+// isDue returns whether the passed in file should be flushed now
+func (f *flusher) isDue(filename string) bool {
+	attempts := f.getAttempts(filename)
+	return attempts == nil || !time.Now().Before(attempts.NextAttempt)
+}
+
+// flushed forgets any failed attempts for the passed in file
+func (f *flusher) flushed(filename string) {
+	delete(f.attempts, filename)
+	os.Remove(SpoolAttemptsFilename(filename))
+}
+
+// failed records a failed attempt to flush the passed in file, backing off before the next attempt or moving it to our
+// dead directory if it has failed too many times or can never be flushed
+func (f *flusher) failed(filename string, err error) {
+	_, invalid := err.(*invalidSpoolFileError)
+
+	// when our backend is unhealthy, files likely failed because of that, such as our database being down, rather
+	// than because of anything wrong with them, so we keep retrying them without counting those attempts
+	if !invalid && !f.healthy {
+		return
+	}
+
+	attempts := f.getAttempts(filename)
+	if attempts == nil {
+		attempts = &spoolAttempts{}
+		f.attempts[filename] = attempts
+	}
+	attempts.Count++
+	attempts.NextAttempt = time.Now().Add(spoolBackoff(attempts.Count))
+
+	if !invalid && (f.maxAttempts <= 0 || attempts.Count < f.maxAttempts) {
+		// record our attempts next to the file so that they survive restarts
+		attemptsJSON, _ := json.Marshal(attempts)
+		writeErr := ioutil.WriteFile(SpoolAttemptsFilename(filename), attemptsJSON, 0640)
+		if writeErr != nil {
+			logrus.WithField("comp", "spool").WithField("filename", filename).WithError(writeErr).Error("error writing spool attempts file")
+		}
+		return
+	}
+
+	log := logrus.WithField("comp", "spool").WithField("filename", filename).WithField("attempts", attempts.Count)
+
+	deadDir := path.Join(f.directory, SpoolDeadDir)
+	moveErr := os.MkdirAll(deadDir, 0770)
+	if moveErr == nil {
+		moveErr = os.Rename(filename, path.Join(deadDir, filepath.Base(filename)))
+	}
+	if moveErr != nil {
+		log.WithError(moveErr).Error("error moving spool file to dead directory")
+		return
+	}
+
+	log.WithError(err).Error("spool file moved to dead directory")
+	metrics.Count("spool_dead", metrics.Labels{"spool": filepath.Base(f.directory)}, 1)
+	f.flushed(filename)
+}
+
+// reportMetrics reports the size and age of our backlog and our number of dead files
+func (f *flusher) reportMetrics() {
+	labels := metrics.Labels{"spool": filepath.Base(f.directory)}
+	metrics.Gauge("spool_backlog", labels, float64(SpoolBacklogSize(f.directory)))
+	metrics.Gauge("spool_oldest_age", labels, SpoolOldestAge(f.directory).Seconds())
+	metrics.Gauge("spool_dead_files", labels, float64(SpoolDeadSize(f.directory)))
+}
+
+// the suffix of the files we record the failed attempts to flush a spool file in, next to the file itself
+const spoolAttemptsSuffix = ".attempts"
+
+// SpoolAttemptsFilename returns the name of the file the failed attempts to flush the passed in file are recorded in,
+// which is moved with the file if it is killed and removed if it is revived
+func SpoolAttemptsFilename(filename string) string {
+	return filename + spoolAttemptsSuffix
+}
+
+// the failed attempts to flush a spool file
+type spoolAttempts struct {
+	Count       int       `json:"count"`
+	NextAttempt time.Time `json:"next_attempt"`
 }
 
 // simple struct that represents our walking function and the directory that gets walked
 type flusher struct {
-	walker    filepath.WalkFunc
-	directory string
+	walker      filepath.WalkFunc
+	directory   string
+	maxAttempts int
+	attempts    map[string]*spoolAttempts
+
+	// whether our backend was healthy when we started walking, we only count failed attempts when it is
+	healthy bool
 }
 
 var flushers []*flusher
@@ -138,3 +432,5 @@ type flusherRegistration struct {
 }
 
 var registeredFlushers []*flusherRegistration
+
+var registeredParsers = make(map[string]SpoolParserFunc)
@@ -0,0 +1,158 @@
+package courier
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpoolBackoff(t *testing.T) {
+	assert.Equal(t, 30*time.Second, spoolBackoff(1))
+	assert.Equal(t, time.Minute, spoolBackoff(2))
+	assert.Equal(t, 4*time.Minute, spoolBackoff(4))
+	assert.Equal(t, time.Hour, spoolBackoff(10))
+	assert.Equal(t, time.Hour, spoolBackoff(100))
+}
+
+func TestSpoolFlusher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := NewConfig()
+	config.SpoolMaxAttempts = 3
+	server := NewServer(config, NewMockBackend())
+
+	for _, name := range []string{"1.json", "2.json", "3.json"} {
+		assert.NoError(t, ioutil.WriteFile(path.Join(dir, name), []byte(`{"name":"`+name+`"}`), 0640))
+	}
+
+	// our first file fails to flush, the second is invalid and the third flushes fine
+	flushed := make([]string, 0)
+	f := newSpoolFlusher(server, dir, func(filename string, contents []byte) error {
+		switch filepath.Base(filename) {
+		case "1.json":
+			return errors.New("db down")
+		case "2.json":
+			return InvalidSpoolFile(errors.New("bad json"))
+		}
+		flushed = append(flushed, filepath.Base(filename))
+		return nil
+	})
+
+	assert.Equal(t, 3, SpoolBacklogSize(dir))
+	assert.True(t, SpoolOldestAge(dir) >= 0)
+
+	filepath.Walk(dir, f.walker)
+
+	// a failing file doesn't stop those behind it being flushed, invalid files are moved straight to our dead directory
+	assert.Equal(t, []string{"3.json"}, flushed)
+	assert.Equal(t, 1, SpoolBacklogSize(dir))
+	assert.Equal(t, 1, SpoolDeadSize(dir))
+	assert.Equal(t, 1, f.attempts[path.Join(dir, "1.json")].Count)
+
+	// our failed file isn't retried until its backoff has passed
+	filepath.Walk(dir, f.walker)
+	assert.Equal(t, 1, f.attempts[path.Join(dir, "1.json")].Count)
+
+	f.attempts[path.Join(dir, "1.json")].NextAttempt = time.Now()
+	filepath.Walk(dir, f.walker)
+	assert.Equal(t, 2, f.attempts[path.Join(dir, "1.json")].Count)
+
+	// our attempts are recorded next to the file so a new flusher picks them up
+	assert.Equal(t, 1, SpoolBacklogSize(dir))
+	restarted := newSpoolFlusher(server, dir, func(string, []byte) error { return nil })
+	assert.False(t, restarted.isDue(path.Join(dir, "1.json")))
+	assert.Equal(t, 2, restarted.attempts[path.Join(dir, "1.json")].Count)
+
+	// failures while our backend is unhealthy aren't counted
+	f.healthy = false
+	f.attempts[path.Join(dir, "1.json")].NextAttempt = time.Now()
+	filepath.Walk(dir, f.walker)
+	assert.Equal(t, 2, f.attempts[path.Join(dir, "1.json")].Count)
+	assert.Equal(t, 1, SpoolBacklogSize(dir))
+	f.healthy = true
+
+	// once it has failed too many times it is moved to our dead directory too
+	f.attempts[path.Join(dir, "1.json")].NextAttempt = time.Now()
+	filepath.Walk(dir, f.walker)
+	assert.Nil(t, f.attempts[path.Join(dir, "1.json")])
+	assert.Equal(t, 0, SpoolBacklogSize(dir))
+	assert.Equal(t, 2, SpoolDeadSize(dir))
+	assert.Equal(t, time.Duration(0), SpoolOldestAge(dir))
+
+	_, err = os.Stat(path.Join(dir, SpoolDeadDir, "1.json"))
+	assert.NoError(t, err)
+	_, err = os.Stat(SpoolAttemptsFilename(path.Join(dir, "1.json")))
+	assert.True(t, os.IsNotExist(err))
+
+	// files which are revived while we remember their attempts have them forgotten
+	assert.NoError(t, os.Rename(path.Join(dir, SpoolDeadDir, "1.json"), path.Join(dir, "1.json")))
+	filepath.Walk(dir, f.walker)
+	assert.Equal(t, 1, f.attempts[path.Join(dir, "1.json")].Count)
+
+	assert.NoError(t, os.Remove(SpoolAttemptsFilename(path.Join(dir, "1.json"))))
+	assert.True(t, f.isDue(path.Join(dir, "1.json")))
+	assert.Nil(t, f.attempts[path.Join(dir, "1.json")])
+}
+
+func TestFlushSpoolFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	defer func(original []*flusherRegistration) { registeredFlushers = original }(registeredFlushers)
+
+	msgsDir := path.Join(dir, "msgs")
+	assert.NoError(t, EnsureSpoolDirPresent(dir, "msgs"))
+	assert.NoError(t, EnsureSpoolDirPresent(msgsDir, SpoolDeadDir))
+
+	flushed := make([]string, 0)
+	RegisterFlusher(msgsDir, func(filename string, contents []byte) error {
+		if string(contents) == "fail" {
+			return errors.New("db down")
+		}
+		flushed = append(flushed, string(contents))
+		return nil
+	})
+
+	// files in our spool directory or its dead directory are flushed and removed
+	assert.NoError(t, ioutil.WriteFile(path.Join(msgsDir, "1.json"), []byte("one"), 0640))
+	assert.NoError(t, ioutil.WriteFile(path.Join(msgsDir, SpoolDeadDir, "2.json"), []byte("two"), 0640))
+	assert.NoError(t, FlushSpoolFile(path.Join(msgsDir, "1.json")))
+	assert.NoError(t, FlushSpoolFile(path.Join(msgsDir, SpoolDeadDir, "2.json")))
+	assert.Equal(t, []string{"one", "two"}, flushed)
+	assert.Equal(t, 0, SpoolBacklogSize(msgsDir))
+	assert.Equal(t, 0, SpoolDeadSize(msgsDir))
+
+	// files which fail to flush are left where they are
+	assert.NoError(t, ioutil.WriteFile(path.Join(msgsDir, "3.json"), []byte("fail"), 0640))
+	assert.EqualError(t, FlushSpoolFile(path.Join(msgsDir, "3.json")), "db down")
+	assert.Equal(t, 1, SpoolBacklogSize(msgsDir))
+
+	// as are files in directories nobody has registered a flusher for
+	err = FlushSpoolFile(path.Join(dir, "statuses", "4.json"))
+	assert.EqualError(t, err, "no flusher registered for spool directory "+path.Join(dir, "statuses"))
+}
+
+func TestValidateSpoolFile(t *testing.T) {
+	defer func(original map[string]SpoolParserFunc) { registeredParsers = original }(registeredParsers)
+	registeredParsers = make(map[string]SpoolParserFunc)
+
+	RegisterSpoolParser("msgs", func(contents []byte) error {
+		if string(contents) != "valid" {
+			return InvalidSpoolFile(errors.New("bad msg"))
+		}
+		return nil
+	})
+
+	assert.NoError(t, ValidateSpoolFile("msgs", []byte("valid")))
+	assert.EqualError(t, ValidateSpoolFile("msgs", []byte("invalid")), "invalid spool file: bad msg")
+	assert.EqualError(t, ValidateSpoolFile("statuses", []byte("valid")), "no parser registered for spool statuses")
+}
@@ -11,10 +11,54 @@ const (
 	MsgWired     MsgStatusValue = "W"
 	MsgErrored   MsgStatusValue = "E"
 	MsgDelivered MsgStatusValue = "D"
+	MsgRead      MsgStatusValue = "R"
 	MsgFailed    MsgStatusValue = "F"
 	NilMsgStatus MsgStatusValue = ""
 )
 
+// the statuses a msg in each status can be updated to, a msg can always be updated to the status it is already in. Any
+// other update is a regression, usually caused by provider callbacks arriving out of order.
+var msgStatusTransitions = map[MsgStatusValue][]MsgStatusValue{
+	MsgPending:   {MsgQueued, MsgWired, MsgSent, MsgDelivered, MsgRead, MsgErrored, MsgFailed},
+	MsgQueued:    {MsgWired, MsgSent, MsgDelivered, MsgRead, MsgErrored, MsgFailed},
+	MsgErrored:   {MsgQueued, MsgWired, MsgSent, MsgDelivered, MsgRead, MsgFailed},
+	MsgWired:     {MsgSent, MsgDelivered, MsgRead, MsgErrored, MsgFailed},
+	MsgSent:      {MsgDelivered, MsgRead, MsgErrored, MsgFailed},
+	MsgDelivered: {MsgRead},
+	MsgRead:      {},
+	MsgFailed:    {MsgWired, MsgSent, MsgDelivered, MsgRead},
+}
+
+// the order we list transitions in
+var msgStatusValues = []MsgStatusValue{MsgPending, MsgQueued, MsgErrored, MsgWired, MsgSent, MsgDelivered, MsgRead, MsgFailed}
+
+// IsValidMsgStatusTransition returns whether a msg in the from status can be updated to the to status
+func IsValidMsgStatusTransition(from MsgStatusValue, to MsgStatusValue) bool {
+	if from == to {
+		return true
+	}
+	for _, s := range msgStatusTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidMsgStatusTransitions returns every pair of from and to statuses which is a valid transition, backends can use
+// these to enforce our state machine in the same statement which updates the msg
+func ValidMsgStatusTransitions() [][2]MsgStatusValue {
+	transitions := make([][2]MsgStatusValue, 0, len(msgStatusValues)*len(msgStatusValues))
+	for _, from := range msgStatusValues {
+		for _, to := range msgStatusValues {
+			if IsValidMsgStatusTransition(from, to) {
+				transitions = append(transitions, [2]MsgStatusValue{from, to})
+			}
+		}
+	}
+	return transitions
+}
+
 //-----------------------------------------------------------------------------
 // MsgStatusUpdate Interface
 //-----------------------------------------------------------------------------
@@ -32,6 +76,9 @@ type MsgStatus interface {
 	Status() MsgStatusValue
 	SetStatus(MsgStatusValue)
 
+	SendError() *SendError
+	SetSendError(*SendError)
+
 	Logs() []*ChannelLog
 	AddLog(log *ChannelLog)
 }
@@ -0,0 +1,47 @@
+package courier
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMsgStatusTransitions(t *testing.T) {
+	tcs := []struct {
+		from  MsgStatusValue
+		to    MsgStatusValue
+		valid bool
+	}{
+		{MsgQueued, MsgWired, true},
+		{MsgWired, MsgSent, true},
+		{MsgSent, MsgDelivered, true},
+		{MsgDelivered, MsgRead, true},
+		{MsgWired, MsgWired, true},
+		{MsgErrored, MsgErrored, true},
+		{MsgErrored, MsgWired, true},
+		{MsgWired, MsgFailed, true},
+		{MsgFailed, MsgDelivered, true},
+
+		// regressions caused by callbacks arriving out of order
+		{MsgDelivered, MsgWired, false},
+		{MsgDelivered, MsgSent, false},
+		{MsgSent, MsgWired, false},
+		{MsgRead, MsgDelivered, false},
+		{MsgDelivered, MsgFailed, false},
+		{MsgFailed, MsgErrored, false},
+		{MsgStatusValue("H"), MsgWired, false},
+	}
+
+	for _, tc := range tcs {
+		assert.Equal(t, tc.valid, IsValidMsgStatusTransition(tc.from, tc.to), "transition mismatch for %s -> %s", tc.from, tc.to)
+	}
+
+	// every transition we list is valid
+	transitions := ValidMsgStatusTransitions()
+	assert.Contains(t, transitions, [2]MsgStatusValue{MsgSent, MsgDelivered})
+	assert.Contains(t, transitions, [2]MsgStatusValue{MsgRead, MsgRead})
+	assert.NotContains(t, transitions, [2]MsgStatusValue{MsgDelivered, MsgSent})
+	for _, transition := range transitions {
+		assert.True(t, IsValidMsgStatusTransition(transition[0], transition[1]))
+	}
+}
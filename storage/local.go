@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+type localStorage struct {
+	dir    string
+	signer *URLSigner
+}
+
+// NewLocalStorage creates a new storage which writes files to the passed in directory, they are served by courier
+// from the URLs of the passed in signer
+func NewLocalStorage(dir string, signer *URLSigner) MediaStorage {
+	return &localStorage{dir: dir, signer: signer}
+}
+
+func (s *localStorage) Name() string { return "local" }
+
+// Test checks that our directory exists, creating it if needed, and that it is writable
+func (s *localStorage) Test() error {
+	err := os.MkdirAll(s.dir, 0770)
+	if err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(s.dir, ".test")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// Put writes the contents of the passed in reader to a temporary file which is moved into place once fully written,
+// so a partial file is never served
+func (s *localStorage) Put(filePath string, contentType string, contents io.Reader) (string, error) {
+	filename := s.filename(filePath)
+
+	err := os.MkdirAll(filepath.Dir(filename), 0770)
+	if err != nil {
+		return "", errors.Wrapf(err, "error creating media directory")
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(filename), ".upload")
+	if err != nil {
+		return "", errors.Wrapf(err, "error creating media file")
+	}
+
+	_, err = io.Copy(file, contents)
+	if err == nil {
+		err = file.Close()
+	} else {
+		file.Close()
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	return s.signer.URL(filePath), nil
+}
+
+// Get opens the file at the passed in path, its content type is taken from its extension
+func (s *localStorage) Get(filePath string) (io.ReadCloser, string, error) {
+	file, err := os.Open(s.filename(filePath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, "", ErrNotFound
+		}
+		return nil, "", err
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(filePath))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return file, contentType, nil
+}
+
+// filename returns the name of the file for the passed in path, which can never be outside of our directory
+func (s *localStorage) filename(filePath string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(path.Clean("/"+filePath)))
+}
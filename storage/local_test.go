@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "media")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s := NewLocalStorage(path.Join(dir, "media"), NewURLSigner("courier.example.com", "sesame", 0))
+	assert.Equal(t, "local", s.Name())
+	assert.NoError(t, s.Test())
+
+	url, err := s.Put("1/abcd/efgh/abcdefgh.jpg", "image/jpeg", strings.NewReader("jpegdata"))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(url, "https://courier.example.com/media/0/"))
+	assert.True(t, strings.HasSuffix(url, "/1/abcd/efgh/abcdefgh.jpg"))
+
+	contents, err := ioutil.ReadFile(path.Join(dir, "media", "1", "abcd", "efgh", "abcdefgh.jpg"))
+	assert.NoError(t, err)
+	assert.Equal(t, "jpegdata", string(contents))
+
+	reader, contentType, err := s.Get("1/abcd/efgh/abcdefgh.jpg")
+	assert.NoError(t, err)
+	contents, _ = ioutil.ReadAll(reader)
+	reader.Close()
+	assert.Equal(t, "jpegdata", string(contents))
+	assert.Equal(t, "image/jpeg", contentType)
+
+	_, _, err = s.Get("1/abcd/efgh/missing.jpg")
+	assert.Equal(t, ErrNotFound, err)
+
+	// paths can't escape our directory
+	assert.NoError(t, ioutil.WriteFile(path.Join(dir, "secret.txt"), []byte("secret"), 0640))
+	_, _, err = s.Get("../secret.txt")
+	assert.Equal(t, ErrNotFound, err)
+}
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// the size of the parts we upload, S3 requires every part but the last to be at least 5MB
+var s3PartSize = 5 * 1024 * 1024
+
+// the longest S3 allows a pre-signed URL to be valid for
+const maxPresignExpiration = time.Hour * 24 * 7
+
+type s3Storage struct {
+	client s3iface.S3API
+	bucket string
+	prefix string
+	signer *URLSigner
+	expiry time.Duration
+}
+
+// NewS3Storage creates a new storage which writes private objects to the passed in bucket, under the passed in prefix.
+// If a signer is passed in, files are served by courier from its URLs, otherwise pre-signed S3 URLs are returned which
+// are valid for the passed in duration, up to the S3 maximum of a week.
+func NewS3Storage(client s3iface.S3API, bucket string, prefix string, signer *URLSigner, expiry time.Duration) MediaStorage {
+	if expiry <= 0 || expiry > maxPresignExpiration {
+		expiry = maxPresignExpiration
+	}
+	return &s3Storage{client: client, bucket: bucket, prefix: prefix, signer: signer, expiry: expiry}
+}
+
+func (s *s3Storage) Name() string { return "s3" }
+
+// Test checks that our bucket is accessible
+func (s *s3Storage) Test() error {
+	_, err := s.client.HeadBucket(&s3.HeadBucketInput{
+		Bucket: aws.String(s.bucket),
+	})
+	return err
+}
+
+// Put streams the contents of the passed in reader to S3. Contents which fit in a single part are written with a
+// single request, larger contents are written part by part as a multipart upload so that only a single part is ever
+// held in memory. If reading or writing fails the upload is aborted.
+func (s *s3Storage) Put(filePath string, contentType string, contents io.Reader) (string, error) {
+	key := s.key(filePath)
+
+	part := make([]byte, s3PartSize)
+	n, err := io.ReadFull(contents, part)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		_, err = s.client.PutObject(&s3.PutObjectInput{
+			Bucket:      aws.String(s.bucket),
+			Body:        bytes.NewReader(part[:n]),
+			Key:         aws.String(key),
+			ContentType: aws.String(contentType),
+			ACL:         aws.String(s3.ObjectCannedACLPrivate),
+		})
+		if err != nil {
+			return "", err
+		}
+		return s.url(filePath, key)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	upload, err := s.client.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+		ACL:         aws.String(s3.ObjectCannedACLPrivate),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	abort := func(err error) (string, error) {
+		s.client.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(s.bucket),
+			Key:      aws.String(key),
+			UploadId: upload.UploadId,
+		})
+		return "", err
+	}
+
+	parts := make([]*s3.CompletedPart, 0)
+	for n > 0 {
+		number := aws.Int64(int64(len(parts) + 1))
+		uploaded, err := s.client.UploadPart(&s3.UploadPartInput{
+			Bucket:     aws.String(s.bucket),
+			Key:        aws.String(key),
+			UploadId:   upload.UploadId,
+			PartNumber: number,
+			Body:       bytes.NewReader(part[:n]),
+		})
+		if err != nil {
+			return abort(err)
+		}
+		parts = append(parts, &s3.CompletedPart{ETag: uploaded.ETag, PartNumber: number})
+
+		n, err = io.ReadFull(contents, part)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return abort(err)
+		}
+	}
+
+	_, err = s.client.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(key),
+		UploadId:        upload.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return abort(err)
+	}
+
+	return s.url(filePath, key)
+}
+
+// Get fetches the object at the passed in path, used when we serve files ourselves
+func (s *s3Storage) Get(filePath string) (io.ReadCloser, string, error) {
+	output, err := s.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(filePath)),
+	})
+	if err != nil {
+		if awsErr, isAWSErr := err.(awserr.Error); isAWSErr && awsErr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, "", ErrNotFound
+		}
+		return nil, "", err
+	}
+	return output.Body, aws.StringValue(output.ContentType), nil
+}
+
+// key returns the key in our bucket of the passed in path
+func (s *s3Storage) key(filePath string) string {
+	return path.Join("/", s.prefix, filePath)
+}
+
+// url returns the URL the object with the passed in path and key can be fetched from
+func (s *s3Storage) url(filePath string, key string) (string, error) {
+	if s.signer != nil {
+		return s.signer.URL(filePath), nil
+	}
+
+	req, _ := s.client.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	return req.Presign(s.expiry)
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/stretchr/testify/assert"
+)
+
+// testS3Client records the objects and parts written to it
+type testS3Client struct {
+	s3iface.S3API
+
+	objects   map[string]string
+	acls      []string
+	parts     []string
+	completed bool
+	aborted   bool
+	failPart  int
+}
+
+func (c *testS3Client) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	body, _ := ioutil.ReadAll(input.Body)
+	c.objects[*input.Key] = string(body)
+	c.acls = append(c.acls, *input.ACL)
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (c *testS3Client) CreateMultipartUpload(input *s3.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error) {
+	c.acls = append(c.acls, *input.ACL)
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String("upload1")}, nil
+}
+
+func (c *testS3Client) UploadPart(input *s3.UploadPartInput) (*s3.UploadPartOutput, error) {
+	if int(*input.PartNumber) == c.failPart {
+		return nil, errors.New("part failed")
+	}
+	body, _ := ioutil.ReadAll(input.Body)
+	c.parts = append(c.parts, string(body))
+	return &s3.UploadPartOutput{ETag: aws.String("etag")}, nil
+}
+
+func (c *testS3Client) CompleteMultipartUpload(*s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error) {
+	c.completed = true
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func (c *testS3Client) AbortMultipartUpload(*s3.AbortMultipartUploadInput) (*s3.AbortMultipartUploadOutput, error) {
+	c.aborted = true
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func TestS3Storage(t *testing.T) {
+	defer func(original int) { s3PartSize = original }(s3PartSize)
+	s3PartSize = 4
+
+	signer := NewURLSigner("courier.example.com", "sesame", 0)
+
+	// contents which fit in a single part are put in one request
+	client := &testS3Client{objects: make(map[string]string)}
+	s := NewS3Storage(client, "bucket", "/media/", signer, 0)
+	assert.Equal(t, "s3", s.Name())
+
+	url, err := s.Put("1/small.txt", "text/plain", bytes.NewReader([]byte("abc")))
+	assert.NoError(t, err)
+	assert.Equal(t, signer.URL("1/small.txt"), url)
+	assert.Equal(t, map[string]string{"/media/1/small.txt": "abc"}, client.objects)
+	assert.Equal(t, 0, len(client.parts))
+
+	// larger contents are uploaded in parts
+	client = &testS3Client{objects: make(map[string]string)}
+	s = NewS3Storage(client, "bucket", "media", signer, 0)
+	url, err = s.Put("1/large.txt", "text/plain", strings.NewReader("abcdefghij"))
+	assert.NoError(t, err)
+	assert.Equal(t, signer.URL("1/large.txt"), url)
+	assert.Equal(t, []string{"abcd", "efgh", "ij"}, client.parts)
+	assert.True(t, client.completed)
+	assert.Equal(t, 0, len(client.objects))
+
+	// a failed part aborts the upload
+	client = &testS3Client{objects: make(map[string]string), failPart: 2}
+	s = NewS3Storage(client, "bucket", "media", signer, 0)
+	_, err = s.Put("1/large.txt", "text/plain", strings.NewReader("abcdefghij"))
+	assert.EqualError(t, err, "part failed")
+	assert.True(t, client.aborted)
+	assert.False(t, client.completed)
+
+	// nothing is ever public
+	assert.Equal(t, []string{s3.ObjectCannedACLPrivate}, client.acls)
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nyaruka/courier/utils"
+	"github.com/pkg/errors"
+)
+
+// MediaStorage is the interface for somewhere downloaded attachments can be written to and served from
+type MediaStorage interface {
+	// Name returns the name of this storage, used in logs and health checks
+	Name() string
+
+	// Test checks that this storage is configured correctly and can be written to
+	Test() error
+
+	// Put writes the contents of the passed in reader to the passed in path, returning the URL it can be fetched from
+	Put(path string, contentType string, contents io.Reader) (string, error)
+
+	// Get opens the file at the passed in path, returning its contents and content type
+	Get(path string) (io.ReadCloser, string, error)
+}
+
+// ErrNotFound is returned by Get when there is no file at the passed in path
+var ErrNotFound = errors.New("media not found")
+
+// URLSigner creates and verifies the signed URLs stored media is served from by courier under its /media/ route
+type URLSigner struct {
+	Domain     string
+	Secret     string
+	Expiration time.Duration
+}
+
+// NewURLSigner creates a new signer for URLs on the passed in domain, which expire after the passed in duration, or
+// never if it is zero
+func NewURLSigner(domain string, secret string, expiration time.Duration) *URLSigner {
+	return &URLSigner{Domain: domain, Secret: secret, Expiration: expiration}
+}
+
+// URL returns a signed URL for the file at the passed in path. The expiry and signature come before the path so that
+// URLs still end with the extension of the file, which some channels use to decide how to treat it.
+func (s *URLSigner) URL(path string) string {
+	path = strings.TrimPrefix(path, "/")
+
+	expires := "0"
+	if s.Expiration > 0 {
+		expires = strconv.FormatInt(time.Now().Add(s.Expiration).Unix(), 10)
+	}
+	return fmt.Sprintf("https://%s/media/%s/%s/%s", s.Domain, expires, s.signature(path, expires), path)
+}
+
+// Verify checks that the passed in signature is valid for the passed in path and expiry, and that it hasn't expired.
+// URLs which never expire are only valid if our URLs never expire either.
+func (s *URLSigner) Verify(path string, expires string, signature string, now time.Time) error {
+	path = strings.TrimPrefix(path, "/")
+
+	if !hmac.Equal([]byte(s.signature(path, expires)), []byte(signature)) {
+		return errors.New("invalid media URL signature")
+	}
+
+	seconds, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return errors.New("invalid media URL expiry")
+	}
+	if (seconds == 0 && s.Expiration > 0) || (seconds != 0 && now.Unix() > seconds) {
+		return errors.New("media URL has expired")
+	}
+	return nil
+}
+
+func (s *URLSigner) signature(path string, expires string) string {
+	return utils.SignHMAC256(s.Secret, path+":"+expires)
+}
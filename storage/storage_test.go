@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestURLSigner(t *testing.T) {
+	signer := NewURLSigner("courier.example.com", "sesame", 0)
+
+	url := signer.URL("/1/abcd/efgh/abcdefgh.jpg")
+	assert.True(t, strings.HasPrefix(url, "https://courier.example.com/media/0/"))
+	assert.True(t, strings.HasSuffix(url, "/1/abcd/efgh/abcdefgh.jpg"))
+
+	// pull our signature back out of our URL
+	parts := strings.SplitN(strings.TrimPrefix(url, "https://courier.example.com/media/"), "/", 3)
+	assert.Equal(t, "0", parts[0])
+	assert.Equal(t, "1/abcd/efgh/abcdefgh.jpg", parts[2])
+
+	// URLs which don't expire are always valid
+	assert.NoError(t, signer.Verify(parts[2], parts[0], parts[1], time.Now().Add(time.Hour*24*365)))
+
+	// but not for another path, expiry or secret
+	assert.EqualError(t, signer.Verify("1/abcd/efgh/other.jpg", parts[0], parts[1], time.Now()), "invalid media URL signature")
+	assert.EqualError(t, signer.Verify(parts[2], "1", parts[1], time.Now()), "invalid media URL signature")
+	assert.EqualError(t, NewURLSigner("courier.example.com", "open", 0).Verify(parts[2], parts[0], parts[1], time.Now()), "invalid media URL signature")
+
+	// URLs which expire are valid until they do
+	signer = NewURLSigner("courier.example.com", "sesame", time.Hour)
+	parts = strings.SplitN(strings.TrimPrefix(signer.URL("1/abcd/efgh/abcdefgh.jpg"), "https://courier.example.com/media/"), "/", 3)
+	assert.NoError(t, signer.Verify(parts[2], parts[0], parts[1], time.Now()))
+	assert.EqualError(t, signer.Verify(parts[2], parts[0], parts[1], time.Now().Add(time.Hour*2)), "media URL has expired")
+
+	// and URLs which never expire aren't valid for signers whose URLs do
+	parts = strings.SplitN(strings.TrimPrefix(NewURLSigner("courier.example.com", "sesame", 0).URL("1/abcd/efgh/abcdefgh.jpg"), "https://courier.example.com/media/"), "/", 3)
+	assert.EqualError(t, signer.Verify(parts[2], parts[0], parts[1], time.Now()), "media URL has expired")
+}
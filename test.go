@@ -32,12 +32,16 @@ type MockBackend struct {
 	outgoingMsgs    []Msg
 	msgStatuses     []MsgStatus
 	channelEvents   []ChannelEvent
+	readWatermarks  []*MockReadWatermark
 	lastContactName string
 
 	sentMsgs  map[MsgID]bool
 	redisPool *redis.Pool
 
 	seenExternalIDs []string
+	pausedChannels  map[ChannelUUID]bool
+	invalidated     []ChannelUUID
+	notifications   chan bool
 }
 
 // NewMockBackend returns a new mock backend suitable for testing
@@ -65,10 +69,12 @@ func NewMockBackend() *MockBackend {
 	}
 
 	return &MockBackend{
-		channels:  make(map[ChannelUUID]Channel),
-		contacts:  make(map[urns.URN]Contact),
-		sentMsgs:  make(map[MsgID]bool),
-		redisPool: redisPool,
+		channels:       make(map[ChannelUUID]Channel),
+		contacts:       make(map[urns.URN]Contact),
+		sentMsgs:       make(map[MsgID]bool),
+		pausedChannels: make(map[ChannelUUID]bool),
+		redisPool:      redisPool,
+		notifications:  make(chan bool, 1),
 	}
 }
 
@@ -122,6 +128,29 @@ func (mb *MockBackend) PushOutgoingMsg(msg Msg) {
 	defer mb.mutex.Unlock()
 
 	mb.outgoingMsgs = append(mb.outgoingMsgs, msg)
+
+	select {
+	case mb.notifications <- true:
+	default:
+	}
+}
+
+// OutgoingNotifications returns the channel we notify whenever an outgoing msg is pushed
+func (mb *MockBackend) OutgoingNotifications() <-chan bool {
+	return mb.notifications
+}
+
+// PopNextOutgoingMsgs returns up to count of the next messages that should be sent
+func (mb *MockBackend) PopNextOutgoingMsgs(ctx context.Context, count int) ([]Msg, error) {
+	msgs := make([]Msg, 0, count)
+	for len(msgs) < count {
+		msg, _ := mb.PopNextOutgoingMsg(ctx)
+		if msg == nil {
+			break
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
 }
 
 // PopNextOutgoingMsg returns the next message that should be sent, or nil if there are none to send
@@ -129,10 +158,11 @@ func (mb *MockBackend) PopNextOutgoingMsg(ctx context.Context) (Msg, error) {
 	mb.mutex.Lock()
 	defer mb.mutex.Unlock()
 
-	if len(mb.outgoingMsgs) > 0 {
-		msg, rest := mb.outgoingMsgs[0], mb.outgoingMsgs[1:]
-		mb.outgoingMsgs = rest
-		return msg, nil
+	for i, msg := range mb.outgoingMsgs {
+		if !mb.pausedChannels[msg.Channel().UUID()] {
+			mb.outgoingMsgs = append(mb.outgoingMsgs[:i:i], mb.outgoingMsgs[i+1:]...)
+			return msg, nil
+		}
 	}
 
 	return nil, nil
@@ -211,6 +241,26 @@ func (mb *MockBackend) WriteMsgStatus(ctx context.Context, status MsgStatus) err
 	return nil
 }
 
+// WriteMsgsReadBefore records the read watermark, we don't track sent msgs so no statuses are written
+func (mb *MockBackend) WriteMsgsReadBefore(ctx context.Context, channel Channel, urn urns.URN, watermark time.Time) ([]MsgStatus, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.readWatermarks = append(mb.readWatermarks, &MockReadWatermark{Channel: channel, URN: urn, Watermark: watermark})
+	return nil, nil
+}
+
+// GetLastReadWatermark returns the last read watermark written, if any
+func (mb *MockBackend) GetLastReadWatermark() *MockReadWatermark {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	if len(mb.readWatermarks) == 0 {
+		return nil
+	}
+	return mb.readWatermarks[len(mb.readWatermarks)-1]
+}
+
 // NewChannelEvent creates a new channel event with the passed in parameters
 func (mb *MockBackend) NewChannelEvent(channel Channel, eventType ChannelEventType, urn urns.URN) ChannelEvent {
 	return &mockChannelEvent{
@@ -317,9 +367,124 @@ func (mb *MockBackend) WriteExternalIDSeen(msg Msg) {
 	mb.seenExternalIDs = append(mb.seenExternalIDs, msg.ExternalID())
 }
 
-// Health gives a string representing our health, empty for our mock
-func (mb *MockBackend) Health() string {
-	return ""
+// Queues returns a queue for each channel which has outgoing msgs
+func (mb *MockBackend) Queues(ctx context.Context) ([]*ChannelQueue, error) {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	queues := make([]*ChannelQueue, 0)
+	byChannel := make(map[ChannelUUID]*ChannelQueue)
+	for _, msg := range mb.outgoingMsgs {
+		queue, found := byChannel[msg.Channel().UUID()]
+		if !found {
+			queue = &ChannelQueue{ChannelUUID: msg.Channel().UUID(), ChannelType: msg.Channel().ChannelType(), State: "active", Breaker: "closed", Paused: mb.pausedChannels[msg.Channel().UUID()]}
+			byChannel[msg.Channel().UUID()] = queue
+			queues = append(queues, queue)
+		}
+		if msg.HighPriority() {
+			queue.Size++
+		} else {
+			queue.BulkSize++
+		}
+	}
+	return queues, nil
+}
+
+// PeekQueue returns the next outgoing msgs for the passed in channel
+func (mb *MockBackend) PeekQueue(ctx context.Context, uuid ChannelUUID, count int) ([]*QueuedItem, error) {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	items := make([]*QueuedItem, 0)
+	for _, msg := range mb.outgoingMsgs {
+		if msg.Channel().UUID() == uuid && len(items) < count {
+			value, _ := json.Marshal([]interface{}{map[string]interface{}{"id": msg.ID(), "text": msg.Text()}})
+			items = append(items, &QueuedItem{HighPriority: msg.HighPriority(), AvailableOn: time.Now(), Value: value})
+		}
+	}
+	return items, nil
+}
+
+// PurgeQueue removes all outgoing msgs for the passed in channel
+func (mb *MockBackend) PurgeQueue(ctx context.Context, uuid ChannelUUID) (int, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	remaining := make([]Msg, 0, len(mb.outgoingMsgs))
+	for _, msg := range mb.outgoingMsgs {
+		if msg.Channel().UUID() != uuid {
+			remaining = append(remaining, msg)
+		}
+	}
+	purged := len(mb.outgoingMsgs) - len(remaining)
+	mb.outgoingMsgs = remaining
+	return purged, nil
+}
+
+// PauseChannel pauses sending for the passed in channel
+func (mb *MockBackend) PauseChannel(ctx context.Context, uuid ChannelUUID) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.pausedChannels[uuid] = true
+	return nil
+}
+
+// ResumeChannel resumes sending for the passed in channel
+func (mb *MockBackend) ResumeChannel(ctx context.Context, uuid ChannelUUID) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	delete(mb.pausedChannels, uuid)
+	return nil
+}
+
+// IsChannelPaused returns whether the passed in channel is paused
+func (mb *MockBackend) IsChannelPaused(ctx context.Context, uuid ChannelUUID) (bool, error) {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	return mb.pausedChannels[uuid], nil
+}
+
+// InvalidateChannel records that the passed in channel has been invalidated
+func (mb *MockBackend) InvalidateChannel(ctx context.Context, uuid ChannelUUID) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.invalidated = append(mb.invalidated, uuid)
+	return nil
+}
+
+// InvalidatedChannels returns the UUIDs of the channels which have been invalidated
+func (mb *MockBackend) InvalidatedChannels() []ChannelUUID {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	return mb.invalidated
+}
+
+// DrainChannel removes all outgoing msgs for the passed in channel, writing a failed status for each
+func (mb *MockBackend) DrainChannel(ctx context.Context, uuid ChannelUUID) (int, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	remaining := make([]Msg, 0, len(mb.outgoingMsgs))
+	for _, msg := range mb.outgoingMsgs {
+		if msg.Channel().UUID() != uuid {
+			remaining = append(remaining, msg)
+		} else {
+			mb.msgStatuses = append(mb.msgStatuses, &mockMsgStatus{channel: msg.Channel(), id: msg.ID(), status: MsgFailed, createdOn: time.Now().In(time.UTC)})
+		}
+	}
+	failed := len(mb.outgoingMsgs) - len(remaining)
+	mb.outgoingMsgs = remaining
+	return failed, nil
+}
+
+// Health returns an empty health report for our mock
+func (mb *MockBackend) Health() *HealthReport {
+	return NewHealthReport()
 }
 
 // Status returns a string describing the status of the service, queue size etc..
@@ -539,11 +704,19 @@ func (m *mockMsg) WithMetadata(metadata json.RawMessage) Msg { m.metadata = meta
 // Mock status implementation
 //-----------------------------------------------------------------------------
 
+// MockReadWatermark is a read watermark written to a mock backend
+type MockReadWatermark struct {
+	Channel   Channel
+	URN       urns.URN
+	Watermark time.Time
+}
+
 type mockMsgStatus struct {
 	channel    Channel
 	id         MsgID
 	externalID string
 	status     MsgStatusValue
+	sendError  *SendError
 	createdOn  time.Time
 
 	logs []*ChannelLog
@@ -559,6 +732,9 @@ func (m *mockMsgStatus) SetExternalID(id string) { m.externalID = id }
 func (m *mockMsgStatus) Status() MsgStatusValue          { return m.status }
 func (m *mockMsgStatus) SetStatus(status MsgStatusValue) { m.status = status }
 
+func (m *mockMsgStatus) SendError() *SendError       { return m.sendError }
+func (m *mockMsgStatus) SetSendError(err *SendError) { m.sendError = err }
+
 func (m *mockMsgStatus) Logs() []*ChannelLog    { return m.logs }
 func (m *mockMsgStatus) AddLog(log *ChannelLog) { m.logs = append(m.logs, log) }
 